@@ -3,10 +3,11 @@ package main
 import (
 	"bufio"
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
+	"ip-api/internal/amap"
 	"ip-api/internal/fusion"
+	"ip-api/internal/hotcache"
 	"ip-api/internal/ingest"
 	"ip-api/internal/localdb"
 	"ip-api/internal/localdb/ip2region"
@@ -40,22 +41,6 @@ func ipToInt(ip string) (uint32, error) {
 	return x, nil
 }
 
-// 文档注释：覆盖 KV 表写入（amap 来源）
-// 背景：优先通过 KV 覆盖让 API 首步命中；assoc_key 用于来源标识与分域管理。
-func upsertKV(ctx context.Context, db *sql.DB, assocKey string, ip string, country, region, province, city, isp string, score float64, confidence float64) error {
-	v, err := ipToInt(ip)
-	if err != nil {
-		return err
-	}
-	_, err = db.ExecContext(ctx, `INSERT INTO _ip_overrides_kv(assoc_key, ip_int, country, region, province, city, isp, score, confidence)
-        VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)
-        ON CONFLICT (assoc_key, ip_int) DO UPDATE SET country=EXCLUDED.country, region=EXCLUDED.region, province=EXCLUDED.province, city=EXCLUDED.city, isp=EXCLUDED.isp, score=EXCLUDED.score, confidence=EXCLUDED.confidence, updated_at=now()
-        WHERE COALESCE(_ip_overrides_kv.score, 0) + 20 <= EXCLUDED.score`,
-		assocKey, int64(v), country, region, province, city, isp, score, confidence,
-	)
-	return err
-}
-
 // 文档注释：简单令牌桶限流（每分钟）
 // 背景：受外部配额限制，控制每分钟最大请求数；超出时阻塞等待下一分钟刷新。
 type minuteLimiter struct {
@@ -125,7 +110,13 @@ func main() {
 		assocKey = "amap"
 	}
 
-	client := &http.Client{Timeout: 5 * time.Second}
+	// 高德客户端：重试退避（AMAP_RETRY_MAX）与每日配额（AMAP_DAILY_QUOTA）；Redis 可用时与在线服务共享配额计数
+	rc := utils.OpenRedisFromEnv()
+	if err := rc.Ping(context.Background()).Err(); err != nil {
+		l.Warn("redis_unavailable_quota_local", "err", err)
+		rc = nil
+	}
+	ac := amap.NewClientFromEnv(key, &http.Client{Timeout: 5 * time.Second}, rc)
 	// 聚合数据源初始化
 	lang := os.Getenv("IPIP_LANG")
 	if lang == "" {
@@ -143,7 +134,7 @@ func main() {
 	}
 	st := store.AttachDB(db)
 	kvSrc := &fusion.KVSource{Store: st}
-	amapSrc := &fusion.AMapSource{Key: key, Client: client}
+	amapSrc := &fusion.AMapSource{Client: ac}
 	ipipSrc := &fusion.IPIPSource{Cache: ipipCache}
 	var ip2rSrc fusion.DataSource
 	if p := os.Getenv("IP2REGION_V4_PATH"); p != "" {
@@ -159,6 +150,8 @@ func main() {
 	}
 	sources = append(sources, amapSrc)
 	limiter := &minuteLimiter{capacity: ratePerMin}
+	// 写回策略与在线服务共用（阈值/一致来源数/受保护命名空间/演练）；冷却期仅进程内生效
+	wp := fusion.LoadWritePolicy(st, nil)
 
 	// 任务派发
 	type job struct{ ip string }
@@ -175,23 +168,33 @@ func main() {
 				}
 				// 聚合查询
 				ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
-				loc, score, conf := fusion.Aggregate(ctx, sources, j.ip)
+				loc, score, conf, agreeing := fusion.Aggregate(ctx, sources, j.ip)
 				cancel()
 				if loc.Province == "" && loc.City == "" {
 					logger.L().Warn("fusion_skip_empty", "ip", j.ip)
 					continue
 				}
-				// 写库：优先 KV 覆盖；高分可写精确表
-				if err := upsertKV(context.Background(), db, assocKey, j.ip, loc.Country, loc.Region, loc.Province, loc.City, loc.ISP, score, conf); err != nil {
+				// 写库：经写回策略判定后写 KV 覆盖；开启精确写入且达到阈值时写精确表
+				d := wp.Decide(context.Background(), j.ip, loc, score, assocKey, agreeing)
+				if !d.WriteKV || d.DryRun {
+					continue
+				}
+				il := ingest.Location{Country: loc.Country, Region: loc.Region, Province: loc.Province, City: loc.City, ISP: loc.ISP}
+				applied, err := st.UpsertOverrideKV(store.WithChange(context.Background(), "", "amap-ingest"), d.Assoc, j.ip, il, score, conf, d.Margin)
+				if err != nil {
 					logger.L().Error("kv_upsert_error", "ip", j.ip, "err", err)
+					wp.ReleaseCooldown(context.Background(), j.ip)
 					continue
 				}
-				if writeExact {
-					_, wExact := fusion.DecideWrite(fusion.Location{Country: loc.Country, Region: loc.Region, Province: loc.Province, City: loc.City, ISP: loc.ISP}, score)
-					if wExact {
-						if v, e := ipToInt(j.ip); e == nil {
-							_ = ingest.WriteExact(context.Background(), db, v, ingest.Location{Country: loc.Country, Region: loc.Region, Province: loc.Province, City: loc.City, ISP: loc.ISP}, "amap")
-						}
+				if !applied {
+					wp.ReleaseCooldown(context.Background(), j.ip)
+				} else {
+					// 通知在线服务失效该 IP 的结果缓存（Redis 不可用时由服务端缓存 TTL 兜底）
+					_ = hotcache.Invalidate(context.Background(), rc, j.ip)
+				}
+				if writeExact && d.WriteExact {
+					if v, e := ipToInt(j.ip); e == nil {
+						_ = ingest.WriteExact(context.Background(), db, v, il, d.Assoc)
 					}
 				}
 				logger.L().Debug("fusion_ingest_ok", "ip", j.ip, "province", loc.Province, "city", loc.City, "score", score)
@@ -213,33 +216,13 @@ func main() {
 				limit = n
 			}
 		}
-		// 直接 SQL 查询候选，筛掉已覆盖/已精确
-		rows, err := db.Query(`
-            SELECT r.ip_int
-            FROM _ip_recent_ips r
-            LEFT JOIN _ip_overrides_kv k ON k.ip_int = r.ip_int
-            LEFT JOIN _ip_exact e ON e.ip_int = r.ip_int
-            WHERE r.last_seen >= now() - make_interval(hours => $1)
-              AND k.ip_int IS NULL
-              AND e.ip_int IS NULL
-            ORDER BY r.last_seen DESC
-            LIMIT $2`, hours, limit)
+		// 候选与服务端一致：筛掉已覆盖/已精确，匿名化记录的 /24 前缀以 .1 探测
+		ips, err := st.FetchRecentCandidates(context.Background(), hours, limit)
 		if err != nil {
 			l.Error("db_source_query_error", "err", err)
 			os.Exit(1)
 		}
-		defer rows.Close()
-		for rows.Next() {
-			var v int64
-			if err := rows.Scan(&v); err != nil {
-				l.Error("db_source_scan_error", "err", err)
-				os.Exit(1)
-			}
-			a := (v >> 24) & 0xff
-			b := (v >> 16) & 0xff
-			c := (v >> 8) & 0xff
-			d := v & 0xff
-			ip := fmt.Sprintf("%d.%d.%d.%d", a, b, c, d)
+		for _, ip := range ips {
 			jobs <- job{ip: ip}
 			total++
 		}
@@ -272,5 +255,5 @@ func main() {
 		}
 	}
 	wg.Wait()
-	l.Info("amap_ingest_done", "total", total)
+	l.Info("amap_ingest_done", "total", total, "quota_used_today", ac.Quota.Used(context.Background()))
 }
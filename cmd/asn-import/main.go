@@ -0,0 +1,146 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"io"
+	"ip-api/internal/asn"
+	"ip-api/internal/logger"
+	"ip-api/internal/migrate"
+	"ip-api/internal/utils"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+)
+
+// 文档注释：导入 ASN 前缀映射与元信息
+// 背景：离线从本地文件导入，服务启动时从数据库加载到内存；文件来源——
+// - ASN_RIB_FILES：BGP 路由表导出（pyasn ipasn 数据或 bgpdump -m 输出），逗号分隔多个文件；
+// - ASN_DELEGATED_FILES：RIR 扩展委派文件（delegated-<rir>-extended-latest），逗号分隔；
+// - ASN_NAMES_FILE：ASN 名称文件（pyasn asnames JSON 或 "ASN 名称" 文本）。
+// 约束：.gz/.bz2 后缀自动解压；ASN_IMPORT_REPLACE（默认 true）为 true 时按来源全量替换前缀；任一文件读取失败即退出，不写入部分数据。
+func main() {
+	_ = godotenv.Load(".env")
+	l := logger.Setup()
+	ribFiles := splitFiles(os.Getenv("ASN_RIB_FILES"))
+	delegFiles := splitFiles(os.Getenv("ASN_DELEGATED_FILES"))
+	namesFile := strings.TrimSpace(os.Getenv("ASN_NAMES_FILE"))
+	if len(ribFiles) == 0 && len(delegFiles) == 0 && namesFile == "" {
+		l.Error("asn_import_no_input", "hint", "set ASN_RIB_FILES / ASN_DELEGATED_FILES / ASN_NAMES_FILE")
+		os.Exit(1)
+	}
+	replace := os.Getenv("ASN_IMPORT_REPLACE") != "false"
+
+	var bgp []asn.Prefix
+	for _, f := range ribFiles {
+		var ps []asn.Prefix
+		var skipped int
+		err := withFile(f, func(r io.Reader) (err error) {
+			ps, skipped, err = asn.ParseRIB(r)
+			return err
+		})
+		if err != nil {
+			l.Error("asn_rib_read_error", "file", f, "err", err)
+			os.Exit(1)
+		}
+		l.Info("asn_rib_parsed", "file", f, "prefixes", len(ps), "skipped", skipped)
+		bgp = append(bgp, ps...)
+	}
+	var rir []asn.Prefix
+	var recs []asn.Record
+	for _, f := range delegFiles {
+		var ps []asn.Prefix
+		var rs []asn.Record
+		var skipped int
+		err := withFile(f, func(r io.Reader) (err error) {
+			ps, rs, skipped, err = asn.ParseDelegated(r)
+			return err
+		})
+		if err != nil {
+			l.Error("asn_delegated_read_error", "file", f, "err", err)
+			os.Exit(1)
+		}
+		l.Info("asn_delegated_parsed", "file", f, "prefixes", len(ps), "asns", len(rs), "skipped", skipped)
+		rir = append(rir, ps...)
+		recs = append(recs, rs...)
+	}
+	var names map[uint32]string
+	if namesFile != "" {
+		err := withFile(namesFile, func(r io.Reader) (err error) {
+			names, err = asn.ParseNames(r)
+			return err
+		})
+		if err != nil {
+			l.Error("asn_names_read_error", "file", namesFile, "err", err)
+			os.Exit(1)
+		}
+		l.Info("asn_names_parsed", "file", namesFile, "names", len(names))
+	}
+	recs = asn.MergeNames(recs, names)
+
+	db, err := utils.OpenPostgresFromEnv()
+	if err != nil {
+		l.Error("db_open_error", "err", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	if err := migrate.EnsureSchema(db); err != nil {
+		l.Error("schema_error", "err", err)
+		os.Exit(1)
+	}
+	ctx := context.Background()
+	if len(bgp) > 0 {
+		if _, err := asn.ImportPrefixes(ctx, db, asn.SourceBGP, bgp, replace); err != nil {
+			l.Error("asn_bgp_import_error", "err", err)
+			os.Exit(1)
+		}
+	}
+	if len(rir) > 0 {
+		if _, err := asn.ImportPrefixes(ctx, db, asn.SourceRIR, rir, replace); err != nil {
+			l.Error("asn_rir_import_error", "err", err)
+			os.Exit(1)
+		}
+	}
+	if len(recs) > 0 {
+		if err := asn.ImportRecords(ctx, db, recs); err != nil {
+			l.Error("asn_record_import_error", "err", err)
+			os.Exit(1)
+		}
+	}
+	l.Info("asn_import_done", "bgp", len(bgp), "rir", len(rir), "records", len(recs))
+}
+
+func splitFiles(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// 文档注释：打开文件（按后缀透明解压）并交给解析函数
+func withFile(path string, fn func(io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case strings.HasSuffix(path, ".bz2"):
+		r = bzip2.NewReader(f)
+	}
+	return fn(r)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"ip-api/internal/logger"
+	"ip-api/internal/store"
 	"ip-api/internal/utils"
 	"os"
 	"time"
@@ -90,5 +91,9 @@ func main() {
 		lastIP = ipInt
 	}
 	flush()
+	// 通知在线服务重建特例段索引
+	if err := store.NotifySpecialChanged(context.Background(), db); err != nil {
+		l.Error("cidr_notify_error", "err", err)
+	}
 	l.Info("cidr_build_done")
 }
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"ip-api/internal/logger"
+	"ip-api/internal/store"
 	"ip-api/internal/utils"
 	"os"
 
@@ -52,5 +54,9 @@ func main() {
 		l.Error("cidr_rollback_error", "err", err)
 		os.Exit(1)
 	}
+	// 通知在线服务重建特例段索引
+	if err := store.NotifySpecialChanged(context.Background(), db); err != nil {
+		l.Error("cidr_notify_error", "err", err)
+	}
 	l.Info("cidr_rollback_done", "prefix", prefix, "keep", keepN)
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"ip-api/internal/export"
+	"ip-api/internal/localdb/unified"
+	"ip-api/internal/logger"
+	"ip-api/internal/migrate"
+	"ip-api/internal/utils"
+	"os"
+	"path/filepath"
+
+	"github.com/joho/godotenv"
+)
+
+// 文档注释：导出有效数据为离线格式
+// 背景：其他团队需要离线使用修正后的数据；导出与统一库编译共用同一有效视图（范围、特例段、精确表、KV 覆盖按优先级决出），
+// 使用方读取导出文件得到与 /api/ip 数据库回退相同的结果。
+// 约束：
+// - EXPORT_FORMATS：逗号分隔的 mmdb、xdb、csv、ndjson（默认 mmdb,csv）；
+// - EXPORT_DIR：输出目录（默认 data/export），同时写入 manifest.json；
+// - EXPORT_SOURCE / EXPORT_VERSION：来源标识与发布版本（默认 ip-api 与数据版本），决定文件名并写入清单；
+// - EXPORT_LANG：mmdb names 的语言键（默认 zh-CN）。
+func main() {
+	_ = godotenv.Load(".env")
+	l := logger.Setup()
+	formats, err := export.ParseFormats(envOr("EXPORT_FORMATS", "mmdb,csv"))
+	if err != nil {
+		l.Error("export_config_error", "err", err)
+		os.Exit(1)
+	}
+	dir := envOr("EXPORT_DIR", filepath.Join("data", "export"))
+	db, err := utils.OpenPostgresFromEnv()
+	if err != nil {
+		l.Error("db_open_error", "err", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	if err := migrate.EnsureSchema(db); err != nil {
+		l.Error("schema_error", "err", err)
+		os.Exit(1)
+	}
+	v, err := unified.Collect(context.Background(), db)
+	if err != nil {
+		l.Error("export_collect_error", "err", err)
+		os.Exit(1)
+	}
+	l.Info("export_view_ready", "version", v.Meta.Version, "intervals", v.Len(), "locations", v.Meta.Locations)
+	m, err := export.Run(v, dir, formats, export.Options{
+		Source:   os.Getenv("EXPORT_SOURCE"),
+		Version:  os.Getenv("EXPORT_VERSION"),
+		Language: os.Getenv("EXPORT_LANG"),
+	})
+	if err != nil {
+		l.Error("export_error", "err", err)
+		os.Exit(1)
+	}
+	l.Info("export_ok", "dir", dir, "version", m.Version, "files", len(m.Files))
+}
+
+func envOr(name, def string) string {
+	if s := os.Getenv(name); s != "" {
+		return s
+	}
+	return def
+}
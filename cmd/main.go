@@ -3,25 +3,36 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"ip-api/internal/analytics"
 	"ip-api/internal/api"
+	"ip-api/internal/asn"
 	"ip-api/internal/fusion"
+	"ip-api/internal/hotcache"
 	"ip-api/internal/ipip"
 	"ip-api/internal/localdb"
 	"ip-api/internal/localdb/chain"
 	"ip-api/internal/localdb/exact"
+	"ip-api/internal/localdb/file"
 	"ip-api/internal/localdb/ip2region"
 	ipipcache "ip-api/internal/localdb/ipip"
+	"ip-api/internal/localdb/unified"
 	"ip-api/internal/logger"
 	"ip-api/internal/metrics"
 	"ip-api/internal/middleware"
 	"ip-api/internal/migrate"
 	"ip-api/internal/plugins"
+	"ip-api/internal/reload"
+	"ip-api/internal/revgeo"
 	"ip-api/internal/store"
 	"ip-api/internal/utils"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -62,6 +73,19 @@ func main() {
 		l.Error("schema_error", "err", err)
 		os.Exit(1)
 	}
+	// 最近查询记录：按日分区保留 RECENT_IPS_RETENTION_DAYS 天（默认 30，0 不清理）；RECENT_IPS_ANONYMIZE=true 时只记录 /24 前缀
+	st.SetRecentAnonymize(os.Getenv("RECENT_IPS_ANONYMIZE") == "true")
+	recentDays := 30
+	if n, err := strconv.Atoi(os.Getenv("RECENT_IPS_RETENTION_DAYS")); err == nil && n >= 0 {
+		recentDays = n
+	}
+	store.StartRecentRetention(context.Background(), db, recentDays)
+	// 特例段进程内索引：数据库回退查询不再逐请求扫描 _ip_cidr_special，变更经 LISTEN/NOTIFY 触发重建
+	if os.Getenv("SPECIAL_INDEX_ENABLE") != "false" {
+		six := store.NewSpecialIndex(db)
+		st.UseSpecialIndex(six)
+		six.Start(context.Background(), utils.BuildPostgresDSNFromEnv())
+	}
 
 	rc := utils.OpenRedisFromEnv()
 	if rc == nil {
@@ -74,6 +98,28 @@ func main() {
 		}
 	}
 
+	// 过期 KV 覆盖清理：自动化命名空间的覆盖到期后删除并失效结果缓存（OVERRIDE_CLEANUP_INTERVAL_SECONDS，默认 300，0 关闭）
+	cleanup := 300
+	if v := os.Getenv("OVERRIDE_CLEANUP_INTERVAL_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cleanup = n
+		}
+	}
+	store.StartKVExpiry(context.Background(), db, time.Duration(cleanup)*time.Second, func(xs []store.Expired) {
+		for _, e := range xs {
+			var err error
+			if e.Range {
+				err = hotcache.InvalidateRange(context.Background(), rc, e.Start, e.End)
+			} else {
+				err = hotcache.Invalidate(context.Background(), rc, store.FormatIP(e.Start))
+			}
+			if err != nil {
+				l.Error("kv_expiry_invalidate_error", "err", err)
+				return
+			}
+		}
+	})
+
 	// 背景：已废弃远程数据源导入；仅使用本地 IPIP 初始化与写库
 
 	// 背景：废弃自动更新，改用本地 ipip 数据源；启动时并行导入到数据库
@@ -93,6 +139,7 @@ func main() {
 				}
 				l.Info("ipip_import_begin", "lang", lang)
 				go func() {
+					defer r.Close()
 					if err := ipip.ImportIPv4LeavesToDBConcurrent(db, r, lang); err != nil {
 						l.Error("ipip_import_error", "err", err)
 					} else {
@@ -127,7 +174,9 @@ func main() {
 						l.Error("ipip_special_import_error", "err", err)
 					} else {
 						l.Info("ipip_special_import_success")
+						_ = store.NotifySpecialChanged(context.Background(), db)
 					}
+					r.Close()
 				} else {
 					l.Error("ipip_open_error", "err", err)
 				}
@@ -145,89 +194,206 @@ func main() {
 	// 文档注释：插件管理器初始化
 	// 背景：统一管理内置/外部插件，提供健康插件集合给融合层；在后台启动心跳监控。
 	pm := plugins.NewManager()
-	pm.Register(plugins.NewBuiltin("kv", "1.0", "kv", &fusion.KVSource{Store: st}))
+	pm.Register(plugins.WithCache(plugins.NewBuiltin("kv", "1.0", "kv", &fusion.KVSource{Store: st}), rc))
 	l.Info("plugin_register", "name", "kv")
-	pm.Register(plugins.NewEdgeOnePlugin())
-	l.Info("plugin_register", "name", "edgeone")
-	// 外部地理接口移除：不注册 AMap 在线插件，避免外部调用与敏感信息外泄
-	pm.Start(context.Background())
 	// 文档注释：注册反地理插件（按坐标查询）
 	// 背景：采用插件标准载入新模块；数据目录默认 data/revgeo，可通过 REVERSE_GEO_DATA_DIR 配置。
+	// 约束：需先于 EdgeOne 插件创建，EdgeOne 复用其多边形判定由经纬度推导省市。
 	dataDir := os.Getenv("REVERSE_GEO_DATA_DIR")
 	if dataDir == "" {
 		dataDir = filepath.Join("data", "revgeo")
 	}
-	if p, err := plugins.NewReverseGeoPlugin(dataDir); err == nil {
-		pm.Register(p)
+	rg, err := plugins.NewReverseGeoPlugin(dataDir)
+	if err == nil {
+		pm.Register(rg)
 		l.Info("plugin_register", "name", "revgeo")
 	} else {
 		l.Error("revgeo_init_error", "err", err)
 	}
+	// 文档注释：数据文件热重载
+	// 背景：IPIP、IP2Region 与反地理数据目录按 RELOAD_POLL_SECONDS 轮询；新文件先经文件头校验与金丝雀样本比对，
+	// 通过后原子切换到各自的 DynamicCache（链式缓存层与融合插件共用）或反地理编排器，上一可用版本保留用于回滚。
+	// 约束：替换文件应使用"写临时文件再 rename"，原地覆盖会影响仍在服务中的映射。
+	rw := reload.NewWatcher()
+	var ipipDC, ip2rDC localdb.DynamicCache
+	lang := os.Getenv("IPIP_LANG")
+	if lang == "" {
+		lang = "zh-CN"
+	}
+	canaryIPs := reload.CanaryIPs()
+	ipHit := func(c interface {
+		Lookup(string) (localdb.Location, bool)
+	}, ip string) bool {
+		loc, ok := c.Lookup(ip)
+		return ok && loc.Country != ""
+	}
+	// 文档注释：注册内置插件（依赖数据就绪）
+	// 背景：IPIP/IP2Region 作为内置插件加入融合，首次加载成功时注册；插件引用 DynamicCache，随热重载切换。
+	// 后续切换丢弃由旧数据得出的插件缓存与热点缓存。
+	var ipipOnce, ip2rOnce sync.Once
+	swapped := func(once *sync.Once, plugin string, p plugins.Plugin) {
+		once.Do(func() {
+			pm.Register(plugins.WithCache(p, rc))
+			l.Info("plugin_register", "name", plugin)
+		})
+		_, _, _ = pm.PurgeCache(context.Background(), plugin, "")
+		_ = hotcache.Invalidate(context.Background(), rc, hotcache.All)
+	}
+	reload.Watch(rw, reload.Spec[*ipipcache.IPIPCache]{
+		Name:  "ipip",
+		Path:  ipipPath,
+		Open:  func() (*ipipcache.IPIPCache, error) { return ipipcache.NewIPIPCache(ipipPath, lang) },
+		Check: reload.Canaries(canaryIPs, func(c *ipipcache.IPIPCache, ip string) bool { return ipHit(c, ip) }),
+		Apply: func(c *ipipcache.IPIPCache) {
+			ipipDC.Set(c)
+			swapped(&ipipOnce, "ipip", plugins.NewBuiltin("ipip", "1.0", "ipip", &fusion.IPIPSource{Cache: &ipipDC}))
+		},
+		Close:   func(c *ipipcache.IPIPCache) { _ = c.Close() },
+		Version: (*ipipcache.IPIPCache).DataVersion,
+	}, nil, false)
+	ip2rV4 := os.Getenv("IP2REGION_V4_PATH")
+	if ip2rV4 != "" {
+		reload.Watch(rw, reload.Spec[*ip2region.IP2RegionCache]{
+			Name:  "ip2region",
+			Path:  ip2rV4,
+			Open:  func() (*ip2region.IP2RegionCache, error) { return ip2region.NewIP2RegionCache(ip2rV4, "") },
+			Check: reload.Canaries(canaryIPs, func(c *ip2region.IP2RegionCache, ip string) bool { return ipHit(c, ip) }),
+			Apply: func(c *ip2region.IP2RegionCache) {
+				ip2rDC.Set(c)
+				swapped(&ip2rOnce, "ip2region", plugins.NewIP2RegionPlugin(&ip2rDC))
+			},
+			Close:   (*ip2region.IP2RegionCache).Close,
+			Version: (*ip2region.IP2RegionCache).DataVersion,
+		}, nil, false)
+	}
+	if rg != nil {
+		reload.Watch(rw, reload.Spec[*revgeo.Orchestrator]{
+			Name: "revgeo",
+			Path: dataDir,
+			Open: func() (*revgeo.Orchestrator, error) {
+				snap, err := revgeo.LoadSnapshot(dataDir)
+				if err != nil {
+					return nil, err
+				}
+				if len(snap.Units) == 0 && len(snap.Centroids) == 0 {
+					return nil, errors.New("revgeo: empty snapshot")
+				}
+				return revgeo.NewOrchestrator(snap), nil
+			},
+			Check: reload.Canaries(reload.CanaryPoints(), func(o *revgeo.Orchestrator, p reload.Point) bool {
+				u, _, _ := o.Query(p.Lat, p.Lon, "")
+				return u.Country != ""
+			}),
+			Apply: rg.SetOrchestrator,
+			Version: func(o *revgeo.Orchestrator) string {
+				s := o.Snapshot()
+				return fmt.Sprintf("%s units=%d centroids=%d", s.BuiltAt.UTC().Format(time.RFC3339), len(s.Units), len(s.Centroids))
+			},
+		}, rg.Orchestrator(), true)
+	}
+	reloadInterval := 10
+	if v := os.Getenv("RELOAD_POLL_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			reloadInterval = n
+		}
+	}
+	rw.Start(context.Background(), time.Duration(reloadInterval)*time.Second)
+	// 层变量保持接口类型：未配置的数据源传 nil，由链式缓存剔除
+	var ipipLayer, ip2rLayer interface {
+		Lookup(string) (localdb.Location, bool)
+	} = &ipipDC, nil
+	if ip2rV4 != "" {
+		ip2rLayer = &ip2rDC
+	}
+	// 每个启用的 CDN 地理头映射（CDN_GEO_PRESETS/CDN_GEO_HEADER_MAP）注册一个同名插件
+	gaz := revgeo.LoadGazetteer(dataDir)
+	for _, name := range middleware.NewGeoExtractorFromEnv().Providers() {
+		pm.Register(plugins.NewCDNGeoPlugin(name, rg, gaz))
+		l.Info("plugin_register", "name", name)
+	}
+	// 文档注释：注册 ASN 插件（补全运营商与 ASN）
+	// 背景：前缀映射由 asn-import 导入数据库，启动后后台加载到内存；加载完成前插件与响应补全均视为未命中。
+	var ah asn.Holder
+	pm.Register(plugins.NewASNPlugin(&ah))
+	l.Info("plugin_register", "name", "asn")
+	go func() {
+		if t, err := asn.LoadFromDB(context.Background(), db); err == nil {
+			ah.Set(t)
+		} else {
+			l.Error("asn_load_error", "err", err)
+		}
+	}()
+	// 外部地理接口移除：不注册 AMap 在线插件，避免外部调用与敏感信息外泄
+	pm.Start(context.Background())
+	// 文档注释：精确库增量层
+	// 背景：融合写回登记到内存覆盖层立即生效，文件由增量层单飞、防抖重建并定期压实；始终作为链式缓存首层，初始无文件时仅覆盖层生效。
+	ex := exact.NewOverlay(fileDir, db)
+	ex.Start(context.Background())
+	// 文档注释：统一查询库（UNIFIED_DB_ENABLE=true 时启用）
+	// 背景：范围/特例段/精确表/覆盖在编译期合并为单一区间文件，位于增量层之后；加载后 API 不再回退数据库查询。
+	var uh unified.Holder
+	unifiedDir := os.Getenv("UNIFIED_DIR")
+	if unifiedDir == "" {
+		unifiedDir = filepath.Join(fileDir, "unified")
+	}
+	if os.Getenv("UNIFIED_DB_ENABLE") == "true" {
+		interval := 3600
+		if v := os.Getenv("UNIFIED_RECOMPILE_INTERVAL_SECONDS"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				interval = n
+			}
+		}
+		uh.Start(context.Background(), db, unifiedDir, time.Duration(interval)*time.Second)
+	}
 	go func() {
 		for {
-			var haveOverrides int64
+			var haveOverrides, haveOverridesKV, haveExact int64
 			_ = db.QueryRow("SELECT COUNT(1) FROM _ip_overrides").Scan(&haveOverrides)
-			var haveOverridesKV int64
-			_ = db.QueryRow("SELECT COUNT(1) FROM _ip_overrides_kv").Scan(&haveOverridesKV)
+			_ = db.QueryRow("SELECT (SELECT COUNT(1) FROM _ip_overrides_kv) + (SELECT COUNT(1) FROM _ip_overrides_kv_range)").Scan(&haveOverridesKV)
+			_ = db.QueryRow("SELECT COUNT(1) FROM _ip_exact").Scan(&haveExact)
 			var mc interface {
 				Lookup(string) (localdb.Location, bool)
 			}
-			var exactCache interface {
-				Lookup(string) (localdb.Location, bool)
-			}
-			var iptree interface {
-				Lookup(string) (localdb.Location, bool)
-			}
-			var ip2r interface {
-				Lookup(string) (localdb.Location, bool)
-			}
-			if haveOverrides > 0 || haveOverridesKV > 0 {
-				if err := exact.BuildExactDBFromDB(fileDir, db); err == nil {
-					if edb, err := exact.NewExactDB(fileDir, db); err == nil {
-						exactCache = edb
-						l.Info("exactdb_ready")
-					}
+			switch {
+			case ex.Ready():
+			case haveOverrides > 0 || haveOverridesKV > 0 || haveExact > 0:
+				if err := ex.Rebuild(context.Background(), true); err == nil {
+					l.Info("exactdb_ready")
 				} else {
 					l.Error("exactdb_build_error", "err", err)
 				}
-			} else {
+			default:
 				l.Debug("exactdb_skip", "reason", "no_overrides")
 			}
-			lang := os.Getenv("IPIP_LANG")
-			if lang == "" {
-				lang = "zh-CN"
-			}
-			if iptree2, err := ipipcache.NewIPIPCache(ipipPath, lang); err == nil {
-				iptree = iptree2
-				l.Info("ipiptree_ready")
-			} else {
-				l.Error("ipiptree_error", "err", err)
+			// 范围文件缓存：不访问数据库的范围层，库中范围/地点条数变化时重建
+			var ranges interface {
+				Lookup(string) (localdb.Location, bool)
 			}
-			// IP2Region v4（按需）
-			ip2rV4 := os.Getenv("IP2REGION_V4_PATH")
-			if ip2rV4 != "" {
-				if c, err := ip2region.NewIP2RegionCache(ip2rV4, ""); err == nil {
-					ip2r = c
-					l.Info("ip2region_ready")
+			if os.Getenv("FILECACHE_ENABLE") != "false" {
+				rangeDir := filepath.Join(fileDir, "ranges")
+				if file.NeedsRebuild(rangeDir, db) {
+					if err := file.BuildFilesFromDB(rangeDir, db); err != nil {
+						l.Error("filecache_build_error", "err", err)
+					}
+				}
+				if fc, err := file.NewFileCache(rangeDir); err == nil {
+					ranges = fc
 				} else {
-					l.Error("ip2region_error", "err", err)
+					l.Error("filecache_open_error", "err", err)
 				}
 			}
-			if exactCache != nil || iptree != nil || ip2r != nil {
-				mc = chain.NewChainCache(exactCache, iptree, ip2r)
+			// IPIP/IP2Region 由热重载目标加载，层对象为可原子替换的 DynamicCache，首次就绪后替换无需重建链
+			if ex.Ready() || uh.Ready() || ranges != nil || ipipDC.Ready() || ip2rDC.Ready() {
+				mc = chain.NewChainCache(
+					chain.Layer{Name: "exact", Cache: ex},
+					chain.Layer{Name: "unified", Cache: &uh},
+					chain.Layer{Name: "ranges", Cache: ranges},
+					chain.Layer{Name: "ipip", Cache: ipipLayer},
+					chain.Layer{Name: "ip2region", Cache: ip2rLayer},
+				)
 				dcache.Set(mc)
 				l.Info("filecache_ready")
-				l.Debug("cache_stack", "exact", exactCache != nil, "tree", iptree != nil, "ip2r", ip2r != nil)
-				// 文档注释：注册内置插件（依赖缓存就绪）
-				// 背景：IPIP/IP2Region 作为内置插件加入融合；权重由环境变量或默认值决定。
-				if iptree != nil {
-					pm.Register(plugins.NewBuiltin("ipip", "1.0", "ipip", &fusion.IPIPSource{Cache: iptree}))
-					l.Info("plugin_register", "name", "ipip")
-				}
-				if ip2r != nil {
-					pm.Register(plugins.NewIP2RegionPlugin(ip2r))
-					l.Info("plugin_register", "name", "ip2region")
-				}
+				l.Debug("cache_stack", "exact", ex.Ready(), "unified", uh.Ready(), "ranges", ranges != nil, "tree", ipipDC.Ready(), "ip2r", ip2rDC.Ready())
 				break
 			}
 			time.Sleep(2 * time.Second)
@@ -235,7 +401,29 @@ func main() {
 	}()
 	// 外部地理接口移除：不注册进程外 HTTP 插件，避免外部调用与敏感信息外泄
 	// 文档注释：构建路由（携带动态缓存与插件管理器）
-	apiMux := api.BuildRoutes(st, rc, &dcache, pm)
+	// 小时查询统计：按端点/解析阶段/国家省份/是否命中累计，周期合并写入 _ip_stats_hourly（STATS_HOURLY_ENABLE=false 关闭）
+	var ar *analytics.Recorder
+	if strings.ToLower(os.Getenv("STATS_HOURLY_ENABLE")) != "false" {
+		retention, flush := 90, 10
+		if n, err := strconv.Atoi(os.Getenv("STATS_HOURLY_RETENTION_DAYS")); err == nil && n >= 0 {
+			retention = n
+		}
+		if n, err := strconv.Atoi(os.Getenv("STATS_FLUSH_SECONDS")); err == nil && n > 0 {
+			flush = n
+		}
+		ar = analytics.NewRecorder(db, time.Duration(retention)*24*time.Hour)
+		ar.Start(context.Background(), time.Duration(flush)*time.Second)
+	}
+	// 去重访客：访客 IP 加盐哈希后按日/月计入 HyperLogLog（配置 VISITOR_HASH_SALT 时写入 Redis、多实例共享；否则告警并进程内计数）
+	uv := analytics.NewVisitors(rc, os.Getenv("VISITOR_HASH_SALT"))
+	uv.Start(context.Background())
+	apiMux := api.BuildRoutes(st, rc, &dcache, pm, ex, &uh, &ah, ar, uv)
+	// 订阅其他进程（override-kv、amap-ingest、其他实例）的覆盖失效广播，清理本进程热点缓存
+	hotcache.Listen(context.Background(), rc)
+	// KV 覆盖变更通知（LISTEN/NOTIFY）：删除后触发精确库重建与统一库重编译，并在切换后再次失效结果缓存
+	api.StartKVSync(context.Background(), utils.BuildPostgresDSNFromEnv(), rc, ex, &uh)
+	api.RegisterPluginAdminRoutes(apiMux, pm)
+	api.RegisterReloadAdminRoutes(apiMux, rw)
 	mux.Handle(apiBase+"/", http.StripPrefix(apiBase, apiMux))
 	mux.Handle(apiBase+"/metrics", metrics.Handler())
 	mux.HandleFunc(apiBase+"/reload-exact", func(w http.ResponseWriter, r *http.Request) {
@@ -244,37 +432,29 @@ func main() {
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
-		lang := os.Getenv("IPIP_LANG")
-		if lang == "" {
-			lang = "zh-CN"
-		}
-		var exactCache interface {
-			Lookup(string) (localdb.Location, bool)
-		}
-		if err := exact.BuildExactDBFromDB(fileDir, db); err == nil {
-			if edb, err := exact.NewExactDB(fileDir, db); err == nil {
-				exactCache = edb
-				l.Info("exactdb_reloaded")
-			} else {
-				l.Error("exactdb_open_error", "err", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-		} else {
+		// 经增量层单飞重建：与后台重建合并，链式缓存引用的增量层原地切换文件，其余层保持不变
+		if err := ex.Rebuild(r.Context(), true); err != nil {
 			l.Error("exactdb_build_error", "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		var iptree interface {
-			Lookup(string) (localdb.Location, bool)
+		l.Info("exactdb_reloaded")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc(apiBase+"/reload-unified", func(w http.ResponseWriter, r *http.Request) {
+		t := r.Header.Get("x-admin-token")
+		if t == "" || t != os.Getenv("ADMIN_TOKEN") {
+			w.WriteHeader(http.StatusForbidden)
+			return
 		}
-		if iptree2, err := ipipcache.NewIPIPCache(ipipPath, lang); err == nil {
-			iptree = iptree2
-		} else {
-			l.Error("ipiptree_error", "err", err)
+		// compile=true 时先由数据库编译新版本，否则加载外部编译产出的 CURRENT 版本
+		if err := uh.Reload(r.Context(), db, unifiedDir, r.URL.Query().Get("compile") == "true"); err != nil {
+			l.Error("unified_reload_error", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
-		mc := chain.NewMultiCache(exactCache, iptree)
-		dcache.Set(mc)
+		// 新版本可能改变任意 IP 的结果：清空各实例进程内热点缓存
+		_ = hotcache.Invalidate(r.Context(), rc, hotcache.All)
 		w.WriteHeader(http.StatusNoContent)
 	})
 
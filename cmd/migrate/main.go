@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"ip-api/internal/logger"
+	"ip-api/internal/migrate"
+	"ip-api/internal/utils"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// 文档注释：数据库迁移命令
+// 用法：
+// - migrate status：列出各迁移版本、名称、执行时间（未执行为 pending，文件在执行后被修改标记 modified）；
+// - migrate up [版本]：执行至指定版本（缺省为最新）；
+// - migrate down [个数]：回退最近执行的迁移（缺省 1 个）。
+// 约束：连接参数取自 .env 与 PG_* 环境变量；与服务启动时的自动迁移共用 advisory lock，可在线执行。
+func main() {
+	_ = godotenv.Load(".env")
+	l := logger.Setup()
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	n := 0
+	if len(os.Args) > 2 {
+		v, err := strconv.Atoi(os.Args[2])
+		if err != nil || v <= 0 {
+			usage()
+			os.Exit(2)
+		}
+		n = v
+	}
+	db, err := utils.OpenPostgresFromEnv()
+	if err != nil {
+		l.Error("db_open_error", "err", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "status":
+		st, err := migrate.Status(ctx, db)
+		if err != nil {
+			l.Error("migrate_status_error", "err", err)
+			os.Exit(1)
+		}
+		for _, s := range st {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = s.AppliedAt.Local().Format(time.DateTime)
+				if s.Modified {
+					state += " modified"
+				}
+			}
+			fmt.Printf("%04d  %-32s  %s\n", s.Version, s.Name, state)
+		}
+	case "up":
+		done, err := migrate.Up(ctx, db, n)
+		report("up", done)
+		if err != nil {
+			l.Error("migrate_up_error", "err", err)
+			os.Exit(1)
+		}
+	case "down":
+		done, err := migrate.Down(ctx, db, n)
+		report("down", done)
+		if err != nil {
+			l.Error("migrate_down_error", "err", err)
+			os.Exit(1)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func report(dir string, ms []migrate.Migration) {
+	if len(ms) == 0 {
+		fmt.Println("nothing to do")
+	}
+	for _, m := range ms {
+		fmt.Printf("%s %04d_%s\n", dir, m.Version, m.Name)
+	}
+}
+
+func usage() {
+	fmt.Println("usage: migrate status | up [version] | down [steps]")
+}
@@ -2,15 +2,21 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"database/sql"
 	"fmt"
+	"ip-api/internal/hotcache"
 	"ip-api/internal/migrate"
+	"ip-api/internal/store"
 	"ip-api/internal/utils"
 	"os"
+	"os/user"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
 )
 
 func ipToInt(ip string) (uint32, error) {
@@ -30,34 +36,79 @@ func ensureSchema(db *sql.DB) error {
 	return migrate.EnsureSchema(db)
 }
 
+// tagged：标注操作人（当前系统用户）与来源，写入经触发器记入变更历史
+func tagged() context.Context {
+	actor := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		actor = u.Username
+	}
+	return store.WithChange(context.Background(), actor, "override-kv")
+}
+
+// isRange：目标为 CIDR 或 "起-止" 区间时写入区间覆盖表
+func isRange(target string) bool { return strings.ContainsAny(target, "/-") }
+
 func upsertKV(db *sql.DB, key string, ip string, country, region, province, city, isp string) error {
+	if isRange(ip) {
+		return upsertRange(db, key, ip, country, region, province, city, isp)
+	}
 	v, err := ipToInt(ip)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(`INSERT INTO _ip_overrides_kv(assoc_key, ip_int, country, region, province, city, isp)
+	_, err = store.ExecTagged(tagged(), db, `INSERT INTO _ip_overrides_kv(assoc_key, ip_int, country, region, province, city, isp)
         VALUES($1,$2,$3,$4,$5,$6,$7)
-        ON CONFLICT (assoc_key, ip_int) DO UPDATE SET country=EXCLUDED.country, region=EXCLUDED.region, province=EXCLUDED.province, city=EXCLUDED.city, isp=EXCLUDED.isp, updated_at=now()`,
+        ON CONFLICT (assoc_key, ip_int) DO UPDATE SET country=EXCLUDED.country, region=EXCLUDED.region, province=EXCLUDED.province, city=EXCLUDED.city, isp=EXCLUDED.isp, expires_at=NULL, updated_at=now()`,
 		key, int64(v), country, region, province, city, isp,
 	)
 	return err
 }
 
+func upsertRange(db *sql.DB, key string, cidr string, country, region, province, city, isp string) error {
+	start, end, err := store.ParseRange(cidr)
+	if err != nil {
+		return err
+	}
+	_, err = store.ExecTagged(tagged(), db, `INSERT INTO _ip_overrides_kv_range(assoc_key, start_int, end_int, country, region, province, city, isp)
+        VALUES($1,$2,$3,$4,$5,$6,$7,$8)
+        ON CONFLICT (assoc_key, start_int, end_int) DO UPDATE SET country=EXCLUDED.country, region=EXCLUDED.region, province=EXCLUDED.province, city=EXCLUDED.city, isp=EXCLUDED.isp, expires_at=NULL, updated_at=now()`,
+		key, int64(start), int64(end), country, region, province, city, isp,
+	)
+	return err
+}
+
 func delKV(db *sql.DB, key string, ip string) error {
+	if isRange(ip) {
+		start, end, err := store.ParseRange(ip)
+		if err != nil {
+			return err
+		}
+		_, err = store.ExecTagged(tagged(), db, `DELETE FROM _ip_overrides_kv_range WHERE assoc_key=$1 AND start_int=$2 AND end_int=$3`, key, int64(start), int64(end))
+		return err
+	}
 	v, err := ipToInt(ip)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(`DELETE FROM _ip_overrides_kv WHERE assoc_key=$1 AND ip_int=$2`, key, int64(v))
+	_, err = store.ExecTagged(tagged(), db, `DELETE FROM _ip_overrides_kv WHERE assoc_key=$1 AND ip_int=$2`, key, int64(v))
 	return err
 }
 
 func getKV(db *sql.DB, key string, ip string) (string, error) {
-	v, err := ipToInt(ip)
-	if err != nil {
-		return "", err
+	var row *sql.Row
+	if isRange(ip) {
+		start, end, err := store.ParseRange(ip)
+		if err != nil {
+			return "", err
+		}
+		row = db.QueryRow(`SELECT country, region, province, city, isp FROM _ip_overrides_kv_range WHERE assoc_key=$1 AND start_int=$2 AND end_int=$3`, key, int64(start), int64(end))
+	} else {
+		v, err := ipToInt(ip)
+		if err != nil {
+			return "", err
+		}
+		row = db.QueryRow(`SELECT country, region, province, city, isp FROM _ip_overrides_kv WHERE assoc_key=$1 AND ip_int=$2`, key, int64(v))
 	}
-	row := db.QueryRow(`SELECT country, region, province, city, isp FROM _ip_overrides_kv WHERE assoc_key=$1 AND ip_int=$2`, key, int64(v))
 	var c, r, p, ci, isp string
 	if err := row.Scan(&c, &r, &p, &ci, &isp); err != nil {
 		return "", err
@@ -66,7 +117,9 @@ func getKV(db *sql.DB, key string, ip string) (string, error) {
 }
 
 func listKV(db *sql.DB, key string, limit int) ([]string, error) {
-	rows, err := db.Query(`SELECT ip_int, country, region, province, city, isp FROM _ip_overrides_kv WHERE assoc_key=$1 ORDER BY updated_at DESC LIMIT $2`, key, limit)
+	rows, err := db.Query(`SELECT ip_int, NULL::BIGINT, country, region, province, city, isp, updated_at FROM _ip_overrides_kv WHERE assoc_key=$1
+        UNION ALL SELECT start_int, end_int, country, region, province, city, isp, updated_at FROM _ip_overrides_kv_range WHERE assoc_key=$1
+        ORDER BY updated_at DESC LIMIT $2`, key, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -74,8 +127,10 @@ func listKV(db *sql.DB, key string, limit int) ([]string, error) {
 	var out []string
 	for rows.Next() {
 		var v int64
+		var end sql.NullInt64
 		var c, r, p, ci, isp string
-		if err := rows.Scan(&v, &c, &r, &p, &ci, &isp); err != nil {
+		var at time.Time
+		if err := rows.Scan(&v, &end, &c, &r, &p, &ci, &isp, &at); err != nil {
 			return nil, err
 		}
 		a := (v >> 24) & 0xff
@@ -83,6 +138,9 @@ func listKV(db *sql.DB, key string, limit int) ([]string, error) {
 		c1 := (v >> 8) & 0xff
 		d := v & 0xff
 		ip := fmt.Sprintf("%d.%d.%d.%d", a, b, c1, d)
+		if end.Valid {
+			ip = store.FormatRange(uint32(v), uint32(end.Int64))
+		}
 		out = append(out, fmt.Sprintf("%s -> %s | %s | %s | %s | %s", ip, c, r, p, ci, isp))
 	}
 	return out, nil
@@ -93,7 +151,8 @@ func findKeys(db *sql.DB, ip string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	rows, err := db.Query(`SELECT DISTINCT assoc_key FROM _ip_overrides_kv WHERE ip_int=$1`, int64(v))
+	rows, err := db.Query(`SELECT DISTINCT assoc_key, NULL::BIGINT, NULL::BIGINT FROM _ip_overrides_kv WHERE ip_int=$1
+        UNION ALL SELECT assoc_key, start_int, end_int FROM _ip_overrides_kv_range WHERE start_int <= $1 AND end_int >= $1`, int64(v))
 	if err != nil {
 		return nil, err
 	}
@@ -101,22 +160,54 @@ func findKeys(db *sql.DB, ip string) ([]string, error) {
 	var out []string
 	for rows.Next() {
 		var k string
-		if err := rows.Scan(&k); err != nil {
+		var start, end sql.NullInt64
+		if err := rows.Scan(&k, &start, &end); err != nil {
 			return nil, err
 		}
+		if start.Valid {
+			k += " (" + store.FormatRange(uint32(start.Int64), uint32(end.Int64)) + ")"
+		}
 		out = append(out, k)
 	}
 	return out, nil
 }
 
+func formatValue(v *store.KVValue) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%s | %s | %s | %s | %s (score %.2f)", v.Country, v.Region, v.Province, v.City, v.ISP, v.Score)
+}
+
+func printHistory(db *sql.DB, ip, key string, limit int) error {
+	xs, err := store.KVHistory(context.Background(), db, ip, key, limit)
+	if err != nil {
+		return err
+	}
+	if len(xs) == 0 {
+		fmt.Println("none")
+	}
+	for _, c := range xs {
+		key := c.AssocKey
+		if c.Range != "" {
+			key += " " + c.Range
+		}
+		fmt.Printf("#%d %s %s %s by %s via %s\n", c.ID, c.ChangedAt.Local().Format("2006-01-02 15:04:05"), key, c.Op, c.Actor, c.Source)
+		fmt.Printf("    old: %s\n    new: %s\n", formatValue(c.Old), formatValue(c.New))
+	}
+	return nil
+}
+
 func printHelp() {
 	fmt.Println("commands:")
-	fmt.Println("  add <key> <ip> <country> <region> [province] [city] [isp]")
-	fmt.Println("  set <key> <ip> <country> <region> [province] [city] [isp]")
-	fmt.Println("  del <key> <ip>")
-	fmt.Println("  get <key> <ip>")
+	fmt.Println("  add <key> <ip|cidr|start-end> <country> <region> [province] [city] [isp]")
+	fmt.Println("  set <key> <ip|cidr|start-end> <country> <region> [province] [city] [isp]")
+	fmt.Println("  del <key> <ip|cidr|start-end>")
+	fmt.Println("  get <key> <ip|cidr|start-end>")
 	fmt.Println("  list <key> [limit]")
 	fmt.Println("  keys <ip>")
+	fmt.Println("  history <ip> [key] [limit]")
+	fmt.Println("  revert <id>")
 	fmt.Println("  help")
 	fmt.Println("  exit")
 }
@@ -135,6 +226,36 @@ func prompt(r *bufio.Reader, label, def string) string {
 	return s
 }
 
+// openRedis：按环境变量连接 Redis 用于广播失效；不可达时返回 nil（在线服务的热点缓存由 TTL 兜底）
+func openRedis() *redis.Client {
+	rc := utils.OpenRedisFromEnv()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := rc.Ping(ctx).Err(); err != nil {
+		fmt.Println("redis unavailable, skip invalidation:", err)
+		_ = rc.Close()
+		return nil
+	}
+	return rc
+}
+
+// invalidate：覆盖变更后删除结果缓存并通知在线服务清理热点缓存（区间目标按区间失效）
+func invalidate(rc *redis.Client, ip string) {
+	var err error
+	if isRange(ip) {
+		start, end, e := store.ParseRange(ip)
+		if e != nil {
+			return
+		}
+		err = hotcache.InvalidateRange(context.Background(), rc, start, end)
+	} else {
+		err = hotcache.Invalidate(context.Background(), rc, ip)
+	}
+	if err != nil {
+		fmt.Println("invalidate error:", err)
+	}
+}
+
 func main() {
 	var envFile string
 	for i := 1; i < len(os.Args); i++ {
@@ -146,10 +267,12 @@ func main() {
 		}
 	}
 	var db *sql.DB
+	var rc *redis.Client
 	var err error
 	if envFile != "" {
 		_ = godotenv.Load(envFile)
 		db, err = utils.OpenPostgresFromEnv()
+		rc = openRedis()
 	} else {
 		r := bufio.NewReader(os.Stdin)
 		fmt.Println("输入数据库连接参数，回车使用默认值")
@@ -196,7 +319,7 @@ func main() {
 			printHelp()
 		case "add", "set":
 			if len(parts) < 5 {
-				fmt.Println("usage: add <key> <ip> <country> <region> [province] [city] [isp]")
+				fmt.Println("usage: add <key> <ip|cidr|start-end> <country> <region> [province] [city] [isp]")
 				continue
 			}
 			key := parts[1]
@@ -218,21 +341,23 @@ func main() {
 			if err := upsertKV(db, key, ip, country, region, province, city, isp); err != nil {
 				fmt.Println("error:", err)
 			} else {
+				invalidate(rc, ip)
 				fmt.Println("ok")
 			}
 		case "del":
 			if len(parts) < 3 {
-				fmt.Println("usage: del <key> <ip>")
+				fmt.Println("usage: del <key> <ip|cidr|start-end>")
 				continue
 			}
 			if err := delKV(db, parts[1], parts[2]); err != nil {
 				fmt.Println("error:", err)
 			} else {
+				invalidate(rc, parts[2])
 				fmt.Println("ok")
 			}
 		case "get":
 			if len(parts) < 3 {
-				fmt.Println("usage: get <key> <ip>")
+				fmt.Println("usage: get <key> <ip|cidr|start-end>")
 				continue
 			}
 			s, err := getKV(db, parts[1], parts[2])
@@ -277,6 +402,43 @@ func main() {
 					fmt.Println(k)
 				}
 			}
+		case "history":
+			if len(parts) < 2 {
+				fmt.Println("usage: history <ip> [key] [limit]")
+				continue
+			}
+			key, limit := "", 20
+			for _, a := range parts[2:] {
+				if n, e := strconv.Atoi(a); e == nil && n > 0 {
+					limit = n
+				} else {
+					key = a
+				}
+			}
+			if err := printHistory(db, parts[1], key, limit); err != nil {
+				fmt.Println("error:", err)
+			}
+		case "revert":
+			if len(parts) < 2 {
+				fmt.Println("usage: revert <id>")
+				continue
+			}
+			id, err := strconv.ParseInt(strings.TrimPrefix(parts[1], "#"), 10, 64)
+			if err != nil {
+				fmt.Println("error: bad id")
+				continue
+			}
+			c, err := store.RevertKV(tagged(), db, id)
+			if err != nil {
+				fmt.Println("error:", err)
+				continue
+			}
+			target := c.IP
+			if c.Range != "" {
+				target = c.Range
+			}
+			invalidate(rc, target)
+			fmt.Printf("ok: %s %s -> %s\n", c.AssocKey, target, formatValue(c.Old))
 		default:
 			fmt.Println("unknown command")
 		}
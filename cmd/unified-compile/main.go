@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"ip-api/internal/localdb/unified"
+	"ip-api/internal/logger"
+	"ip-api/internal/migrate"
+	"ip-api/internal/utils"
+	"os"
+	"path/filepath"
+
+	"github.com/joho/godotenv"
+)
+
+// 文档注释：编译统一查询库
+// 背景：离线或定时任务中编译，服务进程经 POST /api/reload-unified 加载 CURRENT 指向的新版本；
+// 多实例共享目录时只需一处编译。
+// 约束：UNIFIED_DIR 为输出目录（默认 data/localdb/unified）；编译失败时不更新 CURRENT，线上继续使用旧版本。
+func main() {
+	_ = godotenv.Load(".env")
+	l := logger.Setup()
+	dir := os.Getenv("UNIFIED_DIR")
+	if dir == "" {
+		dir = filepath.Join("data", "localdb", "unified")
+	}
+	db, err := utils.OpenPostgresFromEnv()
+	if err != nil {
+		l.Error("db_open_error", "err", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	if err := migrate.EnsureSchema(db); err != nil {
+		l.Error("schema_error", "err", err)
+		os.Exit(1)
+	}
+	meta, err := unified.Compile(context.Background(), db, dir)
+	if err != nil {
+		l.Error("unified_compile_error", "err", err)
+		os.Exit(1)
+	}
+	d, err := unified.OpenCurrent(dir)
+	if err != nil {
+		l.Error("unified_verify_error", "err", err)
+		os.Exit(1)
+	}
+	d.Close()
+	l.Info("unified_compile_ok", "version", meta.Version, "intervals", meta.Intervals, "ranges", meta.Ranges, "specials", meta.Specials,
+		"exact", meta.Exact, "overrides", meta.Overrides, "overrides_kv", meta.OverridesKV, "overrides_kv_range", meta.OverridesKVRange)
+}
@@ -4,75 +4,169 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"ip-api/internal/logger"
 	"ip-api/internal/metrics"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
-// 文档注释：高德 IP 定位响应结构
-// 背景：对齐高德 REST API 的返回字段，仅解析本方案需要的省/市/编码等信息；用于离线融合与入库。
-// 约束：status/infocode 用于错误判定与分类聚合；不在此处扩展对外响应模型。
-type IPResponse struct {
-	Status    string `json:"status"`
-	Info      string `json:"info"`
-	Infocode  string `json:"infocode"`
-	Province  string `json:"province"`
-	City      string `json:"city"`
-	Adcode    string `json:"adcode"`
-	Rectangle string `json:"rectangle"`
+// 文档注释：高德 REST 客户端
+// 背景：外部接口存在限流与偶发网关错误，单次调用失败即降级会浪费采集机会；客户端统一负责重试退避、错误分类与每日配额计数。
+// 约束：仅对可重试错误（网络错误、HTTP 5xx/429、限流类 infocode）重试；每次 HTTP 调用（含重试）均计入配额。
+type Client struct {
+	Key         string
+	HTTP        *http.Client
+	RetryMax    int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	Quota       *Quota
+	// Endpoint 为接口地址，默认 https://restapi.amap.com/v3/ip
+	Endpoint string
 }
 
-// 文档注释：查询单个 IP 的定位信息（REST）
+// 文档注释：按环境变量构造客户端
+// 参数：key 为服务端密钥；hc 为可选 HTTP 客户端（为空时使用 5s 超时）；rc 为可选 Redis 客户端（共享配额计数）。
+// 约束：AMAP_RETRY_MAX（默认 2）、AMAP_RETRY_BASE_MS（默认 200）、AMAP_RETRY_MAX_MS（默认 3000）、AMAP_DAILY_QUOTA（默认 0 不限额）。
+func NewClientFromEnv(key string, hc *http.Client, rc *redis.Client) *Client {
+	if hc == nil {
+		hc = &http.Client{Timeout: 5 * time.Second}
+	}
+	return &Client{
+		Key:         key,
+		HTTP:        hc,
+		RetryMax:    envInt("AMAP_RETRY_MAX", 2),
+		BaseBackoff: time.Duration(envInt("AMAP_RETRY_BASE_MS", 200)) * time.Millisecond,
+		MaxBackoff:  time.Duration(envInt("AMAP_RETRY_MAX_MS", 3000)) * time.Millisecond,
+		Quota:       NewQuota(rc, int64(envInt("AMAP_DAILY_QUOTA", 0))),
+	}
+}
+
+// 文档注释：查询单个 IP 的定位信息（REST，带重试）
 // 为什么：离线采集阶段调用外部数据源，补充城市级信息用于融合入库；与在线查询链路解耦，避免引入外部不确定性。
-// 参数：
-// - ctx：请求上下文，用于控制超时与取消；
-// - client：HTTP 客户端，可传入共享实例；为空时使用 5s 超时的默认客户端；
-// - key：高德 Web 服务 API 的后端密钥，必填；
-// - ip：目标 IPv4 文本；为空时由高德按来源定位，不推荐在离线作业使用。
-// 返回：解析后的响应结构；当 status!="1" 时返回错误并附带响应内容以便上层记录。
-// 约束：仅支持国内 IPv4；错误与无数据由上层统一降级处理。
-func QueryIP(ctx context.Context, client *http.Client, key string, ip string) (*IPResponse, error) {
-	if key == "" {
+// 参数：ip 为目标 IPv4 文本；为空时由高德按来源定位，不推荐在离线作业使用。
+// 返回：解析后的响应结构；status!="1" 时返回 *Error（附带响应）；配额耗尽返回 ErrQuotaExceeded。
+// 约束：仅支持国内 IPv4；退避为带抖动的指数退避（full jitter），ctx 取消时立即返回。
+func (c *Client) QueryIP(ctx context.Context, ip string) (*IPResponse, error) {
+	if c == nil || c.Key == "" {
 		return nil, errors.New("missing key")
 	}
+	var lastResp *IPResponse
+	var lastErr error
+	for attempt := 0; attempt <= c.RetryMax; attempt++ {
+		if attempt > 0 {
+			metrics.AMapRetriesTotal.Inc()
+			if err := sleepCtx(ctx, c.backoff(attempt)); err != nil {
+				return lastResp, lastErr
+			}
+		}
+		if err := c.Quota.Reserve(ctx); err != nil {
+			metrics.AMapErrorsTotal.WithLabelValues("quota", "").Inc()
+			logger.L().Debug("amap_quota_reject", "ip", ip)
+			return nil, err
+		}
+		r, retry, err := c.doOnce(ctx, ip)
+		if err == nil {
+			metrics.AMapSuccessTotal.Inc()
+			return r, nil
+		}
+		lastResp, lastErr = r, err
+		metrics.AMapFailTotal.Inc()
+		var ae *Error
+		code := ""
+		if errors.As(err, &ae) {
+			code = ae.Infocode
+			if ae.Quota {
+				c.Quota.MarkExhausted(ctx)
+			}
+		}
+		metrics.AMapErrorsTotal.WithLabelValues(errorClass(err), code).Inc()
+		logger.L().Debug("amap_attempt_fail", "ip", ip, "attempt", attempt, "retry", retry, "err", err)
+		if !retry {
+			break
+		}
+	}
+	return lastResp, lastErr
+}
+
+// 文档注释：单次 HTTP 调用
+// 返回：响应、是否可重试、错误。
+func (c *Client) doOnce(ctx context.Context, ip string) (*IPResponse, bool, error) {
 	q := url.Values{}
-	q.Set("key", key)
+	q.Set("key", c.Key)
 	if ip != "" {
 		q.Set("ip", ip)
 	}
-	u := "https://restapi.amap.com/v3/ip?" + q.Encode()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
-	if err != nil {
-		return nil, err
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://restapi.amap.com/v3/ip"
 	}
-	if client == nil {
-		client = &http.Client{Timeout: 5 * time.Second}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, false, err
 	}
 	t0 := time.Now()
 	metrics.AMapRequestsTotal.Inc()
 	logger.L().Debug("amap_req", "ip", ip)
-	resp, err := client.Do(req)
+	resp, err := c.HTTP.Do(req)
 	if err != nil {
 		logger.L().Error("amap_http_error", "err", err)
-		metrics.AMapFailTotal.Inc()
-		return nil, err
+		return nil, ctx.Err() == nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return nil, true, fmt.Errorf("amap http status %d", resp.StatusCode)
+	}
 	var r IPResponse
 	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
 		logger.L().Error("amap_decode_error", "err", err)
-		metrics.AMapFailTotal.Inc()
-		return nil, err
+		return nil, false, err
 	}
 	dur := time.Since(t0).Milliseconds()
 	metrics.AMapDurationMs.Observe(float64(dur))
-	logger.L().Debug("amap_resp", "ip", ip, "status", r.Status, "infocode", r.Infocode, "province", r.Province, "city", r.City, "duration_ms", dur)
+	logger.L().Debug("amap_resp", "ip", ip, "status", r.Status, "infocode", r.Infocode, "province", r.Province, "city", r.City, "adcode", r.Adcode, "duration_ms", dur)
 	if r.Status != "1" {
-		metrics.AMapFailTotal.Inc()
-		return &r, errors.New("amap error")
+		e := classify(r.Infocode, r.Info)
+		return &r, e.Retryable, e
+	}
+	return &r, false, nil
+}
+
+// 文档注释：第 attempt 次重试前的等待时长（full jitter）
+func (c *Client) backoff(attempt int) time.Duration {
+	base := c.BaseBackoff
+	if base <= 0 {
+		base = 200 * time.Millisecond
+	}
+	ceil := base << uint(min(attempt-1, 16))
+	if c.MaxBackoff > 0 && ceil > c.MaxBackoff {
+		ceil = c.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(ceil) + 1))
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func envInt(name string, def int) int {
+	if s := os.Getenv(name); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			return n
+		}
 	}
-	metrics.AMapSuccessTotal.Inc()
-	return &r, nil
+	return def
 }
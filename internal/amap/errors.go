@@ -0,0 +1,76 @@
+package amap
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrQuotaExceeded：当日配额已用尽（本地计数或高德返回超限）
+var ErrQuotaExceeded = errors.New("amap daily quota exceeded")
+
+// 文档注释：高德接口错误
+// 背景：高德以 infocode 区分错误类型；重试只对限流/服务端繁忙等暂时性错误有意义，密钥/参数错误重试只会浪费配额。
+// 约束：Retryable 为 true 时调用方可退避重试；Quota 为 true 表示当日配额耗尽（不重试并标记配额）。
+type Error struct {
+	Infocode  string
+	Info      string
+	Retryable bool
+	Quota     bool
+}
+
+func (e *Error) Error() string {
+	return "amap error: infocode=" + e.Infocode + " info=" + e.Info
+}
+
+// 文档注释：infocode 分类表
+// 背景：依据高德 Web 服务错误码说明整理；未列出的 1xxxx/2xxxx 视为不可重试，3xxxx（服务引擎错误）视为可重试。
+var (
+	retryableCodes = map[string]bool{
+		"10004": true, // ACCESS_TOO_FREQUENT
+		"10014": true, // QPS_HAS_EXCEEDED_THE_LIMIT
+		"10015": true, // GATEWAY_TIMEOUT
+		"10016": true, // SERVER_IS_BUSY
+		"10017": true, // RESOURCE_UNAVAILABLE
+		"10019": true, // CQPS_HAS_EXCEEDED_THE_LIMIT
+		"10020": true, // CKQPS_HAS_EXCEEDED_THE_LIMIT
+		"10021": true, // CUQPS_HAS_EXCEEDED_THE_LIMIT
+		"20003": true, // UNKNOWN_ERROR
+	}
+	quotaCodes = map[string]bool{
+		"10003": true, // DAILY_QUERY_OVER_LIMIT
+		"10029": true, // ABROAD_DAILY_QUERY_OVER_LIMIT
+		"10044": true, // USER_DAILY_QUERY_OVER_LIMIT
+		"10045": true, // USER_ABROAD_DAILY_QUERY_OVER_LIMIT
+	}
+)
+
+// 文档注释：按 infocode 构造分类错误
+func classify(infocode, info string) *Error {
+	e := &Error{Infocode: infocode, Info: info}
+	switch {
+	case quotaCodes[infocode]:
+		e.Quota = true
+	case retryableCodes[infocode]:
+		e.Retryable = true
+	case strings.HasPrefix(infocode, "3") && len(infocode) == 5:
+		e.Retryable = true
+	}
+	return e
+}
+
+// 文档注释：错误类别（用于指标标签）
+func errorClass(err error) string {
+	var ae *Error
+	switch {
+	case errors.Is(err, ErrQuotaExceeded):
+		return "quota"
+	case errors.As(err, &ae) && ae.Quota:
+		return "quota"
+	case errors.As(err, &ae) && ae.Retryable:
+		return "retryable"
+	case errors.As(err, &ae):
+		return "fatal"
+	default:
+		return "transport"
+	}
+}
@@ -0,0 +1,108 @@
+package amap
+
+import (
+	"context"
+	"ip-api/internal/logger"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 高德配额按北京时间自然日重置；使用固定时区避免依赖系统 tzdata
+var quotaZone = time.FixedZone("CST", 8*3600)
+
+// 文档注释：每日配额计数器
+// 背景：原 minuteLimiter 仅在单进程内限速，多个采集进程与在线插件同时调用时会超出账号日配额；计数放在 Redis 中由所有实例共享。
+// 约束：limit<=0 表示不限额（仍记录用量）；Redis 不可用时回退为进程内计数；高德返回超限错误时标记当日耗尽，后续调用直接拒绝。
+type Quota struct {
+	rc    *redis.Client
+	limit int64
+	mu    sync.Mutex
+	day   string
+	used  int64
+	full  bool
+}
+
+func NewQuota(rc *redis.Client, limit int64) *Quota {
+	return &Quota{rc: rc, limit: limit}
+}
+
+func quotaDay() string { return time.Now().In(quotaZone).Format("20060102") }
+
+// 文档注释：预占一次调用配额
+// 返回：超出当日配额或已标记耗尽时返回 ErrQuotaExceeded；计数失败不阻断调用。
+// NOTE: 超限时不回退计数，当日剩余时间内所有调用都会被拒绝，计数值仅用于观测。
+func (q *Quota) Reserve(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+	day := quotaDay()
+	if q.rc != nil {
+		key := "amap:quota:" + day
+		if n, _ := q.rc.Exists(ctx, key+":full").Result(); n > 0 {
+			return ErrQuotaExceeded
+		}
+		pipe := q.rc.TxPipeline()
+		incr := pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, 48*time.Hour)
+		_, err := pipe.Exec(ctx)
+		if err == nil {
+			if q.limit > 0 && incr.Val() > q.limit {
+				return ErrQuotaExceeded
+			}
+			return nil
+		}
+		logger.L().Debug("amap_quota_redis_error", "err", err)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.day != day {
+		q.day, q.used, q.full = day, 0, false
+	}
+	if q.full {
+		return ErrQuotaExceeded
+	}
+	q.used++
+	if q.limit > 0 && q.used > q.limit {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// 文档注释：标记当日配额耗尽
+// 背景：本地计数可能与高德侧不一致（其他系统共用密钥），以高德返回的超限错误为准。
+func (q *Quota) MarkExhausted(ctx context.Context) {
+	if q == nil {
+		return
+	}
+	day := quotaDay()
+	if q.rc != nil {
+		_ = q.rc.Set(ctx, "amap:quota:"+day+":full", 1, 48*time.Hour).Err()
+	}
+	q.mu.Lock()
+	if q.day != day {
+		q.day, q.used = day, 0
+	}
+	q.full = true
+	q.mu.Unlock()
+	logger.L().Warn("amap_quota_exhausted", "day", day)
+}
+
+// 文档注释：当日已用配额
+func (q *Quota) Used(ctx context.Context) int64 {
+	if q == nil {
+		return 0
+	}
+	if q.rc != nil {
+		if n, err := q.rc.Get(ctx, "amap:quota:"+quotaDay()).Int64(); err == nil {
+			return n
+		}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.day != quotaDay() {
+		return 0
+	}
+	return q.used
+}
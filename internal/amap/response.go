@@ -0,0 +1,123 @@
+package amap
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 文档注释：多态字符串字段
+// 背景：高德在无法定位（如境外/局域网 IP）时将 province/city/adcode/rectangle 返回为空数组 `[]` 而非字符串，直接按 string 解码会整体失败。
+// 约束：字符串原样保留；数组取非空元素以 "|" 连接（空数组为空串）；数字转为十进制文本；null 为空串。
+type flexString string
+
+func (f *flexString) UnmarshalJSON(b []byte) error {
+	s := strings.TrimSpace(string(b))
+	switch {
+	case s == "" || s == "null":
+		*f = ""
+	case s[0] == '"':
+		var v string
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		*f = flexString(v)
+	case s[0] == '[':
+		var arr []flexString
+		if err := json.Unmarshal(b, &arr); err != nil {
+			return err
+		}
+		parts := make([]string, 0, len(arr))
+		for _, v := range arr {
+			if v != "" {
+				parts = append(parts, string(v))
+			}
+		}
+		*f = flexString(strings.Join(parts, "|"))
+	default:
+		var n json.Number
+		if err := json.Unmarshal(b, &n); err != nil {
+			return err
+		}
+		*f = flexString(n.String())
+	}
+	return nil
+}
+
+// 文档注释：高德 IP 定位响应结构
+// 背景：对齐高德 REST API 的返回字段，仅解析本方案需要的省/市/编码等信息；用于离线融合与入库。
+// 约束：status/infocode 用于错误判定与分类聚合；不在此处扩展对外响应模型；字段均按多态字符串解码。
+type IPResponse struct {
+	Status    string `json:"-"`
+	Info      string `json:"-"`
+	Infocode  string `json:"-"`
+	Province  string `json:"-"`
+	City      string `json:"-"`
+	Adcode    string `json:"-"`
+	Rectangle string `json:"-"`
+}
+
+func (r *IPResponse) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Status    flexString `json:"status"`
+		Info      flexString `json:"info"`
+		Infocode  flexString `json:"infocode"`
+		Province  flexString `json:"province"`
+		City      flexString `json:"city"`
+		Adcode    flexString `json:"adcode"`
+		Rectangle flexString `json:"rectangle"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*r = IPResponse{
+		Status:    string(raw.Status),
+		Info:      string(raw.Info),
+		Infocode:  string(raw.Infocode),
+		Province:  string(raw.Province),
+		City:      string(raw.City),
+		Adcode:    string(raw.Adcode),
+		Rectangle: string(raw.Rectangle),
+	}
+	return nil
+}
+
+// 文档注释：解析定位矩形为中心点与半径
+// 背景：rectangle 形如 "116.01,39.66;116.78,40.21"（左下;右上，GCJ-02 经纬度），表示定位所在城市范围；半径可用于评估定位粒度。
+// 返回：中心点纬度/经度、中心到角点的球面距离（公里）；格式非法时 ok 为 false。
+func (r *IPResponse) Centroid() (lat, lon, radiusKm float64, ok bool) {
+	a, b, found := strings.Cut(r.Rectangle, ";")
+	if !found {
+		return 0, 0, 0, false
+	}
+	lon1, lat1, ok1 := parseLonLat(a)
+	lon2, lat2, ok2 := parseLonLat(b)
+	if !ok1 || !ok2 {
+		return 0, 0, 0, false
+	}
+	lat, lon = (lat1+lat2)/2, (lon1+lon2)/2
+	return lat, lon, haversineKm(lat, lon, lat2, lon2), true
+}
+
+func parseLonLat(s string) (float64, float64, bool) {
+	x, y, ok := strings.Cut(strings.TrimSpace(s), ",")
+	if !ok {
+		return 0, 0, false
+	}
+	lon, e1 := strconv.ParseFloat(strings.TrimSpace(x), 64)
+	lat, e2 := strconv.ParseFloat(strings.TrimSpace(y), 64)
+	if e1 != nil || e2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return 0, 0, false
+	}
+	return lon, lat, true
+}
+
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const r = 6371.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * r * math.Asin(math.Sqrt(h))
+}
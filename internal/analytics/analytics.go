@@ -0,0 +1,159 @@
+// 包 analytics：查询量按小时聚合（端点、解析阶段、国家/省份、是否命中）与去重访客计数，供 /api/stats 输出时间序列、分组统计与 DAU/MAU
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"ip-api/internal/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event：一次查询的统计维度
+// - Endpoint：接口名（ip/asn）；
+// - Stage：给出结果的解析阶段（hot/kv/redis/缓存层名/db/fusion，无结果为 none）；
+// - Country/Province：结果中的国家与省份（空结果为空串）；
+// - Hit：结果非空。
+type Event struct {
+	Endpoint string
+	Stage    string
+	Country  string
+	Province string
+	Hit      bool
+}
+
+type key struct {
+	hour int64
+	Event
+}
+
+// 缓冲条目上限：数据库长时间不可用时丢弃新维度组合，避免内存无限增长
+const maxPending = 100000
+
+// 每条 INSERT 合并的行数
+const flushBatch = 500
+
+// 文档注释：小时聚合记录器
+// 背景：逐请求写库会把查询量直接放大为写入量；Record 只在进程内累加，Start 定期把增量合并写入 _ip_stats_hourly（多实例累加互不覆盖）。
+// 约束：
+// - 小时按 UTC 截断；
+// - 写入失败的增量保留到下一次合并；
+// - nil 记录器的 Record 为空操作（统计关闭）。
+type Recorder struct {
+	db        *sql.DB
+	retention time.Duration
+
+	mu      sync.Mutex
+	pending map[key]int64
+}
+
+// NewRecorder：retention 为小时聚合的保留时长（≤0 不清理）
+func NewRecorder(db *sql.DB, retention time.Duration) *Recorder {
+	return &Recorder{db: db, retention: retention, pending: map[key]int64{}}
+}
+
+// Record：累加一次查询
+func (r *Recorder) Record(ev Event) {
+	if r == nil {
+		return
+	}
+	k := key{hour: time.Now().UTC().Truncate(time.Hour).Unix(), Event: ev}
+	r.mu.Lock()
+	if _, ok := r.pending[k]; ok || len(r.pending) < maxPending {
+		r.pending[k]++
+	}
+	r.mu.Unlock()
+}
+
+// 文档注释：启动定期合并
+// 参数：interval 为合并周期；ctx 结束时执行最后一次合并后退出。
+// NOTE: 过期数据每小时清理一次（多实例重复执行无副作用）。
+func (r *Recorder) Start(ctx context.Context, interval time.Duration) {
+	if r == nil || interval <= 0 {
+		return
+	}
+	l := logger.L()
+	go func() {
+		tk := time.NewTicker(interval)
+		defer tk.Stop()
+		var pruned time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				if err := r.Flush(context.WithoutCancel(ctx)); err != nil {
+					l.Error("stats_hourly_flush_error", "err", err)
+				}
+				return
+			case <-tk.C:
+			}
+			if err := r.Flush(ctx); err != nil {
+				l.Error("stats_hourly_flush_error", "err", err)
+			}
+			if r.retention > 0 && time.Since(pruned) >= time.Hour {
+				pruned = time.Now()
+				if _, err := r.db.ExecContext(ctx, "DELETE FROM _ip_stats_hourly WHERE hour < $1", time.Now().Add(-r.retention)); err != nil {
+					l.Error("stats_hourly_prune_error", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Flush：把累计的增量写入数据库；失败的批次放回缓冲
+func (r *Recorder) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	batch := r.pending
+	r.pending = map[key]int64{}
+	r.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	keys := make([]key, 0, len(batch))
+	for k := range batch {
+		keys = append(keys, k)
+	}
+	for i := 0; i < len(keys); i += flushBatch {
+		part := keys[i:min(i+flushBatch, len(keys))]
+		if err := r.write(ctx, part, batch); err != nil {
+			r.restore(keys[i:], batch)
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) write(ctx context.Context, keys []key, counts map[key]int64) error {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO _ip_stats_hourly(hour, endpoint, stage, country, province, hit, queries) VALUES ")
+	args := make([]any, 0, len(keys)*7)
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		n := i * 7
+		sb.WriteString("(")
+		for j := 1; j <= 7; j++ {
+			if j > 1 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString("$" + strconv.Itoa(n+j))
+		}
+		sb.WriteString(")")
+		args = append(args, time.Unix(k.hour, 0).UTC(), k.Endpoint, k.Stage, k.Country, k.Province, k.Hit, counts[k])
+	}
+	sb.WriteString(" ON CONFLICT (hour, endpoint, stage, country, province, hit) DO UPDATE SET queries = _ip_stats_hourly.queries + EXCLUDED.queries")
+	_, err := r.db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+func (r *Recorder) restore(keys []key, counts map[key]int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range keys {
+		if _, ok := r.pending[k]; ok || len(r.pending) < maxPending {
+			r.pending[k] += counts[k]
+		}
+	}
+}
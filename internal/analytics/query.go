@@ -0,0 +1,120 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 查询窗口上限（与默认保留期一致）
+const maxRange = 90 * 24 * time.Hour
+
+// Point：时间序列中的一小时
+type Point struct {
+	Hour    time.Time `json:"hour"`
+	Queries int64     `json:"queries"`
+	Hits    int64     `json:"hits"`
+}
+
+// Group：分组统计
+type Group struct {
+	Key     string `json:"key"`
+	Queries int64  `json:"queries"`
+	Hits    int64  `json:"hits"`
+}
+
+// groupCols：允许的分组维度 → 列表达式
+var groupCols = map[string]string{
+	"endpoint": "endpoint",
+	"stage":    "stage",
+	"country":  "country",
+	"province": "province",
+	"hit":      "CASE WHEN hit THEN 'hit' ELSE 'empty' END",
+}
+
+var (
+	ErrBadRange = errors.New("analytics: bad range (use e.g. 24h, 7d; max 90d)")
+	ErrBadGroup = errors.New("analytics: bad group (endpoint, stage, country, province, hit)")
+)
+
+// 文档注释：解析统计窗口
+// 参数：s 为 "<n>h" 或 "<n>d"。
+// 返回：窗口时长；格式错误、非正或超过 90 天时返回 ErrBadRange。
+func ParseRange(s string) (time.Duration, error) {
+	unit := time.Hour
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case !strings.HasSuffix(s, "h"):
+		return 0, ErrBadRange
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 || time.Duration(n)*unit > maxRange {
+		return 0, ErrBadRange
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// 文档注释：小时时间序列
+// 返回：从 since 所在小时到当前小时逐小时的点，无数据的小时补零。
+// NOTE: 各实例按 STATS_FLUSH_SECONDS 周期合并写入，当前小时的数据有相应延迟。
+func Series(ctx context.Context, db *sql.DB, since time.Time) ([]Point, error) {
+	from := since.UTC().Truncate(time.Hour)
+	rows, err := db.QueryContext(ctx, `SELECT hour, SUM(queries), SUM(queries) FILTER (WHERE hit)
+        FROM _ip_stats_hourly WHERE hour >= $1 GROUP BY hour`, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	got := map[int64]Point{}
+	for rows.Next() {
+		var p Point
+		var hits sql.NullInt64
+		if err := rows.Scan(&p.Hour, &p.Queries, &hits); err != nil {
+			return nil, err
+		}
+		p.Hits = hits.Int64
+		got[p.Hour.Unix()] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var out []Point
+	for h := from; !h.After(time.Now()); h = h.Add(time.Hour) {
+		p, ok := got[h.Unix()]
+		if !ok {
+			p = Point{}
+		}
+		p.Hour = h
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// 文档注释：按维度分组统计
+// 参数：group 为 endpoint/stage/country/province/hit；limit 为返回组数上限（按查询量降序）。
+// 返回：维度不支持时返回 ErrBadGroup。
+func Breakdown(ctx context.Context, db *sql.DB, since time.Time, group string, limit int) ([]Group, error) {
+	col, ok := groupCols[group]
+	if !ok {
+		return nil, ErrBadGroup
+	}
+	rows, err := db.QueryContext(ctx, `SELECT `+col+` AS k, SUM(queries) AS q, COALESCE(SUM(queries) FILTER (WHERE hit), 0)
+        FROM _ip_stats_hourly WHERE hour >= $1 GROUP BY k ORDER BY q DESC LIMIT $2`, since.UTC().Truncate(time.Hour), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Group{}
+	for rows.Next() {
+		var g Group
+		if err := rows.Scan(&g.Key, &g.Queries, &g.Hits); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,209 @@
+package analytics
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"ip-api/internal/logger"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis 键：按日与按月的访客 HLL（日键保留 35 天以覆盖周统计与回溯，月键保留 62 天）
+const (
+	visitorDayPrefix   = "hll:visitors:d:"
+	visitorMonthPrefix = "hll:visitors:m:"
+	visitorDayTTL      = 35 * 24 * time.Hour
+	visitorMonthTTL    = 62 * 24 * time.Hour
+)
+
+// Uniques：去重访客数（当日、最近 7 日、当月）
+type Uniques struct {
+	DAU int64 `json:"dau"`
+	WAU int64 `json:"wau"`
+	MAU int64 `json:"mau"`
+}
+
+// 登记队列容量与每次合并写入的上限；队列满时丢弃（统计允许少量误差，不阻塞请求）
+const (
+	visitorQueue = 8192
+	visitorBatch = 1000
+)
+
+// 文档注释：去重访客计数
+// 背景：IncrStats 的访客计数只是“通过布隆去重的请求数”，同一访客跨去重窗口会重复计数；
+// 这里按日、按月对访客 IP 的哈希做 HyperLogLog 计数（Redis PFADD/PFCOUNT，多实例共享），误差约 0.81%。
+// 约束：
+// - 访客 IP 加盐哈希后写入；IPv4 空间可被穷举，盐必须保密，多实例须使用同一盐；未设置盐时不写入 Redis（见 NewVisitors）；
+// - Add 只入队，后台协程按秒合并为一次管道写入，请求路径不访问 Redis；
+// - 日/月按进程本地时区划分；
+// - 未配置 Redis 或 Redis 出错时使用进程内 HLL（只保留最近 7 日与最近两个月，重启清零，多实例不合并）；
+// - nil 计数器的方法为空操作。
+type Visitors struct {
+	rc   *redis.Client
+	salt string
+	ch   chan string
+
+	mu     sync.Mutex
+	days   map[string]*hll
+	months map[string]*hll
+}
+
+// 文档注释：创建去重访客计数
+// 参数：rc 为空时只用进程内计数；salt 为访客 IP 哈希的盐。
+// NOTE: salt 为空时记录告警并改用进程内计数与随机盐：共享 Redis 键须各实例使用同一保密盐，
+// 随机盐会让同一访客在不同实例重复计数，固定的默认盐又可被穷举还原 IP。
+func NewVisitors(rc *redis.Client, salt string) *Visitors {
+	if salt == "" {
+		if rc != nil {
+			logger.L().Warn("visitors_salt_missing", "fallback", "in_process")
+			rc = nil
+		}
+		var b [16]byte
+		_, _ = rand.Read(b[:])
+		salt = hex.EncodeToString(b[:])
+	}
+	return &Visitors{rc: rc, salt: salt, ch: make(chan string, visitorQueue), days: map[string]*hll{}, months: map[string]*hll{}}
+}
+
+// Add：登记一次访问（非阻塞）
+func (v *Visitors) Add(visitor string) {
+	if v == nil || visitor == "" {
+		return
+	}
+	select {
+	case v.ch <- visitor:
+	default:
+		logger.L().Debug("visitors_queue_full")
+	}
+}
+
+// 文档注释：启动后台合并写入
+// NOTE: 每秒或攒满一批时写入一次；ctx 结束时写入剩余条目后退出。
+func (v *Visitors) Start(ctx context.Context) {
+	if v == nil {
+		return
+	}
+	go func() {
+		tk := time.NewTicker(time.Second)
+		defer tk.Stop()
+		batch := make([]string, 0, visitorBatch)
+		for {
+			select {
+			case <-ctx.Done():
+				v.flush(context.WithoutCancel(ctx), batch)
+				return
+			case x := <-v.ch:
+				if batch = append(batch, x); len(batch) < visitorBatch {
+					continue
+				}
+			case <-tk.C:
+			}
+			v.flush(ctx, batch)
+			batch = batch[:0]
+		}
+	}()
+}
+
+func (v *Visitors) flush(ctx context.Context, batch []string) {
+	if len(batch) == 0 {
+		return
+	}
+	now := time.Now()
+	day, month := now.Format("20060102"), now.Format("200601")
+	sums := make([][sha256.Size]byte, len(batch))
+	for i, x := range batch {
+		sums[i] = sha256.Sum256([]byte(v.salt + "|" + x))
+	}
+	if v.rc != nil {
+		ids := make([]any, len(sums))
+		for i := range sums {
+			ids[i] = hex.EncodeToString(sums[i][:16])
+		}
+		_, err := v.rc.Pipelined(ctx, func(p redis.Pipeliner) error {
+			p.PFAdd(ctx, visitorDayPrefix+day, ids...)
+			p.Expire(ctx, visitorDayPrefix+day, visitorDayTTL)
+			p.PFAdd(ctx, visitorMonthPrefix+month, ids...)
+			p.Expire(ctx, visitorMonthPrefix+month, visitorMonthTTL)
+			return nil
+		})
+		if err == nil {
+			return
+		}
+		logger.L().Debug("visitors_pfadd_error", "err", err)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	d, m := localHLL(v.days, day, 7), localHLL(v.months, month, 2)
+	for i := range sums {
+		x := binary.BigEndian.Uint64(sums[i][:8])
+		d.add(x)
+		m.add(x)
+	}
+}
+
+// 文档注释：读取去重访客数
+// 返回：DAU 为当日，WAU 为含当日的最近 7 日并集，MAU 为当月；Redis 出错时返回进程内计数。
+func (v *Visitors) Counts(ctx context.Context) Uniques {
+	if v == nil {
+		return Uniques{}
+	}
+	now := time.Now()
+	week := make([]string, 7)
+	for i := range week {
+		week[i] = visitorDayPrefix + now.AddDate(0, 0, -i).Format("20060102")
+	}
+	if v.rc != nil {
+		var d, w, m *redis.IntCmd
+		_, err := v.rc.Pipelined(ctx, func(p redis.Pipeliner) error {
+			d = p.PFCount(ctx, week[0])
+			w = p.PFCount(ctx, week...)
+			m = p.PFCount(ctx, visitorMonthPrefix+now.Format("200601"))
+			return nil
+		})
+		if err == nil {
+			return Uniques{DAU: d.Val(), WAU: w.Val(), MAU: m.Val()}
+		}
+		logger.L().Debug("visitors_pfcount_error", "err", err)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var u Uniques
+	var union hll
+	for i, k := range week {
+		if h, ok := v.days[k[len(visitorDayPrefix):]]; ok {
+			if i == 0 {
+				u.DAU = h.count()
+			}
+			union.merge(h)
+		}
+	}
+	u.WAU = union.count()
+	if h, ok := v.months[now.Format("200601")]; ok {
+		u.MAU = h.count()
+	}
+	return u
+}
+
+// localHLL：取（必要时创建）某日/某月的进程内计数器，并只保留按键排序最近的 keep 个
+func localHLL(m map[string]*hll, k string, keep int) *hll {
+	if h, ok := m[k]; ok {
+		return h
+	}
+	h := &hll{}
+	m[k] = h
+	for len(m) > keep {
+		oldest := ""
+		for x := range m {
+			if oldest == "" || x < oldest {
+				oldest = x
+			}
+		}
+		delete(m, oldest)
+	}
+	return h
+}
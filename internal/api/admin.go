@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"ip-api/internal/logger"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// 文档注释：管理接口鉴权
+// 背景：管理接口与 /reload-exact 共用 ADMIN_TOKEN；未配置令牌时一律拒绝，避免空令牌放行。
+// 返回：鉴权失败时已写出 403，调用方直接返回即可。
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	t := r.Header.Get("x-admin-token")
+	if t == "" || t != os.Getenv("ADMIN_TOKEN") {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// 文档注释：输出 JSON 响应（管理接口）
+// 约束：管理数据不可被 CDN/浏览器缓存。
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	w.Header().Set("cache-control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// 文档注释：管理操作审计条目
+// 背景：运行期干预（禁用插件、调权重等）会直接改变对外结果，需可追溯“谁在何时改了什么”。
+// 约束：Actor 取 x-admin-actor 头（操作人自报），缺省为访问者 IP；Before/After 为变更前后值。
+type auditEntry struct {
+	At     time.Time `json:"at"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	Target string    `json:"target"`
+	Before any       `json:"before,omitempty"`
+	After  any       `json:"after,omitempty"`
+}
+
+// 审计保留条数：仅保留最近记录供接口查询，完整历史以日志 admin_audit 为准
+const auditKeep = 500
+
+var (
+	auditMu   sync.Mutex
+	auditRing []auditEntry
+)
+
+// 文档注释：记录审计条目
+// 背景：同时写结构化日志（持久留存）与进程内环形列表（供 /admin/audit 快速查看）。
+func recordAudit(r *http.Request, action, target string, before, after any) {
+	actor := r.Header.Get("x-admin-actor")
+	if actor == "" {
+		actor = getVisitorIP(r)
+	}
+	e := auditEntry{At: time.Now(), Actor: actor, Action: action, Target: target, Before: before, After: after}
+	logger.L().Info("admin_audit", "actor", e.Actor, "action", action, "target", target, "before", before, "after", after)
+	auditMu.Lock()
+	auditRing = append(auditRing, e)
+	if len(auditRing) > auditKeep {
+		auditRing = auditRing[len(auditRing)-auditKeep:]
+	}
+	auditMu.Unlock()
+}
+
+// 文档注释：读取最近审计条目（新的在前）
+func recentAudit() []auditEntry {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	out := make([]auditEntry, len(auditRing))
+	for i, e := range auditRing {
+		out[len(auditRing)-1-i] = e
+	}
+	return out
+}
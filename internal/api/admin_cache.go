@@ -0,0 +1,62 @@
+package api
+
+import (
+	"ip-api/internal/hotcache"
+	"ip-api/internal/localdb"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 文档注释：注册本地缓存栈管理接口
+// 背景：链式缓存经 DynamicCache 热切换，仅凭配置无法确认线上实际生效的层与数据版本；
+// GET /admin/cache/stack 返回当前栈（查询顺序、实现类型、数据版本、加载状态）与最近一次切换时间；
+// POST /admin/cache/invalidate?ip= 失效单个 IP 的结果缓存（Redis 与各实例热点缓存），ip 为空时清空全部热点缓存。
+func registerCacheAdminRoutes(apiMux *http.ServeMux, dc *localdb.DynamicCache, rc *redis.Client) {
+	apiMux.HandleFunc("GET /admin/cache/stack", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		m := map[string]any{"layers": []localdb.LayerInfo{}}
+		if dc != nil {
+			if layers, at := dc.Stack(); layers != nil {
+				m["layers"] = layers
+				m["set_at"] = at.UTC().Format(time.RFC3339)
+			}
+		}
+		writeJSON(w, http.StatusOK, m)
+	})
+	apiMux.HandleFunc("POST /admin/cache/invalidate", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		ip := r.URL.Query().Get("ip")
+		if ip == "" {
+			ip = hotcache.All
+		} else if net.ParseIP(ip) == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid ip"})
+			return
+		}
+		err := hotcache.Invalidate(r.Context(), rc, ip)
+		recordAudit(r, "cache_invalidate", ip, nil, err == nil)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]any{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"invalidated": ip})
+	})
+}
+
+// 文档注释：写出应答来源头
+// 背景：x-ip-source 为应答来源（kv/redis/db/fusion 或本地缓存层名），x-ip-source-version 为该层数据版本（未知时不写）；
+// 响应体结构保持不变，避免影响旧客户端。
+func setSource(w http.ResponseWriter, src localdb.Source) {
+	w.Header().Set("x-ip-source", src.Layer)
+	if src.Version != "" {
+		w.Header().Set("x-ip-source-version", src.Version)
+	} else {
+		w.Header().Del("x-ip-source-version")
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"ip-api/internal/hotcache"
+	"ip-api/internal/localdb/exact"
+	"ip-api/internal/store"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 文档注释：注册 KV 覆盖历史管理接口
+// 背景：自动写回出错时需按历史定位并撤销；变更历史由数据库触发器记录（见 store.KVHistory）。
+// GET /admin/kv/history?ip=&key=&limit= 返回该 IP 的变更历史（时间倒序，key 为空时含全部命名空间）；
+// POST /admin/kv/revert?id= 把对应命名空间恢复为该次变更之前的值（连同复制进精确表的错误值），失效该 IP（或区间）的结果缓存并触发精确库重建。
+func registerKVAdminRoutes(apiMux *http.ServeMux, st *store.Store, rc *redis.Client, ex *exact.Overlay) {
+	apiMux.HandleFunc("GET /admin/kv/history", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		q := r.URL.Query()
+		ip := q.Get("ip")
+		if net.ParseIP(ip).To4() == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid ip"})
+			return
+		}
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		xs, err := store.KVHistory(r.Context(), st.DB(), ip, q.Get("key"), min(limit, 500))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		if xs == nil {
+			xs = []store.KVChange{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ip": ip, "history": xs})
+	})
+	apiMux.HandleFunc("POST /admin/kv/revert", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil || id <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid id"})
+			return
+		}
+		actor := r.Header.Get("x-admin-actor")
+		if actor == "" {
+			actor = getVisitorIP(r)
+		}
+		c, err := store.RevertKV(store.WithChange(r.Context(), actor, ""), st.DB(), id)
+		if errors.Is(err, store.ErrNoChange) {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		target := c.AssocKey + "/" + c.IP
+		if c.Range != "" {
+			target = c.AssocKey + "/" + c.Range
+		}
+		recordAudit(r, "kv_revert", target, c.New, c.Old)
+		if ex != nil {
+			ex.Trigger()
+		}
+		if c.Range != "" {
+			start, end, _ := store.ParseRange(c.Range)
+			err = hotcache.InvalidateRange(r.Context(), rc, start, end)
+		} else {
+			err = hotcache.Invalidate(r.Context(), rc, c.IP)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusOK, map[string]any{"reverted": c, "invalidate_error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"reverted": c})
+	})
+}
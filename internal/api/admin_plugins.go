@@ -0,0 +1,154 @@
+package api
+
+import (
+	"errors"
+	"ip-api/internal/plugins"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// 文档注释：注册插件管理接口
+// 背景：融合异常时需在运行期介入插件，而非修改环境变量后重启进程；所有接口均需管理令牌，变更类接口写审计记录。
+// 接口：
+// - GET  /admin/plugins：列出插件名称、版本、assoc、健康、最近心跳、生效权重与近期延迟/失败分位；
+// - GET  /admin/plugins/{name}：单个插件视图；
+// - POST /admin/plugins/{name}/enable|disable：启用/禁用插件参与融合；
+// - POST /admin/plugins/{name}/weight?value=：设置权重（0–10），value 为空或 reset 时恢复默认；
+// - POST /admin/plugins/{name}/heartbeat：立即执行心跳；
+// - POST /admin/plugins/{name}/promote|shadow：影子插件转正参与融合 / 退回影子评估；
+// - POST /admin/plugins/cache/purge?plugin=&ip=：清理插件结果缓存，plugin 为空作用于全部带缓存插件，ip 为空清理全部条目；
+// - GET  /admin/audit：最近的管理操作审计记录。
+func RegisterPluginAdminRoutes(apiMux *http.ServeMux, pm *plugins.Manager) {
+	apiMux.HandleFunc("GET /admin/plugins", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"plugins": pm.List()})
+	})
+	apiMux.HandleFunc("GET /admin/plugins/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		info, err := pm.Info(r.PathValue("name"))
+		if err != nil {
+			writePluginError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+	setEnabled := func(enabled bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !requireAdmin(w, r) {
+				return
+			}
+			name := r.PathValue("name")
+			prev, err := pm.SetEnabled(name, enabled)
+			if err != nil {
+				writePluginError(w, err)
+				return
+			}
+			action := "plugin_disable"
+			if enabled {
+				action = "plugin_enable"
+			}
+			recordAudit(r, action, name, prev, enabled)
+			info, _ := pm.Info(name)
+			writeJSON(w, http.StatusOK, info)
+		}
+	}
+	apiMux.HandleFunc("POST /admin/plugins/{name}/enable", setEnabled(true))
+	apiMux.HandleFunc("POST /admin/plugins/{name}/disable", setEnabled(false))
+	setShadow := func(shadow bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !requireAdmin(w, r) {
+				return
+			}
+			name := r.PathValue("name")
+			prev, err := pm.SetShadow(name, shadow)
+			if err != nil {
+				writePluginError(w, err)
+				return
+			}
+			action := "plugin_promote"
+			if shadow {
+				action = "plugin_shadow"
+			}
+			recordAudit(r, action, name, prev, shadow)
+			info, _ := pm.Info(name)
+			writeJSON(w, http.StatusOK, info)
+		}
+	}
+	apiMux.HandleFunc("POST /admin/plugins/{name}/promote", setShadow(false))
+	apiMux.HandleFunc("POST /admin/plugins/{name}/shadow", setShadow(true))
+	apiMux.HandleFunc("POST /admin/plugins/{name}/weight", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		name := r.PathValue("name")
+		var wp *float64
+		if v := r.URL.Query().Get("value"); v != "" && v != "reset" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]any{"error": "bad weight"})
+				return
+			}
+			wp = &f
+		}
+		prev, err := pm.SetWeight(name, wp)
+		if err != nil {
+			writePluginError(w, err)
+			return
+		}
+		info, _ := pm.Info(name)
+		recordAudit(r, "plugin_weight", name, prev, info.Weight)
+		writeJSON(w, http.StatusOK, info)
+	})
+	apiMux.HandleFunc("POST /admin/plugins/{name}/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		name := r.PathValue("name")
+		err := pm.ForceHeartbeat(r.Context(), name)
+		if errors.Is(err, plugins.ErrPluginNotFound) {
+			writePluginError(w, err)
+			return
+		}
+		recordAudit(r, "plugin_heartbeat", name, nil, err == nil)
+		info, _ := pm.Info(name)
+		writeJSON(w, http.StatusOK, info)
+	})
+	apiMux.HandleFunc("POST /admin/plugins/cache/purge", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		name := r.URL.Query().Get("plugin")
+		ip := r.URL.Query().Get("ip")
+		// ip 须为合法地址：不接受通配字符等任意键片段进入 Redis 键与匹配模式
+		if ip != "" && net.ParseIP(ip) == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid ip"})
+			return
+		}
+		n, names, err := pm.PurgeCache(r.Context(), name, ip)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": err.Error()})
+			return
+		}
+		recordAudit(r, "plugin_cache_purge", name, ip, n)
+		writeJSON(w, http.StatusOK, map[string]any{"purged": n, "plugins": names})
+	})
+	apiMux.HandleFunc("GET /admin/audit", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"entries": recentAudit()})
+	})
+}
+
+func writePluginError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, plugins.ErrPluginNotFound) {
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, map[string]any{"error": err.Error()})
+}
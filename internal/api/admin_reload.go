@@ -0,0 +1,59 @@
+package api
+
+import (
+	"errors"
+	"ip-api/internal/reload"
+	"net/http"
+)
+
+// 文档注释：注册数据文件热重载管理接口
+// 背景：轮询发现的新文件自动校验切换；管理接口用于查看各目标当前/回滚版本与最近错误，并在发现数据问题时立即回滚。
+// 接口：
+// - GET  /admin/reload：各目标（ipip、ip2region、revgeo）的路径、当前版本、加载时间、回滚版本与最近一次校验错误；
+// - POST /admin/reload/{name}：立即重新加载（忽略指纹与稳定等待，仍执行校验）；
+// - POST /admin/reload/{name}/rollback：切回上一可用版本（再次调用可切回）。
+func RegisterReloadAdminRoutes(apiMux *http.ServeMux, rw *reload.Watcher) {
+	apiMux.HandleFunc("GET /admin/reload", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"targets": rw.Status()})
+	})
+	apply := func(action string, fn func(string) error) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !requireAdmin(w, r) {
+				return
+			}
+			name := r.PathValue("name")
+			before := reloadVersion(rw, name)
+			err := fn(name)
+			recordAudit(r, action, name, before, reloadVersion(rw, name))
+			switch {
+			case errors.Is(err, reload.ErrNotFound):
+				writeJSON(w, http.StatusNotFound, map[string]any{"error": err.Error()})
+			case errors.Is(err, reload.ErrNoPrevious):
+				writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error()})
+			case err != nil:
+				writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": err.Error()})
+			default:
+				for _, s := range rw.Status() {
+					if s.Name == name {
+						writeJSON(w, http.StatusOK, s)
+						return
+					}
+				}
+			}
+		}
+	}
+	apiMux.HandleFunc("POST /admin/reload/{name}", apply("data_reload", rw.Reload))
+	apiMux.HandleFunc("POST /admin/reload/{name}/rollback", apply("data_rollback", rw.Rollback))
+}
+
+func reloadVersion(rw *reload.Watcher, name string) string {
+	for _, s := range rw.Status() {
+		if s.Name == name {
+			return s.Version
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"ip-api/internal/analytics"
+	"ip-api/internal/asn"
+	"ip-api/internal/logger"
+	"ip-api/internal/store"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 文档注释：注册 ASN 查询路由
+// 背景：GET /asn/{asn} 返回 ASN 的名称、注册国家、运营商与名下前缀；asn 可写作 13335 或 AS13335。
+// 约束：前缀条数默认返回 1000 条，可用 ?limit= 调整（上限 10000）；prefix_count 为全部条数；有效查询计入小时统计（端点 asn，国家取注册国家）。
+func registerASNRoutes(apiMux *http.ServeMux, st *store.Store, ar *analytics.Recorder) {
+	apiMux.HandleFunc("GET /asn/{asn}", func(w http.ResponseWriter, r *http.Request) {
+		s := strings.TrimPrefix(strings.ToUpper(r.PathValue("asn")), "AS")
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 || n > 0xffffffff {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "bad asn"})
+			return
+		}
+		limit := 1000
+		if v, e := strconv.Atoi(r.URL.Query().Get("limit")); e == nil && v > 0 {
+			limit = min(v, 10000)
+		}
+		info, err := st.GetASN(r.Context(), n, limit)
+		if err != nil {
+			logger.L().Error("asn_query_error", "asn", n, "err", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "query failed"})
+			return
+		}
+		if info == nil {
+			ar.Record(analytics.Event{Endpoint: "asn", Stage: "none"})
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": "asn not found"})
+			return
+		}
+		ar.Record(analytics.Event{Endpoint: "asn", Stage: "db", Country: info.Country, Hit: true})
+		writeJSON(w, http.StatusOK, info)
+	})
+}
+
+// 文档注释：按 ASN 表补全查询结果
+// 背景：本地库与缓存命中路径不经过插件融合，运营商多为空；在输出前按前缀映射补全 ISP 与 ASN，已有值不覆盖。
+func fillASN(res *queryResult, ah *asn.Holder) {
+	if res.IP == "" || (res.ISP != "" && res.ASN != 0) {
+		return
+	}
+	rec, _, ok := ah.Lookup(res.IP)
+	if !ok {
+		return
+	}
+	if res.ASN == 0 {
+		res.ASN = int(rec.ASN)
+	}
+	// 已有 ASN 与前缀映射不一致时不采用其运营商，避免拼接出矛盾结果
+	if res.ISP == "" && res.ASN == int(rec.ASN) {
+		res.ISP = rec.ISP
+	}
+}
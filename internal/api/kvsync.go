@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"ip-api/internal/hotcache"
+	"ip-api/internal/localdb/exact"
+	"ip-api/internal/localdb/unified"
+	"ip-api/internal/logger"
+	"ip-api/internal/store"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 文档注释：KV 覆盖变更与文件层的同步
+// 背景：统一库未加载时，查询链的 KV 前置只能让新增与修改即时生效；删除（override-kv del、撤销新增、过期清理）后前置查询落空，
+// 精确库与统一库在重建前仍返回旧值，且会被重新写入 Redis 结果缓存。统一库加载后不再逐请求查询 KV，新增与修改同样要等重编译纳入。
+// 约束：
+// - 任何变更：立即清理本进程热点缓存与 Redis 结果键（不广播，通知已送达每个实例）；
+// - 删除，或统一库已加载时的任何变更：触发精确库重建与统一库重编译，登记为待同步；每次文件切换后再次清理待同步的区间，直到精确库与（已启用的）统一库都已切换到通知之后开始构建的版本；
+// - 监听重连：可能漏掉通知，同样触发重建。
+type kvSync struct {
+	rc *redis.Client
+	ex *exact.Overlay
+	uh *unified.Holder
+
+	mu        sync.Mutex
+	pending   []kvPending
+	exactAt   time.Time
+	unifiedAt time.Time
+}
+
+type kvPending struct {
+	start, end uint32
+	at         time.Time
+}
+
+// 文档注释：启动 KV 变更同步
+// 参数：dsn 为监听连接 DSN；ex 为精确库增量层；uh 为统一库（未启用时传入未加载的 Holder）。
+func StartKVSync(ctx context.Context, dsn string, rc *redis.Client, ex *exact.Overlay, uh *unified.Holder) {
+	k := &kvSync{rc: rc, ex: ex, uh: uh}
+	ex.OnSwap(func(t time.Time) { k.swapped(ctx, t, false) })
+	uh.OnSwap(func(t time.Time) { k.swapped(ctx, t, true) })
+	store.ListenKV(ctx, dsn, func(ev store.KVEvent) { k.changed(ctx, ev) })
+}
+
+func (k *kvSync) changed(ctx context.Context, ev store.KVEvent) {
+	l := logger.L()
+	if ev.Op != "resync" {
+		if err := hotcache.Drop(ctx, k.rc, ev.Start, ev.End); err != nil {
+			l.Error("kv_sync_invalidate_error", "err", err)
+		}
+	}
+	if ev.Op != "delete" && ev.Op != "resync" && !k.uh.Ready() {
+		return
+	}
+	if ev.Op != "resync" {
+		k.mu.Lock()
+		k.pending = append(k.pending, kvPending{start: ev.Start, end: ev.End, at: time.Now()})
+		k.mu.Unlock()
+	}
+	l.Debug("kv_sync_rebuild", "op", ev.Op)
+	k.ex.Trigger()
+	k.uh.Trigger()
+}
+
+// swapped：文件切换后清理待同步区间，并移除两层都已纳入的条目
+func (k *kvSync) swapped(ctx context.Context, builtAt time.Time, isUnified bool) {
+	k.mu.Lock()
+	if isUnified {
+		k.unifiedAt = builtAt
+	} else {
+		k.exactAt = builtAt
+	}
+	todo := k.pending
+	kept := k.pending[:0:0]
+	for _, p := range k.pending {
+		if p.at.After(k.exactAt) || (k.uh.Ready() && p.at.After(k.unifiedAt)) {
+			kept = append(kept, p)
+		}
+	}
+	k.pending = kept
+	k.mu.Unlock()
+	for _, p := range todo {
+		if err := hotcache.Drop(ctx, k.rc, p.start, p.end); err != nil {
+			logger.L().Error("kv_sync_invalidate_error", "err", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"ip-api/internal/asn"
+	"ip-api/internal/fusion"
+	"ip-api/internal/hotcache"
+	"ip-api/internal/ingest"
+	"ip-api/internal/localdb"
+	"ip-api/internal/localdb/exact"
+	"ip-api/internal/localdb/unified"
+	"ip-api/internal/logger"
+	"ip-api/internal/metrics"
+	"ip-api/internal/plugins"
+	"ip-api/internal/store"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 文档注释：一次解析的结果
+// 背景：单飞期间由同一 IP 的并发请求共用，进程内热点缓存也保存该结构；调用方只读，需修改时先复制 res。
+// 约束：
+// - steps 为分步耗时响应头（x-step-ms-file/x-step-ms-db）；
+// - tail 表示未经 KV/Redis/本地缓存早返回，而是走数据库回退与融合的尾部流程，响应前还需执行 CDN 强制融合与一致性兜底；
+// - 写入热点缓存的条目 tail 恒为 false，与 Redis 命中同样直接返回。
+type resolved struct {
+	res   queryResult
+	src   localdb.Source
+	steps map[string]string
+	tail  bool
+	dbHit bool
+}
+
+// 文档注释：/ip 解析器
+// 背景：把查询链（KV 前置 → Redis → 本地缓存链 → 数据库回退 → 插件融合）与每请求的统计、响应分离，
+// 使同一 IP 的并发未命中经单飞只执行一次，结果写入 Redis 与进程内热点缓存。
+type resolver struct {
+	st    *store.Store
+	rc    *redis.Client
+	dc    *localdb.DynamicCache
+	pm    *plugins.Manager
+	ex    *exact.Overlay
+	uh    *unified.Holder
+	ah    *asn.Holder
+	wp    *fusion.WritePolicy
+	hot   *hotcache.Cache[resolved]
+	group hotcache.Group[resolved]
+}
+
+// 文档注释：创建解析器
+// 约束：HOT_CACHE_SIZE（默认 100000，0 关闭）为进程内热点缓存条目上限；HOT_CACHE_SHARDS（默认 16）为分段数；
+// HOT_CACHE_TTL_SECONDS（默认 60）为条目存活时长，也是未启用 Redis 时其他进程写入覆盖后的最长可见延迟。
+func newResolver(st *store.Store, rc *redis.Client, dc *localdb.DynamicCache, pm *plugins.Manager, ex *exact.Overlay, uh *unified.Holder, ah *asn.Holder) *resolver {
+	z := &resolver{st: st, rc: rc, dc: dc, pm: pm, ex: ex, uh: uh, ah: ah, wp: fusion.LoadWritePolicy(st, rc)}
+	size := envNonNeg("HOT_CACHE_SIZE", 100000)
+	z.hot = hotcache.New[resolved](size, envNonNeg("HOT_CACHE_SHARDS", 16), time.Duration(envNonNeg("HOT_CACHE_TTL_SECONDS", 60))*time.Second)
+	hotcache.OnInvalidate(func(ip string) {
+		if ip == hotcache.All {
+			n := z.hot.Purge()
+			logger.L().Info("hotcache_purge", "count", n)
+			return
+		}
+		z.hot.Delete(ip)
+	})
+	logger.L().Info("hotcache_init", "size", size, "enabled", z.hot != nil)
+	return z
+}
+
+// 文档注释：解析（热点缓存 → 单飞）
+// 背景：热点缓存命中直接返回；未命中时同一 IP 的并发请求合并为一次 resolve，执行者使用脱离取消的上下文，
+// 首个请求断开不影响等待者。
+// 约束：请求携带可信 CDN 地理信息时融合结果依赖请求上下文，不参与合并（仍读写缓存，与 Redis 行为一致）。
+// 返回：结果与是否命中热点缓存。
+func (z *resolver) lookup(ctx context.Context, ip string, isIPv6 bool) (resolved, bool) {
+	if v, ok := z.hot.Get(ip); ok {
+		return v, true
+	}
+	gen := z.hot.Gen(ip)
+	if _, geo := plugins.GeoFromContext(ctx); geo {
+		return z.resolve(ctx, ip, isIPv6, gen), false
+	}
+	v, _, shared := z.group.Do(ip, func() (resolved, error) {
+		return z.resolve(context.WithoutCancel(ctx), ip, isIPv6, gen), nil
+	})
+	if shared {
+		logger.L().Debug("resolve_shared", "ip", ip)
+	}
+	return v, false
+}
+
+// 文档注释：执行查询链
+// 参数：gen 为解析开始前的热点缓存失效代数，期间发生失效时结果不写入热点缓存。
+func (z *resolver) resolve(ctx context.Context, ip string, isIPv6 bool, gen uint64) resolved {
+	l := logger.L()
+	cacheSec := envPositive("CACHE_TTL_SECONDS", 600)
+	out := resolved{res: queryResult{IP: ip}}
+	// 统一库已加载时其内容已含 KV/覆盖/精确/特例/范围，数据库查询仅在未加载时使用；
+	// 加载后的 KV 变更由变更通知触发统一库重编译，并在切换后再次失效结果缓存（见 kvsync.go）
+	dbFallback := z.uh == nil || !z.uh.Ready()
+	// KV 覆盖前置：若命中则直接返回，覆盖文件缓存
+	if !isIPv6 && dbFallback {
+		if kv, _ := z.st.LookupKV(ctx, ip); kv != nil {
+			setLocation(&out.res, kv.Country, kv.Region, kv.Province, kv.City, kv.ISP)
+			out.src = localdb.Source{Layer: "kv"}
+			fillASN(&out.res, z.ah)
+			z.store(ctx, ip, out, cacheSec, gen)
+			return out
+		}
+	}
+	// 背景：热点查询结果写入 Redis，降低重复请求对下游的压力；命中后直接返回
+	if z.rc != nil {
+		s, _ := z.rc.Get(ctx, "ip:"+ip).Result()
+		if s != "" {
+			l.Debug("cache_hit", "key", "ip:"+ip)
+			metrics.RedisHitsTotal.Inc()
+			_ = json.Unmarshal([]byte(s), &out.res)
+			out.src = localdb.Source{Layer: "redis"}
+			var adopted, applied bool
+			if !isIPv6 {
+				adopted, applied = z.fuseOnPartial(ctx, ip, &out, "plugin_fusion_on_cache")
+			}
+			if applied {
+				gen = z.hot.Gen(ip)
+			}
+			fillASN(&out.res, z.ah)
+			if adopted {
+				z.store(ctx, ip, out, cacheSec, gen)
+			} else {
+				z.hot.SetAt(ip, out, gen)
+			}
+			return out
+		}
+		l.Debug("cache_miss", "key", "ip:"+ip)
+		metrics.RedisMissesTotal.Inc()
+	}
+	// 背景：本地缓存链（IPv4）快速读取；未命中回退数据库
+	tFileBegin := time.Now()
+	if z.dc != nil && !isIPv6 {
+		if loc, src, ok := z.dc.Resolve(ip); ok {
+			setLocation(&out.res, loc.Country, loc.Region, loc.Province, loc.City, loc.ISP)
+			out.src = src
+			out.steps = map[string]string{"x-step-ms-file": strconv.FormatInt(time.Since(tFileBegin).Milliseconds(), 10)}
+			l.Debug("localdb_hit", "layer", src.Layer, "version", src.Version)
+			if _, applied := z.fuseOnPartial(ctx, ip, &out, "plugin_fusion_on_localdb"); applied {
+				gen = z.hot.Gen(ip)
+			}
+			fillASN(&out.res, z.ah)
+			if !isEmptyResult(out.res) {
+				z.store(ctx, ip, out, cacheSec, gen)
+			} else {
+				l.Debug("cache_skip_empty", "key", "ip:"+ip)
+			}
+			go func() {
+				if p := net.ParseIP(ip); p != nil && p.To4() != nil {
+					v := p.To4()
+					ipInt := uint32(v[0])<<24 | uint32(v[1])<<16 | uint32(v[2])<<8 | uint32(v[3])
+					l.Debug("lazy_exact_persist", "ip", ip)
+					_ = ingest.WriteExact(context.WithoutCancel(ctx), z.st.DB(), ipInt, ingest.Location{Country: loc.Country, Region: loc.Region, Province: loc.Province, City: loc.City, ISP: loc.ISP}, "filecache")
+				}
+			}()
+			return out
+		}
+		l.Debug("localdb_miss")
+	}
+	out.tail = true
+	// 背景：数据库范围回退（仅 IPv4）；保障本地缓存不足或缺席情况下仍可服务
+	if !isIPv6 && dbFallback {
+		tDBBegin := time.Now()
+		if loc, _ := z.st.LookupIP(ctx, ip); loc != nil {
+			l.Debug("db_range_hit")
+			setLocation(&out.res, loc.Country, loc.Region, loc.Province, loc.City, loc.ISP)
+			out.src = localdb.Source{Layer: "db"}
+			out.steps = map[string]string{"x-step-ms-db": strconv.FormatInt(time.Since(tDBBegin).Milliseconds(), 10)}
+			out.dbHit = true
+		} else {
+			l.Debug("db_range_miss")
+		}
+	}
+	// 插件融合：DB 未命中或命中但字段不完整且开关启用时触发
+	triggerFusion := !isIPv6 && z.pm != nil
+	if !isEmptyResult(out.res) {
+		needOnPartial := os.Getenv("ENABLE_FUSION_ON_PARTIAL_DB") == "true"
+		incomplete := out.res.Province == "" || out.res.City == ""
+		if !needOnPartial || !incomplete {
+			triggerFusion = false
+		} else {
+			l.Debug("fusion_on_partial_db", "ip", ip)
+		}
+	}
+	if triggerFusion {
+		ctx2, cancel := context.WithTimeout(ctx, 4*time.Second)
+		loc, score, conf, top := z.pm.Aggregate(ctx2, ip)
+		cancel()
+		if !isEmptyFusion(loc) {
+			applyFusion(&out.res, loc)
+			out.src = localdb.Source{Layer: "fusion"}
+			l.Debug("plugin_fusion_hit", "score", score, "conf", conf)
+			if writeBack(ctx, z.st, z.rc, z.ex, z.wp, ip, loc, score, conf, top) {
+				gen = z.hot.Gen(ip)
+			}
+		}
+	}
+	fillASN(&out.res, z.ah)
+	if !isEmptyResult(out.res) {
+		z.store(ctx, ip, out, cacheSec, gen)
+	} else if out.dbHit {
+		l.Debug("cache_skip_empty", "key", "ip:"+ip)
+	}
+	return out
+}
+
+// 文档注释：缓存命中但省/市不完整时触发融合（ENABLE_FUSION_ON_PARTIAL_CACHE=true）
+// 背景：Redis 与本地缓存链两条命中路径共用；融合得分达到 FUSION_MIN_SCORE_ON_CACHE（默认 20）或补全了省市时采纳并写回。
+// 返回：adopted 为融合结果已采纳；applied 为写回实际覆盖了 KV（此前读取的失效代数已过期，调用方需重新读取）。
+func (z *resolver) fuseOnPartial(ctx context.Context, ip string, out *resolved, event string) (adopted, applied bool) {
+	if os.Getenv("ENABLE_FUSION_ON_PARTIAL_CACHE") != "true" || z.pm == nil {
+		return false, false
+	}
+	if out.res.Province != "" && out.res.City != "" {
+		return false, false
+	}
+	ctx2, cancel := context.WithTimeout(ctx, 4*time.Second)
+	loc, score, conf, top := z.pm.Aggregate(ctx2, ip)
+	cancel()
+	if isEmptyFusion(loc) {
+		return false, false
+	}
+	minScore := envPositive("FUSION_MIN_SCORE_ON_CACHE", 20)
+	oldComplete := out.res.Province != "" && out.res.City != ""
+	newComplete := loc.Province != "" && loc.City != ""
+	if !(score >= float64(minScore) || (!oldComplete && newComplete) || (oldComplete && newComplete)) {
+		logger.L().Debug(event+"_skip", "score", score, "min", minScore, "old_complete", oldComplete, "new_complete", newComplete)
+		return false, false
+	}
+	applyFusion(&out.res, loc)
+	out.src = localdb.Source{Layer: "fusion"}
+	logger.L().Debug(event, "score", score, "conf", conf)
+	return true, writeBack(ctx, z.st, z.rc, z.ex, z.wp, ip, loc, score, conf, top)
+}
+
+// store：写入 Redis 与进程内热点缓存（热点条目按早返回结果保存）
+func (z *resolver) store(ctx context.Context, ip string, out resolved, cacheSec int, gen uint64) {
+	if z.rc != nil {
+		b, _ := json.Marshal(out.res)
+		_ = z.rc.Set(ctx, "ip:"+ip, string(b), time.Duration(cacheSec)*time.Second).Err()
+		logger.L().Debug("cache_set", "key", "ip:"+ip, "len", len(b), "ttl_s", cacheSec)
+	}
+	out.tail, out.dbHit, out.steps = false, false, nil
+	z.hot.SetAt(ip, out, gen)
+}
+
+func setLocation(res *queryResult, country, region, province, city, isp string) {
+	res.Country, res.Region, res.Province, res.City, res.ISP = country, region, province, city, isp
+}
+
+func applyFusion(res *queryResult, loc fusion.Location) {
+	setLocation(res, loc.Country, loc.Region, loc.Province, loc.City, loc.ISP)
+	res.ASN = loc.ASN
+	res.Adcode = loc.Adcode
+}
+
+func isEmptyResult(res queryResult) bool {
+	return res.Country == "" && res.Region == "" && res.Province == "" && res.City == "" && res.ISP == ""
+}
+
+func isEmptyFusion(loc fusion.Location) bool {
+	return loc.Country == "" && loc.Region == "" && loc.Province == "" && loc.City == "" && loc.ISP == ""
+}
+
+// envPositive：读取正整数环境变量，缺省或非法时返回 def
+func envPositive(name string, def int) int {
+	if s := os.Getenv(name); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// envNonNeg：读取非负整数环境变量（0 表示关闭），缺省或非法时返回 def
+func envNonNeg(name string, def int) int {
+	if s := os.Getenv(name); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return def
+}
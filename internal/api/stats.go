@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"ip-api/internal/analytics"
+	"ip-api/internal/store"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 文档注释：/stats 的时间序列与分组
+// 背景：总计与当日计数无法回答“哪个省份的查询在涨”“空结果集中在哪个阶段”；小时聚合由 analytics.Recorder 写入 _ip_stats_hourly。
+// 参数：
+// - range：统计窗口（如 24h、7d，上限 90d；缺省 24h）；
+// - group：分组维度（endpoint/stage/country/province/hit，可选）；
+// - limit：分组返回条数（默认 50，上限 1000）。
+// 返回：向 m 追加 range、series（逐小时 queries/hits）与 groups；参数非法时返回 analytics.ErrBadRange/ErrBadGroup。
+func statsSeries(ctx context.Context, st *store.Store, q url.Values, m map[string]any) error {
+	rng := q.Get("range")
+	if rng == "" {
+		rng = "24h"
+	}
+	d, err := analytics.ParseRange(rng)
+	if err != nil {
+		return err
+	}
+	since := time.Now().Add(-d)
+	series, err := analytics.Series(ctx, st.DB(), since)
+	if err != nil {
+		return err
+	}
+	m["range"] = rng
+	m["series"] = series
+	if g := q.Get("group"); g != "" {
+		limit := 50
+		if v, e := strconv.Atoi(q.Get("limit")); e == nil && v > 0 {
+			limit = min(v, 1000)
+		}
+		groups, err := analytics.Breakdown(ctx, st.DB(), since, g, limit)
+		if err != nil {
+			return err
+		}
+		m["group"] = g
+		m["groups"] = groups
+	}
+	return nil
+}
+
+// 文档注释：统计用的解析阶段
+// 返回：热点缓存命中为 hot，CDN 强信号强制融合为 fusion，其余取结果来源层（kv/redis/缓存层名/db/fusion，缺失时为 other）；结果为空时为 none。
+func queryStage(rv resolved, hot, forced bool, res queryResult) string {
+	switch {
+	case isEmptyResult(res):
+		return "none"
+	case hot:
+		return "hot"
+	case forced:
+		return "fusion"
+	case rv.src.Layer != "":
+		return rv.src.Layer
+	}
+	return "other"
+}
@@ -10,5 +10,9 @@ type queryResult struct {
     Province string `json:"province"`
     City     string `json:"city"`
     ISP      string `json:"isp"`
+    // ASN 仅在来源提供时输出（如 EdgeOne），保持旧客户端兼容
+    ASN      int    `json:"asn,omitempty"`
+    // Adcode 为行政区划代码，仅在融合来源提供且与城市一致时输出
+    Adcode   string `json:"adcode,omitempty"`
 }
 
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"ip-api/internal/fusion"
+	"ip-api/internal/hotcache"
+	"ip-api/internal/ingest"
+	"ip-api/internal/localdb"
+	"ip-api/internal/localdb/exact"
+	"ip-api/internal/logger"
+	"ip-api/internal/plugins"
+	"ip-api/internal/store"
+	"net"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 文档注释：融合结果写回
+// 背景：缓存命中不完整、本地库命中不完整与数据库未命中三条路径共用同一写回流程：经写回策略判定后写 KV 覆盖、按阈值写精确表，并登记到精确库增量层（立即生效，文件由增量层防抖合并重建）。
+// 参数：top 为最高分来源（提供 assoc 与一致来源数），为空时按策略的兜底命名空间处理。
+// 约束：策略判定跳过或演练模式下不落库、不触发重建；KV 未实际覆盖（分差不足）或写库失败时不登记，并归还冷却期；写库失败仅记录日志，不影响本次响应。
+// 返回：KV 覆盖已实际写入时为 true；此时已失效该 IP 的进程内与 Redis 结果缓存并广播到其他实例。
+func writeBack(ctx context.Context, st *store.Store, rc *redis.Client, ex *exact.Overlay, wp *fusion.WritePolicy, ip string, loc fusion.Location, score, conf float64, top *plugins.Weighted) bool {
+	assoc, agreeing := "", 0
+	if top != nil {
+		assoc, agreeing = top.Assoc, top.Agreeing
+	}
+	d := wp.Decide(ctx, ip, loc, score, assoc, agreeing)
+	if !d.WriteKV || d.DryRun {
+		return false
+	}
+	il := ingest.Location{Country: loc.Country, Region: loc.Region, Province: loc.Province, City: loc.City, ISP: loc.ISP}
+	applied, err := st.UpsertOverrideKV(store.WithChange(ctx, "", "fusion"), d.Assoc, ip, il, score, conf, d.Margin)
+	if err != nil {
+		logger.L().Error("writeback_kv_error", "ip", ip, "assoc", d.Assoc, "err", err)
+		wp.ReleaseCooldown(ctx, ip)
+		return false
+	}
+	if !applied {
+		wp.ReleaseCooldown(ctx, ip)
+		return false
+	}
+	if err := hotcache.Invalidate(ctx, rc, ip); err != nil {
+		logger.L().Error("writeback_invalidate_error", "ip", ip, "err", err)
+	}
+	p := net.ParseIP(ip).To4()
+	if p == nil {
+		return true
+	}
+	ipInt := uint32(p[0])<<24 | uint32(p[1])<<16 | uint32(p[2])<<8 | uint32(p[3])
+	if d.WriteExact {
+		_ = ingest.WriteExact(ctx, st.DB(), ipInt, il, d.Assoc)
+		logger.L().Debug("plugin_write_exact", "ip", ip, "assoc", d.Assoc)
+	}
+	if ex != nil {
+		ex.Put(ipInt, localdb.Location{Country: loc.Country, Region: loc.Region, Province: loc.Province, City: loc.City, ISP: loc.ISP})
+	}
+	return true
+}
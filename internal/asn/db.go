@@ -0,0 +1,137 @@
+package asn
+
+import (
+	"context"
+	"database/sql"
+	"ip-api/internal/logger"
+	"net/netip"
+
+	"github.com/lib/pq"
+)
+
+// 文档注释：导入前缀映射到数据库
+// 背景：RIB 全表约百万条前缀，逐条 UPSERT 过慢；先 COPY 到临时表再一次性合并，单事务内完成，失败整体回滚。
+// 参数：source 为来源（SourceBGP/SourceRIR）；replace 为 true 时先删除该来源的既有前缀（全量替换，清理已撤销的宣告）。
+// 约束：同一前缀在同一来源内仅保留一条（取先出现者）。
+func ImportPrefixes(ctx context.Context, db *sql.DB, source string, prefixes []Prefix, replace bool) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `CREATE TEMP TABLE _asn_stage (seq BIGINT, prefix CIDR, asn BIGINT) ON COMMIT DROP`); err != nil {
+		return 0, err
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("_asn_stage", "seq", "prefix", "asn"))
+	if err != nil {
+		return 0, err
+	}
+	for i, p := range prefixes {
+		if _, err := stmt.ExecContext(ctx, int64(i), p.Net.Masked().String(), int64(p.ASN)); err != nil {
+			stmt.Close()
+			return 0, err
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return 0, err
+	}
+	if err := stmt.Close(); err != nil {
+		return 0, err
+	}
+	if replace {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM _ip_asn_prefixes WHERE source=$1`, source); err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO _ip_asn_prefixes(prefix, asn, source)
+        SELECT DISTINCT ON (prefix) prefix, asn, $1::text FROM _asn_stage ORDER BY prefix, seq
+        ON CONFLICT (prefix, source) DO UPDATE SET asn=EXCLUDED.asn, updated_at=now()`, source)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	logger.L().Info("asn_prefix_import_done", "source", source, "rows", n, "replace", replace)
+	return n, nil
+}
+
+// 文档注释：导入 ASN 元信息
+// 约束：空字段不覆盖库中已有值（名称文件与委派文件可分批导入）；运营商随名称一并更新。
+func ImportRecords(ctx context.Context, db *sql.DB, recs []Record) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO _ip_asn(asn, name, country, registry, isp) VALUES($1,$2,$3,$4,$5)
+        ON CONFLICT (asn) DO UPDATE SET
+            name=COALESCE(NULLIF(EXCLUDED.name, ''), _ip_asn.name),
+            country=COALESCE(NULLIF(EXCLUDED.country, ''), _ip_asn.country),
+            registry=COALESCE(NULLIF(EXCLUDED.registry, ''), _ip_asn.registry),
+            isp=COALESCE(NULLIF(EXCLUDED.isp, ''), _ip_asn.isp),
+            updated_at=now()`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range recs {
+		if _, err := stmt.ExecContext(ctx, int64(r.ASN), r.Name, r.Country, r.Registry, r.ISP); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logger.L().Info("asn_record_import_done", "rows", len(recs))
+	return nil
+}
+
+// 文档注释：从数据库加载查询表
+// 背景：服务进程不直接读取原始文件，统一以数据库为准，多实例加载结果一致。
+func LoadFromDB(ctx context.Context, db *sql.DB) (*Table, error) {
+	rows, err := db.QueryContext(ctx, `SELECT prefix::text, asn, source FROM _ip_asn_prefixes`)
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []Prefix
+	for rows.Next() {
+		var s, src string
+		var n int64
+		if err := rows.Scan(&s, &n, &src); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if p, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, Prefix{Net: p, ASN: uint32(n), Source: src})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = db.QueryContext(ctx, `SELECT asn, name, country, registry, isp FROM _ip_asn`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var recs []Record
+	for rows.Next() {
+		var r Record
+		var n int64
+		if err := rows.Scan(&n, &r.Name, &r.Country, &r.Registry, &r.ISP); err != nil {
+			return nil, err
+		}
+		r.ASN = uint32(n)
+		recs = append(recs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	t := NewTable(prefixes, recs)
+	np, nr := t.Size()
+	logger.L().Info("asn_table_loaded", "prefixes", np, "records", nr)
+	return t, nil
+}
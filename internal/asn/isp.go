@@ -0,0 +1,91 @@
+package asn
+
+import "strings"
+
+// 文档注释：国内主要运营商 ASN 表
+// 背景：三大运营商与教育网的骨干/城域网 ASN 固定，直接映射为本地库使用的中文运营商名，不依赖名称文件。
+var knownISP = map[uint32]string{
+	4134: "电信", 4809: "电信", 4812: "电信", 4811: "电信", 4816: "电信", 23764: "电信", 134420: "电信",
+	4837: "联通", 9929: "联通", 17621: "联通", 17622: "联通", 17623: "联通", 17816: "联通", 4808: "联通",
+	9808: "移动", 24400: "移动", 56040: "移动", 56041: "移动", 56042: "移动", 56044: "移动", 56046: "移动", 56047: "移动", 58453: "移动",
+	4538: "教育网", 4565: "教育网", 24348: "教育网",
+	7641: "广电",
+}
+
+// 文档注释：名称关键字到运营商的映射（按顺序匹配）
+// 背景：省级子网常以独立 ASN 注册（如 CHINANET-GD、CMNET-GUANGDONG），按名称关键字归并到所属运营商。
+var ispKeywords = []struct{ kw, isp string }{
+	{"CHINANET", "电信"}, {"CHINATELECOM", "电信"}, {"CHINA TELECOM", "电信"},
+	{"UNICOM", "联通"}, {"CNCGROUP", "联通"}, {"CHINA169", "联通"},
+	{"CMNET", "移动"}, {"CHINAMOBILE", "移动"}, {"CHINA MOBILE", "移动"},
+	{"CERNET", "教育网"},
+	{"CHINA BROADNET", "广电"}, {"CHINA CABLE", "广电"},
+}
+
+// KnownISP：内置表中的运营商名；未收录时返回空串
+func KnownISP(n uint32) string { return knownISP[n] }
+
+// 文档注释：由 ASN 与名称推导运营商
+// 背景：国内运营商统一为中文简称（与 IPIP 等本地库一致）；其余 ASN 取名称中的机构部分作为运营商展示名。
+// 约束：名称形如 "CLOUDFLARENET - Cloudflare, Inc., US" 时取 " - " 之后的机构名；形如 "CLOUDFLARENET, US" 时取句柄；末尾国家代码去除。
+func DeriveISP(n uint32, name string) string {
+	if v := knownISP[n]; v != "" {
+		return v
+	}
+	up := strings.ToUpper(name)
+	for _, k := range ispKeywords {
+		if strings.Contains(up, k.kw) {
+			return k.isp
+		}
+	}
+	return orgName(name)
+}
+
+func orgName(name string) string {
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndex(name, ","); i >= 0 && len(strings.TrimSpace(name[i+1:])) == 2 {
+		name = strings.TrimSpace(name[:i])
+	}
+	if _, org, ok := strings.Cut(name, " - "); ok && strings.TrimSpace(org) != "" {
+		name = strings.TrimSpace(org)
+	}
+	return name
+}
+
+// 文档注释：名称末尾的国家代码
+// 背景：名称文件通常以 ", US" 结尾；RIR 委派文件缺失的 ASN 以此补全注册国家。
+func nameCountry(name string) string {
+	i := strings.LastIndex(name, ",")
+	if i < 0 {
+		return ""
+	}
+	cc := strings.TrimSpace(name[i+1:])
+	if len(cc) != 2 {
+		return ""
+	}
+	return strings.ToUpper(cc)
+}
+
+// 文档注释：合并名称到 ASN 元信息并推导运营商
+// 背景：委派文件提供国家与注册机构，名称文件提供展示名，两者独立导入；合并后统一计算运营商，名称文件中独有的 ASN 追加为新记录。
+func MergeNames(recs []Record, names map[uint32]string) []Record {
+	idx := make(map[uint32]int, len(recs))
+	for i, r := range recs {
+		idx[r.ASN] = i
+	}
+	for n, name := range names {
+		if i, ok := idx[n]; ok {
+			recs[i].Name = name
+			continue
+		}
+		idx[n] = len(recs)
+		recs = append(recs, Record{ASN: n, Name: name})
+	}
+	for i := range recs {
+		if recs[i].Country == "" {
+			recs[i].Country = nameCountry(recs[i].Name)
+		}
+		recs[i].ISP = DeriveISP(recs[i].ASN, recs[i].Name)
+	}
+	return recs
+}
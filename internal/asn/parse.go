@@ -0,0 +1,227 @@
+package asn
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math/bits"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// 文档注释：解析 BGP 路由表导出文件
+// 背景：兼容两种常见格式——
+// - pyasn ipasn 数据文件：`1.0.0.0/24<TAB>13335`，分号开头为注释；
+// - bgpdump -m 单行格式：`TABLE_DUMP2|时间|B|对端|对端AS|前缀|AS路径|...`，起源 AS 取路径最后一跳。
+// 约束：AS 集合（{a,b}）取首个成员；支持 asdot 写法（1.10 表示 65546）；默认路由与无法解析的行跳过。
+// 返回：前缀列表（来源标记为 SourceBGP）与跳过的行数。
+func ParseRIB(r io.Reader) ([]Prefix, int, error) {
+	var out []Prefix
+	skipped := 0
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		var pfx, origin string
+		if strings.Contains(line, "|") {
+			f := strings.Split(line, "|")
+			if len(f) < 7 {
+				skipped++
+				continue
+			}
+			pfx = f[5]
+			path := strings.Fields(f[6])
+			if len(path) > 0 {
+				origin = path[len(path)-1]
+			}
+		} else {
+			f := strings.Fields(line)
+			if len(f) < 2 {
+				skipped++
+				continue
+			}
+			pfx, origin = f[0], f[1]
+		}
+		p, err := netip.ParsePrefix(pfx)
+		n, ok := parseASN(origin)
+		if err != nil || !ok || p.Bits() == 0 {
+			skipped++
+			continue
+		}
+		out = append(out, Prefix{Net: p.Masked(), ASN: n, Source: SourceBGP})
+	}
+	return out, skipped, sc.Err()
+}
+
+// 文档注释：解析 ASN 文本
+// 约束：接受 "AS" 前缀、asdot 写法与 AS 集合；0 与超出 32 位的值视为无效。
+func parseASN(s string) (uint32, bool) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "AS"), "as")
+	if strings.HasPrefix(s, "{") {
+		s = strings.Trim(s, "{}")
+		s, _, _ = strings.Cut(s, ",")
+	}
+	if hi, lo, ok := strings.Cut(s, "."); ok {
+		h, e1 := strconv.ParseUint(hi, 10, 16)
+		l, e2 := strconv.ParseUint(lo, 10, 16)
+		if e1 != nil || e2 != nil {
+			return 0, false
+		}
+		return uint32(h<<16 | l), h|l != 0
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	return uint32(n), true
+}
+
+// 文档注释：解析 RIR 扩展委派文件（delegated-*-extended）
+// 背景：扩展格式在每行末尾带有机构标识（opaque-id），同一机构名下的 ASN 与地址段由此关联；地址段归属取该机构的最小 ASN。
+// 约束：仅处理 allocated/assigned 记录；IPv4 的 value 为地址数（非 2 的幂时拆分为多个 CIDR），IPv6 的 value 为前缀长度；
+// 机构名下没有 ASN 的地址段无法关联，计入跳过数。
+// 返回：前缀列表（来源 SourceRIR）、ASN 元信息（注册国家与机构）与跳过的地址段数。
+func ParseDelegated(r io.Reader) ([]Prefix, []Record, int, error) {
+	type block struct {
+		start string
+		value uint64
+		v6    bool
+		org   string
+	}
+	var blocks []block
+	var recs []Record
+	orgASN := map[string]uint32{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		f := strings.Split(line, "|")
+		// 版本行与汇总行（第 2 列为 * 或字段不足）跳过
+		if len(f) < 7 || f[1] == "*" {
+			continue
+		}
+		status := f[6]
+		if status != "allocated" && status != "assigned" {
+			continue
+		}
+		org := ""
+		if len(f) > 7 {
+			org = f[7]
+		}
+		v, err := strconv.ParseUint(f[4], 10, 64)
+		if err != nil || v == 0 {
+			continue
+		}
+		switch f[2] {
+		case "asn":
+			first, ok := parseASN(f[3])
+			if !ok {
+				continue
+			}
+			for i := uint64(0); i < v && uint64(first)+i <= 0xffffffff; i++ {
+				n := first + uint32(i)
+				recs = append(recs, Record{ASN: n, Country: strings.ToUpper(f[1]), Registry: f[0]})
+				if org != "" {
+					if cur, ok := orgASN[org]; !ok || n < cur {
+						orgASN[org] = n
+					}
+				}
+			}
+		case "ipv4", "ipv6":
+			blocks = append(blocks, block{start: f[3], value: v, v6: f[2] == "ipv6", org: org})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+	var out []Prefix
+	skipped := 0
+	for _, b := range blocks {
+		n, ok := orgASN[b.org]
+		a, err := netip.ParseAddr(b.start)
+		if !ok || err != nil {
+			skipped++
+			continue
+		}
+		if b.v6 {
+			if p, err := a.Prefix(int(b.value)); err == nil {
+				out = append(out, Prefix{Net: p, ASN: n, Source: SourceRIR})
+			}
+			continue
+		}
+		for _, p := range RangeToPrefixes(a, b.value) {
+			out = append(out, Prefix{Net: p, ASN: n, Source: SourceRIR})
+		}
+	}
+	return out, recs, skipped, nil
+}
+
+// 文档注释：将 IPv4 起始地址与地址数拆分为最少的 CIDR 列表
+// 约束：超出地址空间的部分截断；非 IPv4 地址返回空。
+func RangeToPrefixes(start netip.Addr, count uint64) []netip.Prefix {
+	if !start.Is4() || count == 0 {
+		return nil
+	}
+	b := start.As4()
+	cur := uint64(b[0])<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3])
+	end := min(cur+count, 1<<32)
+	var out []netip.Prefix
+	for cur < end {
+		// 当前地址的对齐块大小与剩余长度共同决定本段掩码
+		size := uint64(1) << bits.TrailingZeros64(cur|1<<32)
+		for size > end-cur {
+			size >>= 1
+		}
+		a := netip.AddrFrom4([4]byte{byte(cur >> 24), byte(cur >> 16), byte(cur >> 8), byte(cur)})
+		out = append(out, netip.PrefixFrom(a, 32-bits.TrailingZeros64(size)))
+		cur += size
+	}
+	return out
+}
+
+// 文档注释：解析 ASN 名称文件
+// 背景：兼容 pyasn 导出的 JSON（{"13335": "CLOUDFLARENET - Cloudflare, Inc., US"}）与文本格式（每行 `13335 CLOUDFLARENET, US`，可带 AS 前缀）。
+// 返回：ASN 到原始名称的映射。
+func ParseNames(r io.Reader) (map[uint32]string, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(1)
+	out := map[uint32]string{}
+	if len(head) == 1 && head[0] == '{' {
+		var m map[string]string
+		if err := json.NewDecoder(br).Decode(&m); err != nil {
+			return nil, err
+		}
+		for k, v := range m {
+			if n, ok := parseASN(k); ok {
+				out[n] = strings.TrimSpace(v)
+			}
+		}
+		return out, nil
+	}
+	sc := bufio.NewScanner(br)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		k, v, ok := strings.Cut(line, " ")
+		if !ok {
+			k, v, ok = strings.Cut(line, "\t")
+		}
+		if !ok {
+			continue
+		}
+		if n, ok := parseASN(k); ok {
+			out[n] = strings.TrimSpace(v)
+		}
+	}
+	return out, sc.Err()
+}
@@ -0,0 +1,194 @@
+// 包 asn：前缀到自治系统号（ASN）的映射数据，提供文件解析、入库与内存最长前缀匹配
+package asn
+
+import (
+	"net/netip"
+	"sort"
+	"sync/atomic"
+)
+
+// 文档注释：前缀来源
+// 背景：BGP 路由表（RIB 导出）反映实际宣告关系，优先级高于 RIR 委派文件按注册机构推断的归属。
+const (
+	SourceBGP = "bgp"
+	SourceRIR = "rir"
+)
+
+// Prefix：单条前缀映射
+type Prefix struct {
+	Net    netip.Prefix
+	ASN    uint32
+	Source string
+}
+
+// Record：ASN 元信息（名称、注册国家、注册机构与归一化运营商）
+type Record struct {
+	ASN      uint32
+	Name     string
+	Country  string
+	Registry string
+	ISP      string
+}
+
+// 文档注释：前缀集合（按掩码长度分桶）
+// 背景：前缀之间存在嵌套（如 /16 与其中的 /24 分属不同 ASN），按长度由长到短逐级查找即为最长前缀匹配；实际出现的长度通常不超过 20 种。
+// 约束：IPv4 以 (地址<<8 | 长度) 作为键以降低百万级前缀的内存占用；IPv6 直接以规范化前缀为键。
+type prefixSet struct {
+	v4     map[uint64]uint32
+	v6     map[netip.Prefix]uint32
+	v4Lens []int
+	v6Lens []int
+}
+
+func newPrefixSet() *prefixSet {
+	return &prefixSet{v4: make(map[uint64]uint32), v6: make(map[netip.Prefix]uint32)}
+}
+
+func v4Key(a netip.Addr, bits int) uint64 {
+	b := a.As4()
+	x := uint64(b[0])<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3])
+	return x<<8 | uint64(bits)
+}
+
+// NOTE: 同一前缀重复出现时保留先加入的映射，调用方按来源可信度顺序加入。
+func (s *prefixSet) add(p netip.Prefix, asn uint32) {
+	p = p.Masked()
+	if p.Addr().Is4() {
+		k := v4Key(p.Addr(), p.Bits())
+		if _, ok := s.v4[k]; !ok {
+			s.v4[k] = asn
+		}
+		return
+	}
+	if _, ok := s.v6[p]; !ok {
+		s.v6[p] = asn
+	}
+}
+
+// 文档注释：整理已出现的掩码长度（由长到短）
+func (s *prefixSet) seal() {
+	seen4 := map[int]bool{}
+	for k := range s.v4 {
+		seen4[int(k&0xff)] = true
+	}
+	seen6 := map[int]bool{}
+	for p := range s.v6 {
+		seen6[p.Bits()] = true
+	}
+	s.v4Lens = sortedDesc(seen4)
+	s.v6Lens = sortedDesc(seen6)
+}
+
+func sortedDesc(m map[int]bool) []int {
+	out := make([]int, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(out)))
+	return out
+}
+
+func (s *prefixSet) lookup(a netip.Addr) (netip.Prefix, uint32, bool) {
+	if a.Is4() {
+		for _, n := range s.v4Lens {
+			p, _ := a.Prefix(n)
+			if v, ok := s.v4[v4Key(p.Addr(), n)]; ok {
+				return p, v, true
+			}
+		}
+		return netip.Prefix{}, 0, false
+	}
+	for _, n := range s.v6Lens {
+		p, _ := a.Prefix(n)
+		if v, ok := s.v6[p]; ok {
+			return p, v, true
+		}
+	}
+	return netip.Prefix{}, 0, false
+}
+
+func (s *prefixSet) size() int { return len(s.v4) + len(s.v6) }
+
+// 文档注释：ASN 查询表（只读）
+// 背景：构建后不再修改，可被多个请求并发读取；数据更新时整表替换（见 Holder）。
+type Table struct {
+	bgp  *prefixSet
+	rir  *prefixSet
+	recs map[uint32]Record
+}
+
+// 文档注释：构建查询表
+// 参数：prefixes 为全部来源的前缀（BGP 与 RIR 分别建表）；recs 为 ASN 元信息。
+func NewTable(prefixes []Prefix, recs []Record) *Table {
+	t := &Table{bgp: newPrefixSet(), rir: newPrefixSet(), recs: make(map[uint32]Record, len(recs))}
+	for _, p := range prefixes {
+		if p.ASN == 0 || !p.Net.IsValid() {
+			continue
+		}
+		if p.Source == SourceRIR {
+			t.rir.add(p.Net, p.ASN)
+		} else {
+			t.bgp.add(p.Net, p.ASN)
+		}
+	}
+	t.bgp.seal()
+	t.rir.seal()
+	for _, r := range recs {
+		t.recs[r.ASN] = r
+	}
+	return t
+}
+
+// 文档注释：按 IP 查询所属 ASN
+// 背景：先在 BGP 表做最长前缀匹配，未命中再查 RIR 委派表；元信息缺失时仍返回 ASN 号。
+// 返回：ASN 元信息、命中的前缀与是否命中。
+func (t *Table) Lookup(ip string) (Record, netip.Prefix, bool) {
+	if t == nil {
+		return Record{}, netip.Prefix{}, false
+	}
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Record{}, netip.Prefix{}, false
+	}
+	a = a.Unmap()
+	p, n, ok := t.bgp.lookup(a)
+	if !ok {
+		p, n, ok = t.rir.lookup(a)
+	}
+	if !ok {
+		return Record{}, netip.Prefix{}, false
+	}
+	r, found := t.recs[n]
+	if !found {
+		r = Record{ASN: n}
+	}
+	if r.ISP == "" {
+		r.ISP = KnownISP(n)
+	}
+	return r, p, true
+}
+
+// Size：前缀条数与 ASN 元信息条数
+func (t *Table) Size() (int, int) {
+	if t == nil {
+		return 0, 0
+	}
+	return t.bgp.size() + t.rir.size(), len(t.recs)
+}
+
+// 文档注释：查询表持有者
+// 背景：服务启动时表尚未加载，导入后需整表替换；读路径通过原子指针无锁读取，未加载时视为未命中。
+type Holder struct{ p atomic.Pointer[Table] }
+
+func (h *Holder) Set(t *Table) { h.p.Store(t) }
+
+func (h *Holder) Table() *Table {
+	if h == nil {
+		return nil
+	}
+	return h.p.Load()
+}
+
+func (h *Holder) Lookup(ip string) (Record, netip.Prefix, bool) {
+	return h.Table().Lookup(ip)
+}
@@ -0,0 +1,180 @@
+// 包 export：把有效区间视图导出为离线格式（MaxMind mmdb、ip2region xdb、CSV、NDJSON）
+package export
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"ip-api/internal/localdb/unified"
+	"ip-api/internal/logger"
+	"ip-api/internal/version"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 支持的导出格式
+const (
+	FormatMMDB   = "mmdb"
+	FormatXDB    = "xdb"
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// Precedence：视图的来源优先级（高到低），写入清单供使用方核对
+var Precedence = []string{"_ip_overrides_kv", "_ip_overrides_kv_range", "_ip_overrides", "_ip_exact", "_ip_cidr_special", "_ip_ipv4_ranges"}
+
+// Options：导出参数
+// - Source：数据来源标识（写入清单与 mmdb 元数据，默认 ip-api）；
+// - Version：发布版本号（为空时取视图数据版本）；
+// - Language：mmdb names 的语言键（默认 zh-CN）。
+type Options struct {
+	Source   string
+	Version  string
+	Language string
+}
+
+// File：清单中的单个导出文件
+type File struct {
+	Format string `json:"format"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// 文档注释：导出清单（manifest.json）
+// 背景：使用方据此核对数据版本、来源条数与文件完整性，无需调用 /api/ip。
+type Manifest struct {
+	Source     string       `json:"source"`
+	Version    string       `json:"version"`
+	Commit     string       `json:"commit"`
+	ExportedAt time.Time    `json:"exported_at"`
+	Precedence []string     `json:"precedence"`
+	Data       unified.Meta `json:"data"`
+	Files      []File       `json:"files"`
+}
+
+type writerFunc func(w io.Writer, v *unified.View, o Options) error
+
+var writers = map[string]writerFunc{
+	FormatMMDB:   writeMMDB,
+	FormatXDB:    writeXDB,
+	FormatCSV:    writeCSV,
+	FormatNDJSON: writeNDJSON,
+}
+
+// 文档注释：解析逗号分隔的格式列表
+// 返回：去重后的格式；含未知格式时返回错误。
+func ParseFormats(s string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" || seen[f] {
+			continue
+		}
+		if _, ok := writers[f]; !ok {
+			return nil, fmt.Errorf("export: unknown format %q", f)
+		}
+		seen[f] = true
+		out = append(out, f)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("export: no format given")
+	}
+	return out, nil
+}
+
+// 文档注释：按格式写出导出文件与清单
+// 背景：每个文件先写临时文件并计算 SHA-256，完成后改名；清单最后写入，存在即表示本次导出完整。
+// 约束：文件名为 <source>-<version>.<格式>；任一格式失败即返回，已写出的文件保留但清单不更新。
+func Run(v *unified.View, dir string, formats []string, o Options) (Manifest, error) {
+	if o.Source == "" {
+		o.Source = "ip-api"
+	}
+	if o.Version == "" {
+		o.Version = fmt.Sprint(v.Meta.Version)
+	}
+	if o.Language == "" {
+		o.Language = "zh-CN"
+	}
+	m := Manifest{Source: o.Source, Version: o.Version, Commit: version.Commit, ExportedAt: time.Now().UTC(), Precedence: Precedence, Data: v.Meta}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return m, err
+	}
+	for _, f := range formats {
+		wf, ok := writers[f]
+		if !ok {
+			return m, fmt.Errorf("export: unknown format %q", f)
+		}
+		t0 := time.Now()
+		name := o.Source + "-" + o.Version + "." + f
+		file, err := writeFile(filepath.Join(dir, name), func(w io.Writer) error { return wf(w, v, o) })
+		if err != nil {
+			return m, fmt.Errorf("export %s: %w", f, err)
+		}
+		file.Format = f
+		m.Files = append(m.Files, file)
+		logger.L().Info("export_file_done", "format", f, "name", name, "size", file.Size, "ms", time.Since(t0).Milliseconds())
+	}
+	b, _ := json.MarshalIndent(m, "", "  ")
+	_, err := writeFile(filepath.Join(dir, "manifest.json"), func(w io.Writer) error {
+		_, err := w.Write(append(b, '\n'))
+		return err
+	})
+	return m, err
+}
+
+func writeFile(path string, fn func(w io.Writer) error) (File, error) {
+	out := File{Name: filepath.Base(path)}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return out, err
+	}
+	h := sha256.New()
+	bw := bufio.NewWriterSize(io.MultiWriter(f, h), 1<<20)
+	if err := fn(bw); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return out, err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return out, err
+	}
+	if st, err := f.Stat(); err == nil {
+		out.Size = st.Size()
+	}
+	if err := f.Close(); err != nil {
+		return out, err
+	}
+	out.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return out, os.Rename(tmp, path)
+}
+
+// 文档注释：把闭区间拆成最少的 CIDR（前缀长度至少为 1，/0 拆为两个 /1）
+func cidrs(start, end uint32, fn func(ip uint32, bits int)) {
+	s, e := uint64(start), uint64(end)
+	for s <= e {
+		bits := 32
+		for bits > 1 {
+			size := uint64(1) << (32 - (bits - 1))
+			if s&(size-1) != 0 || s+size-1 > e {
+				break
+			}
+			bits--
+		}
+		fn(uint32(s), bits)
+		s += uint64(1) << (32 - bits)
+	}
+}
+
+func ipString(v uint32) string {
+	return fmt.Sprintf("%d.%d.%d.%d", v>>24, v>>16&0xff, v>>8&0xff, v&0xff)
+}
@@ -0,0 +1,219 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"ip-api/internal/localdb"
+	"ip-api/internal/localdb/unified"
+	"time"
+)
+
+// 文档注释：MaxMind DB（v2.0）写出
+// 背景：按 GeoIP2-City 的字段结构组织数据（country/subdivisions/city 的 names），常见 geoip2/maxminddb 读取库可直接使用；
+// 额外在顶层写入 region 与 isp 字符串。
+// 约束：
+// - ip_version=4、记录 32 位；IPv6 查询不受支持；
+// - 相同地点只写一份数据，树叶子通过指针引用；视图空隙在树中为空记录（查询未命中）。
+const (
+	mmdbRecordSize = 32
+	mmdbMetaMarker = "\xab\xcd\xefMaxMind.com"
+)
+
+// 数据区类型编号（见 MaxMind DB 规范）
+const (
+	mmdbString = 2
+	mmdbMap    = 7
+	mmdbUint16 = 5
+	mmdbUint32 = 6
+	mmdbUint64 = 9
+	mmdbArray  = 11
+)
+
+var errTreeTooLarge = errors.New("export: mmdb search tree exceeds 32-bit records")
+
+// mmdbTree：构建期二叉树；子节点 0 为空、正数为节点下标、负数为 -(地点下标+1)
+type mmdbTree struct {
+	nodes [][2]int32
+}
+
+func (t *mmdbTree) insert(ip uint32, bits int, loc int32) {
+	n := int32(0)
+	for i := 0; i < bits-1; i++ {
+		b := ip >> (31 - i) & 1
+		c := t.nodes[n][b]
+		if c <= 0 {
+			// 视图区间互不重叠，路径上不会遇到叶子；为空时新建节点
+			c = int32(len(t.nodes))
+			t.nodes = append(t.nodes, [2]int32{})
+			t.nodes[n][b] = c
+		}
+		n = c
+	}
+	t.nodes[n][ip>>(32-bits)&1] = -(loc + 1)
+}
+
+func writeMMDB(w io.Writer, v *unified.View, o Options) error {
+	t := &mmdbTree{nodes: make([][2]int32, 1, 1024)}
+	var data mmdbEncoder
+	var offs []uint32
+	idx := map[localdb.Location]int32{}
+	err := v.Each(func(s, e uint32, l localdb.Location) error {
+		li, ok := idx[l]
+		if !ok {
+			li = int32(len(offs))
+			idx[l] = li
+			offs = append(offs, uint32(data.Len()))
+			data.location(l, o.Language)
+		}
+		cidrs(s, e, func(ip uint32, bits int) { t.insert(ip, bits, li) })
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	nc := uint64(len(t.nodes))
+	if nc+16+uint64(data.Len()) > 0xffffffff {
+		return errTreeTooLarge
+	}
+	rec := func(c int32) uint32 {
+		switch {
+		case c == 0:
+			return uint32(nc)
+		case c > 0:
+			return uint32(c)
+		default:
+			return uint32(nc) + 16 + offs[-c-1]
+		}
+	}
+	var buf [8]byte
+	for _, n := range t.nodes {
+		binary.BigEndian.PutUint32(buf[0:], rec(n[0]))
+		binary.BigEndian.PutUint32(buf[4:], rec(n[1]))
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(make([]byte, 16)); err != nil {
+		return err
+	}
+	if _, err := w.Write(data.Bytes()); err != nil {
+		return err
+	}
+	var meta mmdbEncoder
+	meta.WriteString(mmdbMetaMarker)
+	meta.control(mmdbMap, 9)
+	meta.str("binary_format_major_version")
+	meta.uint(mmdbUint16, 2)
+	meta.str("binary_format_minor_version")
+	meta.uint(mmdbUint16, 0)
+	meta.str("build_epoch")
+	meta.uint(mmdbUint64, uint64(time.Now().Unix()))
+	meta.str("database_type")
+	meta.str(o.Source + "-City")
+	meta.str("description")
+	meta.control(mmdbMap, 1)
+	meta.str("en")
+	meta.str(o.Source + " effective view " + o.Version)
+	meta.str("ip_version")
+	meta.uint(mmdbUint16, 4)
+	meta.str("languages")
+	meta.control(mmdbArray, 1)
+	meta.str(o.Language)
+	meta.str("node_count")
+	meta.uint(mmdbUint32, nc)
+	meta.str("record_size")
+	meta.uint(mmdbUint16, mmdbRecordSize)
+	_, err = w.Write(meta.Bytes())
+	return err
+}
+
+// mmdbEncoder：数据区编码（只实现导出用到的类型）
+type mmdbEncoder struct {
+	bytes.Buffer
+}
+
+// control：写控制字节；扩展类型（>7）先写类型字节，长度扩展字节随后
+func (e *mmdbEncoder) control(typ int, size int) {
+	var ext []byte
+	switch {
+	case size < 29:
+	case size < 29+256:
+		ext = []byte{byte(size - 29)}
+		size = 29
+	case size < 285+65536:
+		n := size - 285
+		ext = []byte{byte(n >> 8), byte(n)}
+		size = 30
+	default:
+		n := size - 65821
+		ext = []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+		size = 31
+	}
+	if typ > 7 {
+		e.WriteByte(byte(size))
+		e.WriteByte(byte(typ - 7))
+	} else {
+		e.WriteByte(byte(typ<<5 | size))
+	}
+	e.Write(ext)
+}
+
+func (e *mmdbEncoder) str(s string) {
+	e.control(mmdbString, len(s))
+	e.WriteString(s)
+}
+
+// uint：无符号整数按最少字节大端写出
+func (e *mmdbEncoder) uint(typ int, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	n := 0
+	for n < 8 && b[n] == 0 {
+		n++
+	}
+	e.control(typ, 8-n)
+	e.Write(b[n:])
+}
+
+// names：{"names": {lang: s}}
+func (e *mmdbEncoder) names(lang, s string) {
+	e.control(mmdbMap, 1)
+	e.str("names")
+	e.control(mmdbMap, 1)
+	e.str(lang)
+	e.str(s)
+}
+
+// location：按 GeoIP2-City 结构写出地点，空字段不写
+func (e *mmdbEncoder) location(l localdb.Location, lang string) {
+	n := 0
+	for _, s := range []string{l.Country, l.Province, l.City, l.Region, l.ISP} {
+		if s != "" {
+			n++
+		}
+	}
+	e.control(mmdbMap, n)
+	if l.City != "" {
+		e.str("city")
+		e.names(lang, l.City)
+	}
+	if l.Country != "" {
+		e.str("country")
+		e.names(lang, l.Country)
+	}
+	if l.ISP != "" {
+		e.str("isp")
+		e.str(l.ISP)
+	}
+	if l.Region != "" {
+		e.str("region")
+		e.str(l.Region)
+	}
+	if l.Province != "" {
+		e.str("subdivisions")
+		e.control(mmdbArray, 1)
+		e.names(lang, l.Province)
+	}
+}
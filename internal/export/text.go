@@ -0,0 +1,48 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"ip-api/internal/localdb"
+	"ip-api/internal/localdb/unified"
+	"strconv"
+)
+
+// 文档注释：CSV 区间文件
+// 布局：首行为列名 start_ip,end_ip,start_int,end_int,country,region,province,city,isp；每行一个闭区间，按起始升序。
+func writeCSV(w io.Writer, v *unified.View, _ Options) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"start_ip", "end_ip", "start_int", "end_int", "country", "region", "province", "city", "isp"})
+	err := v.Each(func(s, e uint32, l localdb.Location) error {
+		return cw.Write([]string{ipString(s), ipString(e), strconv.FormatUint(uint64(s), 10), strconv.FormatUint(uint64(e), 10),
+			l.Country, l.Region, l.Province, l.City, l.ISP})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type ndjsonRow struct {
+	StartIP  string `json:"start_ip"`
+	EndIP    string `json:"end_ip"`
+	StartInt uint32 `json:"start_int"`
+	EndInt   uint32 `json:"end_int"`
+	Country  string `json:"country"`
+	Region   string `json:"region"`
+	Province string `json:"province"`
+	City     string `json:"city"`
+	ISP      string `json:"isp"`
+}
+
+// 文档注释：NDJSON 区间文件
+// 布局：每行一个 JSON 对象，字段同 CSV 列名，地点字段名与 /api/ip 返回一致。
+func writeNDJSON(w io.Writer, v *unified.View, _ Options) error {
+	enc := json.NewEncoder(w)
+	return v.Each(func(s, e uint32, l localdb.Location) error {
+		return enc.Encode(ndjsonRow{StartIP: ipString(s), EndIP: ipString(e), StartInt: s, EndInt: e,
+			Country: l.Country, Region: l.Region, Province: l.Province, City: l.City, ISP: l.ISP})
+	})
+}
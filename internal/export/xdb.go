@@ -0,0 +1,122 @@
+package export
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"ip-api/internal/localdb"
+	"ip-api/internal/localdb/unified"
+	"time"
+)
+
+// 文档注释：ip2region xdb（结构 2.0，IPv4）写出
+// 布局（小端序）：256 字节头｜向量索引 256×256×8 字节（按前两段定位段索引的首/末条）｜地区字符串区｜段索引（每条 14 字节：起、止、地区长度 uint16、地区偏移）。
+// 约束：
+// - 段索引覆盖全部 IPv4 空间且每段不跨 /16；视图空隙写为地区长度 0，ip2region 查询返回空串（未命中）；
+// - 地区字符串为 国家|区域|省份|城市|ISP，空字段写 0（与 ip2region 惯例及本仓库解析一致），相同地区只写一份；
+// - 文件超过 4GiB 时返回错误。
+const (
+	xdbHeaderLen  = 256
+	xdbVectorRows = 256
+	xdbVectorCols = 256
+	xdbVectorSize = 8
+	xdbSegSize    = 14
+	xdbStructure  = 2
+	xdbPolicy     = 1
+)
+
+var errXDBTooLarge = errors.New("export: xdb file exceeds 4GiB")
+
+func writeXDB(w io.Writer, v *unified.View, _ Options) error {
+	type region struct {
+		off uint32
+		n   uint16
+	}
+	dataOff := uint64(xdbHeaderLen + xdbVectorRows*xdbVectorCols*xdbVectorSize)
+	var data []byte
+	var segs []byte
+	regions := map[localdb.Location]region{}
+	var rec [xdbSegSize]byte
+	// emit：追加一段（按 /16 切分），r 为空时表示空隙
+	emit := func(s, e uint32, r region) {
+		for {
+			end := min(e, s|0xffff)
+			binary.LittleEndian.PutUint32(rec[0:], s)
+			binary.LittleEndian.PutUint32(rec[4:], end)
+			binary.LittleEndian.PutUint16(rec[8:], r.n)
+			binary.LittleEndian.PutUint32(rec[10:], r.off)
+			segs = append(segs, rec[:]...)
+			if end == e {
+				return
+			}
+			s = end + 1
+		}
+	}
+	next := uint64(0)
+	err := v.Each(func(s, e uint32, l localdb.Location) error {
+		r, ok := regions[l]
+		if !ok {
+			txt := regionText(l)
+			r = region{off: uint32(dataOff + uint64(len(data))), n: uint16(len(txt))}
+			data = append(data, txt...)
+			regions[l] = r
+			if dataOff+uint64(len(data)) > 0xffffffff {
+				return errXDBTooLarge
+			}
+		}
+		if uint64(s) > next {
+			emit(uint32(next), s-1, region{})
+		}
+		emit(s, e, r)
+		next = uint64(e) + 1
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if next <= 0xffffffff {
+		emit(uint32(next), 0xffffffff, region{})
+	}
+	segOff := dataOff + uint64(len(data))
+	if segOff+uint64(len(segs)) > 0xffffffff {
+		return errXDBTooLarge
+	}
+	// 向量索引：每个 /16 的首条与末条段索引位置
+	vec := make([]byte, xdbVectorRows*xdbVectorCols*xdbVectorSize)
+	for i := 0; i < len(segs); i += xdbSegSize {
+		p := uint32(segOff) + uint32(i)
+		k := int(binary.LittleEndian.Uint32(segs[i:])>>16) * xdbVectorSize
+		if binary.LittleEndian.Uint32(vec[k+4:]) == 0 {
+			binary.LittleEndian.PutUint32(vec[k:], p)
+		}
+		binary.LittleEndian.PutUint32(vec[k+4:], p)
+	}
+	hdr := make([]byte, xdbHeaderLen)
+	binary.LittleEndian.PutUint16(hdr[0:], xdbStructure)
+	binary.LittleEndian.PutUint16(hdr[2:], xdbPolicy)
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(time.Now().Unix()))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(segOff))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(segOff)+uint32(len(segs)-xdbSegSize))
+	binary.LittleEndian.PutUint16(hdr[16:], 4)
+	binary.LittleEndian.PutUint16(hdr[18:], 4)
+	for _, b := range [][]byte{hdr, vec, data, segs} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func regionText(l localdb.Location) string {
+	out := ""
+	for i, s := range []string{l.Country, l.Region, l.Province, l.City, l.ISP} {
+		if s == "" {
+			s = "0"
+		}
+		if i > 0 {
+			out += "|"
+		}
+		out += s
+	}
+	return out
+}
@@ -6,7 +6,6 @@ import (
 	"ip-api/internal/localdb"
 	"ip-api/internal/store"
 	"math"
-	"net/http"
 	"os"
 	"strconv"
 )
@@ -17,6 +16,10 @@ type Location struct {
 	Province string
 	City     string
 	ISP      string
+	// ASN 为自治系统号（0 表示未知）；不参与质量系数与一致性计算
+	ASN int
+	// Adcode 为行政区划代码（如高德 adcode），用于下游按代码关联；不参与投票
+	Adcode string
 }
 
 type WeightedResult struct {
@@ -52,7 +55,8 @@ func qualityCoeff(loc Location) float64 {
 }
 
 // 文档注释：加权聚合并返回融合结果与最高分
-func Aggregate(ctx context.Context, sources []DataSource, ip string) (Location, float64, float64) {
+// 返回：融合结果、最高分、最高分来源置信度，以及与融合结果一致的来源数（供写回策略判定）。
+func Aggregate(ctx context.Context, sources []DataSource, ip string) (Location, float64, float64, int) {
 	var results []WeightedResult
 	for _, s := range sources {
 		loc, conf := s.Query(ctx, ip)
@@ -109,21 +113,25 @@ func Aggregate(ctx context.Context, sources []DataSource, ip string) (Location,
 		maxScore = results[0].Score
 		maxConf = results[0].Confidence
 	}
-	return out, maxScore, maxConf
+	locs := make([]Location, 0, len(results))
+	for _, r := range results {
+		locs = append(locs, r.Location)
+	}
+	return out, maxScore, maxConf, Agreement(out, locs)
 }
 
 // 数据源实现：AMap REST
+// 约束：Client 负责重试与配额；置信度按定位矩形半径区分城市级与更粗粒度结果。
 type AMapSource struct {
-	Key    string
-	Client *http.Client
+	Client *amap.Client
 }
 
 func (s *AMapSource) Query(ctx context.Context, ip string) (Location, float64) {
 	var out Location
-	if s.Key == "" {
+	if s.Client == nil || s.Client.Key == "" {
 		return out, 0
 	}
-	r, err := amap.QueryIP(ctx, s.Client, s.Key, ip)
+	r, err := s.Client.QueryIP(ctx, ip)
 	if err != nil || r == nil || r.Status != "1" {
 		return out, 0.2
 	}
@@ -131,8 +139,25 @@ func (s *AMapSource) Query(ctx context.Context, ip string) (Location, float64) {
 	out.Region = r.Province
 	out.Province = r.Province
 	out.City = r.City
-	return out, 0.8
+	out.Adcode = r.Adcode
+	return out, AMapConfidence(r)
 }
+
+// 文档注释：高德结果置信度
+// 背景：高德对无法精确定位的 IP 返回空城市或覆盖范围很大的矩形，此时结果只可信到省级。
+func AMapConfidence(r *amap.IPResponse) float64 {
+	if r.Province == "" {
+		return 0.2
+	}
+	if r.City == "" {
+		return 0.5
+	}
+	if _, _, radius, ok := r.Centroid(); ok && radius > 80 {
+		return 0.7
+	}
+	return 0.8
+}
+
 func (s *AMapSource) GetWeight() float64 { return readWeight("FUSION_WEIGHT_AMAP", 8.0) }
 func (s *AMapSource) Name() string       { return "amap" }
 
@@ -230,11 +255,3 @@ func readWeight(env string, def float64) float64 {
 	}
 	return f
 }
-
-// 文档注释：根据最高分决定写库策略（简化）
-// 背景：单 IP 融合后，根据分数阈值决定写入 KV（覆盖）或 Exact（精确）；CIDR 聚合后续扩展。
-func DecideWrite(out Location, score float64) (writeKV bool, writeExact bool) {
-	writeKV = true
-	writeExact = score >= 80.0
-	return
-}
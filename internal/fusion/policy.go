@@ -0,0 +1,284 @@
+package fusion
+
+import (
+	"context"
+	"ip-api/internal/logger"
+	"ip-api/internal/metrics"
+	"ip-api/internal/store"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 文档注释：单个 assoc_key 的写回阈值
+// 背景：不同来源可信度不同（如 AMap 精细但偶有偏差、IPIP 稳定但粗粒度），需分别设置写入门槛与覆盖分差。
+type AssocThreshold struct {
+	KVMinScore    float64
+	ExactMinScore float64
+	Margin        float64
+}
+
+// 文档注释：写回决策
+// 背景：描述一次融合结果“是否/如何”写库；Reason 为跳过原因（写入时为 ok），用于指标与日志。
+// 约束：DryRun 为 true 时 WriteKV/WriteExact 仅表示“本应写入”，调用方不得落库。
+type WriteDecision struct {
+	WriteKV    bool
+	WriteExact bool
+	Assoc      string
+	Margin     float64
+	Reason     string
+	DryRun     bool
+}
+
+// 文档注释：融合结果写回策略
+// 背景：原实现总是写 KV、分数 ≥80 写精确表，融合答案可能覆盖运维录入值，单个噪声来源也可能把错误数据固化到库中。
+// 约束：判定顺序为 空结果 → 受保护命名空间 → 分数阈值 → 一致来源数 → 冷却期 → 演练；任一环节不满足即跳过。
+type WritePolicy struct {
+	Default       AssocThreshold
+	PerAssoc      map[string]AssocThreshold
+	MinAgreeing   int
+	Cooldown      time.Duration
+	Protected     []string
+	FallbackAssoc string
+	DryRun        bool
+	// Store 用于检查受保护命名空间中是否已有人工覆盖；为空时只拦截直接写入受保护命名空间
+	Store *store.Store
+	// rc 非空时冷却期记录在 Redis，多实例共享；否则仅进程内生效
+	rc       *redis.Client
+	mu       sync.Mutex
+	cooldown map[string]time.Time
+}
+
+// 文档注释：从环境变量加载写回策略
+// 参数：st 为数据访问入口；rc 为可选 Redis 客户端（冷却期共享）。
+// 约束：
+// - WRITEBACK_KV_MIN_SCORE / WRITEBACK_EXACT_MIN_SCORE / WRITEBACK_OVERWRITE_MARGIN 为默认阈值（0/80/20，与原行为一致）；
+// - WRITEBACK_ASSOC_THRESHOLDS 形如 "amap=30:85:10,ipip=40"，依次为 KV 阈值:精确阈值:覆盖分差，缺省项沿用默认；
+// - WRITEBACK_MIN_AGREEING（默认 1）、WRITEBACK_COOLDOWN_SECONDS（默认 0 关闭）、WRITEBACK_PROTECTED_NAMESPACES（默认 global）；
+// - WRITEBACK_FALLBACK_ASSOC 为无来源关联键时的写入命名空间（默认 fusion）；WRITEBACK_DRY_RUN=true 时只记录不落库。
+func LoadWritePolicy(st *store.Store, rc *redis.Client) *WritePolicy {
+	p := &WritePolicy{
+		Default: AssocThreshold{
+			KVMinScore:    readWeight("WRITEBACK_KV_MIN_SCORE", 0),
+			ExactMinScore: readWeight("WRITEBACK_EXACT_MIN_SCORE", 80),
+			Margin:        readWeight("WRITEBACK_OVERWRITE_MARGIN", 20),
+		},
+		PerAssoc:      map[string]AssocThreshold{},
+		MinAgreeing:   1,
+		Protected:     []string{"global"},
+		FallbackAssoc: "fusion",
+		DryRun:        strings.ToLower(os.Getenv("WRITEBACK_DRY_RUN")) == "true",
+		Store:         st,
+		rc:            rc,
+		cooldown:      map[string]time.Time{},
+	}
+	if n, err := strconv.Atoi(os.Getenv("WRITEBACK_MIN_AGREEING")); err == nil && n > 0 {
+		p.MinAgreeing = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("WRITEBACK_COOLDOWN_SECONDS")); err == nil && n > 0 {
+		p.Cooldown = time.Duration(n) * time.Second
+	}
+	if v, ok := os.LookupEnv("WRITEBACK_PROTECTED_NAMESPACES"); ok {
+		p.Protected = splitList(v)
+	}
+	if v := strings.TrimSpace(os.Getenv("WRITEBACK_FALLBACK_ASSOC")); v != "" {
+		p.FallbackAssoc = v
+	}
+	for _, item := range splitList(os.Getenv("WRITEBACK_ASSOC_THRESHOLDS")) {
+		name, spec, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			logger.L().Warn("writeback_threshold_invalid", "item", item)
+			continue
+		}
+		th := p.Default
+		dst := []*float64{&th.KVMinScore, &th.ExactMinScore, &th.Margin}
+		for i, part := range strings.Split(spec, ":") {
+			if i >= len(dst) || strings.TrimSpace(part) == "" {
+				continue
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(part), 64); err == nil {
+				*dst[i] = f
+			}
+		}
+		p.PerAssoc[strings.TrimSpace(name)] = th
+	}
+	logger.L().Info("writeback_policy_loaded", "min_agreeing", p.MinAgreeing, "cooldown_s", int(p.Cooldown.Seconds()),
+		"protected", strings.Join(p.Protected, ","), "assoc_overrides", len(p.PerAssoc), "dry_run", p.DryRun)
+	return p
+}
+
+// 文档注释：评估一次融合结果的写回
+// 参数：assoc 为最高分来源关联键（可为空）；agreeing 为与融合结果一致的来源数。
+// 返回：写回决策；写入决策会同时登记冷却期，演练模式不登记。
+// 约束：冷却期在其余判定全部通过后才登记；落库时被拒（分差不足未覆盖）或失败的，调用方须调用 ReleaseCooldown 归还。
+func (p *WritePolicy) Decide(ctx context.Context, ip string, out Location, score float64, assoc string, agreeing int) WriteDecision {
+	if assoc == "" {
+		assoc = p.FallbackAssoc
+	}
+	d := WriteDecision{Assoc: assoc, DryRun: p.DryRun}
+	th := p.threshold(assoc)
+	d.Margin = th.Margin
+	switch {
+	case out.Country == "" && out.Region == "" && out.Province == "" && out.City == "" && out.ISP == "":
+		d.Reason = "empty"
+	case p.isProtected(assoc):
+		d.Reason = "protected_namespace"
+	case p.hasProtectedOverride(ctx, ip):
+		d.Reason = "protected_override"
+	case score < th.KVMinScore:
+		d.Reason = "below_threshold"
+	case agreeing < p.MinAgreeing:
+		d.Reason = "insufficient_agreement"
+	case !p.acquireCooldown(ctx, ip):
+		d.Reason = "cooldown"
+	default:
+		d.Reason = "ok"
+		d.WriteKV = true
+		d.WriteExact = score >= th.ExactMinScore
+	}
+	outcome := d.Reason
+	if d.WriteKV && d.DryRun {
+		outcome = "dry_run"
+	}
+	metrics.WritebackDecisionsTotal.WithLabelValues(assoc, outcome).Inc()
+	if d.WriteKV && d.DryRun {
+		logger.L().Info("writeback_dry_run", "ip", ip, "assoc", assoc, "score", score, "agreeing", agreeing, "exact", d.WriteExact, "loc", out)
+	} else {
+		logger.L().Debug("writeback_decision", "ip", ip, "assoc", assoc, "score", score, "agreeing", agreeing, "reason", d.Reason, "exact", d.WriteExact)
+	}
+	return d
+}
+
+func (p *WritePolicy) threshold(assoc string) AssocThreshold {
+	if th, ok := p.PerAssoc[assoc]; ok {
+		return th
+	}
+	return p.Default
+}
+
+func (p *WritePolicy) isProtected(assoc string) bool {
+	for _, n := range p.Protected {
+		if n == assoc {
+			return true
+		}
+	}
+	return false
+}
+
+// NOTE: 查询失败时按“存在覆盖”处理，宁可少写也不冒险改写人工数据。
+func (p *WritePolicy) hasProtectedOverride(ctx context.Context, ip string) bool {
+	if p.Store == nil || len(p.Protected) == 0 {
+		return false
+	}
+	ok, err := p.Store.HasOverrideIn(ctx, ip, p.Protected)
+	if err != nil {
+		logger.L().Warn("writeback_protected_check_error", "ip", ip, "err", err)
+		return true
+	}
+	return ok
+}
+
+// 文档注释：登记并检查冷却期
+// 背景：同一 IP 在短时间内反复融合（如热点 IP 每次命中不完整缓存）会导致频繁改写与重建，冷却期内仅首次写入生效。
+// 返回：true 表示可写（已登记冷却）；演练模式只检查不登记。
+func (p *WritePolicy) acquireCooldown(ctx context.Context, ip string) bool {
+	if p.Cooldown <= 0 {
+		return true
+	}
+	if p.rc != nil {
+		key := "wbcool:" + ip
+		if p.DryRun {
+			n, err := p.rc.Exists(ctx, key).Result()
+			return err != nil || n == 0
+		}
+		ok, err := p.rc.SetNX(ctx, key, 1, p.Cooldown).Result()
+		if err == nil {
+			return ok
+		}
+		logger.L().Debug("writeback_cooldown_redis_error", "err", err)
+	}
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if until, ok := p.cooldown[ip]; ok && now.Before(until) {
+		return false
+	}
+	if p.DryRun {
+		return true
+	}
+	// 惰性清理过期条目，避免长时间运行后映射无限增长
+	if len(p.cooldown) >= 65536 {
+		for k, until := range p.cooldown {
+			if now.After(until) {
+				delete(p.cooldown, k)
+			}
+		}
+	}
+	p.cooldown[ip] = now.Add(p.Cooldown)
+	return true
+}
+
+// 文档注释：归还冷却期
+// 背景：Decide 放行后，落库仍可能因分差不足未覆盖或写库失败而未生效；不归还会让冷却期内的正常写回被误拦。
+// NOTE: Redis 中直接删除冷却键；并发下可能连带释放其他实例刚登记的冷却，只会多放行一次写入。
+func (p *WritePolicy) ReleaseCooldown(ctx context.Context, ip string) {
+	if p == nil || p.Cooldown <= 0 || p.DryRun {
+		return
+	}
+	if p.rc != nil {
+		if err := p.rc.Del(ctx, "wbcool:"+ip).Err(); err != nil {
+			logger.L().Debug("writeback_cooldown_redis_error", "err", err)
+		}
+	}
+	p.mu.Lock()
+	delete(p.cooldown, ip)
+	p.mu.Unlock()
+}
+
+// 文档注释：统计与融合结果一致的来源数
+// 背景：按融合结果最细的非空层级（城市 → 省份 → 国家）比较，消除“省/市”等后缀差异；空结果来源不计入。
+func Agreement(out Location, locs []Location) int {
+	get := func(l Location) string { return l.Country }
+	switch {
+	case out.City != "":
+		get = func(l Location) string { return l.City }
+	case out.Province != "":
+		get = func(l Location) string { return l.Province }
+	case out.Country == "":
+		return 0
+	}
+	want := NormalizeName(get(out))
+	n := 0
+	for _, l := range locs {
+		if v := NormalizeName(get(l)); v != "" && v == want {
+			n++
+		}
+	}
+	return n
+}
+
+// 文档注释：地名归一化（比较用）
+// NOTE: 仅消除常见行政后缀与空白差异（如“广东省”与“广东”），不做同义词映射。
+func NormalizeName(v string) string {
+	v = strings.TrimSpace(v)
+	for _, suf := range []string{"省", "市", "自治区"} {
+		if t := strings.TrimSuffix(v, suf); t != "" {
+			v = t
+		}
+	}
+	return v
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
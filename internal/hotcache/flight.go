@@ -0,0 +1,48 @@
+package hotcache
+
+import (
+	"ip-api/internal/metrics"
+	"sync"
+)
+
+// 文档注释：单飞合并组
+// 背景：同一 IP 的并发未命中原本各自穿透到数据库与插件融合；同一键同时只执行一次，其余调用等待并共享结果。
+// 约束：结果在调用方之间共享，不得修改；fn 的 panic 不做恢复（与直接调用一致）。
+type Group[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[V]
+}
+
+type call[V any] struct {
+	wg  sync.WaitGroup
+	val V
+	err error
+}
+
+// 文档注释：执行或等待同键调用
+// 返回：结果、错误，以及本次是否为共享他人结果（shared=true 表示未实际执行 fn）。
+func (g *Group[V]) Do(key string, fn func() (V, error)) (v V, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[V])
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		metrics.HotCacheFlightsTotal.WithLabelValues("shared").Inc()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	c := &call[V]{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+	metrics.HotCacheFlightsTotal.WithLabelValues("leader").Inc()
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.val, c.err = fn()
+	return c.val, c.err, false
+}
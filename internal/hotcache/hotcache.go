@@ -0,0 +1,168 @@
+// 包 hotcache：/ip 查询路径的进程内热点缓存（分段 LRU + TTL）、单飞合并与跨进程失效
+package hotcache
+
+import (
+	"container/list"
+	"hash/maphash"
+	"ip-api/internal/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 文档注释：分段 LRU 热点缓存
+// 背景：未启用 Redis 时没有结果缓存，启用时每次请求也要一次网络往返；进程内缓存位于 Redis 之前，热点 IP 直接在内存命中。
+// 按键哈希分段加锁，降低高并发下的锁竞争；各段容量满时淘汰最久未使用条目，读取时惰性剔除过期条目。
+// 约束：值按原样返回，调用方不得修改；容量为 0 的缓存为 nil，所有方法对 nil 接收者安全（视为未命中）。
+// 失效代数：按段记录，Delete 递增键所在段的代数、Purge 递增全部段的代数；SetAt 仅在该段代数未变时写入，
+// 避免失效前开始的解析把旧结果写回，且单键删除不会丢弃其它段上并发解析的写入。
+type Cache[V any] struct {
+	seed   maphash.Seed
+	ttl    time.Duration
+	shards []*shard[V]
+}
+
+type shard[V any] struct {
+	mu   sync.Mutex
+	gen  atomic.Uint64
+	cap  int
+	lst  *list.List
+	dict map[string]*list.Element
+}
+
+type entry[V any] struct {
+	key string
+	val V
+	exp time.Time
+}
+
+// 文档注释：创建热点缓存
+// 参数：capacity 为总条目上限（≤0 时返回 nil，即关闭）；shards 为分段数；ttl 为条目存活时长。
+func New[V any](capacity, shards int, ttl time.Duration) *Cache[V] {
+	if capacity <= 0 || ttl <= 0 {
+		return nil
+	}
+	shards = max(min(shards, capacity), 1)
+	c := &Cache[V]{seed: maphash.MakeSeed(), ttl: ttl, shards: make([]*shard[V], shards)}
+	for i := range c.shards {
+		c.shards[i] = &shard[V]{cap: max(capacity/shards, 1), lst: list.New(), dict: make(map[string]*list.Element)}
+	}
+	return c
+}
+
+func (c *Cache[V]) shard(key string) *shard[V] {
+	return c.shards[maphash.String(c.seed, key)%uint64(len(c.shards))]
+}
+
+func (c *Cache[V]) Get(key string) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+	s := c.shard(key)
+	s.mu.Lock()
+	e, ok := s.dict[key]
+	if ok {
+		it := e.Value.(entry[V])
+		if time.Now().Before(it.exp) {
+			s.lst.MoveToFront(e)
+			s.mu.Unlock()
+			metrics.HotCacheLookupsTotal.WithLabelValues("hit").Inc()
+			return it.val, true
+		}
+		s.lst.Remove(e)
+		delete(s.dict, key)
+		metrics.HotCacheEntries.Dec()
+	}
+	s.mu.Unlock()
+	metrics.HotCacheLookupsTotal.WithLabelValues("miss").Inc()
+	return zero, false
+}
+
+// Gen：键所在段的当前失效代数（解析开始前读取，写回时传给 SetAt）
+func (c *Cache[V]) Gen(key string) uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.shard(key).gen.Load()
+}
+
+// Set：无条件写入
+func (c *Cache[V]) Set(key string, v V) { c.SetAt(key, v, c.Gen(key)) }
+
+// SetAt：键所在段的代数仍为 gen 时写入（期间该段发生过失效则丢弃）
+func (c *Cache[V]) SetAt(key string, v V, gen uint64) {
+	if c == nil {
+		return
+	}
+	s := c.shard(key)
+	it := entry[V]{key: key, val: v, exp: time.Now().Add(c.ttl)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gen.Load() != gen {
+		return
+	}
+	if e, ok := s.dict[key]; ok {
+		e.Value = it
+		s.lst.MoveToFront(e)
+		return
+	}
+	s.dict[key] = s.lst.PushFront(it)
+	metrics.HotCacheEntries.Inc()
+	for s.lst.Len() > s.cap {
+		back := s.lst.Back()
+		delete(s.dict, back.Value.(entry[V]).key)
+		s.lst.Remove(back)
+		metrics.HotCacheEntries.Dec()
+		metrics.HotCacheEvictionsTotal.Inc()
+	}
+}
+
+// Delete：删除单个键
+func (c *Cache[V]) Delete(key string) {
+	if c == nil {
+		return
+	}
+	s := c.shard(key)
+	s.mu.Lock()
+	s.gen.Add(1)
+	if e, ok := s.dict[key]; ok {
+		s.lst.Remove(e)
+		delete(s.dict, key)
+		metrics.HotCacheEntries.Dec()
+	}
+	s.mu.Unlock()
+}
+
+// 文档注释：清空全部条目
+// 返回：清理条数。
+func (c *Cache[V]) Purge() int {
+	if c == nil {
+		return 0
+	}
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.gen.Add(1)
+		n += len(s.dict)
+		s.lst.Init()
+		clear(s.dict)
+		s.mu.Unlock()
+	}
+	metrics.HotCacheEntries.Sub(float64(n))
+	return n
+}
+
+// Len：当前条目数（含尚未惰性剔除的过期条目）
+func (c *Cache[V]) Len() int {
+	if c == nil {
+		return 0
+	}
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		n += len(s.dict)
+		s.mu.Unlock()
+	}
+	return n
+}
@@ -0,0 +1,212 @@
+package hotcache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"ip-api/internal/logger"
+	"ip-api/internal/metrics"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 失效广播频道与“全部失效”标记
+const (
+	Channel = "ipapi:invalidate"
+	All     = "*"
+)
+
+// ResultKeyPrefix：Redis 中 /ip 结果缓存键前缀（与 api 包一致）
+const ResultKeyPrefix = "ip:"
+
+var (
+	hookMu sync.RWMutex
+	hooks  []func(ip string)
+	// origin：本进程标识，广播载荷为 "<origin> <ip>"，订阅时忽略自身发出的广播
+	origin = func() string {
+		var b [8]byte
+		_, _ = rand.Read(b[:])
+		return hex.EncodeToString(b[:])
+	}()
+)
+
+// 文档注释：登记本进程的失效回调
+// 背景：进程内缓存（/ip 热点缓存等）在覆盖变更时需清理；回调参数为 IP 或 All。
+func OnInvalidate(fn func(ip string)) {
+	hookMu.Lock()
+	hooks = append(hooks, fn)
+	hookMu.Unlock()
+}
+
+func runHooks(ip string) {
+	scope := "ip"
+	if ip == All {
+		scope = "all"
+	}
+	metrics.HotCacheInvalidationsTotal.WithLabelValues(scope).Inc()
+	hookMu.RLock()
+	defer hookMu.RUnlock()
+	for _, fn := range hooks {
+		fn(ip)
+	}
+}
+
+// 文档注释：失效指定 IP（或 All）的查询结果
+// 背景：覆盖写入后调用；先执行本进程回调，rc 非空时再删除 Redis 结果缓存键并广播，其他实例经 Listen 同步清理。
+// 约束：All 不删除 Redis 结果键（按 TTL 自然过期），仅清空各实例进程内缓存；Redis 失败时返回错误，本进程回调已执行。
+func Invalidate(ctx context.Context, rc *redis.Client, ips ...string) error {
+	for _, ip := range ips {
+		runHooks(ip)
+	}
+	if rc == nil {
+		return nil
+	}
+	for _, ip := range ips {
+		if ip != All {
+			if err := rc.Del(ctx, ResultKeyPrefix+ip).Err(); err != nil {
+				return err
+			}
+		}
+		if err := rc.Publish(ctx, Channel, origin+" "+ip).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 单个区间逐 IP 失效的上限；更宽的区间在 Redis 中按键扫描删除，进程内缓存整体清空
+const rangeExpandMax = 4096
+
+// 文档注释：失效一个 IPv4 区间（闭区间）的查询结果
+// 背景：区间覆盖写入后调用；广播载荷为 "<origin> <起>-<止>"，各实例按同一规则清理。
+// 约束：区间不超过 rangeExpandMax 个地址时逐 IP 删除；更宽时进程内缓存整体清空，Redis 结果键经 SCAN 按前缀扫描后删除区间内的键。
+func InvalidateRange(ctx context.Context, rc *redis.Client, start, end uint32) error {
+	if err := Drop(ctx, rc, start, end); err != nil || rc == nil || end < start {
+		return err
+	}
+	return rc.Publish(ctx, Channel, origin+" "+ipString(start)+"-"+ipString(end)).Err()
+}
+
+// 文档注释：失效区间但不广播
+// 背景：数据库 KV 变更通知会送达每个服务实例，各实例自行清理本进程缓存与 Redis 结果键，无需再经 Redis 广播。
+// 约束：清理规则与 InvalidateRange 相同；rc 为 nil 时只执行本进程回调。
+func Drop(ctx context.Context, rc *redis.Client, start, end uint32) error {
+	if end < start {
+		return nil
+	}
+	runRange(start, end)
+	if rc == nil {
+		return nil
+	}
+	if uint64(end)-uint64(start) < rangeExpandMax {
+		keys := make([]string, 0, end-start+1)
+		for v := uint64(start); v <= uint64(end); v++ {
+			keys = append(keys, ResultKeyPrefix+ipString(uint32(v)))
+		}
+		if err := rc.Del(ctx, keys...).Err(); err != nil {
+			return err
+		}
+	} else {
+		it := rc.Scan(ctx, 0, ResultKeyPrefix+"*", 1000).Iterator()
+		var batch []string
+		for it.Next(ctx) {
+			k := it.Val()
+			if v, ok := ipValue(strings.TrimPrefix(k, ResultKeyPrefix)); ok && v >= start && v <= end {
+				batch = append(batch, k)
+			}
+			if len(batch) >= 500 {
+				if err := rc.Del(ctx, batch...).Err(); err != nil {
+					return err
+				}
+				batch = batch[:0]
+			}
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+		if len(batch) > 0 {
+			if err := rc.Del(ctx, batch...).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runRange(start, end uint32) {
+	if uint64(end)-uint64(start) >= rangeExpandMax {
+		runHooks(All)
+		return
+	}
+	metrics.HotCacheInvalidationsTotal.WithLabelValues("range").Inc()
+	hookMu.RLock()
+	defer hookMu.RUnlock()
+	for v := uint64(start); v <= uint64(end); v++ {
+		ip := ipString(uint32(v))
+		for _, fn := range hooks {
+			fn(ip)
+		}
+	}
+}
+
+func ipString(v uint32) string {
+	return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}).String()
+}
+
+func ipValue(s string) (uint32, bool) {
+	a, err := netip.ParseAddr(s)
+	if err != nil || !a.Is4() {
+		return 0, false
+	}
+	b := a.As4()
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]), true
+}
+
+// parseRange：解析广播中的 "<起>-<止>"
+func parseRange(s string) (uint32, uint32, bool) {
+	a, b, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	x, ok1 := ipValue(a)
+	y, ok2 := ipValue(b)
+	return x, y, ok1 && ok2 && x <= y
+}
+
+// 文档注释：订阅其他进程的失效广播
+// 背景：override-kv、amap-ingest 等独立进程及其他服务实例写入覆盖后广播，收到后执行本进程回调；
+// 断线由 go-redis 自动重订阅，期间的广播会丢失，由缓存 TTL 兜底。
+// 约束：ctx 结束时退出；rc 为 nil 时不启动。
+func Listen(ctx context.Context, rc *redis.Client) {
+	if rc == nil {
+		return
+	}
+	sub := rc.Subscribe(ctx, Channel)
+	go func() {
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-ch:
+				if !ok {
+					return
+				}
+				from, ip, found := strings.Cut(m.Payload, " ")
+				if !found || from == origin || ip == "" {
+					continue
+				}
+				logger.L().Debug("hotcache_invalidate_recv", "ip", ip)
+				if start, end, ok := parseRange(ip); ok {
+					runRange(start, end)
+					continue
+				}
+				runHooks(ip)
+			}
+		}
+	}()
+}
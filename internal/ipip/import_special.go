@@ -25,50 +25,17 @@ func ImportIPv4LeavesToSpecial(db *sql.DB, r *Reader, language, sourceTag string
 	}
 	defer stmtRange.Close()
 	count := 0
-	off := languageOffset(r, language)
+	off := r.LanguageOffset(language)
 	for leaf := range ch {
-		fields := string(leaf.Raw)
-		parts := make([]string, 0, len(r.meta.Fields))
-		start := 0
-		for i := 0; i < len(fields); i++ {
-			if fields[i] == '\t' {
-				parts = append(parts, fields[start:i])
-				start = i + 1
-			}
-		}
-		parts = append(parts, fields[start:])
-		begin := off
-		end := off + len(r.meta.Fields)
-		if begin < 0 {
-			begin = 0
-		}
-		if end > len(parts) {
-			end = len(parts)
-		}
-		if begin >= end {
+		vals := r.Values(leaf.Raw, off)
+		if vals == nil {
 			continue
 		}
-		seg := parts[begin:end]
-		var country, region, province, city string
-		for i, f := range r.meta.Fields {
-			if i >= len(seg) {
-				break
-			}
-			switch f {
-			case "country_name":
-				country = seg[i]
-			case "region_name":
-				region = seg[i]
-			case "province_name":
-				province = seg[i]
-			case "city_name":
-				city = seg[i]
-			}
-		}
+		loc := r.Location(vals)
 		netw := ipToCIDR(leaf.Prefix<<uint(32-leaf.Length), leaf.Length)
 		s, e, a := cidrRange(netw)
 		var locID int
-		if err := stmtLoc.QueryRow(country, region, province, city, "").Scan(&locID); err != nil {
+		if err := stmtLoc.QueryRow(loc.Country, loc.Region, loc.Province, loc.City, loc.ISP).Scan(&locID); err != nil {
 			return err
 		}
 		if _, err := stmtRange.Exec(int64(s), int64(e), a, locID, sourceTag); err != nil {
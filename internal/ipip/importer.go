@@ -10,25 +10,6 @@ import (
 	"sync/atomic"
 )
 
-// 文档注释：计算语言偏移
-// 背景：IPDB 以语言 -> 起始下标映射组织字段；当目标语言不存在时回退到最小偏移以尽可能读取到有效字段。
-// 参数：Reader 元信息中的 Languages 映射与目标 language。
-// 返回：字段起始偏移；若语言缺失则返回当前文件中最小的偏移值。
-func languageOffset(r *Reader, language string) int {
-	if off, ok := r.meta.Languages[language]; ok {
-		return off
-	}
-	have := false
-	min := 0
-	for _, v := range r.meta.Languages {
-		if !have || v < min {
-			min = v
-			have = true
-		}
-	}
-	return min
-}
-
 // 文档注释：将位前缀转换为 CIDR 网络
 // 背景：导入时需要范围起止整数，故先转为标准 CIDR 表示以便后续计算；长度为掩码位数。
 // 返回：IPv4 网络结构（IP 为网络地址，Mask 为掩码）。
@@ -81,53 +62,17 @@ func ImportIPv4LeavesToDB(db *sql.DB, r *Reader, language string) error {
 	}
 	defer stmtRange.Close()
 	count := 0
-	off := languageOffset(r, language)
+	off := r.LanguageOffset(language)
 	for leaf := range ch {
-		fields := string(leaf.Raw)
-		parts := make([]string, 0, len(r.meta.Fields))
-		// NOTE: 使用手写切割以规避 strings.Split 的额外分配；字段规范为制表符分隔。
-		start := 0
-		for i := 0; i < len(fields); i++ {
-			if fields[i] == '\t' {
-				parts = append(parts, fields[start:i])
-				start = i + 1
-			}
-		}
-		parts = append(parts, fields[start:])
-		begin := off
-		end := off + len(r.meta.Fields)
-		if begin < 0 {
-			begin = 0
-		}
-		if end > len(parts) {
-			end = len(parts)
-		}
-		if begin >= end {
+		vals := r.Values(leaf.Raw, off)
+		if vals == nil {
 			continue
 		}
-		seg := parts[begin:end]
-		var country, region, province, city string
-		for i, f := range r.meta.Fields {
-			if i >= len(seg) {
-				break
-			}
-			switch f {
-			case "country_name":
-				country = seg[i]
-			case "region_name":
-				region = seg[i]
-			case "province_name":
-				province = seg[i]
-			case "city_name":
-				city = seg[i]
-			}
-		}
-		// NOTE: ISP 信息可能缺失，统一使用空字符串参与唯一键；减少后续查询分支复杂度。
-		_, _, _ = country, region, province
+		loc := r.Location(vals)
 		netw := ipToCIDR(leaf.Prefix<<uint(32-leaf.Length), leaf.Length)
 		s, e, a := cidrRange(netw)
 		var locID int
-		if err := stmtLoc.QueryRow(country, region, province, city, "").Scan(&locID); err != nil {
+		if err := stmtLoc.QueryRow(loc.Country, loc.Region, loc.Province, loc.City, loc.ISP).Scan(&locID); err != nil {
 			return err
 		}
 		if _, err := stmtRange.Exec(int64(s), int64(e), a, locID); err != nil {
@@ -181,9 +126,9 @@ func ImportIPv4LeavesToDBConcurrent(db *sql.DB, r *Reader, language string) erro
 		}
 	}
 	type result struct {
-		country, region, province, city string
-		s, e                            uint32
-		a                               int
+		country, region, province, city, isp string
+		s, e                                 uint32
+		a                                    int
 	}
 	pipes := make([]chan result, workers)
 	for i := range pipes {
@@ -192,54 +137,21 @@ func ImportIPv4LeavesToDBConcurrent(db *sql.DB, r *Reader, language string) erro
 
 	var parseWG sync.WaitGroup
 	var produced int64
-	off := languageOffset(r, language)
+	off := r.LanguageOffset(language)
 	logger.L().Debug("ipip_lang_offset", "offset", off)
 	for i := 0; i < workers; i++ {
 		parseWG.Add(1)
 		go func() {
 			defer parseWG.Done()
 			for leaf := range ch {
-				fields := string(leaf.Raw)
-				parts := make([]string, 0, len(r.meta.Fields))
-				start := 0
-				for j := 0; j < len(fields); j++ {
-					if fields[j] == '\t' {
-						parts = append(parts, fields[start:j])
-						start = j + 1
-					}
-				}
-				parts = append(parts, fields[start:])
-				begin := off
-				end := off + len(r.meta.Fields)
-				if begin < 0 {
-					begin = 0
-				}
-				if end > len(parts) {
-					end = len(parts)
-				}
-				if begin >= end {
+				vals := r.Values(leaf.Raw, off)
+				if vals == nil {
 					continue
 				}
-				seg := parts[begin:end]
-				var country, region, province, city string
-				for k, f := range r.meta.Fields {
-					if k >= len(seg) {
-						break
-					}
-					switch f {
-					case "country_name":
-						country = seg[k]
-					case "region_name":
-						region = seg[k]
-					case "province_name":
-						province = seg[k]
-					case "city_name":
-						city = seg[k]
-					}
-				}
+				loc := r.Location(vals)
 				netw := ipToCIDR(leaf.Prefix<<uint(32-leaf.Length), leaf.Length)
 				s, e, a := cidrRange(netw)
-				pipes[a%workers] <- result{country, region, province, city, s, e, a}
+				pipes[a%workers] <- result{loc.Country, loc.Region, loc.Province, loc.City, loc.ISP, s, e, a}
 				n := atomic.AddInt64(&produced, 1)
 				if n%10000 == 0 {
 					logger.L().Debug("ipip_parse_progress", "produced", n)
@@ -302,16 +214,16 @@ func ImportIPv4LeavesToDBConcurrent(db *sql.DB, r *Reader, language string) erro
 			started := false
 			myLocs := make(map[string]int)
 			for v := range pipes[shard] {
-				key := v.country + "|" + v.region + "|" + v.province + "|" + v.city + "|"
+				key := v.country + "|" + v.region + "|" + v.province + "|" + v.city + "|" + v.isp
 				var locID int
 				if id, ok := myLocs[key]; ok {
 					locID = id
 				} else {
-					if err := stmtLocSel.QueryRow(v.country, v.region, v.province, v.city, "").Scan(&locID); err != nil {
+					if err := stmtLocSel.QueryRow(v.country, v.region, v.province, v.city, v.isp).Scan(&locID); err != nil {
 						mu := getLock(&keyLocks, key)
 						mu.Lock()
-						if err2 := stmtLocSel.QueryRow(v.country, v.region, v.province, v.city, "").Scan(&locID); err2 != nil {
-							if err3 := db.QueryRow("INSERT INTO _ip_locations(country,region,province,city,isp) VALUES($1,$2,$3,$4,$5) ON CONFLICT (country,region,province,city,isp) DO UPDATE SET country=EXCLUDED.country RETURNING id", v.country, v.region, v.province, v.city, "").Scan(&locID); err3 != nil {
+						if err2 := stmtLocSel.QueryRow(v.country, v.region, v.province, v.city, v.isp).Scan(&locID); err2 != nil {
+							if err3 := db.QueryRow("INSERT INTO _ip_locations(country,region,province,city,isp) VALUES($1,$2,$3,$4,$5) ON CONFLICT (country,region,province,city,isp) DO UPDATE SET country=EXCLUDED.country RETURNING id", v.country, v.region, v.province, v.city, v.isp).Scan(&locID); err3 != nil {
 								logger.L().Error("ipip_loc_error", "err", err3)
 								mu.Unlock()
 								return
@@ -1,13 +1,16 @@
-// 包 ipip：读取与遍历 IPIP IPDB 数据文件，提供 IPv4 叶子枚举以支持批量导入
-// 背景：围绕二叉前缀树的紧凑存储结构实现，只暴露只读 Reader 接口，降低误用风险与实现复杂度。
+// 包 ipip：读取与遍历 IPIP IPDB 数据文件，提供查询（IPv4/IPv6）与叶子枚举以支持批量导入
+// 背景：围绕二叉前缀树的紧凑存储结构实现，只暴露只读 Reader 接口，降低误用风险与实现复杂度；导入与在线查询共用同一读取器。
 package ipip
 
 import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"ip-api/internal/localdb"
 	"ip-api/internal/logger"
-	"os"
+	"ip-api/internal/mmap"
+	"net/netip"
+	"sort"
 	"sync/atomic"
 )
 
@@ -23,9 +26,14 @@ type meta struct {
 // 文档注释：元信息结构（来自文件头部 JSON）
 // 背景：描述数据版本、字段集合与节点数等加载所需信息；用于语言偏移与解析边界判断。
 // 约束：字段列表与语言映射必须非空，否则视为文件不合法；TotalSize 用于强校验文件体积避免截断。
+// IPVersion 为位标志：0x01 含 IPv4，0x02 含 IPv6。
+const (
+	flagIPv4 = 0x01
+	flagIPv6 = 0x02
+)
 
 type Reader struct {
-	fileSize  int
+	m         *mmap.File
 	nodeCount int
 	v4offset  int
 	meta      meta
@@ -33,28 +41,36 @@ type Reader struct {
 }
 
 // 文档注释：数据读取器
-// 背景：持有解析后的元信息与原始二进制数据片段；v4offset 为 IPv4 根节点偏移，通过前序遍历计算。
+// 背景：持有解析后的元信息与映射的数据段；v4offset 为 IPv4 根节点偏移（IPv4 映射地址 ::ffff:0:0/96 的子树），通过前序遍历计算。
 // 约束：只读，不暴露写入接口；内部偏移与索引均以大端字节序解析，错误边界检查严格返回 error。
+// 查询与枚举返回的原始字节引用映射区，Close 之后不得再使用。
 
 var firstLeafLogged atomic.Bool
 
 // 文档注释：打开并解析 IPDB 文件
 // 参数：path 为文件路径；会执行尺寸校验、元信息 JSON 解码与数据段截取。
 // 返回：Reader 指针；异常包含文件不存在、尺寸错误、JSON 不合法、总大小不匹配等场景。
-// NOTE: v4offset 通过前 96 层节点读取确定根偏移，日志仅输出偏移与首叶样本，便于问题定位。
+// NOTE: 文件以 mmap 打开，由内核按页缓存，不再整体读入堆；v4offset 通过前 96 层节点读取确定根偏移。
 func Open(path string) (*Reader, error) {
-	fi, err := os.Stat(path)
+	mf, err := mmap.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	size := int(fi.Size())
-	if size < 4 {
-		return nil, errors.New("bad ipdb size")
-	}
-	body, err := os.ReadFile(path)
+	r, err := parse(mf.Bytes())
 	if err != nil {
+		mf.Close()
 		return nil, err
 	}
+	r.m = mf
+	logger.L().Debug("ipip_open", "path", path, "build", r.meta.Build, "v4offset", r.v4offset, "ipv6", r.IPv6(), "fields", len(r.meta.Fields))
+	return r, nil
+}
+
+func parse(body []byte) (*Reader, error) {
+	size := len(body)
+	if size < 4 {
+		return nil, errors.New("bad ipdb size")
+	}
 	mlen := int(binary.BigEndian.Uint32(body[0:4]))
 	if size < 4+mlen {
 		return nil, errors.New("bad ipdb meta")
@@ -69,22 +85,43 @@ func Open(path string) (*Reader, error) {
 	if size != (4 + mlen + m.TotalSize) {
 		return nil, errors.New("bad ipdb total size")
 	}
-	r := &Reader{fileSize: size, nodeCount: m.NodeCount, meta: m, data: body[4+mlen:]}
-	if r.v4offset == 0 {
-		node := 0
-		for i := 0; i < 96 && node < r.nodeCount; i++ {
-			if i >= 80 {
-				node = r.readNode(node, 1)
-			} else {
-				node = r.readNode(node, 0)
-			}
+	r := &Reader{nodeCount: m.NodeCount, meta: m, data: body[4+mlen:]}
+	node := 0
+	for i := 0; i < 96 && node < r.nodeCount; i++ {
+		if i >= 80 {
+			node = r.readNode(node, 1)
+		} else {
+			node = r.readNode(node, 0)
 		}
-		r.v4offset = node
-		logger.L().Debug("ipip_v4offset", "offset", r.v4offset)
 	}
+	r.v4offset = node
 	return r, nil
 }
 
+// Close：解除映射
+func (r *Reader) Close() error { return r.m.Close() }
+
+// Build：数据版本（元信息 build，unix 秒）
+func (r *Reader) Build() int64 { return r.meta.Build }
+
+// Fields：每种语言下的字段名（按存储顺序）
+func (r *Reader) Fields() []string { return r.meta.Fields }
+
+// 文档注释：可用语言
+// 返回：按字段偏移升序的语言代码。
+func (r *Reader) Languages() []string {
+	out := make([]string, 0, len(r.meta.Languages))
+	for k := range r.meta.Languages {
+		out = append(out, k)
+	}
+	sort.Slice(out, func(i, j int) bool { return r.meta.Languages[out[i]] < r.meta.Languages[out[j]] })
+	return out
+}
+
+// IPv4/IPv6：文件是否包含对应地址族
+func (r *Reader) IPv4() bool { return r.meta.IPVersion&flagIPv4 != 0 }
+func (r *Reader) IPv6() bool { return r.meta.IPVersion&flagIPv6 != 0 }
+
 // 文档注释：读取节点指针
 // 背景：节点存储为连续 8 字节，左右各 4 字节（BE）；index=0 读左指针，index=1 读右指针。
 // 约束：越界返回原节点以避免 panic；调用方需结合深度与节点范围控制递归。
@@ -101,7 +138,7 @@ func (r *Reader) readNode(node, index int) int {
 // 返回：叶子原始数据；异常覆盖越界与长度不合法。
 func (r *Reader) resolve(node int) ([]byte, error) {
 	resolved := node - r.nodeCount + r.nodeCount*8
-	if resolved >= len(r.data) {
+	if resolved < 0 || resolved+2 > len(r.data) {
 		return nil, errors.New("resolve out of range")
 	}
 	size := int(binary.BigEndian.Uint16(r.data[resolved : resolved+2]))
@@ -111,6 +148,122 @@ func (r *Reader) resolve(node int) ([]byte, error) {
 	return r.data[resolved+2 : resolved+2+size], nil
 }
 
+// 文档注释：查询原始叶子数据
+// 背景：IPv4（含 IPv4 映射的 IPv6 地址）从 v4offset 起走 32 位，IPv6 从根起走 128 位；文件不含对应地址族时未命中。
+// 返回：叶子原始字节（引用映射区）与是否命中。
+func (r *Reader) FindRaw(addr netip.Addr) ([]byte, bool) {
+	addr = addr.Unmap()
+	var node, bits int
+	var b [16]byte
+	switch {
+	case addr.Is4():
+		a4 := addr.As4()
+		copy(b[:], a4[:])
+		node, bits = r.v4offset, 32
+	case addr.Is6() && r.IPv6():
+		b = addr.As16()
+		node, bits = 0, 128
+	default:
+		return nil, false
+	}
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		node = r.readNode(node, int((b[i/8]>>uint(7-i%8))&1))
+	}
+	if node <= r.nodeCount {
+		return nil, false
+	}
+	raw, err := r.resolve(node)
+	if err != nil {
+		return nil, false
+	}
+	return raw, true
+}
+
+// 文档注释：计算语言偏移
+// 背景：IPDB 以语言 -> 起始下标映射组织字段；当目标语言不存在时回退到最小偏移以尽可能读取到有效字段。
+// 返回：字段起始偏移；若语言缺失则返回当前文件中最小的偏移值。
+func (r *Reader) LanguageOffset(language string) int {
+	if off, ok := r.meta.Languages[language]; ok {
+		return off
+	}
+	have := false
+	low := 0
+	for _, v := range r.meta.Languages {
+		if !have || v < low {
+			low = v
+			have = true
+		}
+	}
+	return low
+}
+
+// 文档注释：切分叶子数据并取出指定语言的字段值
+// 参数：off 为 LanguageOffset 的结果。
+// 返回：与 Fields() 对齐的字段值（缺失的尾部字段为空串）；数据不含该语言时返回 nil。
+// NOTE: 使用手写切割以规避 strings.Split 的额外分配；字段规范为制表符分隔。
+func (r *Reader) Values(raw []byte, off int) []string {
+	fields := string(raw)
+	parts := make([]string, 0, len(r.meta.Fields)*len(r.meta.Languages))
+	start := 0
+	for i := 0; i < len(fields); i++ {
+		if fields[i] == '\t' {
+			parts = append(parts, fields[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, fields[start:])
+	begin := max(off, 0)
+	end := min(off+len(r.meta.Fields), len(parts))
+	if begin >= end {
+		return nil
+	}
+	out := make([]string, len(r.meta.Fields))
+	copy(out, parts[begin:end])
+	return out
+}
+
+// 文档注释：字段值映射为地点
+// 背景：免费版仅含国家/省/市；付费版的运营商字段（isp_domain）一并映射，其余字段经 Find 取得。
+func (r *Reader) Location(vals []string) localdb.Location {
+	var l localdb.Location
+	for i, f := range r.meta.Fields {
+		if i >= len(vals) {
+			break
+		}
+		switch f {
+		case "country_name":
+			l.Country = vals[i]
+		case "region_name":
+			l.Region = vals[i]
+		case "province_name":
+			l.Province = vals[i]
+		case "city_name":
+			l.City = vals[i]
+		case "isp_domain":
+			l.ISP = vals[i]
+		}
+	}
+	return l
+}
+
+// 文档注释：查询全部字段
+// 返回：字段名 -> 值（含付费版的 owner_domain、timezone、latitude 等）；未命中或缺少该语言时返回 false。
+func (r *Reader) Find(addr netip.Addr, language string) (map[string]string, bool) {
+	raw, ok := r.FindRaw(addr)
+	if !ok {
+		return nil, false
+	}
+	vals := r.Values(raw, r.LanguageOffset(language))
+	if vals == nil {
+		return nil, false
+	}
+	out := make(map[string]string, len(vals))
+	for i, f := range r.meta.Fields {
+		out[f] = vals[i]
+	}
+	return out, true
+}
+
 type IPv4Leaf struct {
 	Prefix uint32
 	Length int
@@ -119,41 +272,67 @@ type IPv4Leaf struct {
 
 // 文档注释：IPv4 前缀叶子
 // 背景：Prefix 为位前缀累积，Length 为前缀长度（0–32），Raw 为对应位置的元数据原始字节串。
-// 约束：调用方需自行按语言偏移与字段映射解析 Raw 内容。
+// 约束：调用方需自行按语言偏移与字段映射解析 Raw 内容（Values/Location）。
+
+// 文档注释：IPv6 前缀叶子
+// 约束：不含 IPv4 映射子树（::ffff:0:0/96），该部分经 EnumerateIPv4 枚举。
+type IPv6Leaf struct {
+	Prefix netip.Prefix
+	Raw    []byte
+}
 
 // 文档注释：枚举 IPv4 叶子（DFS 前序遍历）
 // 背景：从 v4offset 根开始深度优先遍历，遇到叶子节点时下发到通道；用于批量导入与并行解析。
 // 参数：ch 为输出通道，调用方负责消费与关闭时机（函数内部不关闭）。
 // 异常：解析叶子数据失败时返回 error；为避免阻塞，建议消费者使用足够大的缓冲或并发消费。
 func (r *Reader) EnumerateIPv4(ch chan<- IPv4Leaf) error {
-	var dfs func(node int, depth int, prefix uint32) error
-	dfs = func(node int, depth int, prefix uint32) error {
+	return r.walk(r.v4offset, 32, func(b [16]byte, depth int, raw []byte) {
+		if !firstLeafLogged.Load() {
+			logger.L().Debug("ipip_leaf_sample", "length", depth, "raw_len", len(raw))
+			firstLeafLogged.Store(true)
+		}
+		p := binary.BigEndian.Uint32(b[:4])
+		if depth < 32 {
+			p >>= uint(32 - depth)
+		}
+		ch <- IPv4Leaf{Prefix: p, Length: depth, Raw: raw}
+	})
+}
+
+// 文档注释：枚举 IPv6 叶子（DFS 前序遍历）
+// 约束：文件不含 IPv6 时直接返回；其余同 EnumerateIPv4。
+func (r *Reader) EnumerateIPv6(ch chan<- IPv6Leaf) error {
+	if !r.IPv6() {
+		return nil
+	}
+	return r.walk(0, 128, func(b [16]byte, depth int, raw []byte) {
+		ch <- IPv6Leaf{Prefix: netip.PrefixFrom(netip.AddrFrom16(b), depth), Raw: raw}
+	})
+}
+
+// 文档注释：前序遍历子树
+// 背景：b 为从 root 起累积的地址位（高位对齐）；IPv6 遍历跳过 v4offset 子树。
+func (r *Reader) walk(root, maxDepth int, fn func(b [16]byte, depth int, raw []byte)) error {
+	var dfs func(node, depth int, b [16]byte) error
+	dfs = func(node, depth int, b [16]byte) error {
 		if node > r.nodeCount {
 			raw, err := r.resolve(node)
 			if err != nil {
 				return err
 			}
-			if !firstLeafLogged.Load() {
-				logger.L().Debug("ipip_leaf_sample", "prefix", int(prefix), "length", depth, "raw_len", len(raw))
-				firstLeafLogged.Store(true)
-			}
-			ch <- IPv4Leaf{Prefix: prefix, Length: depth, Raw: raw}
+			fn(b, depth, raw)
 			return nil
 		}
-		if depth >= 32 {
+		if depth >= maxDepth || node == r.nodeCount || (maxDepth == 128 && node == r.v4offset && depth > 0) {
 			return nil
 		}
-		left := r.readNode(node, 0)
-		right := r.readNode(node, 1)
 		// 左分支，bit 0
-		if err := dfs(left, depth+1, prefix<<1); err != nil {
+		if err := dfs(r.readNode(node, 0), depth+1, b); err != nil {
 			return err
 		}
 		// 右分支，bit 1
-		if err := dfs(right, depth+1, (prefix<<1)|1); err != nil {
-			return err
-		}
-		return nil
+		b[depth/8] |= 1 << uint(7-depth%8)
+		return dfs(r.readNode(node, 1), depth+1, b)
 	}
-	return dfs(r.v4offset, 0, 0)
+	return dfs(root, 0, [16]byte{})
 }
@@ -1,19 +1,83 @@
 package chain
 
-import "ip-api/internal/localdb"
+import (
+	"fmt"
+	"ip-api/internal/localdb"
+	"ip-api/internal/metrics"
+	"time"
+)
 
+type lookupable interface {
+	Lookup(string) (localdb.Location, bool)
+}
+
+// Layer：链中一层（Name 用于指标标签与响应来源）
+type Layer struct {
+	Name  string
+	Cache lookupable
+}
+
+// 文档注释：链式缓存
+// 背景：按顺序查询各层，首个命中即返回；记录每层命中/未命中与耗时，并报告命中层及其数据版本，
+// 用于判断线上实际由哪份数据应答。
+// 约束：Cache 为 nil 的层在构造时剔除；层实现 localdb.Versioned 时报告其版本，实现 Ready() 时管理接口展示其加载状态。
 type ChainCache struct {
-    list []interface{ Lookup(string) (localdb.Location, bool) }
+	list []Layer
 }
 
-func NewChainCache(list ...interface{ Lookup(string) (localdb.Location, bool) }) *ChainCache {
-    return &ChainCache{ list: list }
+func NewChainCache(layers ...Layer) *ChainCache {
+	c := &ChainCache{}
+	for _, l := range layers {
+		if l.Cache != nil {
+			c.list = append(c.list, l)
+		}
+	}
+	return c
 }
 
 func (c *ChainCache) Lookup(ip string) (localdb.Location, bool) {
-    for _, s := range c.list {
-        if s == nil { continue }
-        if l, ok := s.Lookup(ip); ok { return l, true }
-    }
-    return localdb.Location{}, false
+	l, _, ok := c.Resolve(ip)
+	return l, ok
+}
+
+// 文档注释：查询并返回命中来源
+// 背景：逐层计时（微秒），指标 ipapi_localdb_layer_lookups_total{layer,result}、ipapi_localdb_layer_duration_us{layer}。
+func (c *ChainCache) Resolve(ip string) (localdb.Location, localdb.Source, bool) {
+	for _, s := range c.list {
+		t0 := time.Now()
+		l, ok := s.Cache.Lookup(ip)
+		metrics.LocalLayerDurationUs.WithLabelValues(s.Name).Observe(float64(time.Since(t0).Microseconds()))
+		if !ok {
+			metrics.LocalLayerLookupsTotal.WithLabelValues(s.Name, "miss").Inc()
+			continue
+		}
+		metrics.LocalLayerLookupsTotal.WithLabelValues(s.Name, "hit").Inc()
+		return l, localdb.Source{Layer: s.Name, Version: version(s.Cache)}, true
+	}
+	return localdb.Location{}, localdb.Source{}, false
+}
+
+// Stack：按查询顺序列出各层
+func (c *ChainCache) Stack() []localdb.LayerInfo {
+	out := make([]localdb.LayerInfo, 0, len(c.list))
+	for _, s := range c.list {
+		var impl any = s.Cache
+		if u, ok := s.Cache.(interface{ Unwrap() any }); ok && u.Unwrap() != nil {
+			// 可热替换层（DynamicCache）展示当前实际实现
+			impl = u.Unwrap()
+		}
+		li := localdb.LayerInfo{Name: s.Name, Type: fmt.Sprintf("%T", impl), Version: version(s.Cache), Ready: true}
+		if r, ok := s.Cache.(interface{ Ready() bool }); ok {
+			li.Ready = r.Ready()
+		}
+		out = append(out, li)
+	}
+	return out
+}
+
+func version(c lookupable) string {
+	if v, ok := c.(localdb.Versioned); ok {
+		return v.DataVersion()
+	}
+	return ""
 }
@@ -2,11 +2,15 @@ package localdb
 
 import (
     "sync/atomic"
+    "time"
 )
 
 type lookupable interface { Lookup(string) (Location, bool) }
 
-type DynamicCache struct { v atomic.Value }
+type DynamicCache struct {
+    v     atomic.Value
+    setAt atomic.Int64
+}
 
 // 文档注释：动态缓存包装器
 // 背景：通过 atomic.Value 提供无锁读写切换（如从内存缓存切换到文件缓存），保障高并发场景下读路径不阻塞。
@@ -21,7 +25,45 @@ func (d *DynamicCache) Lookup(ip string) (Location, bool) {
     return c.Lookup(ip)
 }
 
+// 文档注释：查找并返回命中来源
+// 背景：当前实现支持 Resolver（如链式缓存）时返回命中层与数据版本；否则来源层记为 cache、版本为空。
+func (d *DynamicCache) Resolve(ip string) (Location, Source, bool) {
+    x := d.v.Load()
+    if x == nil { return Location{}, Source{}, false }
+    if r, ok := x.(Resolver); ok { return r.Resolve(ip) }
+    l, ok := x.(lookupable).Lookup(ip)
+    if !ok { return l, Source{}, false }
+    return l, Source{Layer: "cache"}, true
+}
+
+// 文档注释：当前缓存栈
+// 返回：各层描述（当前实现非组合缓存时为单层）与最近一次 Set 的时间；未设置时均为零值。
+func (d *DynamicCache) Stack() ([]LayerInfo, time.Time) {
+    x := d.v.Load()
+    if x == nil { return nil, time.Time{} }
+    at := time.UnixMilli(d.setAt.Load())
+    if s, ok := x.(Stacker); ok { return s.Stack(), at }
+    li := LayerInfo{Name: "cache", Ready: true}
+    if v, ok := x.(Versioned); ok { li.Version = v.DataVersion() }
+    return []LayerInfo{li}, at
+}
+
+// Ready：是否已设置实现（作为链式缓存中的可热替换层时报告加载状态）
+func (d *DynamicCache) Ready() bool { return d.v.Load() != nil }
+
+// DataVersion：当前实现的数据版本（实现 Versioned 时），未设置时为空
+func (d *DynamicCache) DataVersion() string {
+    if v, ok := d.v.Load().(Versioned); ok { return v.DataVersion() }
+    return ""
+}
+
+// Unwrap：当前实现（未设置时为 nil），供管理接口展示实际类型
+func (d *DynamicCache) Unwrap() any { return d.v.Load() }
+
 // 文档注释：设置当前缓存实现（写路径）
 // 背景：用于切换不同实现（内存/文件/远端）；在写入后立即对后续查找生效。
 // WARNING: c 为 nil 会导致后续查找均未命中，应在上层保证非空与可用性。
-func (d *DynamicCache) Set(c lookupable) { d.v.Store(c) }
+func (d *DynamicCache) Set(c lookupable) {
+    d.v.Store(c)
+    d.setAt.Store(time.Now().UnixMilli())
+}
@@ -1,154 +1,266 @@
 package exact
 
 import (
-    "database/sql"
-    "encoding/binary"
-    "ip-api/internal/localdb"
-    "ip-api/internal/logger"
-    "net"
-    "os"
-    "path/filepath"
-    "sort"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"ip-api/internal/localdb"
+	"ip-api/internal/localdb/unified"
+	"ip-api/internal/logger"
+	"ip-api/internal/mmap"
+	"ip-api/internal/namespace"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
 )
 
+// 文档注释：精确 IP 文件库（EXDB v2）
+// 背景：启动或覆盖变更后由数据库构建为只读文件并映射到内存；查询为对映射区的二分查找，命中后按下标取内嵌地点，不访问数据库、不分配内存。
+// 约束：仅支持 IPv4；实例不可变，数据更新通过重建文件并经 DynamicCache 热切换。
 type ExactDB struct {
-    f     *os.File
-    count int
-    db    *sql.DB
+	m     *mmap.File
+	recs  []byte
+	count int
+	locs  []localdb.Location
+	meta  Meta
+}
+
+// Meta：构建元信息（写入文件尾部的 JSON）
+type Meta struct {
+	Version          int       `json:"version"`
+	BuiltAt          time.Time `json:"built_at"`
+	Host             string    `json:"host,omitempty"`
+	Records          int       `json:"records"`
+	Locations        int       `json:"locations"`
+	Exact            int       `json:"exact"`
+	Overrides        int       `json:"overrides"`
+	OverridesKV      int       `json:"overrides_kv"`
+	OverridesKVRange int       `json:"overrides_kv_range"`
 }
 
+// 文档注释：由数据库构建精确文件库
+// 背景：v1 对每条 KV 覆盖逐条查询/插入 _ip_locations（N+1），且把临时地点写回了库；v2 直接联表读取地点字段并在文件内去重，构建过程对数据库只读。
+// 约束：同一 IP 出现在多张表时优先级为 _ip_overrides_kv > _ip_overrides_kv_range > _ip_overrides > _ip_exact（后读入覆盖先读入）；KV 只取未过期的行，多命名空间并存时按 namespace.Precedence 选取。
+// 先写临时文件并 fsync 后原子改名，已映射旧文件的读者不受影响。
 func BuildExactDBFromDB(dir string, db *sql.DB) error {
-    logger.L().Info("exactdb_build_begin", "dir", dir)
-    if err := os.MkdirAll(dir, 0o755); err != nil {
-        return err
-    }
-    fp := filepath.Join(dir, "exact.db")
-    tmp := fp + ".tmp"
-    f, err := os.Create(tmp)
-    if err != nil {
-        return err
-    }
-    defer f.Close()
-    rows, err := db.Query("SELECT ip_int, location_id FROM _ip_overrides ORDER BY ip_int")
-    if err != nil {
-        return err
-    }
-    defer rows.Close()
-    if _, err := f.Write([]byte{'E', 'X', 'D', 'B'}); err != nil {
-        return err
-    }
-    if err := binary.Write(f, binary.BigEndian, uint32(1)); err != nil {
-        return err
-    }
-    var recs [][2]uint32
-    m := make(map[uint32]uint32)
-    for rows.Next() {
-        var v int64
-        var lid int
-        if err := rows.Scan(&v, &lid); err != nil {
-            return err
-        }
-        m[uint32(v)] = uint32(lid)
-    }
-    rows2, err := db.Query("SELECT ip_int, country, region, province, city, isp FROM _ip_overrides_kv ORDER BY ip_int")
-    if err == nil {
-        defer rows2.Close()
-        for rows2.Next() {
-            var v int64
-            var c, r, p, ci, isp string
-            if err := rows2.Scan(&v, &c, &r, &p, &ci, &isp); err != nil {
-                return err
-            }
-            var locID int
-            row := db.QueryRow("SELECT id FROM _ip_locations WHERE country=$1 AND region=$2 AND province=$3 AND city=$4 AND isp=$5", c, r, p, ci, isp)
-            if err := row.Scan(&locID); err != nil {
-                err2 := db.QueryRow("INSERT INTO _ip_locations(country,region,province,city,isp) VALUES($1,$2,$3,$4,$5) RETURNING id", c, r, p, ci, isp).Scan(&locID)
-                if err2 != nil {
-                    return err2
-                }
-            }
-            m[uint32(v)] = uint32(locID)
-        }
-    }
-    for k, v := range m {
-        recs = append(recs, [2]uint32{k, v})
-    }
-    sort.Slice(recs, func(i, j int) bool { return recs[i][0] < recs[j][0] })
-    if err := binary.Write(f, binary.BigEndian, uint32(len(recs))); err != nil {
-        return err
-    }
-    for _, r := range recs {
-        if err := binary.Write(f, binary.BigEndian, r[0]); err != nil {
-            return err
-        }
-        if err := binary.Write(f, binary.BigEndian, r[1]); err != nil {
-            return err
-        }
-    }
-    if err := f.Sync(); err != nil {
-        return err
-    }
-    if err := os.Rename(tmp, fp); err != nil {
-        return err
-    }
-    logger.L().Info("exactdb_build_done", "count", len(recs))
-    return nil
+	logger.L().Info("exactdb_build_begin", "dir", dir)
+	t0 := time.Now()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	enc := newEncoder()
+	m := make(map[uint32]uint32)
+	meta := Meta{Version: version, BuiltAt: t0.UTC()}
+	meta.Host, _ = os.Hostname()
+	rows, err := db.Query(`SELECT e.ip_int, l.country, l.region, l.province, l.city, l.isp
+        FROM _ip_exact e JOIN _ip_locations l ON l.id = e.location_id`)
+	if err != nil {
+		return err
+	}
+	n, err := scanInto(rows, enc, m)
+	if err != nil {
+		return err
+	}
+	meta.Exact = n
+	rows, err = db.Query(`SELECT o.ip_int, l.country, l.region, l.province, l.city, l.isp
+        FROM _ip_overrides o JOIN _ip_locations l ON l.id = o.location_id`)
+	if err != nil {
+		return err
+	}
+	n, err = scanInto(rows, enc, m)
+	if err != nil {
+		return err
+	}
+	meta.Overrides = n
+	prec := pq.Array(namespace.Precedence())
+	rows, err = db.Query(`SELECT DISTINCT ON (start_int, end_int) start_int, end_int, country, region, province, city, isp
+        FROM _ip_overrides_kv_range WHERE `+namespace.Active+`
+        ORDER BY start_int, end_int, `+namespace.OrderBy("$1"), prec)
+	if err != nil {
+		return err
+	}
+	n, err = scanRanges(rows, enc, m, envInt("EXACT_RANGE_EXPAND_MAX", 65536))
+	if err != nil {
+		return err
+	}
+	meta.OverridesKVRange = n
+	rows, err = db.Query(`SELECT DISTINCT ON (ip_int) ip_int, country, region, province, city, isp
+        FROM _ip_overrides_kv WHERE `+namespace.Active+`
+        ORDER BY ip_int, `+namespace.OrderBy("$1"), prec)
+	if err != nil {
+		return err
+	}
+	n, err = scanInto(rows, enc, m)
+	if err != nil {
+		return err
+	}
+	meta.OverridesKV = n
+	recs := make([][2]uint32, 0, len(m))
+	for k, v := range m {
+		recs = append(recs, [2]uint32{k, v})
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i][0] < recs[j][0] })
+	meta.Records = len(recs)
+	meta.Locations = enc.Len()
+	mb, _ := json.Marshal(meta)
+	fp := filepath.Join(dir, "exact.db")
+	tmp := fp + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(enc.encode(recs, mb, t0)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fp); err != nil {
+		return err
+	}
+	logger.L().Info("exactdb_build_done", "count", len(recs), "locations", enc.Len(), "ms", time.Since(t0).Milliseconds())
+	return nil
 }
 
-func NewExactDB(dir string, db *sql.DB) (*ExactDB, error) {
-    fp := filepath.Join(dir, "exact.db")
-    f, err := os.Open(fp)
-    if err != nil {
-        return nil, err
-    }
-    hdr := make([]byte, 12)
-    if _, err := f.ReadAt(hdr, 0); err != nil {
-        f.Close()
-        return nil, err
-    }
-    if string(hdr[:4]) != "EXDB" {
-        f.Close()
-        return nil, os.ErrInvalid
-    }
-    cnt := int(binary.BigEndian.Uint32(hdr[8:12]))
-    logger.L().Debug("exactdb_open", "dir", dir, "count", cnt)
-    return &ExactDB{f: f, count: cnt, db: db}, nil
+// 文档注释：读取 (ip_int, 地点字段) 结果集并登记到编码器
+// 返回：读取行数；后读入的同一 IP 覆盖先读入的。
+func scanInto(rows *sql.Rows, enc *encoder, m map[uint32]uint32) (int, error) {
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var v int64
+		var l localdb.Location
+		if err := rows.Scan(&v, &l.Country, &l.Region, &l.Province, &l.City, &l.ISP); err != nil {
+			return n, err
+		}
+		m[uint32(v)] = enc.loc(l)
+		n++
+	}
+	return n, rows.Err()
 }
 
+// 文档注释：读取区间 KV 覆盖并登记到编码器
+// 背景：精确库只存单 IP；区间先按最具体规则（窄段优先，同宽起点大者优先）展开为互不重叠的段，
+// 不超过 expandMax 个地址的段逐 IP 写入，更宽的段只改写已有记录，段内其余地址由统一库或数据库回退给出。
+// 返回：读取的区间数。
+func scanRanges(rows *sql.Rows, enc *encoder, m map[uint32]uint32, expandMax int) (int, error) {
+	defer rows.Close()
+	var in []unified.Interval
+	for rows.Next() {
+		var a, b int64
+		var l localdb.Location
+		if err := rows.Scan(&a, &b, &l.Country, &l.Region, &l.Province, &l.City, &l.ISP); err != nil {
+			return len(in), err
+		}
+		if a < 0 || b < a || b > 0xffffffff {
+			continue
+		}
+		in = append(in, unified.Interval{Start: uint32(a), End: uint32(b), Loc: enc.loc(l)})
+	}
+	if err := rows.Err(); err != nil {
+		return len(in), err
+	}
+	var keys []uint32
+	for _, iv := range unified.MostSpecific(in) {
+		if uint64(iv.End)-uint64(iv.Start) < uint64(expandMax) {
+			for v := uint64(iv.Start); v <= uint64(iv.End); v++ {
+				m[uint32(v)] = iv.Loc
+			}
+			continue
+		}
+		if keys == nil {
+			keys = make([]uint32, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		}
+		for i := sort.Search(len(keys), func(i int) bool { return keys[i] >= iv.Start }); i < len(keys) && keys[i] <= iv.End; i++ {
+			m[keys[i]] = iv.Loc
+		}
+	}
+	return len(in), nil
+}
+
+// 文档注释：打开精确文件库
+// 背景：映射文件并校验头部与正文 CRC，随后物化地点字典；v1 旧文件或损坏文件返回错误，调用方重建后再打开。
+func NewExactDB(dir string) (*ExactDB, error) {
+	fp := filepath.Join(dir, "exact.db")
+	mf, err := mmap.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	data := mf.Bytes()
+	h, err := decodeHeader(data)
+	if err != nil {
+		mf.Close()
+		return nil, err
+	}
+	locs, err := decodeLocations(data, h)
+	if err != nil {
+		mf.Close()
+		return nil, err
+	}
+	e := &ExactDB{m: mf, recs: data[h.recOff : uint64(h.recOff)+uint64(h.count)*recordSize], count: int(h.count), locs: locs}
+	_ = json.Unmarshal(data[h.metaOff:h.metaOff+h.metaLen], &e.meta)
+	logger.L().Debug("exactdb_open", "dir", dir, "count", e.count, "locations", len(locs), "built_at", time.Unix(h.builtAt, 0).UTC())
+	return e, nil
+}
+
+// 文档注释：精确查询
+// 约束：非 IPv4 直接未命中；地点下标越界视为未命中（构建期保证不会发生）。
 func (e *ExactDB) Lookup(ip string) (localdb.Location, bool) {
-    var zero localdb.Location
-    p := net.ParseIP(ip)
-    if p == nil || p.To4() == nil {
-        return zero, false
-    }
-    v := p.To4()
-    val := uint32(v[0])<<24 | uint32(v[1])<<16 | uint32(v[2])<<8 | uint32(v[3])
-    lo, hi := 0, e.count-1
-    base := int64(12)
-    for lo <= hi {
-        mid := (lo + hi) >> 1
-        off := base + int64(mid)*8
-        buf := make([]byte, 8)
-        if _, err := e.f.ReadAt(buf, off); err != nil {
-            return zero, false
-        }
-        k := binary.BigEndian.Uint32(buf[:4])
-        if val < k {
-            hi = mid - 1
-        } else if val > k {
-            lo = mid + 1
-        } else {
-            lid := int(binary.BigEndian.Uint32(buf[4:8]))
-            var l localdb.Location
-            row := e.db.QueryRow("SELECT country, region, province, city, isp FROM _ip_locations WHERE id=$1", lid)
-            if err := row.Scan(&l.Country, &l.Region, &l.Province, &l.City, &l.ISP); err != nil {
-                return zero, false
-            }
-            logger.L().Debug("exactdb_lookup_hit", "ip_val", int64(val), "loc_id", lid)
-            return l, true
-        }
-    }
-    return zero, false
+	val, ok := ipv4Key(ip)
+	if !ok {
+		return localdb.Location{}, false
+	}
+	lo, hi := 0, e.count-1
+	for lo <= hi {
+		mid := int(uint(lo+hi) >> 1)
+		off := mid * recordSize
+		k := binary.BigEndian.Uint32(e.recs[off:])
+		switch {
+		case val < k:
+			hi = mid - 1
+		case val > k:
+			lo = mid + 1
+		default:
+			lid := binary.BigEndian.Uint32(e.recs[off+4:])
+			if int(lid) >= len(e.locs) {
+				return localdb.Location{}, false
+			}
+			return e.locs[lid], true
+		}
+	}
+	return localdb.Location{}, false
+}
+
+func ipv4Key(ip string) (uint32, bool) {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return 0, false
+	}
+	a = a.Unmap()
+	if !a.Is4() {
+		return 0, false
+	}
+	b := a.As4()
+	return binary.BigEndian.Uint32(b[:]), true
 }
 
-func (e *ExactDB) Close() error { return e.f.Close() }
+// Meta：构建元信息
+func (e *ExactDB) Meta() Meta { return e.meta }
+
+// Len：记录条数
+func (e *ExactDB) Len() int { return e.count }
+
+func (e *ExactDB) Close() error { return e.m.Close() }
@@ -0,0 +1,153 @@
+package exact
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"ip-api/internal/localdb"
+	"ip-api/internal/localdb/locdict"
+	"time"
+)
+
+// 文档注释：EXDB v2 文件格式（大端序）
+// 背景：v1 仅存 (ip, location_id)，每次命中都需回查 _ip_locations，数据库慢或不可用时文件缓存层随之失效；v2 内嵌去重的地点字典，查询完全脱离数据库。
+// 布局：
+// - 头部 64 字节：magic "EXDB"｜version=2｜记录数｜地点数｜记录区偏移｜地点区偏移｜字符串区偏移｜元信息偏移｜元信息长度｜构建时间(unix 秒, int64)｜正文 CRC32C｜保留｜头部 CRC32C；
+// - 记录区：按 IP 升序的 (ip uint32, 地点下标 uint32)，二分查找；
+// - 地点区：每个地点 5 个字符串偏移（country/region/province/city/isp，相对字符串区）；
+// - 字符串区：去重字符串，每个为 uint16 长度 + UTF-8 字节；
+// - 元信息：JSON（构建来源与条数），仅用于排查。
+// 约束：正文 CRC 覆盖头部之后的全部字节，头部 CRC 覆盖前 60 字节；任一校验失败拒绝加载。
+const (
+	magic      = "EXDB"
+	version    = 2
+	headerSize = 64
+	recordSize = 8
+	locSize    = locdict.EntrySize
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt：文件校验失败或结构越界
+var ErrCorrupt = errors.New("exactdb: corrupt file")
+
+type header struct {
+	count    uint32
+	locCount uint32
+	recOff   uint32
+	locOff   uint32
+	strOff   uint32
+	metaOff  uint32
+	metaLen  uint32
+	builtAt  int64
+	bodyCRC  uint32
+}
+
+func (h header) encode() []byte {
+	b := make([]byte, headerSize)
+	copy(b[0:4], magic)
+	be := binary.BigEndian
+	be.PutUint32(b[4:], version)
+	be.PutUint32(b[8:], h.count)
+	be.PutUint32(b[12:], h.locCount)
+	be.PutUint32(b[16:], h.recOff)
+	be.PutUint32(b[20:], h.locOff)
+	be.PutUint32(b[24:], h.strOff)
+	be.PutUint32(b[28:], h.metaOff)
+	be.PutUint32(b[32:], h.metaLen)
+	be.PutUint64(b[36:], uint64(h.builtAt))
+	be.PutUint32(b[44:], h.bodyCRC)
+	be.PutUint32(b[60:], crc32.Checksum(b[:60], castagnoli))
+	return b
+}
+
+// 文档注释：解析并校验头部与正文
+// 返回：版本不符（含 v1 旧文件）时返回带版本号的错误，调用方应重新构建。
+func decodeHeader(data []byte) (header, error) {
+	var h header
+	if len(data) < headerSize || string(data[:4]) != magic {
+		return h, ErrCorrupt
+	}
+	be := binary.BigEndian
+	if v := be.Uint32(data[4:]); v != version {
+		return h, fmt.Errorf("exactdb: unsupported version %d (want %d), rebuild required", v, version)
+	}
+	if be.Uint32(data[60:]) != crc32.Checksum(data[:60], castagnoli) {
+		return h, ErrCorrupt
+	}
+	h = header{
+		count:    be.Uint32(data[8:]),
+		locCount: be.Uint32(data[12:]),
+		recOff:   be.Uint32(data[16:]),
+		locOff:   be.Uint32(data[20:]),
+		strOff:   be.Uint32(data[24:]),
+		metaOff:  be.Uint32(data[28:]),
+		metaLen:  be.Uint32(data[32:]),
+		builtAt:  int64(be.Uint64(data[36:])),
+		bodyCRC:  be.Uint32(data[44:]),
+	}
+	n := uint64(len(data))
+	if uint64(h.recOff)+uint64(h.count)*recordSize > n ||
+		uint64(h.locOff)+uint64(h.locCount)*locSize > n ||
+		uint64(h.strOff) > n || uint64(h.metaOff)+uint64(h.metaLen) > n {
+		return h, ErrCorrupt
+	}
+	if crc32.Checksum(data[headerSize:], castagnoli) != h.bodyCRC {
+		return h, ErrCorrupt
+	}
+	return h, nil
+}
+
+// 文档注释：文件编码器
+// 背景：地点字典由 locdict 去重编码；构建期一次性产出整个文件内容。
+type encoder struct {
+	*locdict.Builder
+}
+
+func newEncoder() *encoder { return &encoder{locdict.NewBuilder()} }
+
+func (e *encoder) loc(l localdb.Location) uint32 { return e.Add(l) }
+
+// 文档注释：产出完整文件内容
+// 参数：recs 为已排序、去重的 (ip, 地点下标)；meta 为 JSON 元信息。
+func (e *encoder) encode(recs [][2]uint32, meta []byte, builtAt time.Time) []byte {
+	var body bytes.Buffer
+	be := binary.BigEndian
+	var w [recordSize]byte
+	for _, r := range recs {
+		be.PutUint32(w[0:], r[0])
+		be.PutUint32(w[4:], r[1])
+		body.Write(w[:])
+	}
+	locs, strs := e.Encode()
+	locOff := headerSize + body.Len()
+	body.Write(locs)
+	strOff := headerSize + body.Len()
+	body.Write(strs)
+	metaOff := headerSize + body.Len()
+	body.Write(meta)
+	h := header{
+		count:    uint32(len(recs)),
+		locCount: uint32(e.Len()),
+		recOff:   headerSize,
+		locOff:   uint32(locOff),
+		strOff:   uint32(strOff),
+		metaOff:  uint32(metaOff),
+		metaLen:  uint32(len(meta)),
+		builtAt:  builtAt.Unix(),
+		bodyCRC:  crc32.Checksum(body.Bytes(), castagnoli),
+	}
+	return append(h.encode(), body.Bytes()...)
+}
+
+// 文档注释：解码地点字典
+// 背景：打开时一次性物化为 Go 字符串，查询时仅按下标取值，不再分配。
+func decodeLocations(data []byte, h header) ([]localdb.Location, error) {
+	locs, err := locdict.Decode(data[h.locOff:], data[h.strOff:], int(h.locCount))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return locs, nil
+}
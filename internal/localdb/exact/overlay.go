@@ -0,0 +1,293 @@
+package exact
+
+import (
+	"context"
+	"database/sql"
+	"ip-api/internal/localdb"
+	"ip-api/internal/logger"
+	"ip-api/internal/metrics"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 旧文件切换后的关闭延迟（与统一查询库一致）
+const closeGrace = 30 * time.Second
+
+// 文档注释：精确库增量层（内存覆盖 + 不可变文件）
+// 背景：原先每次融合写库都起协程全量重建 exact.db 并重开 IPIP/IP2Region，突发请求会并发触发大量重建；
+// 现将最近写入放入内存覆盖层立即生效，文件重建改为进程内单飞、防抖合并，并定期压实（把覆盖层并入新文件）。
+// 约束：
+// - 查询先查覆盖层再查文件；覆盖层条目在包含它的文件切换后才移除，期间写入的条目保留到下一次压实；
+// - 重建同一时刻只有一个在执行，执行期间的请求合并为其后的一次重建；
+// - 定期压实会比对数据库指纹（条数与最近更新时间），其他进程（如 amap-ingest）的写入也会被纳入。
+type Overlay struct {
+	dir string
+	db  *sql.DB
+
+	base atomic.Pointer[ExactDB]
+
+	mu   sync.RWMutex
+	over map[uint32]overlayEntry
+	seq  uint64
+
+	fmu     sync.Mutex
+	running *flight
+	next    *flight
+	lastFP  string
+	onSwap  []func(builtAt time.Time)
+
+	trig     chan struct{}
+	debounce time.Duration
+	interval time.Duration
+	maxOver  int
+}
+
+type overlayEntry struct {
+	loc localdb.Location
+	seq uint64
+}
+
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+// 文档注释：创建增量层
+// 约束：EXACT_REBUILD_DEBOUNCE_MS（默认 2000）为写入后等待合并的时长；EXACT_COMPACT_INTERVAL_SECONDS（默认 300，0 关闭）为定期压实周期；
+// EXACT_OVERLAY_MAX（默认 10000）为覆盖层条数上限，超过时立即触发重建（仍经防抖）。
+func NewOverlay(dir string, db *sql.DB) *Overlay {
+	return &Overlay{
+		dir:      dir,
+		db:       db,
+		over:     make(map[uint32]overlayEntry),
+		trig:     make(chan struct{}, 1),
+		debounce: time.Duration(envInt("EXACT_REBUILD_DEBOUNCE_MS", 2000)) * time.Millisecond,
+		interval: time.Duration(envInt("EXACT_COMPACT_INTERVAL_SECONDS", 300)) * time.Second,
+		maxOver:  envInt("EXACT_OVERLAY_MAX", 10000),
+	}
+}
+
+// 文档注释：查询（覆盖层优先）
+func (o *Overlay) Lookup(ip string) (localdb.Location, bool) {
+	if v, ok := ipv4Key(ip); ok {
+		o.mu.RLock()
+		e, hit := o.over[v]
+		o.mu.RUnlock()
+		if hit {
+			return e.loc, true
+		}
+	}
+	if b := o.base.Load(); b != nil {
+		return b.Lookup(ip)
+	}
+	return localdb.Location{}, false
+}
+
+// 文档注释：登记一次已落库的写入
+// 背景：调用方需先写数据库再登记，保证之后开始的重建一定包含该写入；登记后立即对查询生效并触发防抖重建。
+func (o *Overlay) Put(ipInt uint32, l localdb.Location) {
+	o.mu.Lock()
+	o.seq++
+	o.over[ipInt] = overlayEntry{loc: l, seq: o.seq}
+	n := len(o.over)
+	o.mu.Unlock()
+	metrics.ExactOverlaySize.Set(float64(n))
+	if n == o.maxOver {
+		logger.L().Info("exact_overlay_full", "size", n)
+	}
+	o.Trigger()
+}
+
+// OnSwap：登记文件切换回调，参数为新文件的构建开始时刻（构建时读取的数据不早于该时刻）
+func (o *Overlay) OnSwap(fn func(builtAt time.Time)) {
+	o.fmu.Lock()
+	o.onSwap = append(o.onSwap, fn)
+	o.fmu.Unlock()
+}
+
+// 文档注释：请求一次后台重建（非阻塞，多次请求合并）
+func (o *Overlay) Trigger() {
+	select {
+	case o.trig <- struct{}{}:
+	default:
+	}
+}
+
+// Ready：是否已加载过文件
+func (o *Overlay) Ready() bool { return o.base.Load() != nil }
+
+// DataVersion：当前文件的构建时间（RFC3339）；覆盖层条目比该版本新，未加载文件时为空
+func (o *Overlay) DataVersion() string {
+	if b := o.base.Load(); b != nil {
+		return b.meta.BuiltAt.UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+// 文档注释：启动后台重建循环
+// 背景：收到触发后等待防抖窗口内不再有新写入（最长等待 10 个窗口，避免持续写入导致饥饿）再重建；定期压实在数据库指纹变化时重建。
+func (o *Overlay) Start(ctx context.Context) {
+	go func() {
+		var tick <-chan time.Time
+		if o.interval > 0 {
+			t := time.NewTicker(o.interval)
+			defer t.Stop()
+			tick = t.C
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.trig:
+				o.settle(ctx)
+				if err := o.Rebuild(ctx, true); err != nil {
+					logger.L().Error("exact_rebuild_error", "reason", "trigger", "err", err)
+				}
+			case <-tick:
+				if err := o.Rebuild(ctx, false); err != nil {
+					logger.L().Error("exact_rebuild_error", "reason", "compact", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+func (o *Overlay) settle(ctx context.Context) {
+	if o.debounce <= 0 {
+		return
+	}
+	deadline := time.Now().Add(10 * o.debounce)
+	for {
+		o.mu.RLock()
+		full := len(o.over) >= o.maxOver
+		o.mu.RUnlock()
+		if full {
+			return
+		}
+		t := time.NewTimer(min(o.debounce, time.Until(deadline)))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-o.trig:
+			t.Stop()
+			if time.Now().After(deadline) {
+				return
+			}
+		case <-t.C:
+			return
+		}
+	}
+}
+
+// 文档注释：重建文件并切换（单飞）
+// 参数：force 为 false 时仅在数据库指纹变化或覆盖层非空时重建（定期压实）。
+// 返回：执行期间到达的调用会等待其后的一次重建并共享其结果。
+func (o *Overlay) Rebuild(ctx context.Context, force bool) error {
+	o.fmu.Lock()
+	if o.next != nil {
+		f := o.next
+		o.fmu.Unlock()
+		<-f.done
+		return f.err
+	}
+	if o.running != nil {
+		f := &flight{done: make(chan struct{})}
+		o.next = f
+		o.fmu.Unlock()
+		<-f.done
+		return f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	o.running = f
+	o.fmu.Unlock()
+	first := f
+	for {
+		f.err = o.build(ctx, force)
+		close(f.done)
+		o.fmu.Lock()
+		if o.next == nil {
+			o.running = nil
+			o.fmu.Unlock()
+			return first.err
+		}
+		f, o.running, o.next = o.next, o.next, nil
+		force = true
+		o.fmu.Unlock()
+	}
+}
+
+func (o *Overlay) build(ctx context.Context, force bool) error {
+	fp := o.fingerprint(ctx)
+	o.mu.RLock()
+	gen, pending := o.seq, len(o.over)
+	o.mu.RUnlock()
+	if !force && pending == 0 && fp != "" && fp == o.lastFP && o.Ready() {
+		metrics.ExactRebuildsTotal.WithLabelValues("unchanged").Inc()
+		return nil
+	}
+	t0 := time.Now()
+	if err := BuildExactDBFromDB(o.dir, o.db); err != nil {
+		metrics.ExactRebuildsTotal.WithLabelValues("error").Inc()
+		return err
+	}
+	edb, err := NewExactDB(o.dir)
+	if err != nil {
+		metrics.ExactRebuildsTotal.WithLabelValues("error").Inc()
+		return err
+	}
+	// 旧文件已被改名替换，延迟关闭以回收映射与句柄（给进行中的查询留出时间）
+	if old := o.base.Swap(edb); old != nil {
+		time.AfterFunc(closeGrace, func() { _ = old.Close() })
+	}
+	o.lastFP = fp
+	o.fmu.Lock()
+	fns := o.onSwap
+	o.fmu.Unlock()
+	for _, fn := range fns {
+		fn(edb.meta.BuiltAt)
+	}
+	// 仅移除重建开始前登记的条目；重建期间的写入可能不在新文件中，留待下一次压实
+	o.mu.Lock()
+	for k, e := range o.over {
+		if e.seq <= gen {
+			delete(o.over, k)
+		}
+	}
+	left := len(o.over)
+	o.mu.Unlock()
+	metrics.ExactOverlaySize.Set(float64(left))
+	metrics.ExactRebuildsTotal.WithLabelValues("ok").Inc()
+	metrics.ExactRebuildDurationMs.Observe(float64(time.Since(t0).Milliseconds()))
+	logger.L().Info("exact_rebuild_switch_ok", "records", edb.Len(), "overlay_left", left, "ms", time.Since(t0).Milliseconds())
+	return nil
+}
+
+// 文档注释：数据库指纹（条数与最近更新时间）
+// 返回：查询失败时返回空串（视为已变化）。
+func (o *Overlay) fingerprint(ctx context.Context) string {
+	var a, b, c, d int64
+	var tb, tc, td sql.NullTime
+	err := o.db.QueryRowContext(ctx, `SELECT
+        (SELECT COUNT(1) FROM _ip_overrides),
+        (SELECT COUNT(1) FROM _ip_overrides_kv), (SELECT MAX(updated_at) FROM _ip_overrides_kv),
+        (SELECT COUNT(1) FROM _ip_exact), (SELECT MAX(updated_at) FROM _ip_exact),
+        (SELECT COUNT(1) FROM _ip_overrides_kv_range), (SELECT MAX(updated_at) FROM _ip_overrides_kv_range)`).Scan(&a, &b, &tb, &c, &tc, &d, &td)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatInt(a, 10) + "|" + strconv.FormatInt(b, 10) + "|" + tb.Time.String() + "|" + strconv.FormatInt(c, 10) + "|" + tc.Time.String() +
+		"|" + strconv.FormatInt(d, 10) + "|" + td.Time.String()
+}
+
+func envInt(name string, def int) int {
+	if s := os.Getenv(name); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return def
+}
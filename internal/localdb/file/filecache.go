@@ -1,188 +1,251 @@
 package file
 
 import (
-    "database/sql"
-    "encoding/binary"
-    "encoding/json"
-    "ip-api/internal/localdb"
-    "ip-api/internal/logger"
-    "os"
-    "path/filepath"
-    "sort"
-    "strconv"
+	"bufio"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"ip-api/internal/localdb"
+	"ip-api/internal/logger"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 )
 
-type Range struct {
-    Start uint32
-    End   uint32
-    LocID int
+// 文档注释：范围分片文件格式 v2（大端序）
+// 背景：v1 分片直接存 _ip_locations.id，查询按 locs[id-1] 取值，序列存在空洞（ON CONFLICT 消耗序列、删除行）时错位；
+// v2 构建期把 id 重映射为 locations.json 内的稠密下标。
+// 布局：octet-<首段>.bin = magic "FCB2"｜区间数 uint32｜按起始升序的 (start uint32, end uint32, 地点下标 uint32)。
+const (
+	bucketMagic  = "FCB2"
+	bucketHeader = 8
+	rangeSize    = 12
+	indexVersion = 2
+)
+
+var errBadBucket = errors.New("filecache: bad bucket file")
+
+// index：locations.json 内容；Ranges/Locations 为构建时数据库条数，用于判断是否需要重建
+type index struct {
+	Version   int                `json:"version"`
+	BuiltAt   time.Time          `json:"built_at"`
+	Ranges    int64              `json:"ranges"`
+	Locations []localdb.Location `json:"locations"`
 }
 
+// 文档注释：范围文件缓存（按首段分片、按需加载）
+// 背景：作为链式缓存中不访问数据库的范围层；分片经分段 LRU 限制常驻字节数，同一分片的并发加载合并为一次。
+// 约束：实例绑定构建产物；重建目录后需创建新实例并经 DynamicCache 切换，旧实例不得继续使用。
 type FileCache struct {
-    dir  string
-    locs []localdb.Location
-    lru  map[int][]Range
+	dir     string
+	locs    []localdb.Location
+	builtAt time.Time
+	cache   *bucketCache
 }
 
+// 文档注释：由数据库构建范围分片文件
+// 背景：先写入同级临时目录，完成后整体改名替换，查询方不会读到新旧混合的分片与地点表。
+// 约束：引用不存在地点的范围被丢弃；已映射旧分片的读者（unix）不受替换影响。
 func BuildFilesFromDB(dir string, db *sql.DB) error {
-    logger.L().Debug("filecache_build_begin", "dir", dir)
-    if err := os.MkdirAll(dir, 0o755); err != nil {
-        return err
-    }
-    rows, err := db.Query("SELECT id, country, region, province, city, isp FROM _ip_locations ORDER BY id")
-    if err != nil {
-        return err
-    }
-    defer rows.Close()
-    var locs []localdb.Location
-    for rows.Next() {
-        var id int
-        var l localdb.Location
-        if err := rows.Scan(&id, &l.Country, &l.Region, &l.Province, &l.City, &l.ISP); err != nil {
-            return err
-        }
-        locs = append(locs, l)
-    }
-    b, _ := json.Marshal(locs)
-    if err := os.WriteFile(filepath.Join(dir, "locations.json"), b, 0o644); err != nil {
-        return err
-    }
-    logger.L().Debug("filecache_locations_written", "count", len(locs))
-    rrows, err := db.Query("SELECT start_int, end_int, first_octet, location_id FROM _ip_ipv4_ranges ORDER BY first_octet, start_int")
-    if err != nil {
-        return err
-    }
-    defer rrows.Close()
-    buckets := make(map[int][]Range)
-    for rrows.Next() {
-        var s, e int64
-        var a, lid int
-        if err := rrows.Scan(&s, &e, &a, &lid); err != nil {
-            return err
-        }
-        buckets[a] = append(buckets[a], Range{Start: uint32(s), End: uint32(e), LocID: lid})
-    }
-    for a, arr := range buckets {
-        sort.Slice(arr, func(i, j int) bool { return arr[i].Start < arr[j].Start })
-        fp := filepath.Join(dir, "octet-"+strconv.Itoa(a)+".bin")
-        f, err := os.Create(fp)
-        if err != nil {
-            return err
-        }
-        if err := binary.Write(f, binary.BigEndian, uint32(len(arr))); err != nil {
-            f.Close()
-            return err
-        }
-        for _, r := range arr {
-            if err := binary.Write(f, binary.BigEndian, r.Start); err != nil {
-                f.Close()
-                return err
-            }
-            if err := binary.Write(f, binary.BigEndian, r.End); err != nil {
-                f.Close()
-                return err
-            }
-            if err := binary.Write(f, binary.BigEndian, uint32(r.LocID)); err != nil {
-                f.Close()
-                return err
-            }
-        }
-        _ = f.Close()
-        logger.L().Debug("filecache_bucket_written", "octet", a, "count", len(arr))
-    }
-    logger.L().Info("filecache_build_done")
-    return nil
+	logger.L().Debug("filecache_build_begin", "dir", dir)
+	t0 := time.Now()
+	rows, err := db.Query("SELECT id, country, region, province, city, isp FROM _ip_locations ORDER BY id")
+	if err != nil {
+		return err
+	}
+	ids := make(map[int]uint32)
+	idx := index{Version: indexVersion, BuiltAt: t0.UTC()}
+	for rows.Next() {
+		var id int
+		var l localdb.Location
+		if err := rows.Scan(&id, &l.Country, &l.Region, &l.Province, &l.City, &l.ISP); err != nil {
+			rows.Close()
+			return err
+		}
+		ids[id] = uint32(len(idx.Locations))
+		idx.Locations = append(idx.Locations, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	tmp := dir + ".building"
+	_ = os.RemoveAll(tmp)
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return err
+	}
+	rrows, err := db.Query("SELECT start_int, end_int, first_octet, location_id FROM _ip_ipv4_ranges ORDER BY first_octet, start_int")
+	if err != nil {
+		return err
+	}
+	defer rrows.Close()
+	var cur []byte
+	octet := -1
+	var rec [rangeSize]byte
+	for rrows.Next() {
+		var s, e int64
+		var a, lid int
+		if err := rrows.Scan(&s, &e, &a, &lid); err != nil {
+			return err
+		}
+		idx.Ranges++
+		li, ok := ids[lid]
+		if !ok || a < 0 || a > 255 {
+			continue
+		}
+		if a != octet {
+			if err := writeBucket(tmp, octet, cur); err != nil {
+				return err
+			}
+			octet, cur = a, cur[:0]
+		}
+		binary.BigEndian.PutUint32(rec[0:], uint32(s))
+		binary.BigEndian.PutUint32(rec[4:], uint32(e))
+		binary.BigEndian.PutUint32(rec[8:], li)
+		cur = append(cur, rec[:]...)
+	}
+	if err := rrows.Err(); err != nil {
+		return err
+	}
+	if err := writeBucket(tmp, octet, cur); err != nil {
+		return err
+	}
+	b, _ := json.Marshal(idx)
+	if err := os.WriteFile(filepath.Join(tmp, "locations.json"), b, 0o644); err != nil {
+		return err
+	}
+	old := dir + ".old"
+	_ = os.RemoveAll(old)
+	if _, err := os.Stat(dir); err == nil {
+		if err := os.Rename(dir, old); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return err
+	}
+	_ = os.RemoveAll(old)
+	logger.L().Info("filecache_build_done", "ranges", idx.Ranges, "locations", len(idx.Locations), "ms", time.Since(t0).Milliseconds())
+	return nil
+}
+
+func writeBucket(dir string, octet int, recs []byte) error {
+	if octet < 0 || len(recs) == 0 {
+		return nil
+	}
+	f, err := os.Create(filepath.Join(dir, "octet-"+strconv.Itoa(octet)+".bin"))
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var h [bucketHeader]byte
+	copy(h[:4], bucketMagic)
+	binary.BigEndian.PutUint32(h[4:], uint32(len(recs)/rangeSize))
+	_, _ = w.Write(h[:])
+	_, _ = w.Write(recs)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	logger.L().Debug("filecache_bucket_written", "octet", octet, "count", len(recs)/rangeSize)
+	return f.Close()
 }
 
+// 文档注释：判断构建产物是否与数据库一致
+// 返回：产物缺失、版本过旧或范围/地点条数不一致时返回 true。
+func NeedsRebuild(dir string, db *sql.DB) bool {
+	b, err := os.ReadFile(filepath.Join(dir, "locations.json"))
+	if err != nil {
+		return true
+	}
+	var idx index
+	if json.Unmarshal(b, &idx) != nil || idx.Version != indexVersion {
+		return true
+	}
+	var ranges, locs int64
+	if db.QueryRow("SELECT COUNT(1) FROM _ip_ipv4_ranges").Scan(&ranges) != nil ||
+		db.QueryRow("SELECT COUNT(1) FROM _ip_locations").Scan(&locs) != nil {
+		return false
+	}
+	return ranges != idx.Ranges || locs != int64(len(idx.Locations))
+}
+
+// 文档注释：打开范围文件缓存
+// 约束：FILECACHE_MAX_BYTES（默认 64MiB）为常驻分片字节上限；FILECACHE_SHARDS（默认 16）为 LRU 分段数；
+// FILECACHE_MMAP=true 时分片以 mmap 打开（由内核页缓存承载，上限按映射字节计）。v1 产物返回错误，调用方重建后再打开。
 func NewFileCache(dir string) (*FileCache, error) {
-    b, err := os.ReadFile(filepath.Join(dir, "locations.json"))
-    if err != nil {
-        return nil, err
-    }
-    var locs []localdb.Location
-    if err := json.Unmarshal(b, &locs); err != nil {
-        return nil, err
-    }
-    logger.L().Debug("filecache_init", "dir", dir, "locations", len(locs))
-    return &FileCache{dir: dir, locs: locs, lru: make(map[int][]Range)}, nil
+	b, err := os.ReadFile(filepath.Join(dir, "locations.json"))
+	if err != nil {
+		return nil, err
+	}
+	var idx index
+	if err := json.Unmarshal(b, &idx); err != nil || idx.Version != indexVersion {
+		return nil, errors.New("filecache: unsupported index version, rebuild required")
+	}
+	maxBytes := int64(envInt("FILECACHE_MAX_BYTES", 64<<20))
+	shards := max(envInt("FILECACHE_SHARDS", 16), 1)
+	useMmap := os.Getenv("FILECACHE_MMAP") == "true"
+	logger.L().Debug("filecache_init", "dir", dir, "locations", len(idx.Locations), "max_bytes", maxBytes, "shards", shards, "mmap", useMmap)
+	return &FileCache{dir: dir, locs: idx.Locations, builtAt: idx.BuiltAt, cache: newBucketCache(dir, shards, maxBytes, useMmap)}, nil
 }
 
 func (c *FileCache) Lookup(ip string) (localdb.Location, bool) {
-    var zero localdb.Location
-    p := parseIPv4(ip)
-    if p == nil {
-        return zero, false
-    }
-    a := int(p[0])
-    v := uint32(p[0])<<24 | uint32(p[1])<<16 | uint32(p[2])<<8 | uint32(p[3])
-    arr, ok := c.lru[a]
-    if !ok {
-        fp := filepath.Join(c.dir, "octet-"+strconv.Itoa(a)+".bin")
-        data, err := os.ReadFile(fp)
-        if err != nil {
-            return zero, false
-        }
-        logger.L().Debug("filecache_bucket_load", "octet", a, "size", len(data))
-        if len(data) < 4 {
-            return zero, false
-        }
-        n := int(binary.BigEndian.Uint32(data[:4]))
-        recs := make([]Range, n)
-        off := 4
-        for i := 0; i < n; i++ {
-            if off+12 > len(data) {
-                return zero, false
-            }
-            s := binary.BigEndian.Uint32(data[off : off+4])
-            e := binary.BigEndian.Uint32(data[off+4 : off+8])
-            lid := int(binary.BigEndian.Uint32(data[off+8 : off+12]))
-            recs[i] = Range{Start: s, End: e, LocID: lid}
-            off += 12
-        }
-        c.lru[a] = recs
-        arr = recs
-    }
-    if len(arr) == 0 {
-        return zero, false
-    }
-    i := sort.Search(len(arr), func(i int) bool { return arr[i].Start > v })
-    if i == 0 {
-        return zero, false
-    }
-    r := arr[i-1]
-    if v >= r.Start && v <= r.End {
-        lid := r.LocID
-        if lid >= 1 && lid <= len(c.locs) {
-            return c.locs[lid-1], true
-        }
-    }
-    return zero, false
+	var zero localdb.Location
+	p := parseIPv4(ip)
+	if p == nil {
+		return zero, false
+	}
+	v := uint32(p[0])<<24 | uint32(p[1])<<16 | uint32(p[2])<<8 | uint32(p[3])
+	b, err := c.cache.acquire(int(p[0]))
+	if err != nil {
+		return zero, false
+	}
+	defer b.release()
+	lid, ok := b.search(v)
+	if !ok || int(lid) >= len(c.locs) {
+		return zero, false
+	}
+	return c.locs[lid], true
+}
+
+// DataVersion：构建产物的生成时间（RFC3339）
+func (c *FileCache) DataVersion() string { return c.builtAt.UTC().Format(time.RFC3339) }
+
+func envInt(name string, def int) int {
+	if s := os.Getenv(name); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
 }
 
 func parseIPv4(s string) []byte {
-    b := make([]byte, 0, 4)
-    v := 0
-    c := 0
-    for i := 0; i < len(s); i++ {
-        ch := s[i]
-        if ch >= '0' && ch <= '9' {
-            v = v*10 + int(ch-'0')
-            if v > 255 {
-                return nil
-            }
-        }
-        if ch == '.' {
-            b = append(b, byte(v))
-            v = 0
-            c++
-            if c > 3 {
-                return nil
-            }
-        }
-    }
-    b = append(b, byte(v))
-    if len(b) != 4 {
-        return nil
-    }
-    return b
+	b := make([]byte, 0, 4)
+	v := 0
+	c := 0
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= '0' && ch <= '9' {
+			v = v*10 + int(ch-'0')
+			if v > 255 {
+				return nil
+			}
+		}
+		if ch == '.' {
+			b = append(b, byte(v))
+			v = 0
+			c++
+			if c > 3 {
+				return nil
+			}
+		}
+	}
+	b = append(b, byte(v))
+	if len(b) != 4 {
+		return nil
+	}
+	return b
 }
@@ -0,0 +1,197 @@
+package file
+
+import (
+	"container/list"
+	"encoding/binary"
+	"ip-api/internal/metrics"
+	"ip-api/internal/mmap"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// 文档注释：已加载的范围分片
+// 背景：查询直接在原始字节上二分，不再解析为切片；mmap 模式下淘汰时若仍有查询持有则延迟到最后一个释放者解除映射。
+type bucket struct {
+	octet int
+	recs  []byte
+	n     int
+	size  int64
+	mf    *mmap.File
+	elem  *list.Element
+	refs  atomic.Int32
+	dead  atomic.Bool
+	once  sync.Once
+}
+
+func (b *bucket) search(v uint32) (uint32, bool) {
+	be := binary.BigEndian
+	lo, hi := 0, b.n
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if be.Uint32(b.recs[mid*rangeSize:]) <= v {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return 0, false
+	}
+	off := (lo - 1) * rangeSize
+	if be.Uint32(b.recs[off+4:]) < v {
+		return 0, false
+	}
+	return be.Uint32(b.recs[off+8:]), true
+}
+
+func (b *bucket) release() {
+	if b.refs.Add(-1) == 0 && b.dead.Load() {
+		b.close()
+	}
+}
+
+func (b *bucket) close() {
+	b.once.Do(func() {
+		if b.mf != nil {
+			_ = b.mf.Close()
+		}
+	})
+}
+
+type loadCall struct {
+	done chan struct{}
+	err  error
+}
+
+// shard：LRU 分段；items/calls/ll 受 mu 保护
+type shard struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[int]*bucket
+	calls map[int]*loadCall
+	bytes int64
+	max   int64
+}
+
+// 文档注释：分段有界 LRU（按首段分片）
+// 背景：原实现对 map 无锁写入且不设上限；现按首段取模分段加锁，各段按字节上限淘汰最久未用分片，
+// 同一分片的并发未命中只触发一次加载（单飞），其余请求等待其结果。
+// 约束：单个分片超过段上限时仍会保留（至少保留最近一个），避免反复加载。
+type bucketCache struct {
+	dir    string
+	mmap   bool
+	shards []*shard
+}
+
+func newBucketCache(dir string, shards int, maxBytes int64, useMmap bool) *bucketCache {
+	c := &bucketCache{dir: dir, mmap: useMmap, shards: make([]*shard, shards)}
+	for i := range c.shards {
+		c.shards[i] = &shard{ll: list.New(), items: map[int]*bucket{}, calls: map[int]*loadCall{}, max: max(maxBytes/int64(shards), 1)}
+	}
+	return c
+}
+
+// 文档注释：取得分片并持有引用
+// 返回：调用方用完须 release；分片文件不存在时返回空分片（同样缓存，避免反复打开）。
+func (c *bucketCache) acquire(octet int) (*bucket, error) {
+	s := c.shards[octet%len(c.shards)]
+	for {
+		s.mu.Lock()
+		if b, ok := s.items[octet]; ok {
+			s.ll.MoveToFront(b.elem)
+			b.refs.Add(1)
+			s.mu.Unlock()
+			return b, nil
+		}
+		if cl, ok := s.calls[octet]; ok {
+			s.mu.Unlock()
+			<-cl.done
+			if cl.err != nil {
+				return nil, cl.err
+			}
+			// 加载完成后重新查找（极端情况下已被淘汰则再次加载）
+			continue
+		}
+		cl := &loadCall{done: make(chan struct{})}
+		s.calls[octet] = cl
+		s.mu.Unlock()
+		b, err := c.load(octet)
+		s.mu.Lock()
+		delete(s.calls, octet)
+		if err == nil {
+			b.refs.Add(1)
+			s.insert(b)
+		}
+		cl.err = err
+		s.mu.Unlock()
+		close(cl.done)
+		return b, err
+	}
+}
+
+func (c *bucketCache) load(octet int) (*bucket, error) {
+	fp := filepath.Join(c.dir, "octet-"+strconv.Itoa(octet)+".bin")
+	b := &bucket{octet: octet}
+	var data []byte
+	if c.mmap {
+		mf, err := mmap.Open(fp)
+		if os.IsNotExist(err) {
+			metrics.FileCacheBucketLoadsTotal.WithLabelValues("missing").Inc()
+			return b, nil
+		}
+		if err != nil {
+			metrics.FileCacheBucketLoadsTotal.WithLabelValues("error").Inc()
+			return nil, err
+		}
+		b.mf, data = mf, mf.Bytes()
+	} else {
+		d, err := os.ReadFile(fp)
+		if os.IsNotExist(err) {
+			metrics.FileCacheBucketLoadsTotal.WithLabelValues("missing").Inc()
+			return b, nil
+		}
+		if err != nil {
+			metrics.FileCacheBucketLoadsTotal.WithLabelValues("error").Inc()
+			return nil, err
+		}
+		data = d
+	}
+	if len(data) < bucketHeader || string(data[:4]) != bucketMagic {
+		b.close()
+		metrics.FileCacheBucketLoadsTotal.WithLabelValues("error").Inc()
+		return nil, errBadBucket
+	}
+	n := int(binary.BigEndian.Uint32(data[4:]))
+	if bucketHeader+n*rangeSize > len(data) {
+		b.close()
+		metrics.FileCacheBucketLoadsTotal.WithLabelValues("error").Inc()
+		return nil, errBadBucket
+	}
+	b.recs, b.n, b.size = data[bucketHeader:bucketHeader+n*rangeSize], n, int64(len(data))
+	metrics.FileCacheBucketLoadsTotal.WithLabelValues("ok").Inc()
+	return b, nil
+}
+
+// insert：登记分片并按字节上限淘汰（调用方持有 mu）
+func (s *shard) insert(b *bucket) {
+	b.elem = s.ll.PushFront(b)
+	s.items[b.octet] = b
+	s.bytes += b.size
+	metrics.FileCacheBytes.Add(float64(b.size))
+	for s.bytes > s.max && s.ll.Len() > 1 {
+		e := s.ll.Back()
+		old := e.Value.(*bucket)
+		s.ll.Remove(e)
+		delete(s.items, old.octet)
+		s.bytes -= old.size
+		metrics.FileCacheBytes.Sub(float64(old.size))
+		metrics.FileCacheEvictionsTotal.Inc()
+		old.dead.Store(true)
+		if old.refs.Load() == 0 {
+			old.close()
+		}
+	}
+}
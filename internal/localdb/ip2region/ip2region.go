@@ -1,8 +1,11 @@
 package ip2region
 
 import (
+    "fmt"
     "ip-api/internal/localdb"
+    "os"
     "strings"
+    "time"
     
     "github.com/lionsoul2014/ip2region/binding/golang/xdb"
 )
@@ -10,21 +13,48 @@ import (
 type IP2RegionCache struct {
     v4 *xdb.Searcher
     v6 *xdb.Searcher
+    // version：数据文件头中的生成时间（RFC3339，优先 v4 文件），读取失败时为空
+    version string
 }
 
 func NewIP2RegionCache(v4Path, v6Path string) (*IP2RegionCache, error) {
     var v4s *xdb.Searcher
     var v6s *xdb.Searcher
     var err error
+    // 文件头与索引结构校验：损坏或截断的文件在打开时拒绝，而非在查询时返回错误
+    for _, p := range []string{v4Path, v6Path} {
+        if p == "" { continue }
+        if err := verify(p); err != nil { return nil, err }
+    }
     if v4Path != "" {
         v4s, err = xdb.NewWithFileOnly(xdb.IPv4, v4Path)
         if err != nil { return nil, err }
     }
     if v6Path != "" {
         v6s, err = xdb.NewWithFileOnly(xdb.IPv6, v6Path)
-        if err != nil { return nil, err }
+        if err != nil {
+            if v4s != nil { v4s.Close() }
+            return nil, err
+        }
     }
-    return &IP2RegionCache{ v4: v4s, v6: v6s }, nil
+    c := &IP2RegionCache{ v4: v4s, v6: v6s }
+    for _, p := range []string{v4Path, v6Path} {
+        if p == "" { continue }
+        if h, err := xdb.LoadHeaderFromFile(p); err == nil {
+            c.version = time.Unix(int64(h.CreatedAt), 0).UTC().Format(time.RFC3339)
+            break
+        }
+    }
+    return c, nil
+}
+
+// DataVersion：数据文件生成时间
+func (c *IP2RegionCache) DataVersion() string { return c.version }
+
+// Close：关闭数据文件句柄；之后不得再查询（热重载在版本退役后调用）
+func (c *IP2RegionCache) Close() {
+    if c.v4 != nil { c.v4.Close() }
+    if c.v6 != nil { c.v6.Close() }
 }
 
 func (c *IP2RegionCache) Lookup(ip string) (localdb.Location, bool) {
@@ -43,6 +73,19 @@ func (c *IP2RegionCache) Lookup(ip string) (localdb.Location, bool) {
     return zero, false
 }
 
+// verify：结构版本校验（xdb.Verify）并确认段索引区间落在文件内（截断文件查询时才会暴露）
+func verify(p string) error {
+    if err := xdb.VerifyFromFile(p); err != nil { return err }
+    h, err := xdb.LoadHeaderFromFile(p)
+    if err != nil { return err }
+    fi, err := os.Stat(p)
+    if err != nil { return err }
+    if h.StartIndexPtr > h.EndIndexPtr || int64(h.EndIndexPtr) >= fi.Size() {
+        return fmt.Errorf("ip2region: %s: segment index [%d,%d] out of file size %d", p, h.StartIndexPtr, h.EndIndexPtr, fi.Size())
+    }
+    return nil
+}
+
 func parseRegion(s string) localdb.Location {
     parts := strings.Split(s, "|")
     var l localdb.Location
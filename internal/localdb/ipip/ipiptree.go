@@ -1,179 +1,70 @@
 package ipip
 
 import (
-    "encoding/binary"
-    "encoding/json"
-    "errors"
-    "ip-api/internal/localdb"
-    "net"
-    "os"
+	"ip-api/internal/ipip"
+	"ip-api/internal/localdb"
+	"net/netip"
+	"strconv"
 )
 
-type ipipMeta struct {
-    Build     int64          `json:"build"`
-    IPVersion uint16         `json:"ip_version"`
-    Languages map[string]int `json:"languages"`
-    NodeCount int            `json:"node_count"`
-    TotalSize int            `json:"total_size"`
-    Fields    []string       `json:"fields"`
+// 文档注释：IPIP 在线查询层
+// 背景：与导入共用 internal/ipip.Reader（mmap 打开、支持 IPv6）；此处仅固定语言偏移并适配链式缓存的 Lookup 接口。
+type IPIPCache struct {
+	r   *ipip.Reader
+	off int
 }
 
-type IPIPTree struct {
-    nodeCount int
-    v4offset  int
-    meta      ipipMeta
-    data      []byte
+func NewIPIPCache(path string, language string) (*IPIPCache, error) {
+	r, err := ipip.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &IPIPCache{r: r, off: r.LanguageOffset(language)}, nil
 }
 
-func OpenIPIP(path string) (*IPIPTree, error) {
-    fi, err := os.Stat(path)
-    if err != nil {
-        return nil, err
-    }
-    size := int(fi.Size())
-    if size < 4 {
-        return nil, errors.New("bad ipdb size")
-    }
-    body, err := os.ReadFile(path)
-    if err != nil {
-        return nil, err
-    }
-    mlen := int(binary.BigEndian.Uint32(body[0:4]))
-    if size < 4+mlen {
-        return nil, errors.New("bad ipdb meta")
-    }
-    var m ipipMeta
-    if err := json.Unmarshal(body[4:4+mlen], &m); err != nil {
-        return nil, err
-    }
-    if len(m.Languages) == 0 || len(m.Fields) == 0 {
-        return nil, errors.New("bad ipdb meta fields")
-    }
-    if size != (4 + mlen + m.TotalSize) {
-        return nil, errors.New("bad ipdb total size")
-    }
-    r := &IPIPTree{nodeCount: m.NodeCount, meta: m, data: body[4+mlen:]}
-    if r.v4offset == 0 {
-        node := 0
-        for i := 0; i < 96 && node < r.nodeCount; i++ {
-            if i >= 80 {
-                node = r.readNode(node, 1)
-            } else {
-                node = r.readNode(node, 0)
-            }
-        }
-        r.v4offset = node
-    }
-    return r, nil
+func (c *IPIPCache) Lookup(ip string) (localdb.Location, bool) {
+	vals, ok := c.values(ip)
+	if !ok {
+		return localdb.Location{}, false
+	}
+	return c.r.Location(vals), true
 }
 
-func (r *IPIPTree) readNode(node, index int) int {
-    off := node*8 + index*4
-    if off+4 > len(r.data) {
-        return node
-    }
-    return int(binary.BigEndian.Uint32(r.data[off : off+4]))
+// 文档注释：查询全部字段
+// 返回：字段名 -> 值（付费版含 isp_domain、owner_domain、timezone 等）。
+func (c *IPIPCache) LookupFields(ip string) (map[string]string, bool) {
+	vals, ok := c.values(ip)
+	if !ok {
+		return nil, false
+	}
+	out := make(map[string]string, len(vals))
+	for i, f := range c.r.Fields() {
+		out[f] = vals[i]
+	}
+	return out, true
 }
 
-func (r *IPIPTree) resolve(node int) ([]byte, error) {
-    resolved := node - r.nodeCount + r.nodeCount*8
-    if resolved >= len(r.data) {
-        return nil, errors.New("resolve out of range")
-    }
-    size := int(binary.BigEndian.Uint16(r.data[resolved : resolved+2]))
-    if (resolved + 2 + size) > len(r.data) {
-        return nil, errors.New("resolve size")
-    }
-    return r.data[resolved+2 : resolved+2+size], nil
+func (c *IPIPCache) values(ip string) ([]string, bool) {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, false
+	}
+	raw, ok := c.r.FindRaw(a)
+	if !ok {
+		return nil, false
+	}
+	vals := c.r.Values(raw, c.off)
+	return vals, vals != nil
 }
 
-func langOffset(m ipipMeta, language string) int {
-    if off, ok := m.Languages[language]; ok {
-        return off
-    }
-    have := false
-    min := 0
-    for _, v := range m.Languages {
-        if !have || v < min {
-            min = v
-            have = true
-        }
-    }
-    return min
-}
+// Version：数据版本（IPDB 元信息 build）
+func (c *IPIPCache) Version() int64 { return c.r.Build() }
 
-type IPIPCache struct {
-    r   *IPIPTree
-    off int
-}
+// DataVersion：同 Version，字符串形式（供链式缓存报告来源版本）
+func (c *IPIPCache) DataVersion() string { return strconv.FormatInt(c.r.Build(), 10) }
 
-func NewIPIPCache(path string, language string) (*IPIPCache, error) {
-    r, err := OpenIPIP(path)
-    if err != nil {
-        return nil, err
-    }
-    return &IPIPCache{r: r, off: langOffset(r.meta, language)}, nil
-}
+// Close：解除映射；之后不得再查询（热重载在版本退役后调用）
+func (c *IPIPCache) Close() error { return c.r.Close() }
 
-func (c *IPIPCache) Lookup(ip string) (localdb.Location, bool) {
-    var zero localdb.Location
-    p := net.ParseIP(ip)
-    if p == nil || p.To4() == nil {
-        return zero, false
-    }
-    v := p.To4()
-    node := c.r.v4offset
-    for i := 0; i < 32; i++ {
-        b := (v[i/8] >> uint(7-(i%8))) & 1
-        node = c.r.readNode(node, int(b))
-        if node > c.r.nodeCount {
-            break
-        }
-    }
-    if node <= c.r.nodeCount {
-        return zero, false
-    }
-    raw, err := c.r.resolve(node)
-    if err != nil {
-        return zero, false
-    }
-    fields := string(raw)
-    parts := make([]string, 0, len(c.r.meta.Fields))
-    start := 0
-    for i := 0; i < len(fields); i++ {
-        if fields[i] == '\t' {
-            parts = append(parts, fields[start:i])
-            start = i + 1
-        }
-    }
-    parts = append(parts, fields[start:])
-    begin := c.off
-    end := c.off + len(c.r.meta.Fields)
-    if begin < 0 {
-        begin = 0
-    }
-    if end > len(parts) {
-        end = len(parts)
-    }
-    if begin >= end {
-        return zero, false
-    }
-    seg := parts[begin:end]
-    var l localdb.Location
-    for i, f := range c.r.meta.Fields {
-        if i >= len(seg) {
-            break
-        }
-        switch f {
-        case "country_name":
-            l.Country = seg[i]
-        case "region_name":
-            l.Region = seg[i]
-        case "province_name":
-            l.Province = seg[i]
-        case "city_name":
-            l.City = seg[i]
-        }
-    }
-    return l, true
-}
+// Reader：底层读取器（字段列表、语言、枚举）
+func (c *IPIPCache) Reader() *ipip.Reader { return c.r }
@@ -0,0 +1,119 @@
+// 包 locdict：文件库共用的地点字典编码（按值去重的地点 + 去重字符串池）
+package locdict
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"ip-api/internal/localdb"
+)
+
+// 文档注释：字典布局（大端序）
+// - 地点区：每个地点 5 个字符串偏移（country/region/province/city/isp，相对字符串区），EntrySize 字节；
+// - 字符串区：去重字符串，每个为 uint16 长度 + UTF-8 字节。
+// 约束：地点下标即写入顺序，由文件记录区引用。
+const EntrySize = 20
+
+// ErrCorrupt：字符串偏移越界
+var ErrCorrupt = errors.New("locdict: corrupt dictionary")
+
+// 文档注释：字典构建器
+// 背景：构建期地点按 Location 值去重，字符串再按内容去重；一次性产出地点区与字符串区。
+type Builder struct {
+	locIdx map[localdb.Location]uint32
+	locs   []localdb.Location
+	strIdx map[string]uint32
+	strs   bytes.Buffer
+}
+
+func NewBuilder() *Builder {
+	return &Builder{locIdx: map[localdb.Location]uint32{}, strIdx: map[string]uint32{}}
+}
+
+// Add：登记地点并返回其下标（相同地点返回同一下标）
+func (b *Builder) Add(l localdb.Location) uint32 {
+	if i, ok := b.locIdx[l]; ok {
+		return i
+	}
+	i := uint32(len(b.locs))
+	b.locIdx[l] = i
+	b.locs = append(b.locs, l)
+	return i
+}
+
+// Len：已登记的地点数
+func (b *Builder) Len() int { return len(b.locs) }
+
+// At：按下标取已登记的地点
+func (b *Builder) At(i uint32) localdb.Location { return b.locs[i] }
+
+// NOTE: 单个字段超过 65535 字节时截断（地名不会达到该长度）。
+func (b *Builder) str(s string) uint32 {
+	if i, ok := b.strIdx[s]; ok {
+		return i
+	}
+	if len(s) > 0xffff {
+		s = s[:0xffff]
+	}
+	off := uint32(b.strs.Len())
+	_ = binary.Write(&b.strs, binary.BigEndian, uint16(len(s)))
+	b.strs.WriteString(s)
+	b.strIdx[s] = off
+	return off
+}
+
+// 文档注释：产出地点区与字符串区
+// 约束：地点区长度为 Len()*EntrySize；两区须连续写入文件或分别记录偏移。
+func (b *Builder) Encode() (locs, strs []byte) {
+	out := make([]byte, 0, len(b.locs)*EntrySize)
+	var w [EntrySize]byte
+	for _, l := range b.locs {
+		for i, s := range []string{l.Country, l.Region, l.Province, l.City, l.ISP} {
+			binary.BigEndian.PutUint32(w[i*4:], b.str(s))
+		}
+		out = append(out, w[:]...)
+	}
+	return out, b.strs.Bytes()
+}
+
+// 文档注释：解码地点字典
+// 背景：打开文件时一次性物化为 Go 字符串，查询时仅按下标取值，不再分配，也不再引用映射区。
+// 参数：locs 为地点区（n*EntrySize 字节）；strs 为字符串区起点至文件尾（越界检查以其长度为准）。
+func Decode(locs, strs []byte, n int) ([]localdb.Location, error) {
+	if len(locs) < n*EntrySize {
+		return nil, ErrCorrupt
+	}
+	be := binary.BigEndian
+	// 同一字符串被多个地点引用时共享同一份内存
+	seen := map[uint32]string{}
+	read := func(off uint32) (string, error) {
+		if s, ok := seen[off]; ok {
+			return s, nil
+		}
+		p := uint64(off)
+		if p+2 > uint64(len(strs)) {
+			return "", ErrCorrupt
+		}
+		m := uint64(be.Uint16(strs[p:]))
+		if p+2+m > uint64(len(strs)) {
+			return "", ErrCorrupt
+		}
+		s := string(strs[p+2 : p+2+m])
+		seen[off] = s
+		return s, nil
+	}
+	out := make([]localdb.Location, n)
+	for i := range out {
+		base := i * EntrySize
+		var f [5]string
+		for j := range f {
+			s, err := read(be.Uint32(locs[base+j*4:]))
+			if err != nil {
+				return nil, err
+			}
+			f[j] = s
+		}
+		out[i] = localdb.Location{Country: f[0], Region: f[1], Province: f[2], City: f[3], ISP: f[4]}
+	}
+	return out, nil
+}
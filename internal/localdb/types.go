@@ -1,3 +1,28 @@
 package localdb
 
 type Location struct{ Country, Region, Province, City, ISP string }
+
+// Source：查询命中的来源层与该层数据版本（版本未知时为空）
+type Source struct {
+	Layer   string `json:"layer"`
+	Version string `json:"version,omitempty"`
+}
+
+// Versioned：可报告当前数据版本的缓存层（如构建时间、IPDB build）
+type Versioned interface{ DataVersion() string }
+
+// Resolver：返回命中来源的查询
+type Resolver interface {
+	Resolve(ip string) (Location, Source, bool)
+}
+
+// LayerInfo：缓存栈中一层的描述（管理接口展示）
+type LayerInfo struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Version string `json:"version,omitempty"`
+	Ready   bool   `json:"ready"`
+}
+
+// Stacker：可列出内部缓存层的组合缓存
+type Stacker interface{ Stack() []LayerInfo }
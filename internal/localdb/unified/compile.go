@@ -0,0 +1,128 @@
+package unified
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"ip-api/internal/logger"
+	"ip-api/internal/metrics"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Meta：编译元信息（写入文件尾部的 JSON）
+type Meta struct {
+	Version          uint64    `json:"version"`
+	BuiltAt          time.Time `json:"built_at"`
+	Host             string    `json:"host,omitempty"`
+	Intervals        int       `json:"intervals"`
+	Locations        int       `json:"locations"`
+	Ranges           int       `json:"ranges"`
+	Specials         int       `json:"specials"`
+	Exact            int       `json:"exact"`
+	Overrides        int       `json:"overrides"`
+	OverridesKV      int       `json:"overrides_kv"`
+	OverridesKVRange int       `json:"overrides_kv_range"`
+}
+
+// 保留的历史版本数（含当前），供回退排查
+const keepVersions = 3
+
+// 文档注释：由数据库编译统一库
+// 背景：数据库回退需依次查询 KV、覆盖、精确表与特例段，最多五次往返；编译期一次读出全部来源并决出优先级，
+// 线上查询只需对映射文件做一次二分。
+// 约束：
+// - 优先级与 store.LookupIP 一致：_ip_overrides_kv > _ip_overrides_kv_range（窄段优先）> _ip_overrides > _ip_exact > _ip_cidr_special（仅 active，窄段优先，同宽起点大者优先）> _ip_ipv4_ranges；
+// - KV（含同一区间的区间覆盖）只取未过期的行，多命名空间并存时按 namespace.Precedence 选取；过期行由清理任务删除后，下一次编译生效；
+// - 数据版本取编译时刻的毫秒时间戳，文件名为 unified-<版本>.db，写完 fsync 后原子更新 CURRENT 指针，仅保留最近 keepVersions 个版本。
+// 返回：本次编译的元信息。
+func Compile(ctx context.Context, db *sql.DB, dir string) (meta Meta, err error) {
+	logger.L().Info("unified_compile_begin", "dir", dir)
+	t0 := time.Now()
+	defer func() {
+		if err != nil {
+			metrics.UnifiedCompilesTotal.WithLabelValues("error").Inc()
+			return
+		}
+		metrics.UnifiedCompilesTotal.WithLabelValues("ok").Inc()
+		metrics.UnifiedCompileDurationMs.Observe(float64(time.Since(t0).Milliseconds()))
+	}()
+	meta = Meta{Version: uint64(t0.UnixMilli()), BuiltAt: t0.UTC()}
+	meta.Host, _ = os.Hostname()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return meta, err
+	}
+	v, err := collect(ctx, db, &meta)
+	if err != nil {
+		return meta, err
+	}
+	segs, dict := v.segs, v.dict
+	mb, _ := json.Marshal(meta)
+	name := fileName(meta.Version)
+	if err := writeFile(filepath.Join(dir, name), encode(segs, dict, mb, meta.Version, t0)); err != nil {
+		return meta, err
+	}
+	if err := writeFile(filepath.Join(dir, "CURRENT"), []byte(name+"\n")); err != nil {
+		return meta, err
+	}
+	prune(dir, name)
+	logger.L().Info("unified_compile_done", "version", meta.Version, "intervals", len(segs), "locations", meta.Locations, "ms", time.Since(t0).Milliseconds())
+	return meta, nil
+}
+
+func fileName(version uint64) string { return fmt.Sprintf("unified-%d.db", version) }
+
+// 文档注释：写临时文件、fsync 后原子改名
+func writeFile(path string, b []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// 文档注释：清理旧版本
+// 约束：按版本号降序保留 keepVersions 个，当前版本始终保留；已映射旧文件的读者不受删除影响（unix），Windows 上删除失败时忽略。
+func prune(dir, current string) {
+	vs := Versions(dir)
+	for i, v := range vs {
+		if name := fileName(v); i >= keepVersions && name != current {
+			_ = os.Remove(filepath.Join(dir, name))
+		}
+	}
+}
+
+// 文档注释：列出目录内的统一库版本
+// 返回：版本号降序。
+func Versions(dir string) []uint64 {
+	ents, _ := os.ReadDir(dir)
+	var vs []uint64
+	for _, e := range ents {
+		n := e.Name()
+		if !strings.HasPrefix(n, "unified-") || !strings.HasSuffix(n, ".db") {
+			continue
+		}
+		if v, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(n, "unified-"), ".db"), 10, 64); err == nil {
+			vs = append(vs, v)
+		}
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i] > vs[j] })
+	return vs
+}
@@ -0,0 +1,105 @@
+// 包 unified：编译期决出优先级的统一区间库（范围 + 特例段 + 精确表 + 覆盖）
+package unified
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"ip-api/internal/localdb"
+	"ip-api/internal/localdb/locdict"
+	"ip-api/internal/logger"
+	"ip-api/internal/mmap"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 文档注释：统一库只读实例
+// 背景：映射文件后对区间区二分查找，命中后按下标取内嵌地点，不访问数据库、不分配内存。
+// 约束：仅支持 IPv4；实例不可变，更新通过 Holder 原子切换。
+type DB struct {
+	m     *mmap.File
+	recs  []byte
+	count int
+	locs  []localdb.Location
+	meta  Meta
+}
+
+// 文档注释：打开统一库文件
+// 背景：校验头部与正文 CRC 后物化地点字典；格式不符或损坏返回错误，调用方保持旧实例。
+func Open(path string) (*DB, error) {
+	mf, err := mmap.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	data := mf.Bytes()
+	h, err := decodeHeader(data)
+	if err != nil {
+		mf.Close()
+		return nil, err
+	}
+	locs, err := locdict.Decode(data[h.locOff:], data[h.strOff:], int(h.locCount))
+	if err != nil {
+		mf.Close()
+		return nil, ErrCorrupt
+	}
+	d := &DB{m: mf, recs: data[h.recOff : uint64(h.recOff)+uint64(h.count)*intervalLen], count: int(h.count), locs: locs}
+	_ = json.Unmarshal(data[h.metaOff:h.metaOff+h.metaLen], &d.meta)
+	d.meta.Version = h.version
+	logger.L().Debug("unified_open", "path", path, "version", h.version, "intervals", d.count, "locations", len(locs))
+	return d, nil
+}
+
+// 文档注释：打开目录内 CURRENT 指向的版本
+func OpenCurrent(dir string) (*DB, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "CURRENT"))
+	if err != nil {
+		return nil, err
+	}
+	return Open(filepath.Join(dir, filepath.Base(strings.TrimSpace(string(b)))))
+}
+
+// 文档注释：区间查询
+// 约束：非 IPv4 或落在空隙中直接未命中；地点下标越界视为未命中（编译期保证不会发生）。
+func (d *DB) Lookup(ip string) (localdb.Location, bool) {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return localdb.Location{}, false
+	}
+	if a = a.Unmap(); !a.Is4() {
+		return localdb.Location{}, false
+	}
+	b := a.As4()
+	v := binary.BigEndian.Uint32(b[:])
+	be := binary.BigEndian
+	// 找最后一个 start<=v 的区间
+	lo, hi := 0, d.count
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if be.Uint32(d.recs[mid*intervalLen:]) <= v {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return localdb.Location{}, false
+	}
+	off := (lo - 1) * intervalLen
+	if be.Uint32(d.recs[off+4:]) < v {
+		return localdb.Location{}, false
+	}
+	lid := be.Uint32(d.recs[off+8:])
+	if int(lid) >= len(d.locs) {
+		return localdb.Location{}, false
+	}
+	return d.locs[lid], true
+}
+
+// Meta：编译元信息
+func (d *DB) Meta() Meta { return d.meta }
+
+// Len：区间条数
+func (d *DB) Len() int { return d.count }
+
+func (d *DB) Close() error { return d.m.Close() }
@@ -0,0 +1,135 @@
+package unified
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"ip-api/internal/localdb/locdict"
+	"time"
+)
+
+// 文档注释：UIDB v1 文件格式（大端序）
+// 背景：统一库把范围、特例段、精确表与覆盖合并为互不重叠的区间，优先级在编译期已决出，查询只需一次二分。
+// 布局：
+// - 头部 64 字节：magic "UIDB"｜格式版本=1｜区间数｜地点数｜区间区偏移｜地点区偏移｜字符串区偏移｜元信息偏移｜元信息长度｜构建时间(unix 秒, int64)｜正文 CRC32C｜数据版本(uint64)｜保留｜头部 CRC32C；
+// - 区间区：按起始升序、互不重叠的 (start uint32, end uint32, 地点下标 uint32)；
+// - 地点区与字符串区：locdict 编码；
+// - 元信息：JSON（来源条数与构建信息）。
+// 约束：正文 CRC 覆盖头部之后的全部字节，头部 CRC 覆盖前 60 字节；任一校验失败拒绝加载。
+const (
+	magic       = "UIDB"
+	fmtVersion  = 1
+	headerSize  = 64
+	intervalLen = 12
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt：文件校验失败或结构越界
+var ErrCorrupt = errors.New("unified: corrupt file")
+
+type header struct {
+	count    uint32
+	locCount uint32
+	recOff   uint32
+	locOff   uint32
+	strOff   uint32
+	metaOff  uint32
+	metaLen  uint32
+	builtAt  int64
+	bodyCRC  uint32
+	version  uint64
+}
+
+func (h header) encode() []byte {
+	b := make([]byte, headerSize)
+	copy(b[0:4], magic)
+	be := binary.BigEndian
+	be.PutUint32(b[4:], fmtVersion)
+	be.PutUint32(b[8:], h.count)
+	be.PutUint32(b[12:], h.locCount)
+	be.PutUint32(b[16:], h.recOff)
+	be.PutUint32(b[20:], h.locOff)
+	be.PutUint32(b[24:], h.strOff)
+	be.PutUint32(b[28:], h.metaOff)
+	be.PutUint32(b[32:], h.metaLen)
+	be.PutUint64(b[36:], uint64(h.builtAt))
+	be.PutUint32(b[44:], h.bodyCRC)
+	be.PutUint64(b[48:], h.version)
+	be.PutUint32(b[60:], crc32.Checksum(b[:60], castagnoli))
+	return b
+}
+
+// 文档注释：解析并校验头部与正文
+func decodeHeader(data []byte) (header, error) {
+	var h header
+	if len(data) < headerSize || string(data[:4]) != magic {
+		return h, ErrCorrupt
+	}
+	be := binary.BigEndian
+	if v := be.Uint32(data[4:]); v != fmtVersion {
+		return h, fmt.Errorf("unified: unsupported format %d (want %d)", v, fmtVersion)
+	}
+	if be.Uint32(data[60:]) != crc32.Checksum(data[:60], castagnoli) {
+		return h, ErrCorrupt
+	}
+	h = header{
+		count:    be.Uint32(data[8:]),
+		locCount: be.Uint32(data[12:]),
+		recOff:   be.Uint32(data[16:]),
+		locOff:   be.Uint32(data[20:]),
+		strOff:   be.Uint32(data[24:]),
+		metaOff:  be.Uint32(data[28:]),
+		metaLen:  be.Uint32(data[32:]),
+		builtAt:  int64(be.Uint64(data[36:])),
+		bodyCRC:  be.Uint32(data[44:]),
+		version:  be.Uint64(data[48:]),
+	}
+	n := uint64(len(data))
+	if uint64(h.recOff)+uint64(h.count)*intervalLen > n ||
+		uint64(h.locOff)+uint64(h.locCount)*locdict.EntrySize > n ||
+		uint64(h.strOff) > n || uint64(h.metaOff)+uint64(h.metaLen) > n {
+		return h, ErrCorrupt
+	}
+	if crc32.Checksum(data[headerSize:], castagnoli) != h.bodyCRC {
+		return h, ErrCorrupt
+	}
+	return h, nil
+}
+
+// 文档注释：产出完整文件内容
+// 参数：segs 为已排序、互不重叠的区间；dict 为区间引用的地点字典；meta 为 JSON 元信息。
+func encode(segs []seg, dict *locdict.Builder, meta []byte, version uint64, builtAt time.Time) []byte {
+	var body bytes.Buffer
+	body.Grow(len(segs) * intervalLen)
+	be := binary.BigEndian
+	var w [intervalLen]byte
+	for _, s := range segs {
+		be.PutUint32(w[0:], s.start)
+		be.PutUint32(w[4:], s.end)
+		be.PutUint32(w[8:], s.loc)
+		body.Write(w[:])
+	}
+	locs, strs := dict.Encode()
+	locOff := headerSize + body.Len()
+	body.Write(locs)
+	strOff := headerSize + body.Len()
+	body.Write(strs)
+	metaOff := headerSize + body.Len()
+	body.Write(meta)
+	h := header{
+		count:    uint32(len(segs)),
+		locCount: uint32(dict.Len()),
+		recOff:   headerSize,
+		locOff:   uint32(locOff),
+		strOff:   uint32(strOff),
+		metaOff:  uint32(metaOff),
+		metaLen:  uint32(len(meta)),
+		builtAt:  builtAt.Unix(),
+		bodyCRC:  crc32.Checksum(body.Bytes(), castagnoli),
+		version:  version,
+	}
+	return append(h.encode(), body.Bytes()...)
+}
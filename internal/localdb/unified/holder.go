@@ -0,0 +1,161 @@
+package unified
+
+import (
+	"context"
+	"database/sql"
+	"ip-api/internal/localdb"
+	"ip-api/internal/logger"
+	"ip-api/internal/metrics"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 旧实例在切换后延迟释放映射，等待进行中的查询结束（单次查询为微秒级）
+const closeGrace = 30 * time.Second
+
+// 触发重编译的合并窗口：连续的变更通知只引起一次编译
+const triggerDebounce = 5 * time.Second
+
+// 文档注释：统一库持有者（原子切换）
+// 背景：查询路径无锁读取当前实例；切换后旧实例延迟关闭，查询返回的地点不引用映射区，关闭后仍可安全使用。
+// 约束：编译与加载经 mu 串行，手动重载与定时重编译不会并发编译。
+type Holder struct {
+	p  atomic.Pointer[DB]
+	mu sync.Mutex
+
+	once sync.Once
+	trig chan struct{}
+
+	hmu    sync.Mutex
+	onSwap []func(builtAt time.Time)
+}
+
+// 文档注释：切换到新实例
+// 参数：d 为 nil 时忽略。
+func (h *Holder) Swap(d *DB) {
+	if d == nil {
+		return
+	}
+	old := h.p.Swap(d)
+	metrics.UnifiedVersion.Set(float64(d.meta.Version))
+	metrics.UnifiedIntervals.Set(float64(d.count))
+	if old != nil && old != d {
+		time.AfterFunc(closeGrace, func() { _ = old.Close() })
+	}
+	logger.L().Info("unified_swap", "version", d.meta.Version, "intervals", d.count)
+	h.hmu.Lock()
+	fns := h.onSwap
+	h.hmu.Unlock()
+	for _, fn := range fns {
+		fn(d.meta.BuiltAt)
+	}
+}
+
+// OnSwap：登记切换回调，参数为新实例的编译开始时刻（编译时读取的数据不早于该时刻）
+func (h *Holder) OnSwap(fn func(builtAt time.Time)) {
+	h.hmu.Lock()
+	h.onSwap = append(h.onSwap, fn)
+	h.hmu.Unlock()
+}
+
+// 文档注释：请求一次后台重编译（非阻塞，多次请求合并）
+// 背景：KV 覆盖删除后统一库仍含旧值，等不到定时重编译；未调用 Start 时无效。
+func (h *Holder) Trigger() {
+	select {
+	case h.trigger() <- struct{}{}:
+	default:
+	}
+}
+
+func (h *Holder) trigger() chan struct{} {
+	h.once.Do(func() { h.trig = make(chan struct{}, 1) })
+	return h.trig
+}
+
+// DB：当前实例（未加载时为 nil）
+func (h *Holder) DB() *DB { return h.p.Load() }
+
+// Ready：是否已加载
+func (h *Holder) Ready() bool { return h.p.Load() != nil }
+
+// DataVersion：当前实例的数据版本（编译时刻毫秒时间戳），未加载时为空
+func (h *Holder) DataVersion() string {
+	if d := h.p.Load(); d != nil {
+		return strconv.FormatUint(d.meta.Version, 10)
+	}
+	return ""
+}
+
+func (h *Holder) Lookup(ip string) (localdb.Location, bool) {
+	if d := h.p.Load(); d != nil {
+		return d.Lookup(ip)
+	}
+	return localdb.Location{}, false
+}
+
+// 文档注释：重新加载（可选先编译）
+// 背景：compile 为 false 时仅加载 CURRENT 指向的版本（由 cmd/unified-compile 等外部编译产出）。
+// 约束：编译或打开失败时保持当前实例不变。
+func (h *Holder) Reload(ctx context.Context, db *sql.DB, dir string, compile bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if compile {
+		if _, err := Compile(ctx, db, dir); err != nil {
+			return err
+		}
+	}
+	d, err := OpenCurrent(dir)
+	if err != nil {
+		return err
+	}
+	if cur := h.p.Load(); cur != nil && !compile && cur.meta.Version == d.meta.Version {
+		d.Close()
+		return nil
+	}
+	h.Swap(d)
+	return nil
+}
+
+// 文档注释：启动加载与定时重编译
+// 背景：优先加载已有版本以缩短启动时间，无可用文件时先编译；interval 大于 0 时按周期重编译，纳入其他进程写入的范围与特例段；
+// Trigger 请求在合并窗口后重编译。
+func (h *Holder) Start(ctx context.Context, db *sql.DB, dir string, interval time.Duration) {
+	trig := h.trigger()
+	go func() {
+		if err := h.Reload(ctx, db, dir, false); err != nil {
+			logger.L().Info("unified_load_miss", "dir", dir, "err", err)
+			if err := h.Reload(ctx, db, dir, true); err != nil {
+				logger.L().Error("unified_compile_error", "err", err)
+			}
+		}
+		var tick <-chan time.Time
+		if interval > 0 {
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			tick = t.C
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-trig:
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(triggerDebounce):
+				}
+				// 合并窗口内到达的请求由本次编译一并纳入
+				select {
+				case <-trig:
+				default:
+				}
+			case <-tick:
+			}
+			if err := h.Reload(ctx, db, dir, true); err != nil {
+				logger.L().Error("unified_compile_error", "err", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,133 @@
+package unified
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// 来源层级：数值越大优先级越高，与数据库回退的查询顺序一致
+const (
+	tierRange uint8 = iota
+	tierSpecial
+	tierExact
+	tierOverride
+	tierKVRange
+	tierKV
+)
+
+// span：编译输入区间（闭区间）；同层内 key 越大越优先
+type span struct {
+	start, end uint32
+	key        uint64
+	loc        uint32
+	tier       uint8
+}
+
+// seg：编译输出区间（闭区间，互不重叠）
+type seg struct {
+	start, end uint32
+	loc        uint32
+}
+
+// specialKey：特例段与区间 KV 覆盖的同层优先级（窄段优先，同宽起点大者优先）
+func specialKey(start, end uint32) uint64 {
+	return uint64(0xffffffff-(end-start))<<32 | uint64(start)
+}
+
+func (a *span) above(b *span) bool {
+	if a.tier != b.tier {
+		return a.tier > b.tier
+	}
+	return a.key > b.key
+}
+
+// spanHeap：按优先级的大顶堆（存下标，避免复制区间）
+type spanHeap struct {
+	spans []span
+	idx   []int32
+}
+
+func (h *spanHeap) Len() int           { return len(h.idx) }
+func (h *spanHeap) Less(i, j int) bool { return h.spans[h.idx[i]].above(&h.spans[h.idx[j]]) }
+func (h *spanHeap) Swap(i, j int)      { h.idx[i], h.idx[j] = h.idx[j], h.idx[i] }
+func (h *spanHeap) Push(x any)         { h.idx = append(h.idx, x.(int32)) }
+func (h *spanHeap) Pop() any {
+	n := len(h.idx) - 1
+	x := h.idx[n]
+	h.idx = h.idx[:n]
+	return x
+}
+
+// 文档注释：决出重叠区间的归属
+// 背景：扫描线——在每个区间端点处把新开始的区间入堆、把已结束的堆顶出堆，堆顶即该段的最高优先级来源；
+// 结束的非堆顶区间延迟到成为堆顶时再移除，整体 O(n log n)。
+// 返回：按起始升序、互不重叠的区间；相邻且地点相同的段合并，无来源覆盖的空隙不输出。
+func resolve(spans []span) []seg {
+	if len(spans) == 0 {
+		return nil
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	bounds := make([]uint64, 0, 2*len(spans))
+	for _, s := range spans {
+		bounds = append(bounds, uint64(s.start), uint64(s.end)+1)
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	k := 0
+	for _, b := range bounds {
+		if k == 0 || bounds[k-1] != b {
+			bounds[k] = b
+			k++
+		}
+	}
+	bounds = bounds[:k]
+	h := &spanHeap{spans: spans}
+	var out []seg
+	j := 0
+	for i := 0; i+1 < len(bounds); i++ {
+		b := bounds[i]
+		for j < len(spans) && uint64(spans[j].start) <= b {
+			heap.Push(h, int32(j))
+			j++
+		}
+		for h.Len() > 0 && uint64(spans[h.idx[0]].end) < b {
+			heap.Pop(h)
+		}
+		if h.Len() == 0 {
+			continue
+		}
+		loc := spans[h.idx[0]].loc
+		end := uint32(bounds[i+1] - 1)
+		if n := len(out); n > 0 && out[n-1].loc == loc && uint64(out[n-1].end)+1 == b {
+			out[n-1].end = end
+			continue
+		}
+		out = append(out, seg{start: uint32(b), end: end, loc: loc})
+	}
+	return out
+}
+
+// Interval：特例段区间（闭区间），Loc 为调用方自定的地点编号
+type Interval struct {
+	Start, End uint32
+	Loc        uint32
+}
+
+// 文档注释：嵌套特例段展开为最具体归属
+// 背景：与编译期特例层同一规则（窄段优先、同宽起点大者优先，即数据库回退的 ORDER BY (end-start) ASC, start DESC），
+// 供不启用统一库时的进程内特例段索引复用。
+// 返回：按起始升序、互不重叠的区间；相邻且地点相同的段合并。
+func MostSpecific(in []Interval) []Interval {
+	spans := make([]span, 0, len(in))
+	for _, iv := range in {
+		if iv.End < iv.Start {
+			continue
+		}
+		spans = append(spans, span{start: iv.Start, end: iv.End, loc: iv.Loc, tier: tierSpecial, key: specialKey(iv.Start, iv.End)})
+	}
+	segs := resolve(spans)
+	out := make([]Interval, len(segs))
+	for i, s := range segs {
+		out[i] = Interval{Start: s.start, End: s.end, Loc: s.loc}
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package unified
+
+import (
+	"context"
+	"database/sql"
+	"ip-api/internal/localdb"
+	"ip-api/internal/localdb/locdict"
+	"ip-api/internal/namespace"
+	"os"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// 文档注释：决出优先级后的有效区间视图
+// 背景：统一库编译与离线导出共用同一份视图，导出文件与 /api/ip 数据库回退给出相同答案。
+// 约束：仅含数据库来源（IPv4）；IPIP/IP2Region 等未导入数据库的文件层不在视图内。
+type View struct {
+	Meta Meta
+	segs []seg
+	dict *locdict.Builder
+}
+
+// 文档注释：由数据库收集有效区间视图
+// 背景：与 Compile 相同的来源与优先级，不写文件；Meta.Version 取收集时刻的毫秒时间戳。
+func Collect(ctx context.Context, db *sql.DB) (*View, error) {
+	t0 := time.Now()
+	meta := Meta{Version: uint64(t0.UnixMilli()), BuiltAt: t0.UTC()}
+	meta.Host, _ = os.Hostname()
+	return collect(ctx, db, &meta)
+}
+
+func collect(ctx context.Context, db *sql.DB, meta *Meta) (*View, error) {
+	dict := locdict.NewBuilder()
+	var spans []span
+	sources := []struct {
+		tier  uint8
+		n     *int
+		query string
+	}{
+		{tierRange, &meta.Ranges, `SELECT r.start_int, r.end_int, l.country, l.region, l.province, l.city, l.isp
+            FROM _ip_ipv4_ranges r JOIN _ip_locations l ON l.id = r.location_id`},
+		{tierSpecial, &meta.Specials, `SELECT s.start_int, s.end_int, l.country, l.region, l.province, l.city, l.isp
+            FROM _ip_cidr_special s JOIN _ip_locations l ON l.id = s.location_id WHERE s.active = TRUE`},
+		{tierExact, &meta.Exact, `SELECT e.ip_int, e.ip_int, l.country, l.region, l.province, l.city, l.isp
+            FROM _ip_exact e JOIN _ip_locations l ON l.id = e.location_id`},
+		{tierOverride, &meta.Overrides, `SELECT o.ip_int, o.ip_int, l.country, l.region, l.province, l.city, l.isp
+            FROM _ip_overrides o JOIN _ip_locations l ON l.id = o.location_id`},
+		{tierKVRange, &meta.OverridesKVRange, `SELECT DISTINCT ON (start_int, end_int) start_int, end_int, country, region, province, city, isp
+            FROM _ip_overrides_kv_range WHERE ` + namespace.Active + `
+            ORDER BY start_int, end_int, ` + namespace.OrderBy("$1")},
+		{tierKV, &meta.OverridesKV, `SELECT DISTINCT ON (ip_int) ip_int, ip_int, country, region, province, city, isp
+            FROM _ip_overrides_kv WHERE ` + namespace.Active + `
+            ORDER BY ip_int, ` + namespace.OrderBy("$1")},
+	}
+	for _, src := range sources {
+		var args []any
+		if src.tier >= tierKVRange {
+			args = append(args, pq.Array(namespace.Precedence()))
+		}
+		rows, err := db.QueryContext(ctx, src.query, args...)
+		if err != nil {
+			return nil, err
+		}
+		before := len(spans)
+		spans, err = scanSpans(rows, src.tier, dict, spans)
+		if err != nil {
+			return nil, err
+		}
+		*src.n = len(spans) - before
+	}
+	segs := resolve(spans)
+	meta.Intervals = len(segs)
+	meta.Locations = dict.Len()
+	return &View{Meta: *meta, segs: segs, dict: dict}, nil
+}
+
+// 文档注释：读取 (start, end, 地点字段) 结果集为编译区间
+// 背景：特例段与区间 KV 覆盖同层按“窄段优先、同宽起点大者优先”编码 key；其余来源按读取顺序，后读入者优先。
+func scanSpans(rows *sql.Rows, tier uint8, dict *locdict.Builder, spans []span) ([]span, error) {
+	defer rows.Close()
+	seq := uint64(0)
+	for rows.Next() {
+		var s, e int64
+		var l localdb.Location
+		if err := rows.Scan(&s, &e, &l.Country, &l.Region, &l.Province, &l.City, &l.ISP); err != nil {
+			return spans, err
+		}
+		if s < 0 || e < s || e > 0xffffffff {
+			continue
+		}
+		sp := span{start: uint32(s), end: uint32(e), loc: dict.Add(l), tier: tier, key: seq}
+		if tier == tierSpecial || tier == tierKVRange {
+			sp.key = specialKey(sp.start, sp.end)
+		}
+		spans = append(spans, sp)
+		seq++
+	}
+	return spans, rows.Err()
+}
+
+// Len：区间条数
+func (v *View) Len() int { return len(v.segs) }
+
+// 文档注释：按起始升序遍历区间（闭区间，互不重叠，可能存在空隙）
+// 返回：fn 返回的第一个错误。
+func (v *View) Each(fn func(start, end uint32, loc localdb.Location) error) error {
+	for _, s := range v.segs {
+		if err := fn(s.start, s.end, v.dict.At(s.loc)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -41,6 +41,14 @@ var (
 		Name: "ipapi_amap_fail_total",
 		Help: "Total amap REST failures",
 	})
+	AMapRetriesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ipapi_amap_retries_total",
+		Help: "Total amap REST retries",
+	})
+	AMapErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_amap_errors_total",
+		Help: "AMap errors by class (retryable/fatal/quota/transport) and infocode",
+	}, []string{"class", "infocode"})
 	AMapDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name:    "ipapi_amap_duration_ms",
 		Help:    "AMap REST call duration in milliseconds",
@@ -72,6 +80,133 @@ var (
 		Help:    "Plugin weighted score distribution",
 		Buckets: []float64{10, 20, 40, 60, 80, 90, 100},
 	}, []string{"plugin"})
+	PluginCacheHitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_plugin_cache_hits_total",
+		Help: "Plugin result cache hits by kind (positive/negative)",
+	}, []string{"plugin", "kind"})
+	PluginCacheMissesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_plugin_cache_misses_total",
+		Help: "Plugin result cache misses",
+	}, []string{"plugin"})
+	PluginShadowCompareTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_plugin_shadow_compare_total",
+		Help: "Shadow plugin field comparison against served answer by outcome (agree/disagree/shadow_missing/served_missing)",
+	}, []string{"plugin", "field", "outcome"})
+	PluginShadowDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_plugin_shadow_dropped_total",
+		Help: "Shadow evaluations dropped because the in-flight limit was reached",
+	}, []string{"plugin"})
+	CDNGeoHeadersTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_cdn_geo_headers_total",
+		Help: "Requests carrying CDN geo headers by provider and trust result",
+	}, []string{"provider", "result"})
+	WritebackDecisionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_writeback_decisions_total",
+		Help: "Fusion write-back decisions by assoc key and outcome",
+	}, []string{"assoc", "outcome"})
+	PluginCachePurgedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_plugin_cache_purged_total",
+		Help: "Plugin result cache entries purged by admin",
+	}, []string{"plugin"})
+	ExactRebuildsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_exact_rebuilds_total",
+		Help: "ExactDB rebuilds by result (ok/error/unchanged)",
+	}, []string{"result"})
+	ExactRebuildDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ipapi_exact_rebuild_duration_ms",
+		Help:    "ExactDB rebuild duration in milliseconds",
+		Buckets: []float64{50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000},
+	})
+	ExactOverlaySize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ipapi_exact_overlay_size",
+		Help: "Entries in the in-memory ExactDB overlay awaiting compaction",
+	})
+	UnifiedCompilesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_unified_compiles_total",
+		Help: "Unified lookup database compilations by result (ok/error)",
+	}, []string{"result"})
+	UnifiedCompileDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ipapi_unified_compile_duration_ms",
+		Help:    "Unified lookup database compile duration in milliseconds",
+		Buckets: []float64{500, 1000, 2500, 5000, 10000, 30000, 60000, 120000},
+	})
+	UnifiedVersion = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ipapi_unified_version",
+		Help: "Data version of the loaded unified lookup database",
+	})
+	UnifiedIntervals = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ipapi_unified_intervals",
+		Help: "Intervals in the loaded unified lookup database",
+	})
+	FileCacheBucketLoadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_filecache_bucket_loads_total",
+		Help: "Range file cache bucket loads by result (ok/missing/error)",
+	}, []string{"result"})
+	FileCacheEvictionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ipapi_filecache_evictions_total",
+		Help: "Range file cache buckets evicted by the LRU",
+	})
+	FileCacheBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ipapi_filecache_bytes",
+		Help: "Bytes held by resident range file cache buckets",
+	})
+	LocalLayerLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_localdb_layer_lookups_total",
+		Help: "Local cache chain lookups by layer and result (hit/miss)",
+	}, []string{"layer", "result"})
+	LocalLayerDurationUs = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ipapi_localdb_layer_duration_us",
+		Help:    "Local cache chain per-layer lookup duration in microseconds",
+		Buckets: []float64{1, 2, 5, 10, 25, 50, 100, 250, 1000, 5000},
+	}, []string{"layer"})
+	HotCacheLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_hotcache_lookups_total",
+		Help: "In-process /ip result cache lookups by result (hit/miss)",
+	}, []string{"result"})
+	HotCacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ipapi_hotcache_entries",
+		Help: "In-process /ip result cache entries",
+	})
+	HotCacheEvictionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ipapi_hotcache_evictions_total",
+		Help: "In-process /ip result cache LRU evictions",
+	})
+	HotCacheFlightsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_hotcache_flights_total",
+		Help: "Singleflight /ip resolutions by role (leader executed, shared waited)",
+	}, []string{"role"})
+	HotCacheInvalidationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_hotcache_invalidations_total",
+		Help: "In-process /ip result cache invalidations by scope (ip/range/all)",
+	}, []string{"scope"})
+	ReloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_reload_total",
+		Help: "Data file hot reload attempts by target and result (ok/error/rejected/rollback)",
+	}, []string{"target", "result"})
+	ReloadLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ipapi_reload_last_success_timestamp",
+		Help: "Unix time of the last applied data file version by target",
+	}, []string{"target"})
+	SpecialIndexIntervals = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ipapi_special_index_intervals",
+		Help: "Disjoint intervals in the in-memory CIDR special index",
+	})
+	SpecialIndexRebuildsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_special_index_rebuilds_total",
+		Help: "CIDR special index rebuilds by result (ok/error)",
+	}, []string{"result"})
+	SpecialIndexLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_special_index_lookups_total",
+		Help: "CIDR special index lookups by result (hit/miss)",
+	}, []string{"result"})
+	KVExpiredTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_kv_expired_total",
+		Help: "Expired KV overrides removed by the cleanup job by kind (ip/range)",
+	}, []string{"kind"})
+	ASNLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipapi_asn_lookups_total",
+		Help: "ASN prefix table lookups by result",
+	}, []string{"result"})
 
 	// 反地理指标
 	ReverseGeoRequestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
@@ -109,10 +244,43 @@ func init() {
 	prometheus.MustRegister(PluginDurationMs)
 	prometheus.MustRegister(PluginHeartbeatTotal)
 	prometheus.MustRegister(PluginScore)
+	prometheus.MustRegister(PluginCacheHitsTotal)
+	prometheus.MustRegister(PluginCacheMissesTotal)
+	prometheus.MustRegister(PluginCachePurgedTotal)
+	prometheus.MustRegister(PluginShadowCompareTotal)
+	prometheus.MustRegister(PluginShadowDroppedTotal)
+	prometheus.MustRegister(WritebackDecisionsTotal)
+	prometheus.MustRegister(CDNGeoHeadersTotal)
+	prometheus.MustRegister(AMapRetriesTotal)
+	prometheus.MustRegister(AMapErrorsTotal)
 	prometheus.MustRegister(ReverseGeoRequestsTotal)
 	prometheus.MustRegister(ReverseGeoDurationMs)
 	prometheus.MustRegister(ReverseGeoPipHitsTotal)
 	prometheus.MustRegister(ReverseGeoNearestFallbackTotal)
+	prometheus.MustRegister(ASNLookupsTotal)
+	prometheus.MustRegister(ExactRebuildsTotal)
+	prometheus.MustRegister(ExactRebuildDurationMs)
+	prometheus.MustRegister(ExactOverlaySize)
+	prometheus.MustRegister(UnifiedCompilesTotal)
+	prometheus.MustRegister(UnifiedCompileDurationMs)
+	prometheus.MustRegister(UnifiedVersion)
+	prometheus.MustRegister(UnifiedIntervals)
+	prometheus.MustRegister(FileCacheBucketLoadsTotal)
+	prometheus.MustRegister(FileCacheEvictionsTotal)
+	prometheus.MustRegister(FileCacheBytes)
+	prometheus.MustRegister(LocalLayerLookupsTotal)
+	prometheus.MustRegister(LocalLayerDurationUs)
+	prometheus.MustRegister(HotCacheLookupsTotal)
+	prometheus.MustRegister(HotCacheEntries)
+	prometheus.MustRegister(HotCacheEvictionsTotal)
+	prometheus.MustRegister(HotCacheFlightsTotal)
+	prometheus.MustRegister(HotCacheInvalidationsTotal)
+	prometheus.MustRegister(ReloadsTotal)
+	prometheus.MustRegister(ReloadLastSuccess)
+	prometheus.MustRegister(SpecialIndexIntervals)
+	prometheus.MustRegister(SpecialIndexRebuildsTotal)
+	prometheus.MustRegister(SpecialIndexLookupsTotal)
+	prometheus.MustRegister(KVExpiredTotal)
 }
 
 // 文档注释：返回 Prometheus 指标监听器
@@ -0,0 +1,257 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"ip-api/internal/logger"
+	"ip-api/internal/metrics"
+	"ip-api/internal/plugins"
+)
+
+// 文档注释：CDN 地理头映射
+// 背景：不同 CDN 回源时注入的地理头名称各异，统一以“字段 → 头名”映射描述；每个映射对应一个同名 CDN 地理插件。
+// 约束：每个字段可配置多个候选头（逗号分隔），按顺序取首个非空值；未配置的字段保持为空。
+type GeoHeaderMap struct {
+	Provider      string `json:"provider"`
+	Country       string `json:"country"`
+	CountryAlpha2 string `json:"country_alpha2"`
+	CountryAlpha3 string `json:"country_alpha3"`
+	Region        string `json:"region"`
+	RegionCode    string `json:"region_code"`
+	City          string `json:"city"`
+	Latitude      string `json:"latitude"`
+	Longitude     string `json:"longitude"`
+	ASN           string `json:"asn"`
+	ISP           string `json:"isp"`
+	ClientIP      string `json:"client_ip"`
+}
+
+// 文档注释：内置 CDN 地理头预设
+// 背景：EdgeOne 为控制台自定义头；Cloudflare 需开启“Add visitor location headers”托管转换；CloudFront 需在源请求策略中加入 CloudFront-Viewer-* 头；
+// Fastly 无默认地理头，需在 VCL 中按下列头名设置 client.geo.* 与 client.as.number。
+var geoPresets = map[string]GeoHeaderMap{
+	"edgeone": {
+		Country: "X-EO-Geo-Country", CountryAlpha2: "X-EO-Geo-CountryCodeAlpha2", CountryAlpha3: "X-EO-Geo-CountryCodeAlpha3",
+		Region: "X-EO-Geo-Region", RegionCode: "X-EO-Geo-RegionCode", City: "X-EO-Geo-City",
+		Latitude: "X-EO-Geo-Latitude", Longitude: "X-EO-Geo-Longitude", ASN: "X-EO-Geo-ASN",
+		// 优先新版头部 X-EO-ISP，兼容旧名 X-EO-Geo-CISP
+		ISP: "X-EO-ISP,X-EO-Geo-CISP", ClientIP: "X-EO-Client-IP",
+	},
+	"cloudflare": {
+		CountryAlpha2: "CF-IPCountry", Region: "cf-region", RegionCode: "cf-region-code", City: "cf-ipcity",
+		Latitude: "cf-iplatitude", Longitude: "cf-iplongitude", ClientIP: "CF-Connecting-IP",
+	},
+	"cloudfront": {
+		Country: "CloudFront-Viewer-Country-Name", CountryAlpha2: "CloudFront-Viewer-Country",
+		Region: "CloudFront-Viewer-Country-Region-Name", RegionCode: "CloudFront-Viewer-Country-Region", City: "CloudFront-Viewer-City",
+		Latitude: "CloudFront-Viewer-Latitude", Longitude: "CloudFront-Viewer-Longitude", ASN: "CloudFront-Viewer-ASN",
+		ClientIP: "CloudFront-Viewer-Address",
+	},
+	"fastly": {
+		Country: "Fastly-Geo-Country-Name", CountryAlpha2: "Fastly-Geo-Country-Code", CountryAlpha3: "Fastly-Geo-Country-Code3",
+		RegionCode: "Fastly-Geo-Region", City: "Fastly-Geo-City",
+		Latitude: "Fastly-Geo-Latitude", Longitude: "Fastly-Geo-Longitude", ASN: "Fastly-Geo-ASN", ClientIP: "Fastly-Client-IP",
+	},
+}
+
+// 文档注释：CDN 地理头解析器
+// 背景：地理头由客户端同样可以伪造，只有确认请求来自 CDN 回源时才采信；未通过校验的请求不注入地理信息，融合照常进行。
+// 约束：可信判定满足任一即可——
+// - ORIGIN_DEFENSE_ENABLE=true（源站防御已按回源网段放行）；
+// - 对端地址位于 CDN_GEO_TRUSTED_CIDRS；
+// - 请求头 CDN_GEO_SECRET_HEADER（默认 X-CDN-Geo-Secret）等于 CDN_GEO_SHARED_SECRET（在 CDN 回源规则中注入）；
+// - CDN_GEO_TRUST_ALL=true（仅用于本地调试）。
+// 兼容：以上均未配置时，edgeone 映射沿用旧版行为无条件采信（启动时告警），其余映射一律丢弃；配置任一可信来源后 edgeone 同样需要通过校验。
+// 多个映射按 CDN_GEO_PRESETS 顺序尝试，取首个解析出任一地理字段的映射。
+type GeoExtractor struct {
+	maps          []GeoHeaderMap
+	cidrs         []*net.IPNet
+	secretHeader  string
+	secret        string
+	originDefense bool
+	trustAll      bool
+}
+
+// 文档注释：按环境变量构造解析器
+// 约束：CDN_GEO_PRESETS 为逗号分隔的预设名（默认 edgeone）；CDN_GEO_HEADER_MAP 为 JSON 数组形式的自定义映射（字段同 GeoHeaderMap，provider 必填），同名时覆盖预设。
+func NewGeoExtractorFromEnv() *GeoExtractor {
+	x := &GeoExtractor{
+		secretHeader:  os.Getenv("CDN_GEO_SECRET_HEADER"),
+		secret:        os.Getenv("CDN_GEO_SHARED_SECRET"),
+		originDefense: os.Getenv("ORIGIN_DEFENSE_ENABLE") == "true",
+		trustAll:      os.Getenv("CDN_GEO_TRUST_ALL") == "true",
+	}
+	if x.secretHeader == "" {
+		x.secretHeader = "X-CDN-Geo-Secret"
+	}
+	presets := os.Getenv("CDN_GEO_PRESETS")
+	if presets == "" {
+		presets = "edgeone"
+	}
+	var custom []GeoHeaderMap
+	if s := os.Getenv("CDN_GEO_HEADER_MAP"); s != "" {
+		if err := json.Unmarshal([]byte(s), &custom); err != nil {
+			logger.L().Error("cdn_geo_header_map_invalid", "err", err)
+		}
+	}
+	byName := map[string]GeoHeaderMap{}
+	for _, m := range custom {
+		if m.Provider = strings.ToLower(strings.TrimSpace(m.Provider)); m.Provider != "" {
+			byName[m.Provider] = m
+		}
+	}
+	seen := map[string]bool{}
+	for _, name := range strings.Split(presets, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		m, ok := byName[name]
+		if !ok {
+			m, ok = geoPresets[name]
+			m.Provider = name
+		}
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		x.maps = append(x.maps, m)
+	}
+	// 自定义映射即使未列入预设也启用，排在预设之后
+	for _, m := range custom {
+		if m.Provider != "" && !seen[m.Provider] {
+			seen[m.Provider] = true
+			x.maps = append(x.maps, m)
+		}
+	}
+	for _, s := range strings.Split(os.Getenv("CDN_GEO_TRUSTED_CIDRS"), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(s); err == nil {
+			x.cidrs = append(x.cidrs, n)
+		} else if ip := net.ParseIP(s); ip != nil {
+			x.cidrs = append(x.cidrs, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		}
+	}
+	return x
+}
+
+// 文档注释：已启用的映射名（用于注册同名插件）
+func (x *GeoExtractor) Providers() []string {
+	out := make([]string, 0, len(x.maps))
+	for _, m := range x.maps {
+		out = append(out, m.Provider)
+	}
+	return out
+}
+
+// 文档注释：是否配置了任一可信来源校验
+func (x *GeoExtractor) hasTrustSource() bool {
+	return x.originDefense || x.trustAll || len(x.cidrs) > 0 || x.secret != ""
+}
+
+// 文档注释：解析请求中的 CDN 地理头
+// 返回：可信且至少解析出一个地理字段时 ok 为 true；请求携带地理头但未通过可信校验时计数并丢弃。
+func (x *GeoExtractor) Extract(r *http.Request) (plugins.GeoInfo, bool) {
+	for _, m := range x.maps {
+		g, ok := parseGeoHeaders(r.Header, m)
+		if !ok {
+			continue
+		}
+		if !x.trusted(r) && !(m.Provider == "edgeone" && !x.hasTrustSource()) {
+			metrics.CDNGeoHeadersTotal.WithLabelValues(m.Provider, "untrusted").Inc()
+			logger.L().Debug("cdn_geo_untrusted", "provider", m.Provider, "remote", r.RemoteAddr)
+			return plugins.GeoInfo{}, false
+		}
+		metrics.CDNGeoHeadersTotal.WithLabelValues(m.Provider, "trusted").Inc()
+		return g, true
+	}
+	return plugins.GeoInfo{}, false
+}
+
+func (x *GeoExtractor) trusted(r *http.Request) bool {
+	if x.trustAll || x.originDefense {
+		return true
+	}
+	if x.secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(x.secretHeader)), []byte(x.secret)) == 1 {
+		return true
+	}
+	if len(x.cidrs) > 0 {
+		if ip := net.ParseIP(hostOnly(r.RemoteAddr)); ip != nil {
+			for _, n := range x.cidrs {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// 文档注释：按映射读取地理头
+// 约束：国家代码 XX/T1（Cloudflare 未知/Tor）视为空；客户端地址兼容 "IP:端口" 形式（CloudFront-Viewer-Address）。
+func parseGeoHeaders(h http.Header, m GeoHeaderMap) (plugins.GeoInfo, bool) {
+	g := plugins.GeoInfo{
+		Provider:          m.Provider,
+		CountryName:       headerValue(h, m.Country),
+		CountryCodeAlpha2: strings.ToUpper(headerValue(h, m.CountryAlpha2)),
+		CountryCodeAlpha3: strings.ToUpper(headerValue(h, m.CountryAlpha3)),
+		RegionName:        headerValue(h, m.Region),
+		RegionCode:        headerValue(h, m.RegionCode),
+		CityName:          headerValue(h, m.City),
+		ISP:               headerValue(h, m.ISP),
+		ClientIP:          clientAddr(headerValue(h, m.ClientIP)),
+	}
+	if g.CountryCodeAlpha2 == "XX" || g.CountryCodeAlpha2 == "T1" {
+		g.CountryCodeAlpha2 = ""
+	}
+	if v, e := strconv.ParseFloat(headerValue(h, m.Latitude), 64); e == nil {
+		g.Latitude = v
+	}
+	if v, e := strconv.ParseFloat(headerValue(h, m.Longitude), 64); e == nil {
+		g.Longitude = v
+	}
+	if v, e := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(headerValue(h, m.ASN)), "AS")); e == nil {
+		g.ASN = v
+	}
+	ok := g.CountryName != "" || g.CountryCodeAlpha2 != "" || g.RegionName != "" || g.RegionCode != "" ||
+		g.CityName != "" || g.Latitude != 0 || g.Longitude != 0 || g.ASN != 0 || g.ISP != ""
+	return g, ok
+}
+
+func headerValue(h http.Header, names string) string {
+	if names == "" {
+		return ""
+	}
+	for _, n := range strings.Split(names, ",") {
+		if v := strings.TrimSpace(h.Get(strings.TrimSpace(n))); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// NOTE: CloudFront 的 IPv6 地址形如 "2001:db8::1:443"（无方括号），直接解析失败时去掉最后一段端口再试。
+func clientAddr(v string) string {
+	if v == "" || net.ParseIP(v) != nil {
+		return v
+	}
+	if h, _, err := net.SplitHostPort(v); err == nil && net.ParseIP(h) != nil {
+		return h
+	}
+	if i := strings.LastIndex(v, ":"); i > 0 && net.ParseIP(v[:i]) != nil {
+		return v[:i]
+	}
+	return v
+}
+
+func hostOnly(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
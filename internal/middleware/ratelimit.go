@@ -1,10 +1,10 @@
 package middleware
 
 import (
-	"context"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -40,24 +40,32 @@ func (tb *TokenBucket) allow() bool {
 
 func Wrap(next http.Handler) http.Handler {
 	od := origindefense.NewFromEnv(logger.L())
+	gx := NewGeoExtractorFromEnv()
+	// 未配置可信来源时 edgeone 地理头按旧版行为采信（可被客户端伪造），其余 CDN 地理头被丢弃
+	if !gx.hasTrustSource() {
+		logger.L().Warn("cdn_geo_no_trust_source", "providers", strings.Join(gx.Providers(), ","), "legacy_trusted", "edgeone")
+	}
 	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 文档注释：EdgeOne 地理上下文注入
-		// 背景：在源站防御通过后，解析 EdgeOne 改写的请求头并注入到上下文，供融合层插件读取；解析失败不阻断主流程。
-		// 约束：字段名大小写需与控制台配置一致；经纬度/ASN 做空值与类型容错。
-		geo := parseEdgeOneGeo(r)
-		logger.L().Debug("edgeone_geo_inject",
+		// 文档注释：CDN 地理上下文注入
+		// 背景：在源站防御通过后，按已启用的头映射解析 CDN 回源地理头并经类型化键注入上下文，供融合层插件读取；解析失败或来源不可信时不注入，不阻断主流程。
+		geo, ok := gx.Extract(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logger.L().Debug("cdn_geo_inject",
+			"provider", geo.Provider,
 			"ip", geo.ClientIP,
 			"country", geo.CountryName,
 			"region", geo.RegionName,
-			"province", "",
+			"region_code", geo.RegionCode,
 			"city", geo.CityName,
 			"isp", geo.ISP,
 			"lat", geo.Latitude,
 			"lon", geo.Longitude,
 			"asn", geo.ASN,
 		)
-		ctx := context.WithValue(r.Context(), "edgeone_geo", geo)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r.WithContext(plugins.WithGeo(r.Context(), geo)))
 	})
 	h := od.Wrap(inner)
 	if os.Getenv("RATE_LIMIT_ENABLED") == "true" {
@@ -78,50 +86,3 @@ func Wrap(next http.Handler) http.Handler {
 	}
 	return h
 }
-
-// 文档注释：解析 EdgeOne 请求头为地理信息结构
-// 背景：读取自定义头中的国家/地区/城市/运营商等字段，转换为标准结构体传递到后续处理；不做外部依赖调用。
-// 约束：仅进行基础的字符串读取与数值转换；异常值将被忽略。
-func parseEdgeOneGeo(r *http.Request) plugins.EdgeOneGeoInfo {
-	h := r.Header
-	var g plugins.EdgeOneGeoInfo
-	g.CountryName = h.Get("X-EO-Geo-Country")
-	g.CountryCodeAlpha2 = h.Get("X-EO-Geo-CountryCodeAlpha2")
-	g.CountryCodeAlpha3 = h.Get("X-EO-Geo-CountryCodeAlpha3")
-	g.RegionName = h.Get("X-EO-Geo-Region")
-	g.RegionCode = h.Get("X-EO-Geo-RegionCode")
-	g.CityName = h.Get("X-EO-Geo-City")
-	// 优先新版头部 X-EO-ISP，兼容旧名 X-EO-Geo-CISP
-	if v := h.Get("X-EO-ISP"); v != "" {
-		g.ISP = v
-	} else {
-		g.ISP = h.Get("X-EO-Geo-CISP")
-	}
-	g.ClientIP = h.Get("X-EO-Client-IP")
-	if s := h.Get("X-EO-Geo-Latitude"); s != "" {
-		if v, e := strconv.ParseFloat(s, 64); e == nil {
-			g.Latitude = v
-		}
-	}
-	if s := h.Get("X-EO-Geo-Longitude"); s != "" {
-		if v, e := strconv.ParseFloat(s, 64); e == nil {
-			g.Longitude = v
-		}
-	}
-	if s := h.Get("X-EO-Geo-ASN"); s != "" {
-		if v, e := strconv.Atoi(s); e == nil {
-			g.ASN = v
-		}
-	}
-	logger.L().Debug("edgeone_geo_parse",
-		"ip", g.ClientIP,
-		"country", g.CountryName,
-		"region", g.RegionName,
-		"city", g.CityName,
-		"isp", g.ISP,
-		"lat", g.Latitude,
-		"lon", g.Longitude,
-		"asn", g.ASN,
-	)
-	return g
-}
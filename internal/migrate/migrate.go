@@ -0,0 +1,278 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"ip-api/internal/logger"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 文档注释：版本化数据库迁移
+// 背景：原先每次启动执行一组临时拼凑的 CREATE IF NOT EXISTS/ALTER，与仓库中的 SQL 文件逐渐不一致；
+// 现改为嵌入二进制的编号迁移（sql/NNNN_名称.up.sql 与 .down.sql），执行记录写入 schema_migrations。
+// 约束：
+// - 迁移按编号升序执行，每个迁移与其登记在同一事务中；首行为 "-- migrate:no-transaction" 的迁移不包裹事务，且应只含一条语句（如 CREATE INDEX CONCURRENTLY，多语句会被隐式合并为一个事务）；
+// - 执行期间持有会话级 advisory lock，多实例同时启动时串行，后到者看到已执行的版本后直接返回；
+// - 已执行迁移的文件内容变化（校验和不一致）只记录警告并在 status 中标出，不自动重跑。
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+// advisory lock 键（"ipapimig" 的 ASCII）
+const lockKey int64 = 0x697061706d6967
+
+// Migration：一个编号迁移
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// State：迁移执行状态（status 子命令输出）
+type State struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Modified  bool       `json:"modified,omitempty"`
+}
+
+// 文档注释：读取嵌入的迁移
+// 返回：按编号升序；编号重复或缺少 up 文件时返回错误。
+func Load() ([]Migration, error) {
+	ents, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+	byVer := map[int]*Migration{}
+	for _, e := range ents {
+		name := e.Name()
+		base, dir, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok || (dir != "up" && dir != "down") {
+			return nil, fmt.Errorf("migrate: bad file name %s", name)
+		}
+		num, label, _ := strings.Cut(base, "_")
+		v, err := strconv.Atoi(num)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("migrate: bad version in %s", name)
+		}
+		b, err := files.ReadFile("sql/" + name)
+		if err != nil {
+			return nil, err
+		}
+		m := byVer[v]
+		if m == nil {
+			m = &Migration{Version: v, Name: label}
+			byVer[v] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migrate: duplicate version %d (%s, %s)", v, m.Name, label)
+		}
+		if dir == "up" {
+			m.up = string(b)
+		} else {
+			m.down = string(b)
+		}
+	}
+	out := make([]Migration, 0, len(byVer))
+	for _, m := range byVer {
+		if m.up == "" {
+			return nil, fmt.Errorf("migrate: version %d has no up file", m.Version)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+func checksum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+type applied struct {
+	name string
+	sum  string
+	at   time.Time
+}
+
+// withLock：在独占连接上持有 advisory lock 执行 fn（会话级锁必须与执行语句同一连接）
+func withLock(ctx context.Context, db *sql.DB, fn func(*sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", lockKey)
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INT PRIMARY KEY,
+            name TEXT NOT NULL,
+            checksum TEXT NOT NULL,
+            applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        )`); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func readApplied(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}) (map[int]applied, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int]applied{}
+	for rows.Next() {
+		var v int
+		var a applied
+		if err := rows.Scan(&v, &a.name, &a.sum, &a.at); err != nil {
+			return nil, err
+		}
+		out[v] = a
+	}
+	return out, rows.Err()
+}
+
+// run：执行一个方向的迁移脚本并更新登记
+func run(ctx context.Context, conn *sql.Conn, m Migration, up bool) error {
+	body, record, args := m.up, "INSERT INTO schema_migrations(version, name, checksum) VALUES($1, $2, $3)", []any{m.Version, m.Name, checksum(m.up)}
+	if !up {
+		body, record, args = m.down, "DELETE FROM schema_migrations WHERE version=$1", []any{m.Version}
+	}
+	if strings.HasPrefix(body, "-- migrate:no-transaction") {
+		if _, err := conn.ExecContext(ctx, body); err != nil {
+			return err
+		}
+		_, err := conn.ExecContext(ctx, record, args...)
+		return err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 文档注释：执行未执行的迁移
+// 参数：target 为目标版本（≤0 表示最新）。
+// 返回：本次执行的迁移；出错时已成功的迁移保持提交，错误指明失败的版本。
+func Up(ctx context.Context, db *sql.DB, target int) ([]Migration, error) {
+	ms, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		have, err := readApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range ms {
+			if target > 0 && m.Version > target {
+				break
+			}
+			if a, ok := have[m.Version]; ok {
+				if a.sum != checksum(m.up) {
+					logger.L().Warn("migrate_checksum_mismatch", "version", m.Version, "name", m.Name)
+				}
+				continue
+			}
+			t0 := time.Now()
+			if err := run(ctx, conn, m, true); err != nil {
+				return fmt.Errorf("migrate: up %04d_%s: %w", m.Version, m.Name, err)
+			}
+			logger.L().Info("migrate_up", "version", m.Version, "name", m.Name, "ms", time.Since(t0).Milliseconds())
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// 文档注释：回退最近执行的迁移
+// 参数：steps 为回退个数（≤0 视为 1）。
+// 返回：本次回退的迁移（按回退顺序）；缺少 down 文件的迁移返回错误且不再继续。
+func Down(ctx context.Context, db *sql.DB, steps int) ([]Migration, error) {
+	ms, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	steps = max(steps, 1)
+	var done []Migration
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		have, err := readApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(ms) - 1; i >= 0 && len(done) < steps; i-- {
+			m := ms[i]
+			if _, ok := have[m.Version]; !ok {
+				continue
+			}
+			if m.down == "" {
+				return fmt.Errorf("migrate: %04d_%s has no down file", m.Version, m.Name)
+			}
+			if err := run(ctx, conn, m, false); err != nil {
+				return fmt.Errorf("migrate: down %04d_%s: %w", m.Version, m.Name, err)
+			}
+			logger.L().Info("migrate_down", "version", m.Version, "name", m.Name)
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status：各迁移的执行状态（含数据库中存在但二进制中没有的版本，名称记为库中登记值）
+func Status(ctx context.Context, db *sql.DB) ([]State, error) {
+	ms, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	var out []State
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		have, err := readApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range ms {
+			s := State{Version: m.Version, Name: m.Name}
+			if a, ok := have[m.Version]; ok {
+				at := a.at
+				s.AppliedAt, s.Modified = &at, a.sum != checksum(m.up)
+				delete(have, m.Version)
+			}
+			out = append(out, s)
+		}
+		for v, a := range have {
+			at := a.at
+			out = append(out, State{Version: v, Name: a.name, AppliedAt: &at})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+		return nil
+	})
+	return out, err
+}
+
+// ErrPending：关闭自动迁移时仍有未执行的迁移
+var ErrPending = errors.New("migrate: pending migrations, run `migrate up`")
@@ -1,97 +1,28 @@
 package migrate
 
 import (
+	"context"
 	"database/sql"
-	"ip-api/internal/logger"
+	"os"
 )
 
-// 背景：首次运行自动创建所需表与索引，保障后续导入与查询
-// 约束：使用 IF NOT EXISTS 避免与既有结构冲突；仅创建最小必需结构
+// 文档注释：启动时确保数据库结构为最新
+// 背景：服务与各命令行工具启动时调用；默认执行全部未执行的迁移（多实例同时启动由 advisory lock 串行）。
+// 约束：MIGRATE_AUTO=false 时只检查不执行，存在未执行迁移返回 ErrPending，由运维先执行 `migrate up`。
 func EnsureSchema(db *sql.DB) error {
-	stmts := []string{
-		`CREATE TABLE IF NOT EXISTS _ip_locations (
-            id SERIAL PRIMARY KEY,
-            country TEXT NOT NULL,
-            region TEXT NOT NULL,
-            province TEXT NOT NULL,
-            city TEXT NOT NULL,
-            isp TEXT NOT NULL
-        )`,
-		`CREATE UNIQUE INDEX IF NOT EXISTS uniq_location ON _ip_locations(country,region,province,city,isp)`,
-		`CREATE TABLE IF NOT EXISTS _ip_ipv4_ranges (
-            start_int BIGINT NOT NULL,
-            end_int BIGINT NOT NULL,
-            first_octet INT NOT NULL,
-            location_id INT NOT NULL REFERENCES _ip_locations(id)
-        )`,
-		`CREATE INDEX IF NOT EXISTS idx_ipv4_first_start ON _ip_ipv4_ranges(first_octet, start_int)`,
-		`CREATE TABLE IF NOT EXISTS _ip_stats_total (
-            id INT PRIMARY KEY,
-            total_queries BIGINT NOT NULL DEFAULT 0,
-            total_visitors BIGINT NOT NULL DEFAULT 0
-        )`,
-		`CREATE TABLE IF NOT EXISTS _ip_stats_daily (
-            day DATE PRIMARY KEY,
-            queries BIGINT NOT NULL DEFAULT 0,
-            visitors BIGINT NOT NULL DEFAULT 0
-        )`,
-		`INSERT INTO _ip_stats_total(id, total_queries, total_visitors)
-         VALUES(1, 0, 0)
-         ON CONFLICT (id) DO NOTHING`,
-		`CREATE TABLE IF NOT EXISTS _ip_overrides (
-			ip_int BIGINT PRIMARY KEY,
-			location_id INT NOT NULL REFERENCES _ip_locations(id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS _ip_overrides_kv (
-			assoc_key TEXT NOT NULL DEFAULT 'global',
-			ip_int BIGINT NOT NULL,
-			country TEXT NOT NULL,
-			region TEXT NOT NULL,
-			province TEXT NOT NULL,
-			city TEXT NOT NULL,
-			isp TEXT NOT NULL,
-			updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
-			PRIMARY KEY (assoc_key, ip_int)
-		)`,
-		`CREATE TABLE IF NOT EXISTS _ip_exact (
-            ip_int BIGINT PRIMARY KEY,
-            location_id INT NOT NULL REFERENCES _ip_locations(id),
-            source_tag TEXT NOT NULL DEFAULT '',
-            updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
-        )`,
-		`CREATE TABLE IF NOT EXISTS _ip_cidr_special (
-            start_int BIGINT NOT NULL,
-            end_int BIGINT NOT NULL,
-            first_octet INT NOT NULL,
-            location_id INT NOT NULL REFERENCES _ip_locations(id),
-            source_tag TEXT NOT NULL,
-            active BOOLEAN NOT NULL DEFAULT TRUE,
-            PRIMARY KEY (start_int, end_int, location_id)
-        )`,
-		`CREATE INDEX IF NOT EXISTS idx_cidr_special_first_start ON _ip_cidr_special(first_octet, start_int)`,
-		`CREATE TABLE IF NOT EXISTS _ip_recent_ips (
-            ip_int BIGINT PRIMARY KEY,
-            last_seen TIMESTAMPTZ NOT NULL DEFAULT now(),
-            queries BIGINT NOT NULL DEFAULT 0
-        )`,
-		`CREATE INDEX IF NOT EXISTS idx_recent_last_seen ON _ip_recent_ips(last_seen DESC)`,
-		// 补充覆盖 KV 的评分与置信度列
-		`ALTER TABLE _ip_overrides_kv ADD COLUMN IF NOT EXISTS score REAL NOT NULL DEFAULT 0`,
-		`ALTER TABLE _ip_overrides_kv ADD COLUMN IF NOT EXISTS confidence REAL NOT NULL DEFAULT 0`,
-	}
-	for i, s := range stmts {
-		logger.L().Debug("schema_exec", "idx", i)
-		if _, err := db.Exec(s); err != nil {
-			return err
-		}
-	}
-	// 外键调整为可延迟检查，降低并行写入时父子可见性问题
-	if _, err := db.Exec(`ALTER TABLE _ip_ipv4_ranges DROP CONSTRAINT IF EXISTS _ip_ipv4_ranges_location_id_fkey`); err != nil {
+	ctx := context.Background()
+	if os.Getenv("MIGRATE_AUTO") != "false" {
+		_, err := Up(ctx, db, 0)
 		return err
 	}
-	if _, err := db.Exec(`ALTER TABLE _ip_ipv4_ranges ADD CONSTRAINT _ip_ipv4_ranges_location_id_fkey FOREIGN KEY (location_id) REFERENCES _ip_locations(id) DEFERRABLE INITIALLY DEFERRED`); err != nil {
+	st, err := Status(ctx, db)
+	if err != nil {
 		return err
 	}
-	logger.L().Debug("schema_done", "tables", "_ip_locations,_ip_overrides,_ip_overrides_kv,_ip_exact,_ip_cidr_special,_ip_stats_total,_ip_stats_daily")
+	for _, s := range st {
+		if s.AppliedAt == nil {
+			return ErrPending
+		}
+	}
 	return nil
 }
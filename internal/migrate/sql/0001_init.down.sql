@@ -0,0 +1,3 @@
+-- WARNING: 删除全部业务表与数据，仅用于重建空库
+DROP TABLE IF EXISTS _ip_asn_prefixes, _ip_asn, _ip_recent_ips, _ip_cidr_special, _ip_exact,
+    _ip_overrides_kv, _ip_overrides, _ip_stats_daily, _ip_stats_total, _ip_ipv4_ranges, _ip_locations;
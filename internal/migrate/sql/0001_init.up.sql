@@ -0,0 +1,94 @@
+-- 基线：与此前启动时 EnsureSchema 创建的结构一致；全部 IF NOT EXISTS，已有库可直接登记为已执行
+CREATE TABLE IF NOT EXISTS _ip_locations (
+    id SERIAL PRIMARY KEY,
+    country TEXT NOT NULL,
+    region TEXT NOT NULL,
+    province TEXT NOT NULL,
+    city TEXT NOT NULL,
+    isp TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_location ON _ip_locations(country,region,province,city,isp);
+
+CREATE TABLE IF NOT EXISTS _ip_ipv4_ranges (
+    start_int BIGINT NOT NULL,
+    end_int BIGINT NOT NULL,
+    first_octet INT NOT NULL,
+    location_id INT NOT NULL REFERENCES _ip_locations(id)
+);
+CREATE INDEX IF NOT EXISTS idx_ipv4_first_start ON _ip_ipv4_ranges(first_octet, start_int);
+-- 外键调整为可延迟检查，降低并行写入时父子可见性问题
+ALTER TABLE _ip_ipv4_ranges DROP CONSTRAINT IF EXISTS _ip_ipv4_ranges_location_id_fkey;
+ALTER TABLE _ip_ipv4_ranges ADD CONSTRAINT _ip_ipv4_ranges_location_id_fkey
+    FOREIGN KEY (location_id) REFERENCES _ip_locations(id) DEFERRABLE INITIALLY DEFERRED;
+
+CREATE TABLE IF NOT EXISTS _ip_stats_total (
+    id INT PRIMARY KEY,
+    total_queries BIGINT NOT NULL DEFAULT 0,
+    total_visitors BIGINT NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS _ip_stats_daily (
+    day DATE PRIMARY KEY,
+    queries BIGINT NOT NULL DEFAULT 0,
+    visitors BIGINT NOT NULL DEFAULT 0
+);
+INSERT INTO _ip_stats_total(id, total_queries, total_visitors) VALUES(1, 0, 0) ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS _ip_overrides (
+    ip_int BIGINT PRIMARY KEY,
+    location_id INT NOT NULL REFERENCES _ip_locations(id)
+);
+CREATE TABLE IF NOT EXISTS _ip_overrides_kv (
+    assoc_key TEXT NOT NULL DEFAULT 'global',
+    ip_int BIGINT NOT NULL,
+    country TEXT NOT NULL,
+    region TEXT NOT NULL,
+    province TEXT NOT NULL,
+    city TEXT NOT NULL,
+    isp TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (assoc_key, ip_int)
+);
+ALTER TABLE _ip_overrides_kv ADD COLUMN IF NOT EXISTS score REAL NOT NULL DEFAULT 0;
+ALTER TABLE _ip_overrides_kv ADD COLUMN IF NOT EXISTS confidence REAL NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS _ip_exact (
+    ip_int BIGINT PRIMARY KEY,
+    location_id INT NOT NULL REFERENCES _ip_locations(id),
+    source_tag TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS _ip_cidr_special (
+    start_int BIGINT NOT NULL,
+    end_int BIGINT NOT NULL,
+    first_octet INT NOT NULL,
+    location_id INT NOT NULL REFERENCES _ip_locations(id),
+    source_tag TEXT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    PRIMARY KEY (start_int, end_int, location_id)
+);
+CREATE INDEX IF NOT EXISTS idx_cidr_special_first_start ON _ip_cidr_special(first_octet, start_int);
+
+CREATE TABLE IF NOT EXISTS _ip_recent_ips (
+    ip_int BIGINT PRIMARY KEY,
+    last_seen TIMESTAMPTZ NOT NULL DEFAULT now(),
+    queries BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_recent_last_seen ON _ip_recent_ips(last_seen DESC);
+
+-- ASN 元信息与前缀映射（asn-import 导入，服务启动时加载到内存）
+CREATE TABLE IF NOT EXISTS _ip_asn (
+    asn BIGINT PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    country TEXT NOT NULL DEFAULT '',
+    registry TEXT NOT NULL DEFAULT '',
+    isp TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS _ip_asn_prefixes (
+    prefix CIDR NOT NULL,
+    asn BIGINT NOT NULL,
+    source TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (prefix, source)
+);
+CREATE INDEX IF NOT EXISTS idx_asn_prefixes_asn ON _ip_asn_prefixes(asn);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_cidr_special_source_tag;
+ALTER TABLE _ip_cidr_special DROP COLUMN IF EXISTS updated_at;
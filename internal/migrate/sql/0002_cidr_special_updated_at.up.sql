@@ -0,0 +1,3 @@
+-- cidr-rollback 按 source_tag 的 max(updated_at) 排定版本新旧，基线结构缺少该列
+ALTER TABLE _ip_cidr_special ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS idx_cidr_special_source_tag ON _ip_cidr_special(source_tag);
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS trg_overrides_kv_history ON _ip_overrides_kv;
+DROP TABLE IF EXISTS _ip_overrides_kv_history;
+DROP FUNCTION IF EXISTS _ip_overrides_kv_history_readonly();
+DROP FUNCTION IF EXISTS _ip_overrides_kv_record();
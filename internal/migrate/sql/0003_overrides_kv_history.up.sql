@@ -0,0 +1,46 @@
+-- KV 覆盖变更历史：由触发器在同一事务内追加，写入方经 set_config('ipapi.actor'/'ipapi.source', ..., true) 标注操作人与来源
+CREATE TABLE IF NOT EXISTS _ip_overrides_kv_history (
+    id BIGSERIAL PRIMARY KEY,
+    assoc_key TEXT NOT NULL,
+    ip_int BIGINT NOT NULL,
+    op TEXT NOT NULL,
+    old_value JSONB,
+    new_value JSONB,
+    score REAL,
+    actor TEXT NOT NULL,
+    source TEXT NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_overrides_kv_history_ip ON _ip_overrides_kv_history(ip_int, changed_at DESC);
+
+CREATE OR REPLACE FUNCTION _ip_overrides_kv_record() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO _ip_overrides_kv_history(assoc_key, ip_int, op, old_value, new_value, score, actor, source)
+    VALUES (
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.assoc_key ELSE NEW.assoc_key END,
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.ip_int ELSE NEW.ip_int END,
+        lower(TG_OP),
+        CASE WHEN TG_OP = 'INSERT' THEN NULL ELSE to_jsonb(OLD) END,
+        CASE WHEN TG_OP = 'DELETE' THEN NULL ELSE to_jsonb(NEW) END,
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.score ELSE NEW.score END,
+        COALESCE(NULLIF(current_setting('ipapi.actor', true), ''), current_user),
+        COALESCE(NULLIF(current_setting('ipapi.source', true), ''), 'sql')
+    );
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_overrides_kv_history ON _ip_overrides_kv;
+CREATE TRIGGER trg_overrides_kv_history AFTER INSERT OR UPDATE OR DELETE ON _ip_overrides_kv
+    FOR EACH ROW EXECUTE FUNCTION _ip_overrides_kv_record();
+
+-- 历史只追加：拒绝修改与逐行删除（清理需 TRUNCATE，由管理员显式执行）
+CREATE OR REPLACE FUNCTION _ip_overrides_kv_history_readonly() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '_ip_overrides_kv_history is append-only';
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_overrides_kv_history_readonly ON _ip_overrides_kv_history;
+CREATE TRIGGER trg_overrides_kv_history_readonly BEFORE UPDATE OR DELETE ON _ip_overrides_kv_history
+    FOR EACH ROW EXECUTE FUNCTION _ip_overrides_kv_history_readonly();
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS _ip_overrides_kv_range;
+DROP FUNCTION IF EXISTS _ip_overrides_kv_range_record();
+-- 区间覆盖的历史去掉 end_int 后会被误认为单 IP 记录，先删除（临时停用只读触发器）
+ALTER TABLE _ip_overrides_kv_history DISABLE TRIGGER trg_overrides_kv_history_readonly;
+DELETE FROM _ip_overrides_kv_history WHERE end_int IS NOT NULL;
+ALTER TABLE _ip_overrides_kv_history ENABLE TRIGGER trg_overrides_kv_history_readonly;
+DROP INDEX IF EXISTS idx_overrides_kv_history_range;
+ALTER TABLE _ip_overrides_kv_history DROP COLUMN IF EXISTS end_int;
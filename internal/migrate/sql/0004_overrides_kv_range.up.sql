@@ -0,0 +1,43 @@
+-- 区间 KV 覆盖：与单 IP 覆盖同级，查询时单 IP 优先，区间之间窄段优先（最具体者胜出）
+CREATE TABLE IF NOT EXISTS _ip_overrides_kv_range (
+    assoc_key TEXT NOT NULL DEFAULT 'global',
+    start_int BIGINT NOT NULL,
+    end_int BIGINT NOT NULL,
+    country TEXT NOT NULL,
+    region TEXT NOT NULL,
+    province TEXT NOT NULL,
+    city TEXT NOT NULL,
+    isp TEXT NOT NULL,
+    score REAL NOT NULL DEFAULT 0,
+    confidence REAL NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (assoc_key, start_int, end_int),
+    CHECK (start_int >= 0 AND start_int <= end_int AND end_int <= 4294967295)
+);
+CREATE INDEX IF NOT EXISTS idx_overrides_kv_range_span ON _ip_overrides_kv_range(start_int, end_int);
+
+-- 历史表记录区间：ip_int 为起点，end_int 为终点（单 IP 覆盖为 NULL）
+ALTER TABLE _ip_overrides_kv_history ADD COLUMN IF NOT EXISTS end_int BIGINT;
+CREATE INDEX IF NOT EXISTS idx_overrides_kv_history_range ON _ip_overrides_kv_history(ip_int, end_int) WHERE end_int IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION _ip_overrides_kv_range_record() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO _ip_overrides_kv_history(assoc_key, ip_int, end_int, op, old_value, new_value, score, actor, source)
+    VALUES (
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.assoc_key ELSE NEW.assoc_key END,
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.start_int ELSE NEW.start_int END,
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.end_int ELSE NEW.end_int END,
+        lower(TG_OP),
+        CASE WHEN TG_OP = 'INSERT' THEN NULL ELSE to_jsonb(OLD) END,
+        CASE WHEN TG_OP = 'DELETE' THEN NULL ELSE to_jsonb(NEW) END,
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.score ELSE NEW.score END,
+        COALESCE(NULLIF(current_setting('ipapi.actor', true), ''), current_user),
+        COALESCE(NULLIF(current_setting('ipapi.source', true), ''), 'sql')
+    );
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_overrides_kv_range_history ON _ip_overrides_kv_range;
+CREATE TRIGGER trg_overrides_kv_range_history AFTER INSERT OR UPDATE OR DELETE ON _ip_overrides_kv_range
+    FOR EACH ROW EXECUTE FUNCTION _ip_overrides_kv_range_record();
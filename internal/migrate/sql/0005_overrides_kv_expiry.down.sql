@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_overrides_kv_range_expires;
+DROP INDEX IF EXISTS idx_overrides_kv_expires;
+ALTER TABLE _ip_overrides_kv_range DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE _ip_overrides_kv DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,5 @@
+-- KV 覆盖有效期：自动化命名空间的写入到期后不再生效，由后台任务清理
+ALTER TABLE _ip_overrides_kv ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE _ip_overrides_kv_range ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_overrides_kv_expires ON _ip_overrides_kv(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_overrides_kv_range_expires ON _ip_overrides_kv_range(expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS _ip_stats_hourly;
@@ -0,0 +1,11 @@
+-- 查询量小时聚合：端点、解析阶段、国家/省份、是否命中（由各实例进程内累加后定期合并写入）
+CREATE TABLE IF NOT EXISTS _ip_stats_hourly (
+    hour TIMESTAMPTZ NOT NULL,
+    endpoint TEXT NOT NULL,
+    stage TEXT NOT NULL,
+    country TEXT NOT NULL,
+    province TEXT NOT NULL,
+    hit BOOLEAN NOT NULL,
+    queries BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (hour, endpoint, stage, country, province, hit)
+);
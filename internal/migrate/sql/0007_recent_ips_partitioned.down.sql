@@ -0,0 +1,14 @@
+-- 恢复为不分区的单表：每个地址合并为一行（/24 前缀行以网段起点保留）
+ALTER TABLE _ip_recent_ips RENAME TO _ip_recent_ips_part;
+ALTER INDEX idx_recent_last_seen RENAME TO idx_recent_last_seen_part;
+
+CREATE TABLE _ip_recent_ips (
+    ip_int BIGINT PRIMARY KEY,
+    last_seen TIMESTAMPTZ NOT NULL DEFAULT now(),
+    queries BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_recent_last_seen ON _ip_recent_ips(last_seen DESC);
+
+INSERT INTO _ip_recent_ips(ip_int, last_seen, queries)
+SELECT ip_int, max(last_seen), sum(queries) FROM _ip_recent_ips_part GROUP BY ip_int;
+DROP TABLE _ip_recent_ips_part;
@@ -0,0 +1,29 @@
+-- 最近查询 IP 按日分区：保留期外的整日分区由后台任务直接删除；prefix_len 为 32（完整 IP）或 24（匿名化时只存 /24 前缀，ip_int 为网段起点）
+ALTER TABLE _ip_recent_ips RENAME TO _ip_recent_ips_old;
+ALTER INDEX idx_recent_last_seen RENAME TO idx_recent_last_seen_old;
+
+CREATE TABLE _ip_recent_ips (
+    day DATE NOT NULL DEFAULT current_date,
+    ip_int BIGINT NOT NULL,
+    prefix_len SMALLINT NOT NULL DEFAULT 32,
+    last_seen TIMESTAMPTZ NOT NULL DEFAULT now(),
+    queries BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, ip_int, prefix_len),
+    CHECK (prefix_len IN (24, 32))
+) PARTITION BY RANGE (day);
+CREATE INDEX IF NOT EXISTS idx_recent_last_seen ON _ip_recent_ips(last_seen DESC);
+
+-- 历史数据落入一个遗留分区（截至今日），由保留任务按 day 删除；今日起按日分区，并预建未来两日
+DO $$
+DECLARE d DATE;
+BEGIN
+    EXECUTE format('CREATE TABLE _ip_recent_ips_legacy PARTITION OF _ip_recent_ips FOR VALUES FROM (MINVALUE) TO (%L)', current_date);
+    FOR d IN SELECT generate_series(current_date, current_date + 2, interval '1 day')::date LOOP
+        EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF _ip_recent_ips FOR VALUES FROM (%L) TO (%L)',
+            '_ip_recent_ips_' || to_char(d, 'YYYYMMDD'), d, d + 1);
+    END LOOP;
+END $$;
+
+INSERT INTO _ip_recent_ips(day, ip_int, prefix_len, last_seen, queries)
+SELECT LEAST(last_seen::date, current_date), ip_int, 32, last_seen, queries FROM _ip_recent_ips_old;
+DROP TABLE _ip_recent_ips_old;
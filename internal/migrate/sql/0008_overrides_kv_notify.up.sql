@@ -0,0 +1,39 @@
+-- KV 覆盖变更通知：历史触发器同时发出 NOTIFY（载荷 "<op> <起> <止>"），任何写入方（含其他进程与手工 SQL）的变更都能被服务实例感知
+CREATE OR REPLACE FUNCTION _ip_overrides_kv_record() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO _ip_overrides_kv_history(assoc_key, ip_int, op, old_value, new_value, score, actor, source)
+    VALUES (
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.assoc_key ELSE NEW.assoc_key END,
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.ip_int ELSE NEW.ip_int END,
+        lower(TG_OP),
+        CASE WHEN TG_OP = 'INSERT' THEN NULL ELSE to_jsonb(OLD) END,
+        CASE WHEN TG_OP = 'DELETE' THEN NULL ELSE to_jsonb(NEW) END,
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.score ELSE NEW.score END,
+        COALESCE(NULLIF(current_setting('ipapi.actor', true), ''), current_user),
+        COALESCE(NULLIF(current_setting('ipapi.source', true), ''), 'sql')
+    );
+    PERFORM pg_notify('ipapi_kv_changed', lower(TG_OP) || ' ' || CASE WHEN TG_OP = 'DELETE' THEN OLD.ip_int ELSE NEW.ip_int END
+        || ' ' || CASE WHEN TG_OP = 'DELETE' THEN OLD.ip_int ELSE NEW.ip_int END);
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION _ip_overrides_kv_range_record() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO _ip_overrides_kv_history(assoc_key, ip_int, end_int, op, old_value, new_value, score, actor, source)
+    VALUES (
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.assoc_key ELSE NEW.assoc_key END,
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.start_int ELSE NEW.start_int END,
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.end_int ELSE NEW.end_int END,
+        lower(TG_OP),
+        CASE WHEN TG_OP = 'INSERT' THEN NULL ELSE to_jsonb(OLD) END,
+        CASE WHEN TG_OP = 'DELETE' THEN NULL ELSE to_jsonb(NEW) END,
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.score ELSE NEW.score END,
+        COALESCE(NULLIF(current_setting('ipapi.actor', true), ''), current_user),
+        COALESCE(NULLIF(current_setting('ipapi.source', true), ''), 'sql')
+    );
+    PERFORM pg_notify('ipapi_kv_changed', lower(TG_OP) || ' ' || CASE WHEN TG_OP = 'DELETE' THEN OLD.start_int ELSE NEW.start_int END
+        || ' ' || CASE WHEN TG_OP = 'DELETE' THEN OLD.end_int ELSE NEW.end_int END);
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
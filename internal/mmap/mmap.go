@@ -0,0 +1,48 @@
+// 包 mmap：只读内存映射文件，供本地库按需分页读取而非整体载入堆内存
+package mmap
+
+import "os"
+
+// 文档注释：只读映射文件
+// 背景：精确库/范围库体积随数据增长，整体读入会常驻堆并拉长 GC 扫描；映射后由内核按页缓存，多个进程共享同一份页缓存。
+// 约束：Bytes 返回的切片只读，写入会触发段错误；Close 之后不得再访问切片。不支持 mmap 的平台回退为整体读取。
+type File struct {
+	data  []byte
+	unmap func([]byte) error
+}
+
+// 文档注释：打开并映射文件
+// 返回：空文件映射为空切片（不报错）。
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() == 0 {
+		return &File{}, nil
+	}
+	return mapFile(f, st.Size())
+}
+
+func (m *File) Bytes() []byte { return m.data }
+
+func (m *File) Len() int { return len(m.data) }
+
+// 文档注释：解除映射
+// 约束：调用方需保证已无并发读取（热切换时延迟关闭旧实例）。
+func (m *File) Close() error {
+	if m == nil || m.data == nil {
+		return nil
+	}
+	d := m.data
+	m.data = nil
+	if m.unmap == nil {
+		return nil
+	}
+	return m.unmap(d)
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package mmap
+
+import (
+	"io"
+	"os"
+)
+
+// NOTE: 非 unix 平台（如 Windows 开发环境）整体读入内存，行为与映射一致但不共享页缓存。
+func mapFile(f *os.File, size int64) (*File, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, err
+	}
+	return &File{data: b}, nil
+}
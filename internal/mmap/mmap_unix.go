@@ -0,0 +1,20 @@
+//go:build unix
+
+package mmap
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func mapFile(f *os.File, size int64) (*File, error) {
+	if int64(int(size)) != size {
+		return nil, errors.New("mmap: file too large")
+	}
+	b, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &File{data: b, unmap: syscall.Munmap}, nil
+}
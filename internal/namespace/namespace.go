@@ -0,0 +1,76 @@
+// 包 namespace：KV 覆盖命名空间的优先级与有效期
+package namespace
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 文档注释：命名空间配置
+// 背景：同一 IP（或同一区间）在多个 assoc_key 下都有覆盖时，原查询 LIMIT 1 不带排序，胜出者不确定；
+// 现按配置的优先级选取：列表中越靠前越优先，未列出的命名空间排在其后并按最近更新排序。
+// 约束：
+// - OVERRIDE_NAMESPACE_PRECEDENCE（默认 global,manual,feedback）：人工与反馈命名空间在前，自动化来源在后；
+// - OVERRIDE_AUTO_TTL_HOURS（默认 720，0 表示不过期）：自动化写回（融合、amap-ingest）的有效期；
+// - OVERRIDE_NAMESPACE_TTL_HOURS 形如 "amap=168,fusion=720"，按命名空间覆盖默认有效期；
+// - 环境变量只在首次使用时读取。
+type config struct {
+	order []string
+	auto  time.Duration
+	ttl   map[string]time.Duration
+}
+
+var load = sync.OnceValue(func() config {
+	c := config{order: []string{"global", "manual", "feedback"}, auto: 720 * time.Hour, ttl: map[string]time.Duration{}}
+	if v, ok := os.LookupEnv("OVERRIDE_NAMESPACE_PRECEDENCE"); ok {
+		c.order = splitList(v)
+	}
+	if n, err := strconv.Atoi(os.Getenv("OVERRIDE_AUTO_TTL_HOURS")); err == nil && n >= 0 {
+		c.auto = time.Duration(n) * time.Hour
+	}
+	for _, item := range splitList(os.Getenv("OVERRIDE_NAMESPACE_TTL_HOURS")) {
+		name, h, ok := strings.Cut(item, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(h))
+		if !ok || err != nil || n < 0 {
+			continue
+		}
+		c.ttl[strings.TrimSpace(name)] = time.Duration(n) * time.Hour
+	}
+	return c
+})
+
+// Precedence：命名空间优先级（高到低），作为查询参数与 OrderBy 配合使用
+func Precedence() []string { return load().order }
+
+// 文档注释：自动化写回的有效期
+// 返回：0 表示不过期。
+func TTL(assoc string) time.Duration {
+	c := load()
+	if d, ok := c.ttl[assoc]; ok {
+		return d
+	}
+	return c.auto
+}
+
+// 文档注释：按优先级排序的 SQL 片段
+// 参数：param 为传入 Precedence() 的占位符（如 "$2"）。
+// 返回：可直接拼在 ORDER BY 之后（或其他排序键之后）的表达式：优先级位置升序，未列出者其次，同级最近更新优先。
+func OrderBy(param string) string {
+	return "COALESCE(array_position(" + param + "::text[], assoc_key), 2147483647), updated_at DESC"
+}
+
+// Active：未过期条件（expires_at 为空表示长期有效）
+const Active = "(expires_at IS NULL OR expires_at > now())"
+
+func splitList(s string) []string {
+	var out []string
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			out = append(out, x)
+		}
+	}
+	return out
+}
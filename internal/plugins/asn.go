@@ -0,0 +1,44 @@
+package plugins
+
+import (
+	"context"
+	"ip-api/internal/asn"
+	"ip-api/internal/fusion"
+	"ip-api/internal/metrics"
+)
+
+// 文档注释：ASN 插件（进程内）
+// 背景：本地库（IPIP 等）导入的运营商字段几乎全部为空；按前缀到 ASN 的映射补全运营商与 ASN。
+// 约束：只输出 ISP/ASN，不输出地域字段；作为补全来源不参与排序与字段投票（见 Manager.Aggregate），仅在融合结果缺失时填充。
+type ASNPlugin struct {
+	h *asn.Holder
+}
+
+func NewASNPlugin(h *asn.Holder) *ASNPlugin {
+	return &ASNPlugin{h: h}
+}
+
+func (p *ASNPlugin) Name() string     { return "asn" }
+func (p *ASNPlugin) Version() string  { return "1.0" }
+func (p *ASNPlugin) AssocKey() string { return "asn" }
+
+func (p *ASNPlugin) Query(ctx context.Context, ip string) (fusion.Location, float64) {
+	var out fusion.Location
+	r, _, ok := p.h.Lookup(ip)
+	if !ok {
+		metrics.ASNLookupsTotal.WithLabelValues("miss").Inc()
+		return out, 0
+	}
+	metrics.ASNLookupsTotal.WithLabelValues("hit").Inc()
+	out.ISP = r.ISP
+	out.ASN = int(r.ASN)
+	return out, 0.9
+}
+
+// NOTE: 补全来源不参与评分，权重固定为 0。
+func (p *ASNPlugin) GetWeight(ip string) float64         { return 0 }
+func (p *ASNPlugin) Heartbeat(ctx context.Context) error { return nil }
+
+// 文档注释：补全来源标记
+// 背景：结果不含地域字段，评分恒为 0，若参与排序会挤占 Top 名额并影响写回来源选择。
+func (p *ASNPlugin) Enrichment() bool { return true }
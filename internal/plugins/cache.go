@@ -0,0 +1,204 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"ip-api/internal/fusion"
+	"ip-api/internal/logger"
+	"ip-api/internal/metrics"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 文档注释：插件结果缓存条目
+// 背景：仅缓存插件的原始输出（位置与置信度），权重与评分仍在每次融合时重新计算，避免权重调整后读到旧分数。
+type cachedResult struct {
+	Loc  fusion.Location `json:"loc"`
+	Conf float64         `json:"conf"`
+}
+
+// 文档注释：插件结果缓存后端
+// 背景：统一 Redis 与进程内 LRU 两种实现，装饰器只依赖此契约；Purge 在 prefix 为 true 时按键前缀批量清理，否则只删除该键，返回清理条数。
+type resultStore interface {
+	Get(ctx context.Context, key string) (cachedResult, bool)
+	Set(ctx context.Context, key string, v cachedResult, ttl time.Duration)
+	Purge(ctx context.Context, key string, prefix bool) int
+}
+
+// 文档注释：上下文绑定插件标记
+// 背景：EdgeOne/反地理等插件的结果取决于请求上下文（请求头、坐标）而非单纯的 IP，按 IP 缓存会把一次请求的结果错误复用到其他请求。
+// 约束：实现该接口并返回 true 的插件不会被缓存装饰器包装。
+type contextBound interface {
+	ContextBound() bool
+}
+
+// 文档注释：插件缓存默认 TTL（秒）
+// 背景：AMap 受每日配额限制且结果稳定，默认缓存 1 天、空结果缓存 1 小时；其余插件默认不缓存，需通过环境变量显式开启。
+var defaultCacheTTL = map[string][2]int{
+	"amap": {86400, 3600},
+}
+
+// 文档注释：插件结果缓存装饰器
+// 背景：融合每次都会重新查询全部插件，外部配额型（AMap）与慢速（HTTP）插件成本高；装饰器按 IP 缓存插件输出，命中时不再调用被包装插件。
+// 约束：非空结果使用 ttl，空结果（无任何字段，视为失败/未知）使用 negTTL；negTTL 为 0 时不缓存空结果；ip 为空（按坐标查询）时直接透传。
+type CachedPlugin struct {
+	Plugin
+	store  resultStore
+	ttl    time.Duration
+	negTTL time.Duration
+}
+
+// 文档注释：按环境变量为插件包装缓存
+// 参数：p 为被包装插件；rc 为可选 Redis 客户端，为空或 PLUGIN_CACHE_BACKEND=lru 时使用进程内 LRU。
+// 返回：未配置 TTL 或插件与上下文绑定时原样返回 p，否则返回 *CachedPlugin。
+// 约束：PLUGIN_CACHE_TTL_<NAME>/PLUGIN_CACHE_NEG_TTL_<NAME> 以秒为单位，NAME 为插件名大写；PLUGIN_CACHE_LRU_SIZE 控制 LRU 容量。
+func WithCache(p Plugin, rc *redis.Client) Plugin {
+	if b, ok := p.(contextBound); ok && b.ContextBound() {
+		return p
+	}
+	def := defaultCacheTTL[p.Name()]
+	suffix := strings.ToUpper(p.Name())
+	ttl := readSeconds("PLUGIN_CACHE_TTL_"+suffix, def[0])
+	negTTL := readSeconds("PLUGIN_CACHE_NEG_TTL_"+suffix, def[1])
+	if ttl <= 0 {
+		return p
+	}
+	backend := strings.ToLower(os.Getenv("PLUGIN_CACHE_BACKEND"))
+	var store resultStore
+	if rc != nil && backend != "lru" {
+		store = &redisResultStore{rc: rc}
+		backend = "redis"
+	} else {
+		store = newLRUResultStore(readInt("PLUGIN_CACHE_LRU_SIZE", 65536))
+		backend = "lru"
+	}
+	logger.L().Info("plugin_cache_enabled", "name", p.Name(), "backend", backend, "ttl_s", int(ttl.Seconds()), "neg_ttl_s", int(negTTL.Seconds()))
+	return &CachedPlugin{Plugin: p, store: store, ttl: ttl, negTTL: negTTL}
+}
+
+// 文档注释：带缓存的查询
+// 背景：先读缓存，未命中再调用被包装插件并按结果是否为空选择 TTL 回写；缓存读写失败按未命中处理，不影响查询。
+func (c *CachedPlugin) Query(ctx context.Context, ip string) (fusion.Location, float64) {
+	if ip == "" {
+		return c.Plugin.Query(ctx, ip)
+	}
+	name := c.Plugin.Name()
+	key := cacheKey(name, ip)
+	if v, ok := c.store.Get(ctx, key); ok {
+		kind := "positive"
+		if isEmptyLocation(v.Loc) {
+			kind = "negative"
+		}
+		metrics.PluginCacheHitsTotal.WithLabelValues(name, kind).Inc()
+		logger.L().Debug("plugin_cache_hit", "name", name, "ip", ip, "kind", kind)
+		return v.Loc, v.Conf
+	}
+	metrics.PluginCacheMissesTotal.WithLabelValues(name).Inc()
+	loc, conf := c.Plugin.Query(ctx, ip)
+	ttl := c.ttl
+	if isEmptyLocation(loc) {
+		ttl = c.negTTL
+	}
+	if ttl > 0 {
+		c.store.Set(ctx, key, cachedResult{Loc: loc, Conf: conf}, ttl)
+	}
+	return loc, conf
+}
+
+// 文档注释：清理缓存
+// 参数：ip 为空时清理该插件全部条目，否则仅清理该 IP。
+// 返回：实际清理条数（Redis 后端为删除的键数）。
+func (c *CachedPlugin) Purge(ctx context.Context, ip string) int {
+	name := c.Plugin.Name()
+	var n int
+	if ip == "" {
+		n = c.store.Purge(ctx, cachePrefix(name), true)
+	} else {
+		n = c.store.Purge(ctx, cacheKey(name, ip), false)
+	}
+	metrics.PluginCachePurgedTotal.WithLabelValues(name).Add(float64(n))
+	logger.L().Info("plugin_cache_purge", "name", name, "ip", ip, "count", n)
+	return n
+}
+
+// 文档注释：缓存键
+// 约束：键不含权重等易变参数；IPv6 地址本身含冒号，整体清理须经 cachePrefix 显式声明，不能由键形推断。
+func cacheKey(name, ip string) string {
+	return cachePrefix(name) + ip
+}
+
+// cachePrefix：插件级键前缀，用于整体清理
+func cachePrefix(name string) string {
+	return "plugcache:" + name + ":"
+}
+
+func isEmptyLocation(l fusion.Location) bool {
+	return l.Country == "" && l.Region == "" && l.Province == "" && l.City == "" && l.ISP == ""
+}
+
+// 文档注释：Redis 缓存后端
+// 背景：多实例共享缓存，避免每个实例各自消耗 AMap 配额；值以 JSON 存储便于排查。
+type redisResultStore struct {
+	rc *redis.Client
+}
+
+func (s *redisResultStore) Get(ctx context.Context, key string) (cachedResult, bool) {
+	var v cachedResult
+	b, err := s.rc.Get(ctx, key).Bytes()
+	if err != nil {
+		return v, false
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return v, false
+	}
+	return v, true
+}
+
+func (s *redisResultStore) Set(ctx context.Context, key string, v cachedResult, ttl time.Duration) {
+	b, _ := json.Marshal(v)
+	_ = s.rc.Set(ctx, key, b, ttl).Err()
+}
+
+// NOTE: 整体清理使用 SCAN 分批遍历，避免 KEYS 阻塞 Redis；单 IP 清理直接 DEL。
+func (s *redisResultStore) Purge(ctx context.Context, key string, prefix bool) int {
+	if !prefix {
+		n, _ := s.rc.Del(ctx, key).Result()
+		return int(n)
+	}
+	total := 0
+	var cursor uint64
+	for {
+		keys, next, err := s.rc.Scan(ctx, cursor, key+"*", 500).Result()
+		if err != nil {
+			return total
+		}
+		if len(keys) > 0 {
+			n, _ := s.rc.Del(ctx, keys...).Result()
+			total += int(n)
+		}
+		cursor = next
+		if cursor == 0 {
+			return total
+		}
+	}
+}
+
+func readSeconds(env string, def int) time.Duration {
+	return time.Duration(readInt(env, def)) * time.Second
+}
+
+func readInt(env string, def int) int {
+	s := os.Getenv(env)
+	if s == "" {
+		return def
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,89 @@
+package plugins
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 文档注释：进程内 LRU 缓存后端
+// 背景：未启用 Redis 时的兜底实现；容量满时淘汰最久未使用条目，读取时惰性剔除过期条目。
+// 约束：仅在单进程内有效，多实例部署时各自缓存；容量为 0 时视为 1 以保证可用。
+type lruResultStore struct {
+	mu   sync.Mutex
+	cap  int
+	lst  *list.List
+	dict map[string]*list.Element
+}
+
+type lruEntry struct {
+	key string
+	val cachedResult
+	exp time.Time
+}
+
+func newLRUResultStore(capacity int) *lruResultStore {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &lruResultStore{cap: capacity, lst: list.New(), dict: make(map[string]*list.Element)}
+}
+
+func (s *lruResultStore) Get(ctx context.Context, key string) (cachedResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.dict[key]
+	if !ok {
+		return cachedResult{}, false
+	}
+	it := e.Value.(lruEntry)
+	if time.Now().After(it.exp) {
+		s.lst.Remove(e)
+		delete(s.dict, key)
+		return cachedResult{}, false
+	}
+	s.lst.MoveToFront(e)
+	return it.val, true
+}
+
+func (s *lruResultStore) Set(ctx context.Context, key string, v cachedResult, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it := lruEntry{key: key, val: v, exp: time.Now().Add(ttl)}
+	if e, ok := s.dict[key]; ok {
+		e.Value = it
+		s.lst.MoveToFront(e)
+		return
+	}
+	s.dict[key] = s.lst.PushFront(it)
+	for s.lst.Len() > s.cap {
+		back := s.lst.Back()
+		delete(s.dict, back.Value.(lruEntry).key)
+		s.lst.Remove(back)
+	}
+}
+
+func (s *lruResultStore) Purge(ctx context.Context, key string, prefix bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !prefix {
+		e, ok := s.dict[key]
+		if !ok {
+			return 0
+		}
+		s.lst.Remove(e)
+		delete(s.dict, key)
+		return 1
+	}
+	n := 0
+	for k, e := range s.dict {
+		if strings.HasPrefix(k, key) {
+			s.lst.Remove(e)
+			delete(s.dict, k)
+			n++
+		}
+	}
+	return n
+}
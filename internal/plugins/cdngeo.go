@@ -0,0 +1,203 @@
+package plugins
+
+import (
+	"context"
+	"ip-api/internal/asn"
+	"ip-api/internal/fusion"
+	"ip-api/internal/logger"
+	"ip-api/internal/revgeo"
+	"strings"
+)
+
+// 文档注释：CDN 地理信息载体
+// 背景：承载来自 CDN（EdgeOne/Cloudflare/CloudFront/Fastly/自定义）回源请求头的地理数据，由中间件按头映射解析并经类型化上下文键传递到插件融合层；不直接对外暴露。
+// 约束：Provider 为解析所用的映射名（与同名插件对应）；数值字段解析失败时保持零值。
+type GeoInfo struct {
+	Provider          string
+	CountryName       string
+	CountryCodeAlpha2 string
+	CountryCodeAlpha3 string
+	RegionName        string
+	RegionCode        string
+	CityName          string
+	Latitude          float64
+	Longitude         float64
+	ASN               int
+	ISP               string
+	ClientIP          string
+}
+
+// 类型化上下文键：避免字符串键被其他包误用或覆盖
+type geoCtxKey struct{}
+
+// 文档注释：向上下文注入 CDN 地理信息
+func WithGeo(ctx context.Context, g GeoInfo) context.Context {
+	return context.WithValue(ctx, geoCtxKey{}, g)
+}
+
+// 文档注释：从上下文读取 CDN 地理信息
+// 返回：未注入（无可信头或无映射命中）时 ok 为 false。
+func GeoFromContext(ctx context.Context) (GeoInfo, bool) {
+	g, ok := ctx.Value(geoCtxKey{}).(GeoInfo)
+	return g, ok
+}
+
+// 文档注释：CDN 地理头插件（进程内）
+// 背景：读取请求上下文中的 CDN 地理信息，将其映射为融合层统一字段并赋予高权重/高置信度，用于补全省市等精细字段；每个 CDN 映射注册一个同名插件，便于分别设置权重与分域写库。
+// 约束：仅处理 Provider 与插件名一致、且 geo.ClientIP 与查询目标 IP 一致的信息；缺失或不一致返回低置信度以避免误用。
+// 经纬度可用时经反地理插件做多边形判定推导省市；RegionCode 经地名表映射为省份；ASN/运营商代码归一化后透传。
+type CDNGeoPlugin struct {
+	provider string
+	rg       *ReverseGeoPlugin
+	gaz      *revgeo.Gazetteer
+}
+
+// 文档注释：创建 CDN 地理头插件
+// 参数：provider 为映射名（插件名与 assoc 同名）；rg 为反地理插件（可为空，为空时不做坐标推导）；gaz 为区域代码地名表（可为空）。
+func NewCDNGeoPlugin(provider string, rg *ReverseGeoPlugin, gaz *revgeo.Gazetteer) *CDNGeoPlugin {
+	return &CDNGeoPlugin{provider: provider, rg: rg, gaz: gaz}
+}
+
+func (p *CDNGeoPlugin) Name() string     { return p.provider }
+func (p *CDNGeoPlugin) Version() string  { return "1.3" }
+func (p *CDNGeoPlugin) AssocKey() string { return p.provider }
+
+// 文档注释：查询并映射 CDN 地理信息
+// 背景：从请求上下文读取中间件注入的 geo 信息，按字段完整度估算置信度并输出归一化位置；不依赖外部网络调用。
+// 约束：字段来源优先级——省份：坐标多边形命中 > RegionCode 地名表；城市：坐标多边形命中 > CDN 城市名 > 最近邻兜底；
+// 反地理结果的国家与 CDN 国家冲突时丢弃反地理结果；国家名缺失时按国家代码映射，仍无法确定国家时返回空结果与低置信度。
+func (p *CDNGeoPlugin) Query(ctx context.Context, ip string) (fusion.Location, float64) {
+	var out fusion.Location
+	g, ok := GeoFromContext(ctx)
+	if !ok || g.Provider != p.provider {
+		logger.L().Debug("cdngeo_plugin_ctx_missing", "name", p.provider, "ip", ip)
+		return out, 0.2
+	}
+	if g.ClientIP != "" && g.ClientIP != ip {
+		logger.L().Debug("cdngeo_plugin_ip_mismatch", "name", p.provider, "ip", ip, "client_ip", g.ClientIP)
+		return out, 0.2
+	}
+	out.Country = g.CountryName
+	out.Region = g.RegionName
+	out.City = g.CityName
+	out.ISP = normalizeISP(g.ISP, g.ASN)
+	out.ASN = g.ASN
+	// Cloudflare、Fastly 等仅回传国家代码：按 ISO 代码表映射为本地库一致的中文名（港澳台归入中国并给出省级名称）
+	if out.Country == "" {
+		if c, prov, ok := revgeo.CountryName(g.CountryCodeAlpha2); ok {
+			out.Country = c
+			out.Province = prov
+		}
+	}
+	if e, ok := p.gaz.Lookup(g.CountryCodeAlpha2, g.RegionCode); ok {
+		out.Province = e.Province
+		if out.Country == "" {
+			out.Country = e.Country
+		}
+		if out.City == "" {
+			out.City = e.City
+		}
+	}
+	pip := false
+	if p.rg != nil && (g.Latitude != 0 || g.Longitude != 0) {
+		u, rc, approx := p.rg.Locate(g.Latitude, g.Longitude, "")
+		switch {
+		case u.Province == "" && u.City == "":
+		case out.Country != "" && u.Country != "" && fusion.NormalizeName(out.Country) != fusion.NormalizeName(u.Country):
+			logger.L().Debug("cdngeo_plugin_revgeo_country_conflict", "name", p.provider, "ip", ip, "country", out.Country, "revgeo_country", u.Country)
+		case !approx:
+			pip = u.City != ""
+			out.Province = firstNonEmpty(u.Province, out.Province)
+			out.City = firstNonEmpty(u.City, out.City)
+			out.Country = firstNonEmpty(out.Country, u.Country)
+		default:
+			out.Province = firstNonEmpty(out.Province, u.Province)
+			out.City = firstNonEmpty(out.City, u.City)
+			out.Country = firstNonEmpty(out.Country, u.Country)
+		}
+		logger.L().Debug("cdngeo_plugin_revgeo", "name", p.provider, "ip", ip, "lat", g.Latitude, "lon", g.Longitude, "conf", rc, "approx", approx)
+	}
+	// 国家无法确定时不输出：无国家的城市会在融合中胜出并被写回
+	if out.Country == "" {
+		logger.L().Debug("cdngeo_plugin_country_unknown", "name", p.provider, "ip", ip, "code", g.CountryCodeAlpha2, "city", out.City)
+		return fusion.Location{}, 0.2
+	}
+	if out.Region == "" {
+		out.Region = out.Province
+	}
+	c := 0.2
+	if pip {
+		c = 0.95
+	} else if out.City != "" {
+		c = 0.9
+	} else if out.Region != "" || out.Province != "" {
+		c = 0.8
+	} else if out.Country != "" {
+		c = 0.7
+	}
+	logger.L().Debug("cdngeo_plugin_query_ok",
+		"name", p.provider,
+		"ip", ip,
+		"country", out.Country,
+		"region", out.Region,
+		"province", out.Province,
+		"city", out.City,
+		"isp", out.ISP,
+		"asn", out.ASN,
+		"confidence", c,
+	)
+	return out, c
+}
+
+// 文档注释：运营商名称归一化
+// 背景：CDN 回传的运营商可能是代码（CMCC/CTCC/CUCC）或英文名，需统一为本地库使用的中文名；无法识别时按 ASN 推断，仍未知则原样返回。
+func normalizeISP(isp string, n int) string {
+	if v, ok := ispByCode[strings.ToUpper(strings.TrimSpace(isp))]; ok {
+		return v
+	}
+	if isp != "" || n <= 0 {
+		return isp
+	}
+	return asn.KnownISP(uint32(n))
+}
+
+var ispByCode = map[string]string{
+	"CMCC": "移动", "CHINA MOBILE": "移动", "CHINAMOBILE": "移动",
+	"CTCC": "电信", "CHINA TELECOM": "电信", "CHINANET": "电信", "CHINATELECOM": "电信",
+	"CUCC": "联通", "CHINA UNICOM": "联通", "CHINAUNICOM": "联通",
+	"CBN": "广电", "CERNET": "教育网",
+}
+
+func firstNonEmpty(vs ...string) string {
+	for _, v := range vs {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// 文档注释：读取插件权重（环境变量可调）
+// 背景：允许通过 `FUSION_WEIGHT_<名称大写>`（如 `FUSION_WEIGHT_EDGEONE`）微调融合权重，默认接近满分以优先采用 CDN 回传结果；上限 10。
+func (p *CDNGeoPlugin) GetWeight(ip string) float64 {
+	f := readWeight("FUSION_WEIGHT_"+strings.ToUpper(p.provider), 9.8)
+	if f > 10 {
+		f = 10
+	}
+	if f < 0 {
+		f = 0
+	}
+	return f
+}
+
+// 文档注释：上下文绑定标记
+// 背景：结果来自当次请求头而非 IP 本身，禁止被结果缓存装饰器按 IP 复用。
+func (p *CDNGeoPlugin) ContextBound() bool { return true }
+
+// 文档注释：CDN 来源标记
+// 背景：融合锚定时，CDN 回传的城市/区域在置信度足够高时优先于本地库。
+func (p *CDNGeoPlugin) CDNGeo() bool { return true }
+
+// 文档注释：心跳检查（本地数据源始终健康）
+// 背景：不依赖外部网络或服务，只读取请求上下文；心跳恒定为健康以参与融合。
+func (p *CDNGeoPlugin) Heartbeat(ctx context.Context) error { return nil }
@@ -0,0 +1,157 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// ErrPluginNotFound：按名称未找到已注册插件
+var ErrPluginNotFound = errors.New("plugin not found")
+
+// 文档注释：插件运行期视图（管理接口输出）
+// 背景：汇总注册信息、健康状态、人工干预与近期调用统计，便于在融合异常时定位是哪个来源出了问题。
+// 约束：Shadow 为 true 时插件只参与影子比对，不影响下发结果；Weight 为当前生效权重（人工覆盖优先，否则取插件默认值，上限 10）；Cached 表示被结果缓存装饰器包装。
+type PluginInfo struct {
+	Name           string        `json:"name"`
+	Version        string        `json:"version"`
+	Assoc          string        `json:"assoc"`
+	Healthy        bool          `json:"healthy"`
+	Enabled        bool          `json:"enabled"`
+	Shadow         bool          `json:"shadow"`
+	LastHeartbeat  time.Time     `json:"last_heartbeat"`
+	LastError      string        `json:"last_error,omitempty"`
+	Weight         float64       `json:"weight"`
+	WeightOverride bool          `json:"weight_override"`
+	Cached         bool          `json:"cached"`
+	Stats          StatsSnapshot `json:"stats"`
+}
+
+// 文档注释：列出全部已注册插件（含禁用/不健康）
+// 返回：按名称排序的插件视图。
+func (m *Manager) List() []PluginInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]PluginInfo, 0, len(m.ps))
+	for k, p := range m.ps {
+		out = append(out, m.infoLocked(k, p))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// 文档注释：获取单个插件视图
+func (m *Manager) Info(name string) (PluginInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.ps[name]
+	if !ok {
+		return PluginInfo{}, ErrPluginNotFound
+	}
+	return m.infoLocked(name, p), nil
+}
+
+// NOTE: 调用方需持有读锁；GetWeight 传空 IP 取插件的静态默认权重。
+func (m *Manager) infoLocked(name string, p Plugin) PluginInfo {
+	s := m.st[name]
+	info := PluginInfo{
+		Name:          name,
+		Version:       p.Version(),
+		Assoc:         p.AssocKey(),
+		Healthy:       s.healthy,
+		Enabled:       s.enabled,
+		Shadow:        s.shadow,
+		LastHeartbeat: s.last,
+		LastError:     s.lastErr,
+	}
+	info.Weight = p.GetWeight("")
+	if s.weight != nil {
+		info.Weight = *s.weight
+		info.WeightOverride = true
+	}
+	if info.Weight > 10 {
+		info.Weight = 10
+	}
+	_, info.Cached = p.(*CachedPlugin)
+	if c := m.calls[name]; c != nil {
+		info.Stats = c.snapshot()
+	}
+	return info
+}
+
+// 文档注释：启用/禁用插件
+// 背景：禁用后插件不再参与融合，但仍保留注册与心跳，恢复时无需重启。
+// 返回：修改前的启用状态，用于审计记录。
+func (m *Manager) SetEnabled(name string, enabled bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.st[name]
+	if !ok {
+		return false, ErrPluginNotFound
+	}
+	prev := s.enabled
+	s.enabled = enabled
+	m.st[name] = s
+	return prev, nil
+}
+
+// 文档注释：设置或清除人工权重
+// 参数：w 为 nil 时恢复插件默认权重；否则需在 [0,10] 内。
+// 返回：修改前的生效权重，用于审计记录。
+func (m *Manager) SetWeight(name string, w *float64) (float64, error) {
+	if w != nil && (math.IsNaN(*w) || *w < 0 || *w > 10) {
+		return 0, errors.New("weight must be within [0,10]")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.ps[name]
+	if !ok {
+		return 0, ErrPluginNotFound
+	}
+	prev := m.infoLocked(name, p).Weight
+	s := m.st[name]
+	if w == nil {
+		s.weight = nil
+	} else {
+		v := *w
+		s.weight = &v
+	}
+	m.st[name] = s
+	return prev, nil
+}
+
+// 文档注释：立即执行一次心跳
+// 背景：外部插件恢复后无需等待下一个心跳周期即可重新参与融合。
+// 返回：心跳错误（插件不健康）或插件不存在错误。
+func (m *Manager) ForceHeartbeat(ctx context.Context, name string) error {
+	m.mu.RLock()
+	p, ok := m.ps[name]
+	m.mu.RUnlock()
+	if !ok {
+		return ErrPluginNotFound
+	}
+	return m.heartbeat(ctx, p)
+}
+
+// 文档注释：生效权重（融合计算用）
+// 约束：人工覆盖优先；结果由调用方负责截断到 10。
+func (m *Manager) effectiveWeight(p Plugin, ip string) float64 {
+	m.mu.RLock()
+	s := m.st[p.Name()]
+	m.mu.RUnlock()
+	if s.weight != nil {
+		return *s.weight
+	}
+	return p.GetWeight(ip)
+}
+
+func (m *Manager) recordCall(name string, ms float64, failed bool) {
+	m.mu.RLock()
+	c := m.calls[name]
+	m.mu.RUnlock()
+	if c != nil {
+		c.record(ms, failed)
+	}
+}
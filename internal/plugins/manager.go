@@ -2,6 +2,7 @@ package plugins
 
 import (
 	"context"
+	"errors"
 	"ip-api/internal/fusion"
 	"ip-api/internal/logger"
 	"ip-api/internal/metrics"
@@ -27,9 +28,18 @@ type Plugin interface {
 
 // 文档注释：插件健康状态缓存
 // 背景：记录健康与最近心跳时间；管理层据此筛选“健康插件集合”。
+// 约束：enabled 与 weight 为运行期人工干预结果，心跳只更新健康相关字段，不覆盖人工设置。
 type status struct {
 	healthy bool
 	last    time.Time
+	lastErr string
+	enabled bool
+	// weight 为人工覆盖权重；nil 表示沿用插件自身 GetWeight
+	weight *float64
+	// shadow 为影子插件：照常查询与评分，但不参与融合结果
+	shadow bool
+	// shadowSet 表示 shadow 经管理接口（SetShadow）人工设置，重复注册时优先于注册选项
+	shadowSet bool
 }
 
 // 文档注释：插件管理器
@@ -39,38 +49,95 @@ type Manager struct {
 	mu         sync.RWMutex
 	ps         map[string]Plugin
 	st         map[string]status
+	calls      map[string]*callStats
 	hbInterval time.Duration
+	shadow     *shadowRunner
 }
 
 func NewManager() *Manager {
-	return &Manager{ps: make(map[string]Plugin), st: make(map[string]status), hbInterval: 10 * time.Second}
+	return &Manager{ps: make(map[string]Plugin), st: make(map[string]status), calls: make(map[string]*callStats), hbInterval: 10 * time.Second, shadow: newShadowRunner()}
 }
 
 // 文档注释：注册插件
 // 背景：进程内/外插件均通过此方法注册到管理器；默认设置为健康状态以便参与查询。
-func (m *Manager) Register(p Plugin) {
+// 参数：opts 为注册选项（如 AsShadow）；名称出现在 PLUGIN_SHADOW_NAMES 中的插件同样按影子注册。
+// 约束：影子状态的优先级为 管理接口人工设置 > 注册选项/PLUGIN_SHADOW_NAMES；未经人工设置时重复注册按本次选项重新决定。
+func (m *Manager) Register(p Plugin, opts ...RegisterOption) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.ps[p.Name()] = p
-	m.st[p.Name()] = status{healthy: true, last: time.Now()}
-	logger.L().Info("plugin_registered", "name", p.Name(), "assoc", p.AssocKey(), "version", p.Version())
+	s := status{healthy: true, last: time.Now(), enabled: true, shadow: shadowByEnv(p.Name())}
+	for _, o := range opts {
+		o(&s)
+	}
+	// 重复注册（如数据热加载后替换实例）时保留人工设置的启用状态、权重与影子状态
+	if old, ok := m.st[p.Name()]; ok {
+		s.enabled = old.enabled
+		s.weight = old.weight
+		if old.shadowSet {
+			s.shadow, s.shadowSet = old.shadow, true
+		}
+	}
+	m.st[p.Name()] = s
+	if _, ok := m.calls[p.Name()]; !ok {
+		m.calls[p.Name()] = &callStats{}
+	}
+	logger.L().Info("plugin_registered", "name", p.Name(), "assoc", p.AssocKey(), "version", p.Version(), "shadow", s.shadow)
 }
 
 // 文档注释：获取健康插件集合
-// 背景：供融合层调用；仅返回当前判定为健康的插件。
+// 背景：供融合层调用；仅返回当前判定为健康、未被人工禁用且非影子的插件。
 func (m *Manager) HealthyPlugins() []Plugin {
+	return m.pluginsWhere(false)
+}
+
+// 文档注释：获取健康的影子插件集合
+// 背景：影子插件在融合完成后异步查询，仅用于与下发结果对比。
+func (m *Manager) ShadowPlugins() []Plugin {
+	return m.pluginsWhere(true)
+}
+
+func (m *Manager) pluginsWhere(shadow bool) []Plugin {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	var out []Plugin
 	for k, p := range m.ps {
 		s := m.st[k]
-		if s.healthy {
+		if s.healthy && s.enabled && s.shadow == shadow {
 			out = append(out, p)
 		}
 	}
 	return out
 }
 
+// 文档注释：清理插件结果缓存
+// 背景：数据源修正或配额异常后需丢弃已缓存结果；name 为空时作用于全部带缓存的插件，ip 为空时清理插件全部条目。
+// 返回：清理条数与命中的插件名；未找到带缓存的目标插件时返回错误。
+func (m *Manager) PurgeCache(ctx context.Context, name, ip string) (int, []string, error) {
+	m.mu.RLock()
+	var targets []*CachedPlugin
+	for k, p := range m.ps {
+		if name != "" && k != name {
+			continue
+		}
+		if c, ok := p.(*CachedPlugin); ok {
+			targets = append(targets, c)
+		}
+	}
+	m.mu.RUnlock()
+	if len(targets) == 0 {
+		return 0, nil, errors.New("no cached plugin matched")
+	}
+	total := 0
+	var names []string
+	for _, c := range targets {
+		total += c.Purge(ctx, ip)
+		names = append(names, c.Name())
+	}
+	sort.Strings(names)
+	return total, names, nil
+}
+
 // 文档注释：启动心跳循环
 // 背景：周期性调用插件 Heartbeat 更新健康状态；在 ctx 取消时停止。
 func (m *Manager) Start(ctx context.Context) {
@@ -88,21 +155,40 @@ func (m *Manager) Start(ctx context.Context) {
 	}()
 }
 
+// NOTE: 先复制插件列表再逐个心跳，避免外部插件网络心跳期间持有写锁阻塞融合查询。
 func (m *Manager) doHeartbeat(ctx context.Context) {
+	m.mu.RLock()
+	ps := make([]Plugin, 0, len(m.ps))
+	for _, p := range m.ps {
+		ps = append(ps, p)
+	}
+	m.mu.RUnlock()
+	for _, p := range ps {
+		_ = m.heartbeat(ctx, p)
+	}
+}
+
+// 文档注释：执行单个插件心跳并更新健康状态
+// 约束：仅更新健康/心跳时间/错误信息，保留 enabled 与人工权重。
+func (m *Manager) heartbeat(ctx context.Context, p Plugin) error {
+	err := p.Heartbeat(ctx)
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	for k, p := range m.ps {
-		err := p.Heartbeat(ctx)
-		if err != nil {
-			m.st[k] = status{healthy: false, last: time.Now()}
-			logger.L().Debug("plugin_heartbeat_fail", "name", p.Name(), "err", err)
-			metrics.PluginHeartbeatTotal.WithLabelValues(p.Name(), "fail").Inc()
-		} else {
-			m.st[k] = status{healthy: true, last: time.Now()}
-			logger.L().Debug("plugin_heartbeat_ok", "name", p.Name())
-			metrics.PluginHeartbeatTotal.WithLabelValues(p.Name(), "ok").Inc()
-		}
+	s := m.st[p.Name()]
+	s.last = time.Now()
+	if err != nil {
+		s.healthy = false
+		s.lastErr = err.Error()
+		logger.L().Debug("plugin_heartbeat_fail", "name", p.Name(), "err", err)
+		metrics.PluginHeartbeatTotal.WithLabelValues(p.Name(), "fail").Inc()
+	} else {
+		s.healthy = true
+		s.lastErr = ""
+		logger.L().Debug("plugin_heartbeat_ok", "name", p.Name())
+		metrics.PluginHeartbeatTotal.WithLabelValues(p.Name(), "ok").Inc()
 	}
+	m.st[p.Name()] = s
+	return err
 }
 
 // 文档注释：内置插件适配器
@@ -135,6 +221,8 @@ type Weighted struct {
 	Confidence float64
 	Assoc      string
 	Name       string
+	// Agreeing 为全部参与融合的来源中与融合结果一致的数量
+	Agreeing int
 }
 
 // 文档注释：管理器聚合查询（返回融合与 Top 来源）
@@ -143,45 +231,20 @@ type Weighted struct {
 func (m *Manager) Aggregate(ctx context.Context, ip string) (fusion.Location, float64, float64, *Weighted) {
 	hs := m.HealthyPlugins()
 	logger.L().Debug("plugin_aggregate_begin", "ip", ip, "healthy", len(hs))
-	type wr struct {
-		Loc   fusion.Location
-		Score float64
-		Conf  float64
-		Assoc string
-		Name  string
-	}
-	var results []wr
+	var results, extras []scored
 	for _, p := range hs {
-		t0 := time.Now()
-		metrics.PluginRequestsTotal.WithLabelValues(p.Name()).Inc()
-		l, c := p.Query(ctx, ip)
-		w := p.GetWeight(ip)
-		if w > 10 {
-			w = 10
+		if e, ok := p.(enricher); ok && e.Enrichment() {
+			extras = append(extras, m.evaluate(ctx, p, ip))
+			continue
 		}
-		q := qualityCoeff(l)
-		co := fusion.CoherenceCoeff(l)
-		sc := 100 * (w / 10.0) * q * c * co
-		ms := float64(time.Since(t0).Milliseconds())
-		metrics.PluginDurationMs.WithLabelValues(p.Name()).Observe(ms)
-		if l.Country != "" || l.Region != "" || l.Province != "" || l.City != "" || l.ISP != "" {
-			metrics.PluginSuccessTotal.WithLabelValues(p.Name()).Inc()
-		} else {
-			metrics.PluginFailTotal.WithLabelValues(p.Name()).Inc()
-		}
-		if co < 1.0 {
-			logger.L().Debug("plugin_coherence_penalty_applied", "name", p.Name(), "coeff", co)
-		}
-		results = append(results, wr{Loc: l, Score: sc, Conf: c, Assoc: p.AssocKey(), Name: p.Name()})
-		metrics.PluginScore.WithLabelValues(p.Name()).Observe(sc)
-		logger.L().Debug("plugin_weighted", "name", p.Name(), "w", w, "q", q, "c", c, "score", sc)
+		results = append(results, m.evaluate(ctx, p, ip))
 	}
 	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
 	top := results
 	if len(top) > 3 {
 		top = top[:3]
 	}
-	// 锚定源选择：KV 优先；其次 EdgeOne（有城市/区域且置信度较高）；否则取最高分
+	// 锚定源选择：KV 优先；其次 CDN 地理头（有城市/区域且置信度较高）；否则取最高分
 	anchorIdx := -1
 	for i, r := range top {
 		if r.Name == "kv" && (r.Loc.City != "" || r.Loc.Region != "") {
@@ -191,7 +254,7 @@ func (m *Manager) Aggregate(ctx context.Context, ip string) (fusion.Location, fl
 	}
 	if anchorIdx == -1 {
 		for i, r := range top {
-			if r.Name == "edgeone" && (r.Loc.City != "" || r.Loc.Region != "") && r.Conf >= 0.8 {
+			if r.CDN && (r.Loc.City != "" || r.Loc.Region != "") && r.Conf >= 0.8 {
 				anchorIdx = i
 				break
 			}
@@ -200,7 +263,7 @@ func (m *Manager) Aggregate(ctx context.Context, ip string) (fusion.Location, fl
 	if anchorIdx == -1 && len(top) > 0 {
 		anchorIdx = 0
 	}
-	var anchor wr
+	var anchor scored
 	if anchorIdx >= 0 {
 		anchor = top[anchorIdx]
 		logger.L().Debug("fusion_anchor_source", "name", anchor.Name, "score", anchor.Score, "conf", anchor.Conf)
@@ -249,6 +312,30 @@ func (m *Manager) Aggregate(ctx context.Context, ip string) (fusion.Location, fl
 	out.Province = pick(func(l fusion.Location) string { return l.Province }, anchor.Loc.Province)
 	out.City = pick(func(l fusion.Location) string { return l.City }, anchor.Loc.City)
 	out.ISP = pick(func(l fusion.Location) string { return l.ISP }, anchor.Loc.ISP)
+	// ASN 为数值字段不参与投票：锚定源优先，否则取 Top 中首个非零值
+	out.ASN = anchor.Loc.ASN
+	for _, r := range top {
+		if out.ASN != 0 {
+			break
+		}
+		out.ASN = r.Loc.ASN
+	}
+	// 补全来源（如 ASN）仅填充融合结果缺失的运营商与 ASN
+	for _, r := range extras {
+		if out.ASN == 0 {
+			out.ASN = r.Loc.ASN
+		}
+		if out.ISP == "" && (r.Loc.ASN == 0 || r.Loc.ASN == out.ASN) {
+			out.ISP = r.Loc.ISP
+		}
+	}
+	// 行政区划代码仅在与融合结果城市一致的来源中选取，避免代码与名称错配
+	for _, r := range append([]scored{anchor}, top...) {
+		if r.Loc.Adcode != "" && fusion.NormalizeName(r.Loc.City) == fusion.NormalizeName(out.City) {
+			out.Adcode = r.Loc.Adcode
+			break
+		}
+	}
 	// 国家兜底：当区域/城市显然属于中国而国家非中国，修正为中国
 	if fusion.CoherenceCoeff(out) < 1.0 {
 		logger.L().Info("fusion_country_fallback_applied", "prev_country", out.Country, "region", out.Region, "city", out.City)
@@ -259,15 +346,76 @@ func (m *Manager) Aggregate(ctx context.Context, ip string) (fusion.Location, fl
 	if len(results) > 0 {
 		maxScore = results[0].Score
 		maxConf = results[0].Conf
-		topW = &Weighted{Loc: results[0].Loc, Score: results[0].Score, Confidence: results[0].Conf, Assoc: results[0].Assoc, Name: results[0].Name}
+		locs := make([]fusion.Location, 0, len(results))
+		for _, r := range results {
+			locs = append(locs, r.Loc)
+		}
+		topW = &Weighted{Loc: results[0].Loc, Score: results[0].Score, Confidence: results[0].Conf, Assoc: results[0].Assoc, Name: results[0].Name, Agreeing: fusion.Agreement(out, locs)}
 	}
 	if topW != nil {
 		logger.L().Debug("plugin_aggregate_top", "score", topW.Score, "assoc", topW.Assoc, "conf", topW.Confidence)
 	}
 	logger.L().Debug("plugin_aggregate_end", "ip", ip, "score", maxScore)
+	if sh := m.ShadowPlugins(); len(sh) > 0 {
+		m.shadow.dispatch(ctx, m, ip, out, sh)
+	}
 	return out, maxScore, maxConf, topW
 }
 
+// 文档注释：单个插件评分结果
+type scored struct {
+	Loc   fusion.Location
+	Score float64
+	Conf  float64
+	Assoc string
+	Name  string
+	// CDN 为 CDN 地理头来源（参与锚定选择）
+	CDN bool
+}
+
+// 文档注释：CDN 地理头来源标记
+type cdnGeo interface {
+	CDNGeo() bool
+}
+
+// 文档注释：补全来源标记
+// 背景：只提供属性字段（运营商/ASN）的来源不参与排序、投票与写回来源选择。
+type enricher interface {
+	Enrichment() bool
+}
+
+// 文档注释：查询并评分单个插件
+// 背景：融合插件与影子插件共用同一评分路径（权重×质量×置信度×一致性），保证影子评估结果可直接与在线来源比较。
+// 约束：同时更新插件请求/耗时/成败/分数指标与近期调用统计。
+func (m *Manager) evaluate(ctx context.Context, p Plugin, ip string) scored {
+	t0 := time.Now()
+	metrics.PluginRequestsTotal.WithLabelValues(p.Name()).Inc()
+	l, c := p.Query(ctx, ip)
+	w := m.effectiveWeight(p, ip)
+	if w > 10 {
+		w = 10
+	}
+	q := qualityCoeff(l)
+	co := fusion.CoherenceCoeff(l)
+	sc := 100 * (w / 10.0) * q * c * co
+	ms := float64(time.Since(t0).Milliseconds())
+	metrics.PluginDurationMs.WithLabelValues(p.Name()).Observe(ms)
+	empty := isEmptyLocation(l)
+	if !empty {
+		metrics.PluginSuccessTotal.WithLabelValues(p.Name()).Inc()
+	} else {
+		metrics.PluginFailTotal.WithLabelValues(p.Name()).Inc()
+	}
+	m.recordCall(p.Name(), ms, empty)
+	if co < 1.0 {
+		logger.L().Debug("plugin_coherence_penalty_applied", "name", p.Name(), "coeff", co)
+	}
+	metrics.PluginScore.WithLabelValues(p.Name()).Observe(sc)
+	logger.L().Debug("plugin_weighted", "name", p.Name(), "w", w, "q", q, "c", c, "score", sc)
+	cdn, _ := p.(cdnGeo)
+	return scored{Loc: l, Score: sc, Conf: c, Assoc: p.AssocKey(), Name: p.Name(), CDN: cdn != nil && cdn.CDNGeo()}
+}
+
 // 文档注释：质量系数估算（与融合层一致）
 // 背景：用于 Top 来源选择时的分数计算复用；不代表绝对质量。
 func qualityCoeff(l fusion.Location) float64 {
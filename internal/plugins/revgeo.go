@@ -8,33 +8,43 @@ import (
     "os"
     "path/filepath"
     "strconv"
+    "sync/atomic"
     "time"
 )
 
 // 文档注释：反地理插件（进程内）
 // 背景：按坐标查询行政区；在 PIP 命中时为精确结果，未命中则最近邻兜底并附近似标记；集成到插件管理器以统一心跳与权重管理。
 // 约束：Query 通过上下文读取 lat/lon；当未提供坐标或快照缺失时返回空；权重由环境变量控制。
+// 编排器经 SetOrchestrator 原子替换（数据目录热重载），进行中的查询继续使用旧快照。
 type ReverseGeoPlugin struct {
     name    string
     version string
     assoc   string
-    orch    *revgeo.Orchestrator
+    orch    atomic.Pointer[revgeo.Orchestrator]
 }
 
 func NewReverseGeoPlugin(dataDir string) (*ReverseGeoPlugin, error) {
     if dataDir == "" { dataDir = filepath.Join("data", "revgeo") }
     snap, _ := revgeo.LoadSnapshot(dataDir)
-    orch := revgeo.NewOrchestrator(snap)
-    return &ReverseGeoPlugin{name: "revgeo", version: "1.0", assoc: "revgeo", orch: orch}, nil
+    p := &ReverseGeoPlugin{name: "revgeo", version: "1.0", assoc: "revgeo"}
+    p.orch.Store(revgeo.NewOrchestrator(snap))
+    return p, nil
 }
 
+// Orchestrator：当前编排器（热重载时作为金丝雀对照的旧版本）
+func (p *ReverseGeoPlugin) Orchestrator() *revgeo.Orchestrator { return p.orch.Load() }
+
+// SetOrchestrator：原子替换编排器，后续查询立即使用新快照
+func (p *ReverseGeoPlugin) SetOrchestrator(o *revgeo.Orchestrator) { p.orch.Store(o) }
+
 func (p *ReverseGeoPlugin) Name() string     { return p.name }
 func (p *ReverseGeoPlugin) Version() string  { return p.version }
 func (p *ReverseGeoPlugin) AssocKey() string { return p.assoc }
 
 func (p *ReverseGeoPlugin) Query(ctx context.Context, ip string) (fusion.Location, float64) {
     var out fusion.Location
-    if p.orch == nil { return out, 0 }
+    orch := p.orch.Load()
+    if orch == nil { return out, 0 }
     latV := ctx.Value("lat")
     lonV := ctx.Value("lon")
     csV := ctx.Value("coord_sys")
@@ -43,7 +53,7 @@ func (p *ReverseGeoPlugin) Query(ctx context.Context, ip string) (fusion.Locatio
     lon := toFloat(lonV)
     coordSys := ""
     if csV != nil { if s, ok := csV.(string); ok { coordSys = s } }
-    u, conf, approx := p.orch.Query(lat, lon, coordSys)
+    u, conf, approx := orch.Query(lat, lon, coordSys)
     out.Country = u.Country
     out.Region = u.Region
     out.Province = u.Province
@@ -53,6 +63,15 @@ func (p *ReverseGeoPlugin) Query(ctx context.Context, ip string) (fusion.Locatio
     return out, conf
 }
 
+// 文档注释：按坐标定位行政区（供其他插件复用）
+// 背景：EdgeOne 等携带坐标的来源借此做多边形判定，不经过融合；快照缺失时返回空结果。
+func (p *ReverseGeoPlugin) Locate(lat, lon float64, coordSys string) (revgeo.AdminUnit, float64, bool) {
+    if p == nil { return revgeo.AdminUnit{}, 0, true }
+    orch := p.orch.Load()
+    if orch == nil { return revgeo.AdminUnit{}, 0, true }
+    return orch.Query(lat, lon, coordSys)
+}
+
 func (p *ReverseGeoPlugin) GetWeight(ip string) float64 {
     w := 8.0
     if s := os.Getenv("FUSION_WEIGHT_REVGEO"); s != "" { if f, e := strconv.ParseFloat(s, 64); e == nil && f > 0 { w = f } }
@@ -62,13 +81,17 @@ func (p *ReverseGeoPlugin) GetWeight(ip string) float64 {
 func (p *ReverseGeoPlugin) Heartbeat(ctx context.Context) error {
     // 简单健康：快照存在且质心或边界非空视为健康
     t0 := time.Now()
-    if p.orch == nil { return context.DeadlineExceeded }
+    orch := p.orch.Load()
+    if orch == nil { return context.DeadlineExceeded }
     // 轻触发一次查询以确认结构可用
-    _, _, _ = p.orch.Query(0, 0, "")
+    _, _, _ = orch.Query(0, 0, "")
     logger.L().Debug("reverse_geo_heartbeat", "ms", time.Since(t0).Milliseconds())
     return nil
 }
 
+// 上下文绑定：按坐标查询，结果与 IP 无关，不参与插件结果缓存
+func (p *ReverseGeoPlugin) ContextBound() bool { return true }
+
 func toFloat(v any) float64 {
     switch x := v.(type) {
     case float64:
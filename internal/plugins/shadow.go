@@ -0,0 +1,133 @@
+package plugins
+
+import (
+	"context"
+	"ip-api/internal/fusion"
+	"ip-api/internal/logger"
+	"ip-api/internal/metrics"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 文档注释：插件注册选项
+// 背景：注册时附加运行期属性（如影子模式），避免为每种属性新增注册方法。
+type RegisterOption func(*status)
+
+// 文档注释：以影子模式注册插件
+// 背景：评估新数据源时需在真实流量上观察其表现，但不允许其影响下发结果；影子插件异步查询、照常评分，结果只与下发答案比对。
+func AsShadow() RegisterOption {
+	return func(s *status) { s.shadow = true }
+}
+
+// 文档注释：按环境变量判定影子插件
+// 约束：PLUGIN_SHADOW_NAMES 为逗号分隔的插件名，便于外部插件无需改代码即可进入影子模式。
+func shadowByEnv(name string) bool {
+	for _, n := range strings.Split(os.Getenv("PLUGIN_SHADOW_NAMES"), ",") {
+		if strings.TrimSpace(n) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 文档注释：设置插件影子状态
+// 背景：promote（shadow=false）后插件立即参与融合；demote（shadow=true）后退回影子评估。
+// 返回：修改前的影子状态，用于审计记录。
+func (m *Manager) SetShadow(name string, shadow bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.st[name]
+	if !ok {
+		return false, ErrPluginNotFound
+	}
+	prev := s.shadow
+	s.shadow, s.shadowSet = shadow, true
+	m.st[name] = s
+	return prev, nil
+}
+
+// 文档注释：影子查询执行器
+// 背景：影子查询不得拖慢热路径，融合完成后在独立协程中执行；并发上限用于保护慢速影子源（如外部 HTTP）不无限堆积协程。
+// 约束：超过并发上限时直接丢弃本次影子评估并计数；查询脱离请求取消信号，但受 PLUGIN_SHADOW_TIMEOUT_MS 超时约束。
+type shadowRunner struct {
+	sem       chan struct{}
+	timeout   time.Duration
+	logSample float64
+}
+
+func newShadowRunner() *shadowRunner {
+	sample := 0.01
+	if v, err := strconv.ParseFloat(os.Getenv("PLUGIN_SHADOW_LOG_SAMPLE"), 64); err == nil && v >= 0 && v <= 1 {
+		sample = v
+	}
+	return &shadowRunner{
+		sem:       make(chan struct{}, max(readInt("PLUGIN_SHADOW_MAX_INFLIGHT", 64), 1)),
+		timeout:   time.Duration(readInt("PLUGIN_SHADOW_TIMEOUT_MS", 2000)) * time.Millisecond,
+		logSample: sample,
+	}
+}
+
+// 文档注释：异步派发影子评估
+// 参数：served 为本次已下发的融合结果；ps 为健康的影子插件。
+func (r *shadowRunner) dispatch(ctx context.Context, m *Manager, ip string, served fusion.Location, ps []Plugin) {
+	select {
+	case r.sem <- struct{}{}:
+	default:
+		for _, p := range ps {
+			metrics.PluginShadowDroppedTotal.WithLabelValues(p.Name()).Inc()
+		}
+		return
+	}
+	bg := context.WithoutCancel(ctx)
+	go func() {
+		defer func() { <-r.sem }()
+		for _, p := range ps {
+			qctx, cancel := context.WithTimeout(bg, r.timeout)
+			res := m.evaluate(qctx, p, ip)
+			cancel()
+			r.compare(ip, served, res)
+		}
+	}()
+}
+
+// 文档注释：逐字段比对影子结果与下发结果
+// 背景：结论按字段区分，便于判断新来源是“城市更细”还是“省份就错了”。
+// 约束：outcome 取 agree/disagree/shadow_missing/served_missing，双方均为空的字段不计数；抽样日志按 PLUGIN_SHADOW_LOG_SAMPLE 概率输出。
+func (r *shadowRunner) compare(ip string, served fusion.Location, res scored) {
+	fields := [...]struct {
+		name        string
+		served, got string
+	}{
+		{"country", served.Country, res.Loc.Country},
+		{"region", served.Region, res.Loc.Region},
+		{"province", served.Province, res.Loc.Province},
+		{"city", served.City, res.Loc.City},
+		{"isp", served.ISP, res.Loc.ISP},
+	}
+	var diffs []string
+	for _, f := range fields {
+		a, b := fusion.NormalizeName(f.served), fusion.NormalizeName(f.got)
+		var outcome string
+		switch {
+		case a == "" && b == "":
+			continue
+		case b == "":
+			outcome = "shadow_missing"
+		case a == "":
+			outcome = "served_missing"
+		case a == b:
+			outcome = "agree"
+		default:
+			outcome = "disagree"
+			diffs = append(diffs, f.name)
+		}
+		metrics.PluginShadowCompareTotal.WithLabelValues(res.Name, f.name, outcome).Inc()
+	}
+	if r.logSample > 0 && rand.Float64() < r.logSample {
+		logger.L().Info("plugin_shadow_compare", "name", res.Name, "ip", ip, "score", res.Score, "conf", res.Conf,
+			"disagree", strings.Join(diffs, ","), "served", served, "shadow", res.Loc)
+	}
+}
@@ -0,0 +1,78 @@
+package plugins
+
+import (
+	"sort"
+	"sync"
+)
+
+// 文档注释：插件近期调用采样窗口大小
+// 背景：管理接口只关心“最近”的延迟与失败分布，固定窗口避免长尾历史稀释近期异常。
+const statsWindow = 512
+
+// 文档注释：插件近期调用统计（环形缓冲）
+// 背景：Prometheus 直方图适合长期趋势，但无法在进程内直接回答“当前 p99 是多少”；管理接口读取此窗口计算分位数。
+// 约束：并发安全；记录开销为 O(1)，分位数在读取时排序计算。
+type callStats struct {
+	mu     sync.Mutex
+	durMs  [statsWindow]float64
+	failed [statsWindow]bool
+	next   int
+	n      int
+}
+
+// 文档注释：插件统计快照
+// 背景：对外输出的分位数与失败率；Samples 为窗口内实际样本数，样本不足时分位数参考价值有限。
+type StatsSnapshot struct {
+	Samples   int     `json:"samples"`
+	P50Ms     float64 `json:"p50_ms"`
+	P90Ms     float64 `json:"p90_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+func (s *callStats) record(ms float64, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.durMs[s.next] = ms
+	s.failed[s.next] = failed
+	s.next = (s.next + 1) % statsWindow
+	if s.n < statsWindow {
+		s.n++
+	}
+}
+
+func (s *callStats) snapshot() StatsSnapshot {
+	s.mu.Lock()
+	durs := make([]float64, s.n)
+	copy(durs, s.durMs[:s.n])
+	fails := 0
+	for i := 0; i < s.n; i++ {
+		if s.failed[i] {
+			fails++
+		}
+	}
+	s.mu.Unlock()
+	out := StatsSnapshot{Samples: len(durs)}
+	if len(durs) == 0 {
+		return out
+	}
+	sort.Float64s(durs)
+	out.P50Ms = percentile(durs, 0.50)
+	out.P90Ms = percentile(durs, 0.90)
+	out.P99Ms = percentile(durs, 0.99)
+	out.ErrorRate = float64(fails) / float64(len(durs))
+	return out
+}
+
+// 文档注释：最近秩分位数
+// 约束：sorted 需已升序排列且非空。
+func percentile(sorted []float64, q float64) float64 {
+	idx := int(q*float64(len(sorted)) + 0.5)
+	if idx < 1 {
+		idx = 1
+	}
+	if idx > len(sorted) {
+		idx = len(sorted)
+	}
+	return sorted[idx-1]
+}
@@ -0,0 +1,78 @@
+package reload
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 默认金丝雀：常见公共 DNS 地址（IP 库）与若干城市中心坐标（反地理，纬度,经度）
+const (
+	defaultCanaryIPs    = "114.114.114.114,223.5.5.5,1.2.4.8,8.8.8.8"
+	defaultCanaryPoints = "39.9042,116.4074;31.2304,121.4737;22.5431,114.0579"
+)
+
+// Point：反地理金丝雀坐标（WGS84）
+type Point struct{ Lat, Lon float64 }
+
+// CanaryIPs：RELOAD_CANARY_IPS（逗号分隔）或默认列表
+func CanaryIPs() []string {
+	s := os.Getenv("RELOAD_CANARY_IPS")
+	if s == "" {
+		s = defaultCanaryIPs
+	}
+	var out []string
+	for _, ip := range strings.Split(s, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// CanaryPoints：RELOAD_REVGEO_CANARIES（"纬度,经度" 以分号分隔）或默认列表；格式错误的项被忽略
+func CanaryPoints() []Point {
+	s := os.Getenv("RELOAD_REVGEO_CANARIES")
+	if s == "" {
+		s = defaultCanaryPoints
+	}
+	var out []Point
+	for _, item := range strings.Split(s, ";") {
+		lat, lon, ok := strings.Cut(strings.TrimSpace(item), ",")
+		if !ok {
+			continue
+		}
+		a, e1 := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+		b, e2 := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+		if e1 == nil && e2 == nil {
+			out = append(out, Point{Lat: a, Lon: b})
+		}
+	}
+	return out
+}
+
+// 文档注释：金丝雀校验
+// 背景：数据文件内容因来源而异，无法预设每个样本的"正确"结果；改为以当前版本为基准做回归比对：
+// 当前版本命中的样本，新版本也必须命中；且新版本至少命中一个样本（排除空库或语言/格式错配）。
+// 参数：keys 为样本（IP 或坐标）；hit 判断某版本对样本是否给出有效结果。
+// 返回：可直接用作 Spec.Check 的校验函数；keys 为空时不做校验。
+func Canaries[T, K any](keys []K, hit func(T, K) bool) func(next, cur T, hasCur bool) error {
+	return func(next, cur T, hasCur bool) error {
+		if len(keys) == 0 {
+			return nil
+		}
+		hits := false
+		for _, k := range keys {
+			ok := hit(next, k)
+			hits = hits || ok
+			if !ok && hasCur && hit(cur, k) {
+				return fmt.Errorf("canary %v: hit in current version, miss in candidate", k)
+			}
+		}
+		if !hits {
+			return fmt.Errorf("canary: none of %d samples hit in candidate", len(keys))
+		}
+		return nil
+	}
+}
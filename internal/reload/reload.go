@@ -0,0 +1,313 @@
+// 包 reload：数据文件热重载（轮询变更 → 校验 → 原子切换 → 保留上一可用版本供回滚）
+package reload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"ip-api/internal/logger"
+	"ip-api/internal/metrics"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 文档注释：重载目标描述
+// 背景：IPIP、ip2region 与反地理数据替换后需重启才能生效；各目标以统一流程接入：
+// 打开（含文件头校验）→ Check 金丝雀校验 → Apply 原子切换到服务对象 → 被替换的版本保留为回滚版本。
+// 约束：
+// - Path 为文件或目录（目录按其中各文件的名称、大小、修改时间计算指纹，不递归）；
+// - Open 必须在返回前完成全部解析，失败或 Check 拒绝的候选版本由 Close 释放，不会进入服务；
+// - Apply 需为原子替换（如 DynamicCache.Set），调用期间读路径不被阻塞；
+// - Close、Version 可为空。
+type Spec[T any] struct {
+	Name    string
+	Path    string
+	Open    func() (T, error)
+	Check   func(next, cur T, hasCur bool) error
+	Apply   func(T)
+	Close   func(T)
+	Version func(T) string
+}
+
+// Status：目标当前状态（管理接口展示）
+type Status struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Version   string    `json:"version,omitempty"`
+	LoadedAt  time.Time `json:"loaded_at"`
+	Previous  string    `json:"previous,omitempty"`
+	Rollback  bool      `json:"rollback_available"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// 被替换的版本在服务中可能仍有进行中的查询，延迟释放
+const retireDelay = 30 * time.Second
+
+var (
+	ErrNotFound   = errors.New("reload: unknown target")
+	ErrNoPrevious = errors.New("reload: no previous version to roll back to")
+)
+
+type target interface {
+	name() string
+	loaded() bool
+	poll(force bool) error
+	rollback() error
+	status() Status
+}
+
+// 文档注释：轮询式文件监视器
+// 背景：按固定间隔比较各目标的文件指纹；指纹需在连续两次轮询间保持不变才视为写入完成，避免加载复制中的半截文件。
+// 同一指纹只尝试一次：校验失败的文件不会被反复加载，直到文件再次变化或管理接口强制重载。
+type Watcher struct {
+	mu      sync.Mutex
+	targets []target
+}
+
+func NewWatcher() *Watcher { return &Watcher{} }
+
+type version[T any] struct {
+	v   T
+	ver string
+	at  time.Time
+}
+
+type watched[T any] struct {
+	spec      Spec[T]
+	mu        sync.Mutex
+	fp        string // 最近一次尝试加载的指纹
+	pend      string // 上次轮询看到的新指纹，等待稳定
+	cur       *version[T]
+	prev      *version[T]
+	lastCheck time.Time
+	lastErr   string
+}
+
+// 文档注释：登记重载目标
+// 参数：cur 为启动时已投入服务的版本（ok=false 表示尚无可用版本），其指纹取登记时文件状态。
+// NOTE: 登记时不加载；ok=false 且文件已存在时，由首次轮询（Start 或 Reload）尝试加载。
+func Watch[T any](w *Watcher, s Spec[T], cur T, ok bool) {
+	t := &watched[T]{spec: s}
+	if ok {
+		t.fp = fingerprint(s.Path)
+		t.cur = &version[T]{v: cur, ver: t.versionOf(cur), at: time.Now()}
+	}
+	w.mu.Lock()
+	w.targets = append(w.targets, t)
+	w.mu.Unlock()
+}
+
+// 文档注释：启动轮询
+// 参数：interval ≤0 时只执行一次同步检查（之后仅能经 Reload 手动触发）。
+// NOTE: 首轮检查同步执行且忽略稳定性等待，保证尚未加载的目标在返回前完成首次加载。
+func (w *Watcher) Start(ctx context.Context, interval time.Duration) {
+	for _, t := range w.list() {
+		if t.loaded() {
+			continue
+		}
+		_ = t.poll(true)
+	}
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		tk := time.NewTicker(interval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tk.C:
+				for _, t := range w.list() {
+					_ = t.poll(false)
+				}
+			}
+		}
+	}()
+}
+
+func (w *Watcher) list() []target {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]target(nil), w.targets...)
+}
+
+func (w *Watcher) find(name string) (target, error) {
+	for _, t := range w.list() {
+		if t.name() == name {
+			return t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Reload：立即重新加载目标（忽略指纹与稳定性等待），返回加载或校验错误
+func (w *Watcher) Reload(name string) error {
+	t, err := w.find(name)
+	if err != nil {
+		return err
+	}
+	return t.poll(true)
+}
+
+// Rollback：切回上一可用版本；当前版本转为回滚版本（再次调用可切回）
+func (w *Watcher) Rollback(name string) error {
+	t, err := w.find(name)
+	if err != nil {
+		return err
+	}
+	return t.rollback()
+}
+
+// Status：各目标状态（按登记顺序）
+func (w *Watcher) Status() []Status {
+	ts := w.list()
+	out := make([]Status, 0, len(ts))
+	for _, t := range ts {
+		out = append(out, t.status())
+	}
+	return out
+}
+
+func (t *watched[T]) name() string { return t.spec.Name }
+
+func (t *watched[T]) loaded() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cur != nil
+}
+
+func (t *watched[T]) versionOf(v T) string {
+	if t.spec.Version == nil {
+		return ""
+	}
+	return t.spec.Version(v)
+}
+
+func (t *watched[T]) release(v T) {
+	if t.spec.Close != nil {
+		t.spec.Close(v)
+	}
+}
+
+func (t *watched[T]) poll(force bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fp := fingerprint(t.spec.Path)
+	if fp == "" {
+		// 文件缺失（可能正在替换）：保持当前版本
+		if force {
+			return fmt.Errorf("reload %s: %s not found", t.spec.Name, t.spec.Path)
+		}
+		return nil
+	}
+	if !force {
+		if fp == t.fp {
+			return nil
+		}
+		if fp != t.pend {
+			t.pend = fp
+			return nil
+		}
+	}
+	t.fp, t.pend = fp, ""
+	t.lastCheck = time.Now()
+	l := logger.L()
+	next, err := t.spec.Open()
+	if err != nil {
+		t.lastErr = err.Error()
+		metrics.ReloadsTotal.WithLabelValues(t.spec.Name, "error").Inc()
+		l.Error("reload_open_error", "target", t.spec.Name, "path", t.spec.Path, "err", err)
+		return err
+	}
+	if t.spec.Check != nil {
+		var cur T
+		if t.cur != nil {
+			cur = t.cur.v
+		}
+		if err := t.spec.Check(next, cur, t.cur != nil); err != nil {
+			t.release(next)
+			t.lastErr = err.Error()
+			metrics.ReloadsTotal.WithLabelValues(t.spec.Name, "rejected").Inc()
+			l.Error("reload_rejected", "target", t.spec.Name, "path", t.spec.Path, "err", err)
+			return err
+		}
+	}
+	t.spec.Apply(next)
+	if old := t.prev; old != nil {
+		time.AfterFunc(retireDelay, func() { t.release(old.v) })
+	}
+	t.prev = t.cur
+	t.cur = &version[T]{v: next, ver: t.versionOf(next), at: time.Now()}
+	t.lastErr = ""
+	metrics.ReloadsTotal.WithLabelValues(t.spec.Name, "ok").Inc()
+	metrics.ReloadLastSuccess.WithLabelValues(t.spec.Name).Set(float64(t.cur.at.Unix()))
+	l.Info("reload_applied", "target", t.spec.Name, "path", t.spec.Path, "version", t.cur.ver)
+	return nil
+}
+
+// rollback：回滚版本此前已通过校验，直接切换不再跑金丝雀；指纹保持不变，出问题的文件不会被轮询重新加载
+func (t *watched[T]) rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.prev == nil {
+		return ErrNoPrevious
+	}
+	t.spec.Apply(t.prev.v)
+	t.cur, t.prev = t.prev, t.cur
+	metrics.ReloadsTotal.WithLabelValues(t.spec.Name, "rollback").Inc()
+	logger.L().Info("reload_rollback", "target", t.spec.Name, "version", t.cur.ver, "from", t.prev.ver)
+	return nil
+}
+
+func (t *watched[T]) status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := Status{Name: t.spec.Name, Path: t.spec.Path, LastCheck: t.lastCheck, LastError: t.lastErr}
+	if t.cur != nil {
+		s.Version, s.LoadedAt = t.cur.ver, t.cur.at
+	}
+	if t.prev != nil {
+		s.Previous, s.Rollback = t.prev.ver, true
+	}
+	return s
+}
+
+// 文档注释：文件指纹
+// 返回：文件（或目录下各文件）名称、大小、修改时间的哈希；路径不存在或目录为空时为空串。
+func fingerprint(path string) string {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	h := fnv.New64a()
+	if !fi.IsDir() {
+		fmt.Fprintf(h, "%d:%d", fi.Size(), fi.ModTime().UnixNano())
+		return fmt.Sprintf("%x", h.Sum64())
+	}
+	ents, err := os.ReadDir(path)
+	if err != nil {
+		return ""
+	}
+	sort.Slice(ents, func(i, j int) bool { return ents[i].Name() < ents[j].Name() })
+	n := 0
+	for _, e := range ents {
+		if e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(h, "%s:%d:%d;", e.Name(), info.Size(), info.ModTime().UnixNano())
+		n++
+	}
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%x", h.Sum64())
+}
@@ -0,0 +1,84 @@
+package revgeo
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 文档注释：行政区代码条目
+// 背景：CDN 回传的区域代码（如 EdgeOne RegionCode）只给出代码，需映射为与本地库一致的中文名称后才能参与融合投票。
+type RegionEntry struct {
+	Country  string `json:"country"`
+	Province string `json:"province"`
+	City     string `json:"city"`
+}
+
+// 文档注释：行政区代码地名表
+// 背景：内置 ISO 3166-2:CN 省级代码（字母代码与 GB/T 2260 数字代码两种写法），可由数据目录下 gazetteer.json 扩充或覆盖。
+// 约束：键统一为大写 "国家-代码" 形式（如 CN-GD、CN-44）；只读，构造后并发安全。
+type Gazetteer struct {
+	codes map[string]RegionEntry
+}
+
+// 省级行政区：字母代码、数字代码、中文全称
+var cnProvinces = [][3]string{
+	{"BJ", "11", "北京市"}, {"TJ", "12", "天津市"}, {"HE", "13", "河北省"}, {"SX", "14", "山西省"},
+	{"NM", "15", "内蒙古自治区"}, {"LN", "21", "辽宁省"}, {"JL", "22", "吉林省"}, {"HL", "23", "黑龙江省"},
+	{"SH", "31", "上海市"}, {"JS", "32", "江苏省"}, {"ZJ", "33", "浙江省"}, {"AH", "34", "安徽省"},
+	{"FJ", "35", "福建省"}, {"JX", "36", "江西省"}, {"SD", "37", "山东省"}, {"HA", "41", "河南省"},
+	{"HB", "42", "湖北省"}, {"HN", "43", "湖南省"}, {"GD", "44", "广东省"}, {"GX", "45", "广西壮族自治区"},
+	{"HI", "46", "海南省"}, {"CQ", "50", "重庆市"}, {"SC", "51", "四川省"}, {"GZ", "52", "贵州省"},
+	{"YN", "53", "云南省"}, {"XZ", "54", "西藏自治区"}, {"SN", "61", "陕西省"}, {"GS", "62", "甘肃省"},
+	{"QH", "63", "青海省"}, {"NX", "64", "宁夏回族自治区"}, {"XJ", "65", "新疆维吾尔自治区"}, {"TW", "71", "台湾省"},
+	{"HK", "91", "香港特别行政区"}, {"MO", "92", "澳门特别行政区"},
+}
+
+// 文档注释：加载地名表
+// 参数：dir 为反地理数据目录；存在 gazetteer.json（形如 {"CN-GD": {"country": "中国", "province": "广东省"}}）时合并覆盖内置条目。
+// 约束：文件缺失或格式错误时仅使用内置条目，不返回错误。
+func LoadGazetteer(dir string) *Gazetteer {
+	g := &Gazetteer{codes: make(map[string]RegionEntry, len(cnProvinces)*2)}
+	for _, p := range cnProvinces {
+		e := RegionEntry{Country: "中国", Province: p[2]}
+		g.codes["CN-"+p[0]] = e
+		g.codes["CN-"+p[1]] = e
+	}
+	if dir == "" {
+		return g
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "gazetteer.json"))
+	if err != nil {
+		return g
+	}
+	var extra map[string]RegionEntry
+	if json.Unmarshal(b, &extra) == nil {
+		for k, v := range extra {
+			g.codes[strings.ToUpper(strings.TrimSpace(k))] = v
+		}
+	}
+	return g
+}
+
+// 文档注释：按区域代码查询
+// 参数：country 为 ISO alpha-2 国家代码（code 已带国家前缀时可为空）；code 如 "CN-GD"、"GD"、"44"。
+// 返回：命中条目与是否命中。
+func (g *Gazetteer) Lookup(country, code string) (RegionEntry, bool) {
+	if g == nil {
+		return RegionEntry{}, false
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return RegionEntry{}, false
+	}
+	if !strings.Contains(code, "-") {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if country == "" {
+			return RegionEntry{}, false
+		}
+		code = country + "-" + code
+	}
+	e, ok := g.codes[code]
+	return e, ok
+}
@@ -2,22 +2,27 @@ package revgeo
 
 import (
     "encoding/json"
+    "errors"
+    "fmt"
     "io"
     "os"
     "path/filepath"
     "strings"
+    "time"
 )
 
 // 文档注释：从数据目录加载边界与质心快照
 // 背景：支持从 Natural Earth/geoBoundaries/自建城市质心的 GeoJSON/JSON 文件读取；构建轻量快照用于查询。
 // 约束：约定文件名：boundaries.json 或 *.geojson（行政边界），city_centroids.json（质心）；缺失文件时仅提供最近邻兜底。
+// 返回：快照总是非空（解析失败的文件被跳过）；error 汇总解析失败的文件，热重载据此拒绝损坏的数据目录。
 func LoadSnapshot(dir string) (*Snapshot, error) {
     var units []AdminUnit
     var cents []Centroid
+    var errs []error
     // 质心
     centPath := filepath.Join(dir, "city_centroids.json")
     if b, err := os.ReadFile(centPath); err == nil {
-        _ = json.Unmarshal(b, &cents)
+        if e := json.Unmarshal(b, &cents); e != nil { errs = append(errs, fmt.Errorf("%s: %w", centPath, e)) }
     }
     // 边界：优先 boundaries.json，其次扫描 .geojson
     b0 := filepath.Join(dir, "boundaries.json")
@@ -27,6 +32,8 @@ func LoadSnapshot(dir string) (*Snapshot, error) {
             for _, r := range raw {
                 units = append(units, parseUnit(r))
             }
+        } else {
+            errs = append(errs, fmt.Errorf("%s: %w", b0, e))
         }
     } else {
         entries, _ := os.ReadDir(dir)
@@ -40,13 +47,15 @@ func LoadSnapshot(dir string) (*Snapshot, error) {
                     var gj map[string]any
                     if e2 := json.Unmarshal(bs, &gj); e2 == nil {
                         addUnitsFromGeoJSON(&units, gj)
+                    } else {
+                        errs = append(errs, fmt.Errorf("%s: %w", fp, e2))
                     }
                 }
             }
         }
     }
-    snap := &Snapshot{Units: units, Centroids: cents}
-    return snap, nil
+    snap := &Snapshot{Units: units, Centroids: cents, BuiltAt: time.Now()}
+    return snap, errors.Join(errs...)
 }
 
 func parseUnit(r map[string]any) AdminUnit {
@@ -28,6 +28,9 @@ func NewOrchestrator(snap *Snapshot) *Orchestrator {
     return &Orchestrator{snap: snap, kd: kd, cache: c, maxRadiusKm: r}
 }
 
+// Snapshot：编排器使用的快照（只读）
+func (o *Orchestrator) Snapshot() *Snapshot { return o.snap }
+
 // 文档注释：反地理查询（返回行政区与置信度、是否近似）
 // 背景：输入坐标统一视为 WGS84；国内来源可选 GCJ-02/BD-09 转换。
 // 返回：命中行政区与置信度，approx 表示为非 PIP 精确命中（网格/最近邻）。
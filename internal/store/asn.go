@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// ASNPrefix：ASN 名下的单条前缀
+type ASNPrefix struct {
+	Prefix string `json:"prefix"`
+	Source string `json:"source"`
+}
+
+// ASNInfo：ASN 元信息与前缀列表
+type ASNInfo struct {
+	ASN      int64       `json:"asn"`
+	Name     string      `json:"name"`
+	Country  string      `json:"country"`
+	Registry string      `json:"registry"`
+	ISP      string      `json:"isp"`
+	Total    int         `json:"prefix_count"`
+	Prefixes []ASNPrefix `json:"prefixes"`
+}
+
+// 文档注释：查询 ASN 元信息与名下前缀
+// 背景：供 /api/asn/{asn} 展示；同一前缀同时出现在 BGP 与 RIR 来源时各列一条，便于比对。
+// 参数：limit 为返回前缀的最大条数（Total 为全部条数）。
+// 返回：元信息与前缀均不存在时返回 nil。
+func (s *Store) GetASN(ctx context.Context, asn int64, limit int) (*ASNInfo, error) {
+	info := &ASNInfo{ASN: asn, Prefixes: []ASNPrefix{}}
+	err := s.db.QueryRowContext(ctx, `SELECT name, country, registry, isp FROM _ip_asn WHERE asn=$1`, asn).
+		Scan(&info.Name, &info.Country, &info.Registry, &info.ISP)
+	found := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM _ip_asn_prefixes WHERE asn=$1`, asn).Scan(&info.Total); err != nil {
+		return nil, err
+	}
+	if !found && info.Total == 0 {
+		return nil, nil
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT prefix::text, source FROM _ip_asn_prefixes WHERE asn=$1
+        ORDER BY family(prefix), prefix, source LIMIT $2`, asn, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p ASNPrefix
+		if err := rows.Scan(&p.Prefix, &p.Source); err != nil {
+			return nil, err
+		}
+		info.Prefixes = append(info.Prefixes, p)
+	}
+	return info, rows.Err()
+}
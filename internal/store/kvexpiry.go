@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"ip-api/internal/logger"
+	"ip-api/internal/metrics"
+	"time"
+)
+
+// Expired：被清理的过期覆盖；单 IP 覆盖时 Start == End 且 Range 为 false
+type Expired struct {
+	AssocKey   string
+	Start, End uint32
+	Range      bool
+}
+
+// 每批删除的行数上限，避免长事务与大量历史写入堆在一个事务里
+const expireBatch = 1000
+
+// 文档注释：删除一批已过期的 KV 覆盖
+// 背景：过期行查询时已被忽略，删除只为回收空间并让精确库、统一库的指纹变化从而重建；删除经触发器记入历史（来源 "expiry"）。
+// 约束：FOR UPDATE SKIP LOCKED，多实例同时清理互不阻塞、不重复删除。
+// 返回：本批删除的覆盖（单 IP 与区间合计不超过 2*expireBatch）。
+func PurgeExpiredKV(ctx context.Context, db *sql.DB) ([]Expired, error) {
+	var out []Expired
+	err := inTagged(WithChange(ctx, "", "expiry"), db, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `DELETE FROM _ip_overrides_kv WHERE (assoc_key, ip_int) IN (
+            SELECT assoc_key, ip_int FROM _ip_overrides_kv WHERE expires_at <= now() LIMIT $1 FOR UPDATE SKIP LOCKED)
+            RETURNING assoc_key, ip_int, ip_int, FALSE`, expireBatch)
+		if err != nil {
+			return err
+		}
+		if out, err = scanExpired(rows, out); err != nil {
+			return err
+		}
+		rows, err = tx.QueryContext(ctx, `DELETE FROM _ip_overrides_kv_range WHERE (assoc_key, start_int, end_int) IN (
+            SELECT assoc_key, start_int, end_int FROM _ip_overrides_kv_range WHERE expires_at <= now() LIMIT $1 FOR UPDATE SKIP LOCKED)
+            RETURNING assoc_key, start_int, end_int, TRUE`, expireBatch)
+		if err != nil {
+			return err
+		}
+		out, err = scanExpired(rows, out)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func scanExpired(rows *sql.Rows, out []Expired) ([]Expired, error) {
+	defer rows.Close()
+	for rows.Next() {
+		var e Expired
+		var a, b int64
+		if err := rows.Scan(&e.AssocKey, &a, &b, &e.Range); err != nil {
+			return out, err
+		}
+		e.Start, e.End = uint32(a), uint32(b)
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// 文档注释：启动过期覆盖清理任务
+// 参数：interval ≤0 时不启动；onPurge 在每批删除提交后调用（用于失效结果缓存），可为空。
+// NOTE: 每轮持续按批删除直到不足一批；出错时记录日志，等待下一轮。
+func StartKVExpiry(ctx context.Context, db *sql.DB, interval time.Duration, onPurge func([]Expired)) {
+	if interval <= 0 {
+		return
+	}
+	l := logger.L()
+	go func() {
+		tk := time.NewTicker(interval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tk.C:
+			}
+			for {
+				xs, err := PurgeExpiredKV(ctx, db)
+				if err != nil {
+					l.Error("kv_expiry_error", "err", err)
+					break
+				}
+				if len(xs) == 0 {
+					break
+				}
+				ranges := 0
+				for _, e := range xs {
+					if e.Range {
+						ranges++
+					}
+				}
+				metrics.KVExpiredTotal.WithLabelValues("ip").Add(float64(len(xs) - ranges))
+				metrics.KVExpiredTotal.WithLabelValues("range").Add(float64(ranges))
+				l.Info("kv_expiry_purged", "ip", len(xs)-ranges, "range", ranges)
+				if onPurge != nil {
+					onPurge(xs)
+				}
+				if len(xs) < expireBatch {
+					break
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ip-api/internal/logger"
+	"time"
+)
+
+// 文档注释：KV 覆盖变更历史
+// 背景：_ip_overrides_kv 由 override-kv、融合写回与 amap-ingest 原地更新，错误的自动写入无从追溯也无法撤销；
+// 迁移 0003 在表上挂触发器，任何写入（含手工 SQL）都会在同一事务内向 _ip_overrides_kv_history 追加一条旧值/新值记录。
+// 约束：
+// - 操作人与来源经事务级 set_config 传给触发器，写入方使用 ExecTagged 或 WithChange 标注；未标注时操作人为数据库用户、来源为 "sql"；
+// - 历史表只追加，触发器拒绝 UPDATE/DELETE。
+
+type changeKey struct{}
+
+// Change：写入标注（操作人、来源）
+type Change struct {
+	Actor  string
+	Source string
+}
+
+// WithChange：为后续 KV 写入标注操作人与来源（如 "fusion"、"amap-ingest"、"override-kv"）
+func WithChange(ctx context.Context, actor, source string) context.Context {
+	return context.WithValue(ctx, changeKey{}, Change{Actor: actor, Source: source})
+}
+
+func changeFrom(ctx context.Context) Change {
+	c, _ := ctx.Value(changeKey{}).(Change)
+	return c
+}
+
+// 文档注释：在标注了操作人与来源的事务中执行写入
+// 背景：set_config 的第三个参数为 true 时只在当前事务内生效，单条语句的自动提交无法携带，故包一层事务。
+// 参数：标注取自 ctx（WithChange），缺省时不设置，由触发器取默认值。
+func ExecTagged(ctx context.Context, db *sql.DB, query string, args ...any) (sql.Result, error) {
+	var res sql.Result
+	err := inTagged(ctx, db, func(tx *sql.Tx) error {
+		var err error
+		res, err = tx.ExecContext(ctx, query, args...)
+		return err
+	})
+	return res, err
+}
+
+// inTagged：在标注了操作人与来源的事务中执行 fn，fn 返回错误时回滚
+func inTagged(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	c := changeFrom(ctx)
+	if _, err := tx.ExecContext(ctx, "SELECT set_config('ipapi.actor', $1, true), set_config('ipapi.source', $2, true)", c.Actor, c.Source); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// KVValue：某一版本的覆盖内容（历史中以 JSONB 保存整行）
+type KVValue struct {
+	Country    string     `json:"country"`
+	Region     string     `json:"region"`
+	Province   string     `json:"province"`
+	City       string     `json:"city"`
+	ISP        string     `json:"isp"`
+	Score      float64    `json:"score"`
+	Confidence float64    `json:"confidence"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// KVChange：一条变更历史；Old 为空表示新增，New 为空表示删除；Range 非空时为区间覆盖（IP 为起点）
+type KVChange struct {
+	ID        int64     `json:"id"`
+	AssocKey  string    `json:"assoc_key"`
+	IP        string    `json:"ip"`
+	Range     string    `json:"range,omitempty"`
+	Op        string    `json:"op"`
+	Old       *KVValue  `json:"old,omitempty"`
+	New       *KVValue  `json:"new,omitempty"`
+	Score     float64   `json:"score"`
+	Actor     string    `json:"actor"`
+	Source    string    `json:"source"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// ErrNoChange：指定的历史记录不存在
+var ErrNoChange = errors.New("store: kv history entry not found")
+
+const kvHistoryCols = `id, assoc_key, ip_int, end_int, op, old_value, new_value, COALESCE(score, 0), actor, source, changed_at`
+
+func scanKVChange(sc interface{ Scan(...any) error }) (KVChange, error) {
+	var c KVChange
+	var v int64
+	var end sql.NullInt64
+	var oldv, newv []byte
+	if err := sc.Scan(&c.ID, &c.AssocKey, &v, &end, &c.Op, &oldv, &newv, &c.Score, &c.Actor, &c.Source, &c.ChangedAt); err != nil {
+		return c, err
+	}
+	c.IP = FormatIP(uint32(v))
+	if end.Valid {
+		c.Range = FormatRange(uint32(v), uint32(end.Int64))
+	}
+	for _, p := range []struct {
+		raw []byte
+		dst **KVValue
+	}{{oldv, &c.Old}, {newv, &c.New}} {
+		if len(p.raw) == 0 {
+			continue
+		}
+		var kv KVValue
+		if err := json.Unmarshal(p.raw, &kv); err != nil {
+			return c, err
+		}
+		*p.dst = &kv
+	}
+	return c, nil
+}
+
+// 文档注释：查询 IP 的覆盖变更历史
+// 参数：assocKey 为空时返回全部命名空间；limit ≤0 时取 50。
+// 约束：包含覆盖该 IP 的区间覆盖的变更。
+// 返回：按时间倒序。
+func KVHistory(ctx context.Context, db *sql.DB, ip, assocKey string, limit int) ([]KVChange, error) {
+	val, err := ipToInt(ip)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := db.QueryContext(ctx, `SELECT `+kvHistoryCols+` FROM _ip_overrides_kv_history
+        WHERE ((ip_int = $1 AND end_int IS NULL) OR (end_int IS NOT NULL AND ip_int <= $1 AND end_int >= $1)) AND ($2 = '' OR assoc_key=$2) ORDER BY changed_at DESC, id DESC LIMIT $3`, int64(val), assocKey, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []KVChange
+	for rows.Next() {
+		c, err := scanKVChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// 文档注释：撤销一次变更
+// 背景：自动写入出错时按历史 id 把该命名空间下的覆盖恢复为这次变更之前的值（变更为新增时删除覆盖）；
+// 其后的变更一并被覆盖（有效期一并恢复），撤销本身同样追加一条历史（来源为 "revert:<id>"），可再次撤销。
+// 错误值可能已被复制进 _ip_exact（写回的精确写入、本地缓存链命中的惰性落库），同一事务内删除目标 IP（或区间内）地点与该值相同的精确记录。
+// 参数：操作人取自 ctx（WithChange）。
+// 返回：被撤销的历史记录（调用方据其 IP 或 Range 失效缓存，并触发精确库重建）；id 不存在时返回 ErrNoChange。
+func RevertKV(ctx context.Context, db *sql.DB, id int64) (KVChange, error) {
+	c, err := scanKVChange(db.QueryRowContext(ctx, `SELECT `+kvHistoryCols+` FROM _ip_overrides_kv_history WHERE id=$1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrNoChange
+	}
+	if err != nil {
+		return c, err
+	}
+	start, end := uint32(0), uint32(0)
+	if c.Range != "" {
+		start, end, err = ParseRange(c.Range)
+	} else {
+		start, err = ipToInt(c.IP)
+		end = start
+	}
+	if err != nil {
+		return c, err
+	}
+	tagged := WithChange(ctx, changeFrom(ctx).Actor, fmt.Sprintf("revert:%d", id))
+	return c, inTagged(tagged, db, func(tx *sql.Tx) error {
+		var err error
+		if c.Range != "" {
+			err = revertRange(ctx, tx, c, start, end)
+		} else {
+			err = revertSingle(ctx, tx, c, start)
+		}
+		if err != nil || c.New == nil {
+			return err
+		}
+		n := c.New
+		res, err := tx.ExecContext(ctx, `DELETE FROM _ip_exact e USING _ip_locations l
+            WHERE l.id = e.location_id AND e.ip_int BETWEEN $1 AND $2
+              AND (l.country, l.region, l.province, l.city, l.isp) = ($3, $4, $5, $6, $7)`,
+			int64(start), int64(end), n.Country, n.Region, n.Province, n.City, n.ISP)
+		if err != nil {
+			return err
+		}
+		if k, _ := res.RowsAffected(); k > 0 {
+			logger.L().Info("kv_revert_exact_removed", "id", id, "rows", k)
+		}
+		return nil
+	})
+}
+
+// revertSingle：单 IP 覆盖的撤销（恢复或删除 _ip_overrides_kv 中同一命名空间的行）
+func revertSingle(ctx context.Context, tx *sql.Tx, c KVChange, val uint32) error {
+	if c.Old == nil {
+		_, err := tx.ExecContext(ctx, `DELETE FROM _ip_overrides_kv WHERE assoc_key=$1 AND ip_int=$2`, c.AssocKey, int64(val))
+		return err
+	}
+	o := c.Old
+	_, err := tx.ExecContext(ctx, `INSERT INTO _ip_overrides_kv(assoc_key, ip_int, country, region, province, city, isp, score, confidence, expires_at)
+        VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+        ON CONFLICT (assoc_key, ip_int) DO UPDATE SET country=EXCLUDED.country, region=EXCLUDED.region, province=EXCLUDED.province, city=EXCLUDED.city, isp=EXCLUDED.isp, score=EXCLUDED.score, confidence=EXCLUDED.confidence, expires_at=EXCLUDED.expires_at, updated_at=now()`,
+		c.AssocKey, int64(val), o.Country, o.Region, o.Province, o.City, o.ISP, o.Score, o.Confidence, o.ExpiresAt)
+	return err
+}
+
+// revertRange：区间覆盖的撤销（恢复或删除 _ip_overrides_kv_range 中同一命名空间、同一区间的行）
+func revertRange(ctx context.Context, tx *sql.Tx, c KVChange, start, end uint32) error {
+	if c.Old == nil {
+		_, err := tx.ExecContext(ctx, `DELETE FROM _ip_overrides_kv_range WHERE assoc_key=$1 AND start_int=$2 AND end_int=$3`, c.AssocKey, int64(start), int64(end))
+		return err
+	}
+	o := c.Old
+	_, err := tx.ExecContext(ctx, `INSERT INTO _ip_overrides_kv_range(assoc_key, start_int, end_int, country, region, province, city, isp, score, confidence, expires_at)
+        VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+        ON CONFLICT (assoc_key, start_int, end_int) DO UPDATE SET country=EXCLUDED.country, region=EXCLUDED.region, province=EXCLUDED.province, city=EXCLUDED.city, isp=EXCLUDED.isp, score=EXCLUDED.score, confidence=EXCLUDED.confidence, expires_at=EXCLUDED.expires_at, updated_at=now()`,
+		c.AssocKey, int64(start), int64(end), o.Country, o.Region, o.Province, o.City, o.ISP, o.Score, o.Confidence, o.ExpiresAt)
+	return err
+}
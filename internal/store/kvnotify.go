@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"ip-api/internal/logger"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// KVChannel：KV 覆盖变更通知频道（迁移 0008 的历史触发器发出，载荷 "<op> <起> <止>"）
+const KVChannel = "ipapi_kv_changed"
+
+// KVEvent：一次 KV 覆盖变更；Op 为 insert/update/delete，单 IP 覆盖时 Start == End；
+// 监听连接重连后送出 Op 为 "resync" 的事件（断线期间的通知可能丢失）
+type KVEvent struct {
+	Op         string
+	Start, End uint32
+}
+
+// 文档注释：监听 KV 覆盖变更
+// 参数：dsn 为监听连接使用的 DSN（LISTEN 需独占连接，不占用连接池）；fn 在监听协程中依次调用。
+// NOTE: 与特例段索引相同，监听连接断开后由 pq 自动重连。
+func ListenKV(ctx context.Context, dsn string, fn func(KVEvent)) {
+	l := logger.L()
+	ln := pq.NewListener(dsn, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			l.Error("kv_listen_error", "event", int(ev), "err", err)
+		}
+	})
+	if err := ln.Listen(KVChannel); err != nil {
+		l.Error("kv_listen_error", "err", err)
+	}
+	go func() {
+		defer ln.Close()
+		ping := time.NewTicker(90 * time.Second)
+		defer ping.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-ln.Notify:
+				if n == nil {
+					fn(KVEvent{Op: "resync"})
+					continue
+				}
+				if ev, ok := parseKVEvent(n.Extra); ok {
+					fn(ev)
+				}
+			case <-ping.C:
+				_ = ln.Ping()
+			}
+		}
+	}()
+}
+
+func parseKVEvent(s string) (KVEvent, bool) {
+	f := strings.Fields(s)
+	if len(f) != 3 {
+		return KVEvent{}, false
+	}
+	a, err1 := strconv.ParseUint(f[1], 10, 32)
+	b, err2 := strconv.ParseUint(f[2], 10, 32)
+	if err1 != nil || err2 != nil || b < a {
+		return KVEvent{}, false
+	}
+	return KVEvent{Op: f[0], Start: uint32(a), End: uint32(b)}, true
+}
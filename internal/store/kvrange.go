@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"ip-api/internal/namespace"
+	"net/netip"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// 文档注释：解析覆盖目标
+// 背景：KV 覆盖原先只能针对单个 IP，修正一个错误归属的 /22 需写 1024 行；区间覆盖存于 _ip_overrides_kv_range。
+// 参数：s 为单个 IPv4、CIDR（如 203.0.113.0/24，主机位自动清零）或 "起-止" 区间。
+// 返回：闭区间 [start, end]；单个 IP 时 start == end。
+func ParseRange(s string) (uint32, uint32, error) {
+	if a, b, ok := strings.Cut(s, "-"); ok {
+		x, err := ipToInt(strings.TrimSpace(a))
+		if err != nil {
+			return 0, 0, err
+		}
+		y, err := ipToInt(strings.TrimSpace(b))
+		if err != nil {
+			return 0, 0, err
+		}
+		if y < x {
+			return 0, 0, fmt.Errorf("bad range %s", s)
+		}
+		return x, y, nil
+	}
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil || !p.Addr().Is4() {
+			return 0, 0, fmt.Errorf("bad cidr %s", s)
+		}
+		p = p.Masked()
+		b := p.Addr().As4()
+		start := uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+		return start, start | uint32(uint64(1)<<(32-p.Bits())-1), nil
+	}
+	v, err := ipToInt(s)
+	return v, v, err
+}
+
+// FormatRange：区间的文本形式（恰为 CIDR 时输出 CIDR，否则为 "起-止"）
+func FormatRange(start, end uint32) string {
+	size := uint64(end) - uint64(start) + 1
+	if size&(size-1) == 0 && uint64(start)&(size-1) == 0 {
+		bits := 32
+		for n := size; n > 1; n >>= 1 {
+			bits--
+		}
+		return fmt.Sprintf("%s/%d", FormatIP(start), bits)
+	}
+	return FormatIP(start) + "-" + FormatIP(end)
+}
+
+// FormatIP：IPv4 整数的点分文本
+func FormatIP(v uint32) string {
+	return fmt.Sprintf("%d.%d.%d.%d", v>>24, (v>>16)&0xff, (v>>8)&0xff, v&0xff)
+}
+
+// 文档注释：查询 IP 的单 IP KV 覆盖
+// 约束：忽略已过期的行；多命名空间并存时按 namespace.Precedence 选取。
+func (s *Store) lookupKVSingle(ctx context.Context, val uint32) (*Location, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT country, region, province, city, isp FROM _ip_overrides_kv
+        WHERE ip_int = $1 AND `+namespace.Active+`
+        ORDER BY `+namespace.OrderBy("$2")+` LIMIT 1`, int64(val), pq.Array(namespace.Precedence()))
+	return scanKVLocation(row)
+}
+
+// 文档注释：查询覆盖 IP 的区间 KV 覆盖
+// 约束：忽略已过期的行；最具体者胜出——窄段优先，同宽起点大者优先（与特例段规则一致）；同一区间多命名空间并存时按 namespace.Precedence 选取。
+func (s *Store) lookupKVRange(ctx context.Context, val uint32) (*Location, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT country, region, province, city, isp FROM _ip_overrides_kv_range
+        WHERE start_int <= $1 AND end_int >= $1 AND `+namespace.Active+`
+        ORDER BY (end_int - start_int) ASC, start_int DESC, `+namespace.OrderBy("$2")+` LIMIT 1`, int64(val), pq.Array(namespace.Precedence()))
+	return scanKVLocation(row)
+}
+
+func scanKVLocation(row *sql.Row) (*Location, error) {
+	var l Location
+	if err := row.Scan(&l.Country, &l.Region, &l.Province, &l.City, &l.ISP); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &l, nil
+}
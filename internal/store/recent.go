@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"ip-api/internal/logger"
+	"time"
+)
+
+// 文档注释：最近查询记录的保留与匿名化
+// 背景：_ip_recent_ips 原先无限累积每个被查询的 IP；迁移 0007 将其按日分区（day），保留期外的整日分区直接删除，不产生大批量 DELETE。
+// 约束：
+// - 分区名为 _ip_recent_ips_YYYYMMDD，维护任务预建今日起三天的分区；迁移前的数据在 _ip_recent_ips_legacy 中，按 day 逐行删除；
+// - 匿名化只保存 /24 前缀；IPv6 不写入最近查询记录（也就无需 /48 截断）。
+
+// 预建分区的天数（含今日）
+const recentAhead = 3
+
+// SetRecentAnonymize：最近查询记录是否只保存 /24 前缀（须在开始服务前设置）
+func (s *Store) SetRecentAnonymize(on bool) { s.recentAnon = on }
+
+// 文档注释：预建最近查询的日分区
+// 背景：分区表没有对应分区时写入失败；日期取数据库的 current_date，与 RecordRecent 写入的 day 一致。
+func EnsureRecentPartitions(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `SELECT d::date FROM generate_series(current_date, current_date + $1::int - 1, interval '1 day') d`, recentAhead)
+	if err != nil {
+		return err
+	}
+	var days []time.Time
+	for rows.Next() {
+		var d time.Time
+		if err := rows.Scan(&d); err != nil {
+			rows.Close()
+			return err
+		}
+		days = append(days, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, d := range days {
+		q := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS _ip_recent_ips_%s PARTITION OF _ip_recent_ips FOR VALUES FROM ('%s') TO ('%s')`,
+			d.Format("20060102"), d.Format("2006-01-02"), d.AddDate(0, 0, 1).Format("2006-01-02"))
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 文档注释：删除保留期外的最近查询记录
+// 参数：keepDays 为保留天数（含今日）。
+// 返回：删除的日分区数与遗留分区中删除的行数。
+func PruneRecent(ctx context.Context, db *sql.DB, keepDays int) (int, int64, error) {
+	var cutoff time.Time
+	if err := db.QueryRowContext(ctx, `SELECT current_date - $1::int + 1`, keepDays).Scan(&cutoff); err != nil {
+		return 0, 0, err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT c.relname FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid JOIN pg_class p ON p.oid = i.inhparent
+        WHERE p.relname = '_ip_recent_ips' AND c.relname ~ '^_ip_recent_ips_[0-9]{8}$'`)
+	if err != nil {
+		return 0, 0, err
+	}
+	var drop []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		d, err := time.Parse("20060102", name[len("_ip_recent_ips_"):])
+		if err == nil && d.Before(cutoff) {
+			drop = append(drop, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	for i, name := range drop {
+		if _, err := db.ExecContext(ctx, `DROP TABLE IF EXISTS `+name); err != nil {
+			return i, 0, err
+		}
+	}
+	res, err := db.ExecContext(ctx, `DELETE FROM _ip_recent_ips WHERE day < $1`, cutoff)
+	if err != nil {
+		return len(drop), 0, err
+	}
+	n, _ := res.RowsAffected()
+	return len(drop), n, nil
+}
+
+// 文档注释：启动最近查询记录维护任务
+// 参数：keepDays ≤0 时不清理（仍预建分区）。
+// NOTE: 启动时立即执行一次，其后每小时一次；多实例同时执行时建表冲突只记日志，下一轮重试。
+func StartRecentRetention(ctx context.Context, db *sql.DB, keepDays int) {
+	l := logger.L()
+	run := func() {
+		if err := EnsureRecentPartitions(ctx, db); err != nil {
+			l.Error("recent_partition_error", "err", err)
+		}
+		if keepDays <= 0 {
+			return
+		}
+		parts, rows, err := PruneRecent(ctx, db, keepDays)
+		if err != nil {
+			l.Error("recent_prune_error", "err", err)
+		}
+		if parts > 0 || rows > 0 {
+			l.Info("recent_pruned", "partitions", parts, "rows", rows)
+		}
+	}
+	run()
+	go func() {
+		tk := time.NewTicker(time.Hour)
+		defer tk.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tk.C:
+				run()
+			}
+		}
+	}()
+}
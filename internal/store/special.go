@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"ip-api/internal/localdb"
+	"ip-api/internal/localdb/locdict"
+	"ip-api/internal/localdb/unified"
+	"ip-api/internal/logger"
+	"ip-api/internal/metrics"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SpecialChannel：特例段变更通知频道（cidr-build、cidr-rollback、特例段导入写入后发送）
+const SpecialChannel = "ipapi_cidr_special"
+
+// 通知合并窗口：cidr-build 逐条写入时可能连续收到多次通知，静默该时长后才重建
+const specialDebounce = time.Second
+
+// 文档注释：特例段进程内索引
+// 背景：LookupIP 回退到 _ip_cidr_special 时每次请求一条 SQL（ORDER BY (end_int - start_int) 找最具体的嵌套段）再查一次地点表；
+// 索引把 active 特例段按同一规则（窄段优先、同宽起点大者优先）展开为互不重叠的区间，连同地点一起常驻内存，查询为一次二分查找。
+// 约束：
+// - 重建期间旧索引继续服务，完成后原子替换；首次构建完成前 LookupIP 仍走 SQL；
+// - 依赖 Postgres LISTEN/NOTIFY 感知变更，监听连接重连后无条件重建一次，避免断线期间漏掉通知；
+// - 展开不再按 first_octet 分区：跨 /8 的特例段对其覆盖的全部地址生效（与统一库编译一致）。
+type SpecialIndex struct {
+	db  *sql.DB
+	cur atomic.Pointer[specialTable]
+}
+
+type specialTable struct {
+	segs []unified.Interval
+	dict *locdict.Builder
+}
+
+func NewSpecialIndex(db *sql.DB) *SpecialIndex { return &SpecialIndex{db: db} }
+
+// UseSpecialIndex：LookupIP 的特例段回退改用索引（nil 恢复 SQL 查询）
+func (s *Store) UseSpecialIndex(x *SpecialIndex) { s.special.Store(x) }
+
+// NotifySpecialChanged：通知各服务实例重建特例段索引
+func NotifySpecialChanged(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "SELECT pg_notify($1, '')", SpecialChannel)
+	return err
+}
+
+// 文档注释：从数据库重建索引
+// 返回：查询错误；失败时保留旧索引。
+func (x *SpecialIndex) Rebuild(ctx context.Context) error {
+	t0 := time.Now()
+	rows, err := x.db.QueryContext(ctx, `SELECT s.start_int, s.end_int, l.country, l.region, l.province, l.city, l.isp
+        FROM _ip_cidr_special s JOIN _ip_locations l ON l.id = s.location_id WHERE s.active = TRUE`)
+	if err != nil {
+		metrics.SpecialIndexRebuildsTotal.WithLabelValues("error").Inc()
+		return err
+	}
+	defer rows.Close()
+	dict := locdict.NewBuilder()
+	var in []unified.Interval
+	for rows.Next() {
+		var s, e int64
+		var l localdb.Location
+		if err := rows.Scan(&s, &e, &l.Country, &l.Region, &l.Province, &l.City, &l.ISP); err != nil {
+			metrics.SpecialIndexRebuildsTotal.WithLabelValues("error").Inc()
+			return err
+		}
+		if s < 0 || e < s || e > 0xffffffff {
+			continue
+		}
+		in = append(in, unified.Interval{Start: uint32(s), End: uint32(e), Loc: dict.Add(l)})
+	}
+	if err := rows.Err(); err != nil {
+		metrics.SpecialIndexRebuildsTotal.WithLabelValues("error").Inc()
+		return err
+	}
+	t := &specialTable{segs: unified.MostSpecific(in), dict: dict}
+	x.cur.Store(t)
+	metrics.SpecialIndexRebuildsTotal.WithLabelValues("ok").Inc()
+	metrics.SpecialIndexIntervals.Set(float64(len(t.segs)))
+	logger.L().Info("special_index_built", "ranges", len(in), "intervals", len(t.segs), "locations", dict.Len(), "ms", time.Since(t0).Milliseconds())
+	return nil
+}
+
+// 文档注释：启动索引（首次构建 + 监听变更）
+// 参数：dsn 为监听连接使用的 DSN（LISTEN 需独占连接，不占用连接池）。
+// NOTE: 首次构建在后台执行；构建失败时等待下一次通知或重连再试。
+func (x *SpecialIndex) Start(ctx context.Context, dsn string) {
+	l := logger.L()
+	ln := pq.NewListener(dsn, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			l.Error("special_listen_error", "event", int(ev), "err", err)
+		}
+	})
+	if err := ln.Listen(SpecialChannel); err != nil {
+		l.Error("special_listen_error", "err", err)
+	}
+	go func() {
+		defer ln.Close()
+		rebuild := func() {
+			if err := x.Rebuild(ctx); err != nil {
+				l.Error("special_index_error", "err", err)
+			}
+		}
+		rebuild()
+		timer := time.NewTimer(specialDebounce)
+		timer.Stop()
+		ping := time.NewTicker(90 * time.Second)
+		defer ping.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ln.Notify:
+				// 通知或重连（nil）：合并窗口内的多次变更只重建一次
+				timer.Reset(specialDebounce)
+			case <-timer.C:
+				rebuild()
+			case <-ping.C:
+				_ = ln.Ping()
+			}
+		}
+	}()
+}
+
+// lookup：ready=false 表示索引尚未构建（调用方回退 SQL）
+func (x *SpecialIndex) lookup(val uint32) (Location, bool, bool) {
+	t := x.cur.Load()
+	if t == nil {
+		return Location{}, false, false
+	}
+	i := sort.Search(len(t.segs), func(i int) bool { return t.segs[i].End >= val })
+	if i == len(t.segs) || t.segs[i].Start > val {
+		metrics.SpecialIndexLookupsTotal.WithLabelValues("miss").Inc()
+		return Location{}, false, true
+	}
+	metrics.SpecialIndexLookupsTotal.WithLabelValues("hit").Inc()
+	l := t.dict.At(t.segs[i].Loc)
+	return Location{Country: l.Country, Region: l.Region, Province: l.Province, City: l.City, ISP: l.ISP}, true, true
+}
+
+// Ready：首次构建是否完成
+func (x *SpecialIndex) Ready() bool { return x.cur.Load() != nil }
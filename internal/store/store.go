@@ -8,13 +8,18 @@ import (
     "fmt"
     "ip-api/internal/logger"
     "ip-api/internal/ingest"
+    "ip-api/internal/namespace"
+    "sync/atomic"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // Store: 数据库访问入口，持有连接池并提供查询/统计接口
 type Store struct {
-	db *sql.DB
+	db      *sql.DB
+	special atomic.Pointer[SpecialIndex]
+	// 最近查询记录只保存 /24 前缀（见 SetRecentAnonymize）
+	recentAnon bool
 }
 
 func AttachDB(db *sql.DB) *Store { return &Store{db: db} }
@@ -65,17 +70,30 @@ func (s *Store) LookupIP(ctx context.Context, ip string) (*Location, error) {
 		return nil, nil
 	}
 	logger.L().Debug("db_lookup_begin", "ip", ip, "val", int64(val))
-	row0 := s.db.QueryRowContext(ctx, "SELECT country, region, province, city, isp FROM _ip_overrides_kv WHERE ip_int=$1 LIMIT 1", int64(val))
-	var lk Location
-	if err := row0.Scan(&lk.Country, &lk.Region, &lk.Province, &lk.City, &lk.ISP); err == nil {
+	if lk, _ := s.lookupKVSingle(ctx, val); lk != nil {
 		logger.L().Debug("db_override_kv_hit", "ip_val", int64(val))
-		return &lk, nil
+		return lk, nil
+	}
+	if lr, _ := s.lookupKVRange(ctx, val); lr != nil {
+		logger.L().Debug("db_override_kv_range_hit", "ip_val", int64(val))
+		return lr, nil
 	}
 	row := s.db.QueryRowContext(ctx, "SELECT location_id FROM _ip_overrides WHERE ip_int=$1 LIMIT 1", int64(val))
 	var locID int
 	if err := row.Scan(&locID); err != nil {
 		row2 := s.db.QueryRowContext(ctx, "SELECT location_id FROM _ip_exact WHERE ip_int=$1 LIMIT 1", int64(val))
 		if err := row2.Scan(&locID); err != nil {
+			// 特例段索引就绪时直接在内存中定位最具体的嵌套段（含地点），不再查询数据库
+			if x := s.special.Load(); x != nil {
+				if l, ok, ready := x.lookup(val); ready {
+					if !ok {
+						logger.L().Debug("db_lookup_miss", "ip_val", int64(val))
+						return nil, nil
+					}
+					logger.L().Debug("special_index_hit", "ip_val", int64(val))
+					return &l, nil
+				}
+			}
 			a := int((val >> 24) & 0xff)
 			row3 := s.db.QueryRowContext(ctx, "SELECT location_id FROM _ip_cidr_special WHERE first_octet=$1 AND start_int<=$2 AND end_int>=$2 AND active=TRUE ORDER BY (end_int - start_int) ASC, start_int DESC LIMIT 1", a, int64(val))
 			if err := row3.Scan(&locID); err != nil {
@@ -98,17 +116,17 @@ func (s *Store) LookupIP(ctx context.Context, ip string) (*Location, error) {
 	return &l, nil
 }
 
+// LookupKV：仅查询 KV 覆盖（单 IP 优先，其次最具体的区间覆盖）
 func (s *Store) LookupKV(ctx context.Context, ip string) (*Location, error) {
 	val, err := ipToInt(ip)
 	if err != nil {
 		return nil, nil
 	}
-	row := s.db.QueryRowContext(ctx, "SELECT country, region, province, city, isp FROM _ip_overrides_kv WHERE ip_int=$1 LIMIT 1", int64(val))
-	var l Location
-	if err := row.Scan(&l.Country, &l.Region, &l.Province, &l.City, &l.ISP); err != nil {
-		return nil, nil
+	if l, _ := s.lookupKVSingle(ctx, val); l != nil {
+		return l, nil
 	}
-	return &l, nil
+	lr, _ := s.lookupKVRange(ctx, val)
+	return lr, nil
 }
 
 // IncrStats: 成功查询后递增总计与当日计数；访客存在时递增访客计数
@@ -141,21 +159,26 @@ func (s *Store) GetTotals(ctx context.Context) (*Totals, error) {
 }
 
 // 文档注释：记录最近查询的 IP（去重累加）
-// 背景：作为离线采集候选来源，保留最近访问的 IP 及次数与时间；不影响主查询逻辑。
-// 约束：非法 IP 静默跳过；仅更新 last_seen 与计数。
+// 背景：作为离线采集候选来源，按日分区保留最近访问的 IP 及当日次数与时间；不影响主查询逻辑。
+// 约束：非法 IP（含 IPv6）静默跳过；开启匿名化时只记录 /24 前缀；当日分区缺失（保留任务未运行）时写入失败并忽略。
 func (s *Store) RecordRecent(ctx context.Context, ip string) error {
 	val, err := ipToInt(ip)
 	if err != nil {
 		return nil
 	}
-	_, _ = s.db.ExecContext(ctx, `INSERT INTO _ip_recent_ips(ip_int, last_seen, queries)
-        VALUES($1, now(), 1)
-        ON CONFLICT (ip_int) DO UPDATE SET last_seen=now(), queries=_ip_recent_ips.queries+1`, int64(val))
+	bits := 32
+	if s.recentAnon {
+		val, bits = val&^0xff, 24
+	}
+	_, _ = s.db.ExecContext(ctx, `INSERT INTO _ip_recent_ips(day, ip_int, prefix_len, last_seen, queries)
+        VALUES(current_date, $1, $2, now(), 1)
+        ON CONFLICT (day, ip_int, prefix_len) DO UPDATE SET last_seen=now(), queries=_ip_recent_ips.queries+1`, int64(val), bits)
 	return nil
 }
 
 // 文档注释：获取数据库“待校准候选 IP”列表
-// 背景：从最近查询集合中筛选未被覆盖/未精确命中的 IP，按最近访问排序返回指定数量。
+// 背景：从最近查询集合中筛选未被覆盖（含区间覆盖，已过期的覆盖视为未覆盖）/未精确命中的 IP，按最近访问排序返回指定数量。
+// 约束：/24 前缀行以网段内 .1 作为探测 IP 返回，网段内任一 IP 已有覆盖或精确记录时视为已校准；同一地址跨日多行合并。
 // 参数：hours 为最近窗口小时数，limit 为最大返回数量。
 // 返回：IPv4 文本列表；异常时返回 error。
 func (s *Store) FetchRecentCandidates(ctx context.Context, hours int, limit int) ([]string, error) {
@@ -166,14 +189,20 @@ func (s *Store) FetchRecentCandidates(ctx context.Context, hours int, limit int)
 		limit = 1000
 	}
 	rows, err := s.db.QueryContext(ctx, `
-        SELECT r.ip_int
-        FROM _ip_recent_ips r
-        LEFT JOIN _ip_overrides_kv k ON k.ip_int = r.ip_int
-        LEFT JOIN _ip_exact e ON e.ip_int = r.ip_int
-        WHERE r.last_seen >= now() - make_interval(hours => $1)
-          AND k.ip_int IS NULL
-          AND e.ip_int IS NULL
-        ORDER BY r.last_seen DESC
+        WITH r AS (
+            SELECT ip_int, ip_int + (1::bigint << (32 - prefix_len)) - 1 AS end_int,
+                   CASE WHEN prefix_len = 32 THEN ip_int ELSE ip_int + 1 END AS probe, max(last_seen) AS last_seen
+            FROM _ip_recent_ips
+            WHERE day >= (now() - make_interval(hours => $1))::date AND last_seen >= now() - make_interval(hours => $1)
+            GROUP BY ip_int, prefix_len
+        )
+        SELECT r.probe
+        FROM r
+        WHERE NOT EXISTS (SELECT 1 FROM _ip_overrides_kv k WHERE k.ip_int BETWEEN r.ip_int AND r.end_int AND (k.expires_at IS NULL OR k.expires_at > now()))
+          AND NOT EXISTS (SELECT 1 FROM _ip_exact e WHERE e.ip_int BETWEEN r.ip_int AND r.end_int)
+          AND NOT EXISTS (SELECT 1 FROM _ip_overrides_kv_range kr WHERE kr.start_int <= r.probe AND kr.end_int >= r.probe AND (kr.expires_at IS NULL OR kr.expires_at > now()))
+        GROUP BY r.probe
+        ORDER BY max(r.last_seen) DESC
         LIMIT $2`, hours, limit)
 	if err != nil {
 		return nil, err
@@ -185,24 +214,41 @@ func (s *Store) FetchRecentCandidates(ctx context.Context, hours int, limit int)
 		if err := rows.Scan(&v); err != nil {
 			return nil, err
 		}
-		a := (v >> 24) & 0xff
-		b := (v >> 16) & 0xff
-		c := (v >> 8) & 0xff
-		d := v & 0xff
-		ip := fmt.Sprintf("%d.%d.%d.%d", a, b, c, d)
-		out = append(out, ip)
-	}
-	return out, nil
+		out = append(out, FormatIP(uint32(v)))
+	}
+	return out, rows.Err()
 }
 
-func (s *Store) UpsertOverrideKV(ctx context.Context, assocKey string, ip string, l ingest.Location, score float64, confidence float64) error {
+// 文档注释：自动化写入 KV 覆盖
+// 背景：融合结果回写；同一 assoc_key 下仅当新分数至少高出 margin 时才覆盖旧值，避免分数相近的来源来回改写。
+// 旧值已过期、或新结果与旧值地点相同（重新验证）时不受分差限制；写入时按命名空间设置有效期（namespace.TTL）。
+// 参数：margin 为覆盖所需的最小分差（由写回策略按 assoc 决定）。
+// 返回：是否实际写入（新插入、满足分差被覆盖、过期重写或重新验证续期）。
+// NOTE: 实际写入会追加变更历史，来源由调用方经 WithChange 标注。
+func (s *Store) UpsertOverrideKV(ctx context.Context, assocKey string, ip string, l ingest.Location, score float64, confidence float64, margin float64) (bool, error) {
     val, err := ipToInt(ip)
-    if err != nil { return err }
-    _, err = s.db.ExecContext(ctx, `INSERT INTO _ip_overrides_kv(assoc_key, ip_int, country, region, province, city, isp, score, confidence)
-        VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)
-        ON CONFLICT (assoc_key, ip_int) DO UPDATE SET country=EXCLUDED.country, region=EXCLUDED.region, province=EXCLUDED.province, city=EXCLUDED.city, isp=EXCLUDED.isp, score=EXCLUDED.score, confidence=EXCLUDED.confidence, updated_at=now()
-        WHERE COALESCE(_ip_overrides_kv.score, 0) + 20 <= EXCLUDED.score`,
-        assocKey, int64(val), l.Country, l.Region, l.Province, l.City, l.ISP, score, confidence,
+    if err != nil { return false, err }
+    res, err := ExecTagged(ctx, s.db, `INSERT INTO _ip_overrides_kv(assoc_key, ip_int, country, region, province, city, isp, score, confidence, expires_at)
+        VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9, CASE WHEN $11::float8 > 0 THEN now() + make_interval(secs => $11::float8) END)
+        ON CONFLICT (assoc_key, ip_int) DO UPDATE SET country=EXCLUDED.country, region=EXCLUDED.region, province=EXCLUDED.province, city=EXCLUDED.city, isp=EXCLUDED.isp, score=EXCLUDED.score, confidence=EXCLUDED.confidence, expires_at=EXCLUDED.expires_at, updated_at=now()
+        WHERE COALESCE(_ip_overrides_kv.score, 0) + $10 <= EXCLUDED.score
+           OR _ip_overrides_kv.expires_at <= now()
+           OR (_ip_overrides_kv.country, _ip_overrides_kv.region, _ip_overrides_kv.province, _ip_overrides_kv.city, _ip_overrides_kv.isp) = (EXCLUDED.country, EXCLUDED.region, EXCLUDED.province, EXCLUDED.city, EXCLUDED.isp)`,
+        assocKey, int64(val), l.Country, l.Region, l.Province, l.City, l.ISP, score, confidence, margin, namespace.TTL(assocKey).Seconds(),
     )
-    return err
+    if err != nil { return false, err }
+    n, _ := res.RowsAffected()
+    return n > 0, nil
+}
+
+// 文档注释：判断 IP 在指定命名空间是否存在覆盖
+// 背景：写回策略据此跳过运维人工维护的 IP（受保护命名空间，含覆盖该 IP 的区间覆盖），自动化流程不得改写或叠加。
+func (s *Store) HasOverrideIn(ctx context.Context, ip string, namespaces []string) (bool, error) {
+    if len(namespaces) == 0 { return false, nil }
+    val, err := ipToInt(ip)
+    if err != nil { return false, err }
+    var ok bool
+    err = s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM _ip_overrides_kv WHERE ip_int=$1 AND assoc_key = ANY($2) AND `+namespace.Active+`)
+        OR EXISTS(SELECT 1 FROM _ip_overrides_kv_range WHERE start_int <= $1 AND end_int >= $1 AND assoc_key = ANY($2) AND `+namespace.Active+`)`, int64(val), pq.Array(namespaces)).Scan(&ok)
+    return ok, err
 }